@@ -1,7 +1,7 @@
 package collectors
 
 import (
-	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -131,14 +131,18 @@ RUN_LOOP:
 			d.doneRun <- true
 			break RUN_LOOP
 
-		case dm, opened := <-d.recvFrom:
+		case msg, opened := <-d.recvFrom:
 			if !opened {
 				d.LogInfo("channel closed, exit")
 				return
 			}
 
+			// work on a message from the pool to not allocate it for each packet
+			dm := dnsutils.AcquireDnsMessage()
+			*dm = msg
+
 			// init dns message with additionnals parts
-			transforms.InitDnsMessageFormat(&dm)
+			transforms.InitDnsMessageFormat(dm)
 
 			// compute timestamp
 			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
@@ -167,10 +171,18 @@ RUN_LOOP:
 				dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
 			}
 
-			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
+			// the resource records are decoded only if needed by a transformer or a logger
+			if err = dnsutils.DecodePayloadLazy(dm, &dnsHeader, d.config); err != nil {
 				d.LogError("%v - %v", err, dm)
 			}
 
+			// the rdata and edns errors are only detected when the records are decoded
+			if d.config.Global.Trace.LogMalformed {
+				if err = dm.DecodeSections(); err != nil {
+					d.LogError("%v - %v", err, dm)
+				}
+			}
+
 			if dm.DNS.MalformedPacket {
 				if d.config.Global.Trace.LogMalformed {
 					d.LogInfo("payload: %v", dm.DNS.Payload)
@@ -178,21 +190,23 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if transforms.ProcessMessage(dm) == transformers.RETURN_DROP {
+				dnsutils.ReleaseDnsMessage(dm)
 				continue
 			}
 
 			// convert latency to human
-			dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+			dm.DnsTap.LatencySec = strconv.FormatFloat(dm.DnsTap.Latency, 'f', 6, 64)
 
 			// dispatch dns message to all generators
 			for i := range loggersChannel {
 				select {
-				case loggersChannel[i] <- dm: // Successful send to logger channel
+				case loggersChannel[i] <- *dm: // Successful send to logger channel
 				default:
 					d.dropped <- loggersName[i]
 				}
 			}
+			dnsutils.ReleaseDnsMessage(dm)
 		}
 	}
 	d.LogInfo("processing terminated")
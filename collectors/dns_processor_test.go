@@ -0,0 +1,99 @@
+package collectors
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func Test_DnsProcessor(t *testing.T) {
+	logger := logger.New(true)
+	var o bytes.Buffer
+	logger.SetOutput(&o)
+
+	// init the processor
+	consumer := NewDnsProcessor(dnsutils.GetFakeConfig(), logger, "test", 512)
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+
+	// prepare dns query
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+	payload, _ := dnsmsg.Pack()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+	consumer.GetChannel() <- dm
+
+	// read dns message from the processor
+	dmOut := <-chan_to
+	if dmOut.DNS.Qname != "www.google.fr" {
+		t.Errorf("invalid qname in dns message: %s", dmOut.DNS.Qname)
+	}
+	if dmOut.DNS.Qdcount != 1 || len(dmOut.DNS.Questions) != 1 {
+		t.Errorf("invalid questions in dns message: %v", dmOut.DNS.Questions)
+	}
+}
+
+// Benchmark_DnsProcessor_SyntheticLoad measures the throughput of the decoding path with
+// traffic from the generator collector, use -benchtime=500000x for a 500k messages load.
+func Benchmark_DnsProcessor_SyntheticLoad(b *testing.B) {
+	logger := logger.New(false)
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Generator.Seed = 1
+	config.Collectors.Generator.Domains = []string{"dnscollector.dev", "google.com", "example.org"}
+	config.Collectors.Generator.RandomSubdomainRatio = 0.2
+	gen := NewGenerator([]dnsutils.Worker{}, config, logger, "bench")
+
+	// prepare a set of queries and replies
+	samples := []dnsutils.DnsMessage{}
+	for len(samples) < 10000 {
+		samples = append(samples, gen.GenerateMessages(time.Now())...)
+	}
+
+	consumer := NewDnsProcessor(config, logger, "bench", 65535)
+	chan_to := make(chan dnsutils.DnsMessage, 65535)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"bench"})
+
+	done := make(chan int)
+	go func() {
+		received := 0
+		idle := time.NewTimer(time.Second)
+		for received < b.N {
+			select {
+			case <-chan_to:
+				received++
+				if received%1000 == 0 {
+					idle.Reset(time.Second)
+				}
+			case <-idle.C:
+				// some messages have been dropped
+				done <- received
+				return
+			}
+		}
+		done <- received
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		consumer.GetChannel() <- samples[i%len(samples)]
+	}
+	received := <-done
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	b.ReportMetric(float64(received)/elapsed.Seconds(), "msg/s")
+	if received != b.N {
+		b.Logf("%d messages dropped", b.N-received)
+	}
+}
@@ -188,12 +188,12 @@ RUN_LOOP:
 				continue
 			}
 
-			// init dns message
-			dm := dnsutils.DnsMessage{}
+			// init dns message, taken from the pool to not allocate it for each packet
+			dm := dnsutils.AcquireDnsMessage()
 			dm.Init()
 
 			// init dns message with additionnals parts
-			transforms.InitDnsMessageFormat(&dm)
+			transforms.InitDnsMessageFormat(dm)
 
 			identity := dt.GetIdentity()
 			if len(identity) > 0 {
@@ -270,7 +270,14 @@ RUN_LOOP:
 				d.LogInfo("dns parser malformed packet: %s", err)
 			}
 
-			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
+			// the resource records are decoded only if needed by a transformer or a logger
+			err = dnsutils.DecodePayloadLazy(dm, &dnsHeader, d.config)
+
+			// the rdata and edns errors are only detected when the records are decoded
+			if err == nil && d.config.Global.Trace.LogMalformed {
+				err = dm.DecodeSections()
+			}
+			if err != nil {
 				// decoding error
 				if d.config.Global.Trace.LogMalformed {
 					d.LogError("%v - %v", err, dm)
@@ -279,21 +286,23 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if transforms.ProcessMessage(dm) == transformers.RETURN_DROP {
+				dnsutils.ReleaseDnsMessage(dm)
 				continue
 			}
 
 			// convert latency to human
-			dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+			dm.DnsTap.LatencySec = strconv.FormatFloat(dm.DnsTap.Latency, 'f', 6, 64)
 
 			// dispatch dns message to connected loggers
 			for i := range loggersChannel {
 				select {
-				case loggersChannel[i] <- dm: // Successful send to logger channel
+				case loggersChannel[i] <- *dm: // Successful send to logger channel
 				default:
 					d.dropped <- loggersName[i]
 				}
 			}
+			dnsutils.ReleaseDnsMessage(dm)
 
 		}
 	}
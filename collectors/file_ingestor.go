@@ -13,6 +13,7 @@ import (
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
 	framestream "github.com/farsightsec/golang-framestream"
 	"github.com/fsnotify/fsnotify"
@@ -27,7 +28,8 @@ func IsValidMode(mode string) bool {
 	switch mode {
 	case
 		dnsutils.MODE_PCAP,
-		dnsutils.MODE_DNSTAP:
+		dnsutils.MODE_DNSTAP,
+		dnsutils.MODE_PROTOBUF:
 		return true
 	}
 	return false
@@ -130,6 +132,12 @@ func (c *FileIngestor) ProcessFile(filePath string) {
 			c.LogInfo("file ready to process %s", filePath)
 			go c.ProcessDnstap(filePath)
 		}
+	case dnsutils.MODE_PROTOBUF:
+		// process protobuf files written by the logfile logger
+		if filepath.Ext(filePath) == ".pb" {
+			c.LogInfo("file ready to process %s", filePath)
+			go c.ProcessProtobuf(filePath)
+		}
 	}
 }
 
@@ -337,6 +345,57 @@ func (c *FileIngestor) ProcessDnstap(filePath string) error {
 	return nil
 }
 
+func (c *FileIngestor) ProcessProtobuf(filePath string) error {
+	// open the file
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the dns messages are already decoded, only the transformers are applied
+	loggersChannel, _ := c.Loggers()
+	subprocessors := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, loggersChannel, 0)
+
+	fileName := filepath.Base(filePath)
+	c.LogInfo("processing protobuf file [%s]", fileName)
+
+	reader := dnsutils.NewProtobufReader(f)
+	for {
+		dm := dnsutils.DnsMessage{}
+		err := reader.Read(&dm)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			c.LogError("unable to decode protobuf message: %s", err)
+			break
+		}
+
+		subprocessors.InitDnsMessageFormat(&dm)
+		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			continue
+		}
+
+		for i := range loggersChannel {
+			loggersChannel[i] <- dm
+		}
+	}
+	subprocessors.Reset()
+
+	// remove it ?
+	c.LogInfo("processing of [%s] terminated", fileName)
+	if c.config.Collectors.FileIngestor.DeleteAfter {
+		c.LogInfo("delete file [%s]", fileName)
+		os.Remove(filePath)
+	}
+
+	// remove event timer for this file
+	c.RemoveEvent(filePath)
+
+	return nil
+}
+
 func (c *FileIngestor) RegisterEvent(filePath string) {
 	// Get timer.
 	c.mu.Lock()
@@ -399,6 +458,11 @@ func (c *FileIngestor) Run() {
 			if filepath.Ext(fn) == ".fstrm" {
 				go c.ProcessDnstap(fn)
 			}
+		case dnsutils.MODE_PROTOBUF:
+			// process protobuf
+			if filepath.Ext(fn) == ".pb" {
+				go c.ProcessProtobuf(fn)
+			}
 		}
 	}
 
@@ -1,6 +1,8 @@
 package collectors
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -30,3 +32,34 @@ func Test_FileIngestor_Pcap(t *testing.T) {
 		}
 	}
 }
+
+func Test_FileIngestor_Protobuf(t *testing.T) {
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+
+	// write a protobuf file with an enriched message
+	dir := t.TempDir()
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Suspicious = &dnsutils.TransformSuspicious{Score: 2.0}
+	data, err := dm.ToProtobufDelimited()
+	if err != nil {
+		t.Fatalf("unable to encode message: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dnscollector.pb"), data, 0644); err != nil {
+		t.Fatalf("unable to write protobuf file: %v", err)
+	}
+
+	// watch the temporary folder
+	config.Collectors.FileIngestor.WatchDir = dir
+	config.Collectors.FileIngestor.WatchMode = dnsutils.MODE_PROTOBUF
+
+	// init collector
+	c := NewFileIngestor([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// waiting message in channel
+	msg := <-g.Channel()
+	if msg.DNS.Qname != dm.DNS.Qname || msg.Suspicious == nil || msg.Suspicious.Score != 2.0 {
+		t.Errorf("invalid message: %+v", msg)
+	}
+}
@@ -1,7 +1,6 @@
 package collectors
 
 import (
-	"fmt"
 	"io"
 	"os"
 	"regexp"
@@ -12,7 +11,6 @@ import (
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
 	"github.com/hpcloud/tail"
-	"github.com/miekg/dns"
 )
 
 type Tail struct {
@@ -230,31 +228,17 @@ func (c *Tail) Run() {
 		dm.DnsTap.Timestamp = ts.UnixNano()
 		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
 
-		// fake dns packet
-		dnspkt := new(dns.Msg)
-		var dnstype uint16
-		dnstype = dns.TypeA
-		if dm.DNS.Qtype == "AAAA" {
-			dnstype = dns.TypeAAAA
+		// encode the dns packet from the extracted fields, the message is
+		// forwarded without payload if the fields can not be encoded
+		payload, err := dnsutils.EncodeDns(&dm)
+		if err != nil {
+			c.LogError("unable to encode the dns packet of the line: %v", err)
 		}
-		dnspkt.SetQuestion(dm.DNS.Qname, dnstype)
-
-		if dm.DNS.Type == dnsutils.DnsReply {
-			rr, _ := dns.NewRR(fmt.Sprintf("%s %s 0.0.0.0", dm.DNS.Qname, dm.DNS.Qtype))
-			if err == nil {
-				dnspkt.Answer = append(dnspkt.Answer, rr)
-			}
-			var rcode int
-			rcode = 0
-			if dm.DNS.Rcode == "NXDOMAIN" {
-				rcode = 3
-			}
-			dnspkt.Rcode = rcode
+		dm.DNS.Payload = payload
+		if len(payload) > 0 {
+			dm.DNS.Length = len(payload)
 		}
 
-		dm.DNS.Payload, _ = dnspkt.Pack()
-		dm.DNS.Length = len(dm.DNS.Payload)
-
 		// apply all enabled transformers
 		if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
 			continue
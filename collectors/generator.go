@@ -0,0 +1,445 @@
+package collectors
+
+import (
+	"math"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+const (
+	GENERATOR_DISTRIB_UNIFORM     = "uniform"
+	GENERATOR_DISTRIB_ZIPF        = "zipf"
+	GENERATOR_DISTRIB_EXPONENTIAL = "exponential"
+
+	generatorBatchSize = 1000
+)
+
+var (
+	generatorDefaultQtypes = map[string]int{"A": 60, "AAAA": 25, "HTTPS": 5, "TXT": 4, "MX": 3, "PTR": 3}
+	generatorDefaultRcodes = map[string]int{"NOERROR": 90, "NXDOMAIN": 8, "SERVFAIL": 2}
+	generatorDgaTlds       = []string{"com", "net", "org", "info", "biz", "ru", "top", "xyz"}
+	generatorLabelChars    = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// weightedChoice picks a string according to the relative weights
+// provided in the configuration (qtypes, rcodes...)
+type weightedChoice struct {
+	items  []string
+	cumuls []int
+	total  int
+}
+
+func newWeightedChoice(weights map[string]int) weightedChoice {
+	w := weightedChoice{}
+
+	// sort the keys to keep the draws reproducible with a fixed seed
+	keys := make([]string, 0, len(weights))
+	for k := range weights {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if weights[k] <= 0 {
+			continue
+		}
+		w.total += weights[k]
+		w.items = append(w.items, k)
+		w.cumuls = append(w.cumuls, w.total)
+	}
+	return w
+}
+
+func (w *weightedChoice) Pick(rnd *rand.Rand) string {
+	if w.total == 0 {
+		return ""
+	}
+	n := rnd.Intn(w.total)
+	i := sort.SearchInts(w.cumuls, n+1)
+	return w.items[i]
+}
+
+type Generator struct {
+	done          chan bool
+	exit          chan bool
+	loggers       []dnsutils.Worker
+	config        *dnsutils.Config
+	logger        *logger.Logger
+	name          string
+	identity      string
+	rnd           *rand.Rand
+	zipf          *rand.Zipf
+	qtypes        weightedChoice
+	rcodes        weightedChoice
+	clientSubnets []netip.Prefix
+	serverIp      string
+	serverIp6     string
+}
+
+func NewGenerator(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Generator {
+	logger.Info("[%s] collector=generator - enabled", name)
+	s := &Generator{
+		done:    make(chan bool),
+		exit:    make(chan bool),
+		config:  config,
+		loggers: loggers,
+		logger:  logger,
+		name:    name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *Generator) GetName() string { return c.name }
+
+func (c *Generator) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *Generator) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *Generator) ReadConfig() {
+	cfg := c.config.Collectors.Generator
+
+	c.identity = c.config.GetServerIdentity()
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	c.rnd = rand.New(rand.NewSource(seed))
+
+	if len(cfg.Domains) == 0 {
+		c.logger.Fatal("collector=generator - at least one domain must be provided")
+	}
+
+	switch cfg.QnameDistribution {
+	case GENERATOR_DISTRIB_UNIFORM:
+	case GENERATOR_DISTRIB_ZIPF:
+		c.zipf = rand.NewZipf(c.rnd, 1.1, 1, uint64(len(cfg.Domains)-1))
+	default:
+		c.logger.Fatal("collector=generator - invalid qname distribution: ", cfg.QnameDistribution)
+	}
+
+	switch cfg.LatencyDistribution {
+	case GENERATOR_DISTRIB_UNIFORM, GENERATOR_DISTRIB_EXPONENTIAL:
+	default:
+		c.logger.Fatal("collector=generator - invalid latency distribution: ", cfg.LatencyDistribution)
+	}
+	if cfg.LatencyMin < 0 || cfg.LatencyMax < cfg.LatencyMin {
+		c.logger.Fatal("collector=generator - invalid latency range")
+	}
+
+	switch cfg.Protocol {
+	case dnsutils.PROTO_UDP, dnsutils.PROTO_TCP:
+	default:
+		c.logger.Fatal("collector=generator - invalid protocol: ", cfg.Protocol)
+	}
+
+	// qtypes and rcodes mix, use the built-in one if not provided
+	qtypes := cfg.Qtypes
+	if len(qtypes) == 0 {
+		qtypes = generatorDefaultQtypes
+	}
+	for qtype := range qtypes {
+		if _, ok := dns.StringToType[qtype]; !ok {
+			c.logger.Fatal("collector=generator - invalid qtype: ", qtype)
+		}
+	}
+	c.qtypes = newWeightedChoice(qtypes)
+
+	rcodes := cfg.Rcodes
+	if len(rcodes) == 0 {
+		rcodes = generatorDefaultRcodes
+	}
+	for rcode := range rcodes {
+		if _, ok := dns.StringToRcode[rcode]; !ok {
+			c.logger.Fatal("collector=generator - invalid rcode: ", rcode)
+		}
+	}
+	c.rcodes = newWeightedChoice(rcodes)
+
+	// pool of client ips
+	c.clientSubnets = nil
+	for _, subnet := range cfg.ClientSubnets {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			addr, err := netip.ParseAddr(subnet)
+			if err != nil {
+				c.logger.Fatal("collector=generator - invalid client subnet: ", subnet)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		c.clientSubnets = append(c.clientSubnets, prefix.Masked())
+	}
+	if len(c.clientSubnets) == 0 {
+		c.logger.Fatal("collector=generator - at least one client subnet must be provided")
+	}
+
+	c.serverIp = cfg.ServerIp
+	c.serverIp6 = cfg.ServerIp6
+}
+
+func (c *Generator) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=generator - "+msg, v...)
+}
+
+func (c *Generator) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=generator - "+msg, v...)
+}
+
+func (c *Generator) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *Generator) Stop() {
+	c.LogInfo("stopping...")
+
+	// exit to close properly
+	c.exit <- true
+
+	// read done channel and block until run is terminated
+	<-c.done
+	close(c.done)
+}
+
+func (c *Generator) randomLabel(minLen, maxLen int) string {
+	n := minLen + c.rnd.Intn(maxLen-minLen+1)
+	var b strings.Builder
+	b.Grow(n)
+	for i := 0; i < n; i++ {
+		b.WriteByte(generatorLabelChars[c.rnd.Intn(len(generatorLabelChars))])
+	}
+	return b.String()
+}
+
+// GenerateQname returns a qname according to the configured mix:
+// a DGA-like name, a random subdomain of a known domain (water torture)
+// or a plain domain picked from the list.
+func (c *Generator) GenerateQname() string {
+	cfg := c.config.Collectors.Generator
+
+	if cfg.DgaRatio > 0 && c.rnd.Float64() < cfg.DgaRatio {
+		return c.randomLabel(10, 24) + "." + generatorDgaTlds[c.rnd.Intn(len(generatorDgaTlds))]
+	}
+
+	var domain string
+	if c.zipf != nil {
+		domain = cfg.Domains[c.zipf.Uint64()]
+	} else {
+		domain = cfg.Domains[c.rnd.Intn(len(cfg.Domains))]
+	}
+
+	if cfg.RandomSubdomainRatio > 0 && c.rnd.Float64() < cfg.RandomSubdomainRatio {
+		return c.randomLabel(8, 16) + "." + domain
+	}
+	return domain
+}
+
+// GenerateClientIp picks a random address in one of the client subnets
+func (c *Generator) GenerateClientIp() netip.Addr {
+	prefix := c.clientSubnets[c.rnd.Intn(len(c.clientSubnets))]
+	addr := prefix.Addr().AsSlice()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+
+	// randomize host bits, starting from the last byte
+	for i := len(addr) - 1; i >= 0 && hostBits > 0; i-- {
+		mask := byte(0xff)
+		if hostBits < 8 {
+			mask = byte(1<<hostBits) - 1
+		}
+		addr[i] |= byte(c.rnd.Intn(256)) & mask
+		hostBits -= 8
+	}
+
+	ip, _ := netip.AddrFromSlice(addr)
+	return ip
+}
+
+// GenerateLatency returns a latency in seconds
+func (c *Generator) GenerateLatency() float64 {
+	cfg := c.config.Collectors.Generator
+	var latency float64
+	switch cfg.LatencyDistribution {
+	case GENERATOR_DISTRIB_EXPONENTIAL:
+		// most of the values are close to the minimum, with a long tail
+		latency = cfg.LatencyMin + c.rnd.ExpFloat64()*(cfg.LatencyMax-cfg.LatencyMin)/4
+		latency = math.Min(latency, cfg.LatencyMax)
+	default:
+		latency = cfg.LatencyMin + c.rnd.Float64()*(cfg.LatencyMax-cfg.LatencyMin)
+	}
+	return latency / 1000
+}
+
+func (c *Generator) addAnswer(reply *dns.Msg, qname string, qtype uint16) {
+	hdr := dns.RR_Header{Name: dns.Fqdn(qname), Rrtype: qtype, Class: dns.ClassINET, Ttl: uint32(60 + c.rnd.Intn(3540))}
+	switch qtype {
+	case dns.TypeA:
+		ip := netip.AddrFrom4([4]byte{198, 51, 100, byte(c.rnd.Intn(256))})
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: ip.AsSlice()})
+	case dns.TypeAAAA:
+		ip := netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: byte(c.rnd.Intn(256))})
+		reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip.AsSlice()})
+	case dns.TypeTXT:
+		reply.Answer = append(reply.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"v=spf1 -all"}})
+	case dns.TypeMX:
+		reply.Answer = append(reply.Answer, &dns.MX{Hdr: hdr, Preference: 10, Mx: dns.Fqdn("mail." + qname)})
+	case dns.TypePTR:
+		reply.Answer = append(reply.Answer, &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn("host." + qname)})
+	}
+}
+
+// GenerateMessages builds a synthetic query and, if enabled, the related reply
+// as they would be seen on the wire by a sniffer.
+func (c *Generator) GenerateMessages(ts time.Time) []dnsutils.DnsMessage {
+	cfg := c.config.Collectors.Generator
+
+	qname := c.GenerateQname()
+	qtype := dns.StringToType[c.qtypes.Pick(c.rnd)]
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(qname), qtype)
+	query.Id = uint16(c.rnd.Intn(65536))
+
+	clientIp := c.GenerateClientIp()
+	clientPort := strconv.Itoa(1024 + c.rnd.Intn(64512))
+	family, serverIp := dnsutils.PROTO_IPV4, c.serverIp
+	if clientIp.Is6() {
+		family, serverIp = dnsutils.PROTO_IPV6, c.serverIp6
+	}
+
+	payload, err := query.Pack()
+	if err != nil {
+		c.LogError("unable to pack query: %v", err)
+		return nil
+	}
+
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	dm.DnsTap.Identity = c.identity
+	dm.NetworkInfo.Family = family
+	dm.NetworkInfo.Protocol = cfg.Protocol
+	dm.NetworkInfo.QueryIp = clientIp.String()
+	dm.NetworkInfo.QueryPort = clientPort
+	dm.NetworkInfo.ResponseIp = serverIp
+	dm.NetworkInfo.ResponsePort = "53"
+	dm.DnsTap.TimeSec = int(ts.Unix())
+	dm.DnsTap.TimeNsec = ts.Nanosecond()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	msgs := []dnsutils.DnsMessage{dm}
+	if !cfg.WithReplies {
+		return msgs
+	}
+
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	reply.RecursionAvailable = true
+	reply.Rcode = dns.StringToRcode[c.rcodes.Pick(c.rnd)]
+	if reply.Rcode == dns.RcodeSuccess {
+		c.addAnswer(reply, qname, qtype)
+	}
+
+	payload, err = reply.Pack()
+	if err != nil {
+		c.LogError("unable to pack reply: %v", err)
+		return msgs
+	}
+
+	// source and destination are reversed, as seen on the wire
+	tsReply := ts.Add(time.Duration(c.GenerateLatency() * float64(time.Second)))
+	dmReply := dm
+	dmReply.NetworkInfo.QueryIp = serverIp
+	dmReply.NetworkInfo.QueryPort = "53"
+	dmReply.NetworkInfo.ResponseIp = clientIp.String()
+	dmReply.NetworkInfo.ResponsePort = clientPort
+	dmReply.DnsTap.TimeSec = int(tsReply.Unix())
+	dmReply.DnsTap.TimeNsec = tsReply.Nanosecond()
+	dmReply.DNS.Payload = payload
+	dmReply.DNS.Length = len(payload)
+
+	return append(msgs, dmReply)
+}
+
+func (c *Generator) Run() {
+	c.LogInfo("starting collector...")
+	cfg := c.config.Collectors.Generator
+
+	dnsProcessor := NewDnsProcessor(c.config, c.logger, c.name, cfg.ChannelBufferSize)
+	go dnsProcessor.Run(c.Loggers())
+
+	stopGen := make(chan bool)
+	doneGen := make(chan bool)
+
+	go func() {
+		defer close(doneGen)
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		start := time.Now()
+		sent := 0
+		for {
+			// with a rate equal to zero, generate as fast as possible
+			if cfg.Rate > 0 {
+				select {
+				case <-stopGen:
+					return
+				case <-ticker.C:
+				}
+			}
+
+			// number of queries expected since the start
+			due := generatorBatchSize
+			if cfg.Rate > 0 {
+				due = int(time.Since(start).Seconds()*float64(cfg.Rate)) - sent
+			}
+			if cfg.MaxQueries > 0 && sent+due > cfg.MaxQueries {
+				due = cfg.MaxQueries - sent
+			}
+
+			for i := 0; i < due; i++ {
+				for _, dm := range c.GenerateMessages(time.Now()) {
+					select {
+					case <-stopGen:
+						return
+					case dnsProcessor.GetChannel() <- dm:
+					}
+				}
+				sent++
+			}
+
+			if cfg.MaxQueries > 0 && sent >= cfg.MaxQueries {
+				c.LogInfo("%d queries generated, max reached", sent)
+				return
+			}
+		}
+	}()
+
+	<-c.exit
+
+	// stop the generator then the dns processor
+	close(stopGen)
+	<-doneGen
+	dnsProcessor.Stop()
+
+	c.LogInfo("run terminated")
+	c.done <- true
+}
@@ -0,0 +1,81 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_Generator_Run(t *testing.T) {
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Generator.Rate = 0
+	config.Collectors.Generator.MaxQueries = 10
+	config.Collectors.Generator.Seed = 1
+	config.Collectors.Generator.Domains = []string{"dnscollector.dev"}
+	config.Collectors.Generator.Qtypes = map[string]int{"AAAA": 1}
+	config.Collectors.Generator.Rcodes = map[string]int{"NXDOMAIN": 1}
+
+	// init collector
+	c := NewGenerator([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// one query and one reply per generated transaction
+	for i := 0; i < 20; i++ {
+		select {
+		case dm := <-g.Channel():
+			if dm.DNS.Qname != "dnscollector.dev" {
+				t.Errorf("invalid qname, got %s", dm.DNS.Qname)
+			}
+			if dm.DNS.Qtype != "AAAA" {
+				t.Errorf("invalid qtype, got %s", dm.DNS.Qtype)
+			}
+			if dm.DNS.Type == dnsutils.DnsReply && dm.DNS.Rcode != "NXDOMAIN" {
+				t.Errorf("invalid rcode, got %s", dm.DNS.Rcode)
+			}
+			if !strings.HasPrefix(dm.NetworkInfo.QueryIp, "192.168.0.") {
+				t.Errorf("invalid query ip, got %s", dm.NetworkInfo.QueryIp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout, only %d messages received", i)
+		}
+	}
+
+	c.Stop()
+}
+
+func Test_Generator_Qnames(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Generator.Seed = 1
+	config.Collectors.Generator.Domains = []string{"dnscollector.dev"}
+	config.Collectors.Generator.RandomSubdomainRatio = 1
+
+	c := NewGenerator([]dnsutils.Worker{}, config, logger.New(false), "test")
+	qname := c.GenerateQname()
+	if !strings.HasSuffix(qname, ".dnscollector.dev") || qname == "dnscollector.dev" {
+		t.Errorf("random subdomain expected, got %s", qname)
+	}
+
+	config.Collectors.Generator.DgaRatio = 1
+	qname = c.GenerateQname()
+	if strings.HasSuffix(qname, "dnscollector.dev") || strings.Count(qname, ".") != 1 {
+		t.Errorf("dga-like domain expected, got %s", qname)
+	}
+}
+
+func Test_Generator_ClientIp(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Generator.ClientSubnets = []string{"10.0.0.0/30", "2001:db8::/126"}
+
+	c := NewGenerator([]dnsutils.Worker{}, config, logger.New(false), "test")
+	for i := 0; i < 100; i++ {
+		ip := c.GenerateClientIp()
+		if !c.clientSubnets[0].Contains(ip) && !c.clientSubnets[1].Contains(ip) {
+			t.Errorf("ip %s not in the client subnets", ip)
+		}
+	}
+}
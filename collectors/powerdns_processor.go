@@ -165,12 +165,12 @@ RUN_LOOP:
 				continue
 			}
 
-			// init dns message
-			dm := dnsutils.DnsMessage{}
+			// init dns message, taken from the pool to not allocate it for each packet
+			dm := dnsutils.AcquireDnsMessage()
 			dm.Init()
 
 			// init dns message with additionnals parts
-			transforms.InitDnsMessageFormat(&dm)
+			transforms.InitDnsMessageFormat(dm)
 
 			// init powerdns with default values
 			dm.PowerDns = &dnsutils.PowerDns{
@@ -212,7 +212,7 @@ RUN_LOOP:
 
 				// convert latency to human
 				dm.DnsTap.Latency = tsReply - tsQuery
-				dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+				dm.DnsTap.LatencySec = strconv.FormatFloat(dm.DnsTap.Latency, 'f', 6, 64)
 				dm.DNS.Rcode = dnsutils.RcodeToString(int(pbdm.Response.GetRcode()))
 			}
 
@@ -367,18 +367,20 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if transforms.ProcessMessage(dm) == transformers.RETURN_DROP {
+				dnsutils.ReleaseDnsMessage(dm)
 				continue
 			}
 
 			// dispatch dns messages to connected loggers
 			for i := range loggersChannel {
 				select {
-				case loggersChannel[i] <- dm: // Successful send to logger channel
+				case loggersChannel[i] <- *dm: // Successful send to logger channel
 				default:
 					d.dropped <- loggersName[i]
 				}
 			}
+			dnsutils.ReleaseDnsMessage(dm)
 		}
 	}
 	d.LogInfo("processing terminated")
@@ -0,0 +1,313 @@
+package collectors
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+// ProtobufReceiver receives the stream of DNS messages sent by the tcpclient logger
+// in protobuf mode, each message is prefixed by its length encoded as a varint.
+type ProtobufReceiver struct {
+	doneRun      chan bool
+	doneMonitor  chan bool
+	stopMonitor  chan bool
+	listen       net.Listener
+	connId       int
+	conns        []net.Conn
+	handlers     sync.WaitGroup
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	droppedCount int
+	dropped      chan int
+	sync.RWMutex
+}
+
+func NewProtobufReceiver(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *ProtobufReceiver {
+	logger.Info("[%s] protobuf collector - enabled", name)
+	s := &ProtobufReceiver{
+		doneRun:     make(chan bool),
+		doneMonitor: make(chan bool),
+		stopMonitor: make(chan bool),
+		dropped:     make(chan int),
+		config:      config,
+		loggers:     loggers,
+		logger:      logger,
+		name:        name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *ProtobufReceiver) GetName() string { return c.name }
+
+func (c *ProtobufReceiver) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *ProtobufReceiver) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *ProtobufReceiver) ReadConfig() {
+	if !dnsutils.IsValidTLS(c.config.Collectors.Protobuf.TlsMinVersion) {
+		c.logger.Fatal("collector=protobuf - invalid tls min version")
+	}
+}
+
+func (c *ProtobufReceiver) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=protobuf - "+msg, v...)
+}
+
+func (c *ProtobufReceiver) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=protobuf - "+msg, v...)
+}
+
+func (c *ProtobufReceiver) LogConnInfo(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=protobuf#%d - ", c.name, connId)
+	c.logger.Info(prefix+msg, v...)
+}
+
+func (c *ProtobufReceiver) LogConnError(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=protobuf#%d - ", c.name, connId)
+	c.logger.Error(prefix+msg, v...)
+}
+
+func (c *ProtobufReceiver) HandleConn(conn net.Conn) {
+	// close connection on function exit
+	defer conn.Close()
+	defer c.handlers.Done()
+
+	var connId int
+	c.Lock()
+	c.connId++
+	connId = c.connId
+	c.Unlock()
+
+	// get peer address
+	peer := conn.RemoteAddr().String()
+	c.LogConnInfo(connId, "new connection from %s", peer)
+
+	// the dns messages are already decoded by the sender, only the transformers are applied
+	loggersChannel, _ := c.Loggers()
+	transforms := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, loggersChannel, connId)
+
+	reader := dnsutils.NewProtobufReader(conn)
+	for {
+		dm := dnsutils.DnsMessage{}
+		err := reader.Read(&dm)
+		if err != nil {
+			connClosed := false
+
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				if errors.Is(opErr, net.ErrClosed) {
+					connClosed = true
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				connClosed = true
+			}
+
+			if connClosed {
+				c.LogConnInfo(connId, "connection closed with peer %s", peer)
+			} else {
+				c.LogConnError(connId, "protobuf reader error: %s", err)
+			}
+			break
+		}
+
+		// apply all enabled transformers
+		if transforms.ApplyTransforms(&dm) == transformers.RETURN_DROP {
+			continue
+		}
+
+		// dispatch dns messages to connected loggers
+		for i := range loggersChannel {
+			select {
+			case loggersChannel[i] <- dm: // Successful send to logger channel
+			default:
+				c.dropped <- 1
+			}
+		}
+	}
+	transforms.Reset()
+
+	// here the connection is closed,
+	// then removes the current connection from the list
+	c.Lock()
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			conn = nil
+		}
+	}
+	c.Unlock()
+
+	c.LogConnInfo(connId, "connection handler terminated")
+}
+
+func (c *ProtobufReceiver) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *ProtobufReceiver) Stop() {
+	c.LogInfo("stopping...")
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	c.Lock()
+	for _, conn := range c.conns {
+		peer := conn.RemoteAddr().String()
+		c.LogInfo("%s - closing connection...", peer)
+		netlib.Close(conn, c.config.Collectors.Protobuf.ResetConn)
+	}
+
+	// Finally close the listener to unblock accept
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+	c.Unlock()
+
+	// wait for the connection handlers before to close the dropped channel
+	c.handlers.Wait()
+
+	// stop monitor goroutine
+	c.LogInfo("stopping monitor...")
+	c.stopMonitor <- true
+	<-c.doneMonitor
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	<-c.doneRun
+	close(c.doneRun)
+}
+
+func (c *ProtobufReceiver) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Collectors.Protobuf.ListenIP + ":" + strconv.Itoa(c.config.Collectors.Protobuf.ListenPort)
+
+	// listening with tls enabled ?
+	if c.config.Collectors.Protobuf.TlsSupport {
+		c.LogInfo("tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(c.config.Collectors.Protobuf.CertFile, c.config.Collectors.Protobuf.KeyFile)
+		if err != nil {
+			c.logger.Fatal("loading certificate failed:", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = dnsutils.TLS_VERSION[c.config.Collectors.Protobuf.TlsMinVersion]
+
+		listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+	} else {
+		listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+	}
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s", listener.Addr())
+	c.listen = listener
+	return nil
+}
+
+func (c *ProtobufReceiver) MonitorCollector() {
+	watchInterval := 10 * time.Second
+	bufferFull := time.NewTimer(watchInterval)
+MONITOR_LOOP:
+	for {
+		select {
+		case <-c.stopMonitor:
+			close(c.dropped)
+			bufferFull.Stop()
+			c.doneMonitor <- true
+			break MONITOR_LOOP
+		case <-c.dropped:
+			c.droppedCount++
+		case <-bufferFull.C:
+			if c.droppedCount > 0 {
+				c.LogError("recv buffer is full, %d packet(s) dropped", c.droppedCount)
+				c.droppedCount = 0
+			}
+			bufferFull.Reset(watchInterval)
+		}
+	}
+	c.LogInfo("monitor terminated")
+}
+
+func (c *ProtobufReceiver) Run() {
+
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			prefixlog := fmt.Sprintf("[%s] ", c.name)
+			c.logger.Fatal(prefixlog+"collector=protobuf listening failed: ", err)
+		}
+	}
+
+	// start goroutine to count dropped messsages
+	go c.MonitorCollector()
+
+	for {
+		// Accept() blocks waiting for new connection.
+		conn, err := c.listen.Accept()
+		if err != nil {
+			break
+		}
+
+		if c.config.Collectors.Protobuf.RcvBufSize > 0 {
+			before, actual, err := netlib.SetSock_RCVBUF(
+				conn,
+				c.config.Collectors.Protobuf.RcvBufSize,
+				c.config.Collectors.Protobuf.TlsSupport,
+			)
+			if err != nil {
+				c.logger.Fatal("Unable to set SO_RCVBUF: ", err)
+			}
+			c.LogInfo("set SO_RCVBUF option, value before: %d, desired: %d, actual: %d",
+				before,
+				c.config.Collectors.Protobuf.RcvBufSize,
+				actual)
+		}
+
+		c.Lock()
+		c.conns = append(c.conns, conn)
+		c.handlers.Add(1)
+		c.Unlock()
+		go c.HandleConn(conn)
+
+	}
+
+	c.LogInfo("run terminated")
+	c.doneRun <- true
+}
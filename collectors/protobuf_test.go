@@ -0,0 +1,56 @@
+package collectors
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func TestProtobufReceiver_Run(t *testing.T) {
+	g := loggers.NewFakeLogger()
+
+	c := NewProtobufReceiver([]dnsutils.Worker{g}, dnsutils.GetFakeConfig(), logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector protobuf listening error: ", err)
+	}
+	go c.Run()
+
+	conn, err := net.Dial(dnsutils.SOCKET_TCP, ":6002")
+	if err != nil {
+		t.Fatal("could not connect to TCP server: ", err)
+	}
+	defer conn.Close()
+
+	// send two messages, as the tcpclient logger in protobuf mode
+	qnames := []string{"dns.collector", "dnscollector.dev"}
+	for _, qname := range qnames {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		dm.Suspicious = &dnsutils.TransformSuspicious{Score: 2.0}
+		data, err := dm.ToProtobufDelimited()
+		if err != nil {
+			t.Fatalf("unable to encode message: %v", err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("unable to send message: %v", err)
+		}
+	}
+
+	for _, qname := range qnames {
+		select {
+		case dm := <-g.Channel():
+			if dm.DNS.Qname != qname {
+				t.Errorf("qname error want %s, got: %s", qname, dm.DNS.Qname)
+			}
+			if dm.Suspicious == nil || dm.Suspicious.Score != 2.0 {
+				t.Errorf("suspicious score not decoded: %v", dm.Suspicious)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+}
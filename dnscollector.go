@@ -135,7 +135,6 @@ func main() {
 		// load config
 		cfg := make(map[string]interface{})
 		cfg["loggers"] = output.Params
-		cfg["outgoing-transformers"] = make(map[string]interface{})
 		for _, p := range output.Params {
 			p.(map[string]interface{})["enable"] = true
 		}
@@ -145,10 +144,11 @@ func main() {
 		subcfg.SetDefault()
 
 		// add transformer
-		for k, v := range output.Transforms {
-			v.(map[string]interface{})["enable"] = true
-			cfg["outgoing-transformers"].(map[string]interface{})[k] = v
+		transforms, err := output.GetTransformersConfig()
+		if err != nil {
+			panic(fmt.Sprintf("main - yaml logger config error: %v", err))
 		}
+		cfg["outgoing-transformers"] = transforms
 
 		// copy global config
 		subcfg.Global = config.Global
@@ -215,7 +215,6 @@ func main() {
 		// load config
 		cfg := make(map[string]interface{})
 		cfg["collectors"] = input.Params
-		cfg["ingoing-transformers"] = make(map[string]interface{})
 		for _, p := range input.Params {
 			p.(map[string]interface{})["enable"] = true
 		}
@@ -225,10 +224,11 @@ func main() {
 		subcfg.SetDefault()
 
 		// add transformer
-		for k, v := range input.Transforms {
-			v.(map[string]interface{})["enable"] = true
-			cfg["ingoing-transformers"].(map[string]interface{})[k] = v
+		transforms, err := input.GetTransformersConfig()
+		if err != nil {
+			panic(fmt.Sprintf("main - yaml collector config error: %v", err))
 		}
+		cfg["ingoing-transformers"] = transforms
 
 		// copy global config
 		subcfg.Global = config.Global
@@ -260,9 +260,15 @@ func main() {
 		if subcfg.Collectors.PowerDNS.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewProtobufPowerDNS(nil, subcfg, logger, input.Name)
 		}
+		if subcfg.Collectors.Protobuf.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewProtobufReceiver(nil, subcfg, logger, input.Name)
+		}
 		if subcfg.Collectors.FileIngestor.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewFileIngestor(nil, subcfg, logger, input.Name)
 		}
+		if subcfg.Collectors.Generator.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewGenerator(nil, subcfg, logger, input.Name)
+		}
 		if subcfg.Collectors.Tzsp.Enable {
 			mapCollectors[input.Name] = collectors.NewTzsp(nil, subcfg, logger, input.Name)
 		}
@@ -1,7 +1,9 @@
 package dnsutils
 
 import (
+	"fmt"
 	"os"
+	"regexp"
 
 	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v3"
@@ -30,23 +32,89 @@ func IsValidTLS(mode string) bool {
 	return false
 }
 
+// label names of the messages, valid for prometheus and loki
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func IsValidLabelName(name string) bool {
+	return labelNameRegex.MatchString(name)
+}
+
+// SanitizeLabelName replaces the invalid characters of a label name by underscores
+func SanitizeLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
 type MultiplexInOut struct {
 	Name       string                 `yaml:"name"`
-	Transforms map[string]interface{} `yaml:"transforms"`
+	Transforms interface{}            `yaml:"transforms"`
 	Params     map[string]interface{} `yaml:",inline"`
 }
 
+// GetTransformersConfig returns the configuration of the transformers, defined as a map
+// to use the default order or as a list to apply them in order, one transformer per item.
+// The same transformer can be used several times in a list.
+func (m *MultiplexInOut) GetTransformersConfig() (map[string]interface{}, error) {
+	cfg := make(map[string]interface{})
+
+	switch transforms := m.Transforms.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range transforms {
+			cfg[k] = enableTransformer(v)
+		}
+	case []interface{}:
+		pipeline := []interface{}{}
+		for _, item := range transforms {
+			transform, ok := item.(map[string]interface{})
+			if !ok || len(transform) != 1 {
+				return nil, fmt.Errorf("%s - one transformer expected per item of the transforms list", m.Name)
+			}
+			for k, v := range transform {
+				pipeline = append(pipeline, map[string]interface{}{k: enableTransformer(v)})
+			}
+		}
+		cfg["pipeline"] = pipeline
+	default:
+		return nil, fmt.Errorf("%s - transforms must be a map or a list", m.Name)
+	}
+	return cfg, nil
+}
+
+func enableTransformer(params interface{}) map[string]interface{} {
+	p, ok := params.(map[string]interface{})
+	if !ok {
+		p = make(map[string]interface{})
+	}
+	p["enable"] = true
+	return p
+}
+
 type MultiplexRoutes struct {
 	Src []string `yaml:"from,flow"`
 	Dst []string `yaml:"to,flow"`
 }
 
 type ConfigTransformers struct {
+	// transformers applied in order, each item enables one transformer
+	Pipeline    []ConfigTransformers `yaml:"pipeline"`
 	UserPrivacy struct {
-		Enable        bool `yaml:"enable"`
-		AnonymizeIP   bool `yaml:"anonymize-ip"`
-		MinimazeQname bool `yaml:"minimaze-qname"`
-		HashIP        bool `yaml:"hash-ip"`
+		Enable                bool   `yaml:"enable"`
+		AnonymizeIP           bool   `yaml:"anonymize-ip"`
+		AnonymizeV4Bits       int    `yaml:"anonymize-v4bits"`
+		AnonymizeV6Bits       int    `yaml:"anonymize-v6bits"`
+		PrefixPreserving      bool   `yaml:"prefix-preserving"`
+		AnonymizeResponseIP   bool   `yaml:"anonymize-response-ip"`
+		AnonymizeRdata        bool   `yaml:"anonymize-rdata"`
+		AnonymizeClientSubnet bool   `yaml:"anonymize-client-subnet"`
+		KeyFile               string `yaml:"key-file"`
+		MinimazeQname         bool   `yaml:"minimaze-qname"`
+		HashIP                bool   `yaml:"hash-ip"`
 	} `yaml:"user-privacy"`
 	Normalize struct {
 		Enable         bool `yaml:"enable"`
@@ -54,18 +122,25 @@ type ConfigTransformers struct {
 		QuietText      bool `yaml:"quiet-text"`
 		AddTld         bool `yaml:"add-tld"`
 		AddTldPlusOne  bool `yaml:"add-tld-plus-one"`
+		AddUnicode     bool `yaml:"add-unicode"`
 	} `yaml:"normalize"`
 	Latency struct {
 		Enable            bool `yaml:"enable"`
 		MeasureLatency    bool `yaml:"measure-latency"`
 		UnansweredQueries bool `yaml:"unanswered-queries"`
+		Transaction       bool `yaml:"transaction"`
 		QueriesTimeout    int  `yaml:"queries-timeout"`
+		MaxTrackedQueries int  `yaml:"max-tracked-queries"`
 	}
 	Reducer struct {
-		Enable                    bool `yaml:"enable"`
-		RepetitiveTrafficDetector bool `yaml:"repetitive-traffic-detector"`
-		QnamePlusOne              bool `yaml:"qname-plus-one"`
-		WatchInterval             int  `yaml:"watch-interval"`
+		Enable                    bool     `yaml:"enable"`
+		RepetitiveTrafficDetector bool     `yaml:"repetitive-traffic-detector"`
+		QnamePlusOne              bool     `yaml:"qname-plus-one"`
+		WatchInterval             int      `yaml:"watch-interval"`
+		Keys                      []string `yaml:"keys,flow"`
+		Window                    string   `yaml:"window"`
+		MaxSessionLength          int      `yaml:"max-session-length"`
+		WindowStep                int      `yaml:"window-step"`
 	}
 	Filtering struct {
 		Enable          bool     `yaml:"enable"`
@@ -80,12 +155,18 @@ type ConfigTransformers struct {
 		LogQueries      bool     `yaml:"log-queries"`
 		LogReplies      bool     `yaml:"log-replies"`
 		Downsample      int      `yaml:"downsample"`
+		SamplingRate    float64  `yaml:"sampling-rate"`
+		SamplingKeys    []string `yaml:"sampling-keys,flow"`
+		DropExpression  string   `yaml:"drop-expression"`
+		KeepExpression  string   `yaml:"keep-expression"`
 	} `yaml:"filtering"`
 	GeoIP struct {
-		Enable        bool   `yaml:"enable"`
-		DbCountryFile string `yaml:"mmdb-country-file"`
-		DbCityFile    string `yaml:"mmdb-city-file"`
-		DbAsnFile     string `yaml:"mmdb-asn-file"`
+		Enable           bool   `yaml:"enable"`
+		DbCountryFile    string `yaml:"mmdb-country-file"`
+		DbCityFile       string `yaml:"mmdb-city-file"`
+		DbAsnFile        string `yaml:"mmdb-asn-file"`
+		LookupResponseIp bool   `yaml:"lookup-response-ip"`
+		LookupAnswers    bool   `yaml:"lookup-answers"`
 	} `yaml:"geoip"`
 	Suspicious struct {
 		Enable             bool     `yaml:"enable"`
@@ -96,18 +177,78 @@ type ConfigTransformers struct {
 		UnallowedChars     []string `yaml:"unallowed-chars,flow"`
 		ThresholdMaxLabels int      `yaml:"threshold-max-labels"`
 		WhitelistDomains   []string `yaml:"whitelist-domains,flow"`
+		// stateful detectors, per registered domain and time window
+		Window                    int                `yaml:"window"`
+		ThresholdUniqueSubdomains int                `yaml:"threshold-unique-subdomains"`
+		ThresholdTxtNullQueries   int                `yaml:"threshold-txt-null-queries"`
+		ThresholdRandomSubdomains int                `yaml:"threshold-random-subdomains"`
+		ThresholdEntropy          float64            `yaml:"threshold-entropy"`
+		ThresholdNgramLikelihood  float64            `yaml:"threshold-ngram-likelihood"`
+		Weights                   map[string]float64 `yaml:"weights"`
 	} `yaml:"suspicious"`
 	Extract struct {
 		Enable     bool `yaml:"enable"`
 		AddPayload bool `yaml:"add-payload"`
 	} `yaml:"extract"`
 	MachineLearning struct {
-		Enable      bool `yaml:"enable"`
-		AddFeatures bool `yaml:"add-features"`
+		Enable      bool   `yaml:"enable"`
+		AddFeatures bool   `yaml:"add-features"`
+		ModelFile   string `yaml:"model-file"`
 	} `yaml:"machine-learning"`
+	Rpz struct {
+		Enable bool            `yaml:"enable"`
+		Zones  []ConfigRpzZone `yaml:"zones"`
+	} `yaml:"rpz"`
+	SubnetLabels struct {
+		Enable bool     `yaml:"enable"`
+		Files  []string `yaml:"files,flow"`
+	} `yaml:"subnet-labels"`
+	ClientNames struct {
+		Enable            bool     `yaml:"enable"`
+		DhcpdLeaseFiles   []string `yaml:"dhcpd-lease-files,flow"`
+		KeaLeaseFiles     []string `yaml:"kea-lease-files,flow"`
+		DnsmasqLeaseFiles []string `yaml:"dnsmasq-lease-files,flow"`
+		HostsFiles        []string `yaml:"hosts-files,flow"`
+		PtrLookup         bool     `yaml:"ptr-lookup"`
+		PtrResolver       string   `yaml:"ptr-resolver"`
+		PtrTimeout        int      `yaml:"ptr-timeout"`
+		PtrCacheTtl       int      `yaml:"ptr-cache-ttl"`
+		PtrCacheSize      int      `yaml:"ptr-cache-size"`
+	} `yaml:"client-names"`
+	Rewrite struct {
+		Enable     bool                     `yaml:"enable"`
+		Operations []ConfigRewriteOperation `yaml:"operations"`
+	} `yaml:"rewrite"`
+}
+
+// ConfigRpzZone is a response policy zone loaded from a zone file
+type ConfigRpzZone struct {
+	Name string `yaml:"name"`
+	File string `yaml:"file"`
+}
+
+// ConfigRewriteOperation is an operation of the rewrite transformer on a field
+// of the flattened dns message (dns.qname, labels.site, ...)
+type ConfigRewriteOperation struct {
+	Action      string `yaml:"action"`
+	Field       string `yaml:"field"`
+	Value       string `yaml:"value"`
+	Target      string `yaml:"target"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	Length      int    `yaml:"length"`
+}
+
+// UnmarshalYAML sets the default values before decoding,
+// needed for the transformers defined in the pipeline list
+func (c *ConfigTransformers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ConfigTransformers
+	c.SetDefault()
+	return unmarshal((*plain)(c))
 }
 
 func (c *ConfigTransformers) SetDefault() {
+	c.Pipeline = nil
 	c.Suspicious.Enable = false
 	c.Suspicious.ThresholdQnameLen = 100
 	c.Suspicious.ThresholdPacketLen = 1000
@@ -117,9 +258,23 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Suspicious.UnallowedChars = []string{"\"", "==", "/", ":"}
 	c.Suspicious.ThresholdMaxLabels = 10
 	c.Suspicious.WhitelistDomains = []string{"\\.ip6\\.arpa"}
+	c.Suspicious.Window = 60
+	c.Suspicious.ThresholdUniqueSubdomains = 0
+	c.Suspicious.ThresholdTxtNullQueries = 0
+	c.Suspicious.ThresholdRandomSubdomains = 0
+	c.Suspicious.ThresholdEntropy = 0
+	c.Suspicious.ThresholdNgramLikelihood = 0
+	c.Suspicious.Weights = map[string]float64{}
 
 	c.UserPrivacy.Enable = false
 	c.UserPrivacy.AnonymizeIP = false
+	c.UserPrivacy.AnonymizeV4Bits = 16
+	c.UserPrivacy.AnonymizeV6Bits = 64
+	c.UserPrivacy.PrefixPreserving = false
+	c.UserPrivacy.AnonymizeResponseIP = false
+	c.UserPrivacy.AnonymizeRdata = false
+	c.UserPrivacy.AnonymizeClientSubnet = false
+	c.UserPrivacy.KeyFile = ""
 	c.UserPrivacy.MinimazeQname = false
 	c.UserPrivacy.HashIP = false
 
@@ -128,16 +283,23 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Normalize.QuietText = false
 	c.Normalize.AddTld = false
 	c.Normalize.AddTldPlusOne = false
+	c.Normalize.AddUnicode = false
 
 	c.Latency.Enable = false
 	c.Latency.MeasureLatency = false
 	c.Latency.UnansweredQueries = false
+	c.Latency.Transaction = false
 	c.Latency.QueriesTimeout = 2
+	c.Latency.MaxTrackedQueries = 100000
 
 	c.Reducer.Enable = false
 	c.Reducer.RepetitiveTrafficDetector = false
 	c.Reducer.QnamePlusOne = false
 	c.Reducer.WatchInterval = 5
+	c.Reducer.Keys = []string{"dnstap.identity", "dnstap.operation", "network.query-ip", "dns.qname", "dns.qtype"}
+	c.Reducer.Window = "tumbling"
+	c.Reducer.MaxSessionLength = 60
+	c.Reducer.WindowStep = 1
 
 	c.Filtering.Enable = false
 	c.Filtering.DropFqdnFile = ""
@@ -149,17 +311,44 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Filtering.LogQueries = true
 	c.Filtering.LogReplies = true
 	c.Filtering.Downsample = 0
+	c.Filtering.SamplingRate = 1
+	c.Filtering.SamplingKeys = []string{}
+	c.Filtering.DropExpression = ""
+	c.Filtering.KeepExpression = ""
 
 	c.GeoIP.Enable = false
 	c.GeoIP.DbCountryFile = ""
 	c.GeoIP.DbCityFile = ""
 	c.GeoIP.DbAsnFile = ""
+	c.GeoIP.LookupResponseIp = false
+	c.GeoIP.LookupAnswers = false
 
 	c.Extract.Enable = false
 	c.Extract.AddPayload = false
 
 	c.MachineLearning.Enable = false
 	c.MachineLearning.AddFeatures = false
+	c.MachineLearning.ModelFile = ""
+
+	c.Rpz.Enable = false
+	c.Rpz.Zones = []ConfigRpzZone{}
+
+	c.SubnetLabels.Enable = false
+	c.SubnetLabels.Files = []string{}
+
+	c.ClientNames.Enable = false
+	c.ClientNames.DhcpdLeaseFiles = []string{}
+	c.ClientNames.KeaLeaseFiles = []string{}
+	c.ClientNames.DnsmasqLeaseFiles = []string{}
+	c.ClientNames.HostsFiles = []string{}
+	c.ClientNames.PtrLookup = false
+	c.ClientNames.PtrResolver = "127.0.0.1:53"
+	c.ClientNames.PtrTimeout = 2
+	c.ClientNames.PtrCacheTtl = 3600
+	c.ClientNames.PtrCacheSize = 10000
+
+	c.Rewrite.Enable = false
+	c.Rewrite.Operations = []ConfigRewriteOperation{}
 }
 
 /* main configuration */
@@ -234,6 +423,17 @@ type Config struct {
 			ResetConn         bool   `yaml:"reset-conn"`
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
 		} `yaml:"powerdns"`
+		Protobuf struct {
+			Enable        bool   `yaml:"enable"`
+			ListenIP      string `yaml:"listen-ip"`
+			ListenPort    int    `yaml:"listen-port"`
+			TlsSupport    bool   `yaml:"tls-support"`
+			TlsMinVersion string `yaml:"tls-min-version"`
+			CertFile      string `yaml:"cert-file"`
+			KeyFile       string `yaml:"key-file"`
+			RcvBufSize    int    `yaml:"sock-rcvbuf"`
+			ResetConn     bool   `yaml:"reset-conn"`
+		} `yaml:"protobuf"`
 		FileIngestor struct {
 			Enable            bool   `yaml:"enable"`
 			WatchDir          string `yaml:"watch-dir"`
@@ -248,6 +448,27 @@ type Config struct {
 			ListenPort        int    `yaml:"listen-port"`
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
 		}
+		Generator struct {
+			Enable               bool           `yaml:"enable"`
+			Rate                 int            `yaml:"rate"`
+			MaxQueries           int            `yaml:"max-queries"`
+			Seed                 int64          `yaml:"seed"`
+			Domains              []string       `yaml:"domains,flow"`
+			QnameDistribution    string         `yaml:"qname-distribution"`
+			RandomSubdomainRatio float64        `yaml:"random-subdomain-ratio"`
+			DgaRatio             float64        `yaml:"dga-ratio"`
+			Qtypes               map[string]int `yaml:"qtypes"`
+			Rcodes               map[string]int `yaml:"rcodes"`
+			ClientSubnets        []string       `yaml:"client-subnets,flow"`
+			ServerIp             string         `yaml:"server-ip"`
+			ServerIp6            string         `yaml:"server-ip6"`
+			Protocol             string         `yaml:"protocol"`
+			WithReplies          bool           `yaml:"with-replies"`
+			LatencyDistribution  string         `yaml:"latency-distribution"`
+			LatencyMin           float64        `yaml:"latency-min"`
+			LatencyMax           float64        `yaml:"latency-max"`
+			ChannelBufferSize    int            `yaml:"chan-buffer-size"`
+		} `yaml:"generator"`
 	} `yaml:"collectors"`
 
 	IngoingTransformers ConfigTransformers `yaml:"ingoing-transformers"`
@@ -397,6 +618,7 @@ type Config struct {
 			BasicAuthPwdFile  string            `yaml:"basic-auth-pwd-file"`
 			TenantId          string            `yaml:"tenant-id"`
 			RelabelConfigs    []*relabel.Config `yaml:"relabel-configs"`
+			StreamLabels      []string          `yaml:"stream-labels,flow"`
 			ChannelBufferSize int               `yaml:"chan-buffer-size"`
 		} `yaml:"lokiclient"`
 		Statsd struct {
@@ -556,6 +778,16 @@ func (c *Config) SetDefault() {
 	c.Collectors.PowerDNS.ResetConn = true
 	c.Collectors.PowerDNS.ChannelBufferSize = 65535
 
+	c.Collectors.Protobuf.Enable = false
+	c.Collectors.Protobuf.ListenIP = ANY_IP
+	c.Collectors.Protobuf.ListenPort = 6002
+	c.Collectors.Protobuf.TlsSupport = false
+	c.Collectors.Protobuf.TlsMinVersion = TLS_v12
+	c.Collectors.Protobuf.CertFile = ""
+	c.Collectors.Protobuf.KeyFile = ""
+	c.Collectors.Protobuf.RcvBufSize = 0
+	c.Collectors.Protobuf.ResetConn = true
+
 	c.Collectors.FileIngestor.Enable = false
 	c.Collectors.FileIngestor.WatchDir = ""
 	c.Collectors.FileIngestor.PcapDnsPort = 53
@@ -568,6 +800,26 @@ func (c *Config) SetDefault() {
 	c.Collectors.Tzsp.ListenPort = 10000
 	c.Collectors.Tzsp.ChannelBufferSize = 65535
 
+	c.Collectors.Generator.Enable = false
+	c.Collectors.Generator.Rate = 100
+	c.Collectors.Generator.MaxQueries = 0
+	c.Collectors.Generator.Seed = 0
+	c.Collectors.Generator.Domains = []string{"dnscollector.dev"}
+	c.Collectors.Generator.QnameDistribution = "uniform"
+	c.Collectors.Generator.RandomSubdomainRatio = 0
+	c.Collectors.Generator.DgaRatio = 0
+	c.Collectors.Generator.Qtypes = nil
+	c.Collectors.Generator.Rcodes = nil
+	c.Collectors.Generator.ClientSubnets = []string{"192.168.0.0/24"}
+	c.Collectors.Generator.ServerIp = "192.0.2.53"
+	c.Collectors.Generator.ServerIp6 = "2001:db8::53"
+	c.Collectors.Generator.Protocol = PROTO_UDP
+	c.Collectors.Generator.WithReplies = true
+	c.Collectors.Generator.LatencyDistribution = "uniform"
+	c.Collectors.Generator.LatencyMin = 1
+	c.Collectors.Generator.LatencyMax = 50
+	c.Collectors.Generator.ChannelBufferSize = 65535
+
 	// Transformers for collectors
 	c.IngoingTransformers.SetDefault()
 
@@ -703,6 +955,7 @@ func (c *Config) SetDefault() {
 	c.Loggers.LokiClient.BasicAuthPwd = ""
 	c.Loggers.LokiClient.BasicAuthPwdFile = ""
 	c.Loggers.LokiClient.TenantId = ""
+	c.Loggers.LokiClient.StreamLabels = []string{}
 	c.Loggers.LokiClient.ChannelBufferSize = 65535
 
 	c.Loggers.Statsd.Enable = false
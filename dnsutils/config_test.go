@@ -0,0 +1,112 @@
+package dnsutils
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfig_GetTransformersConfig(t *testing.T) {
+	testcases := []struct {
+		name       string
+		transforms string
+		expected   map[string]interface{}
+		err        bool
+	}{
+		{
+			name:       "undefined",
+			transforms: "",
+			expected:   map[string]interface{}{},
+		},
+		{
+			name:       "map",
+			transforms: "transforms:\n  normalize:\n    qname-lowercase: true\n  suspicious:\n",
+			expected: map[string]interface{}{
+				"normalize":  map[string]interface{}{"qname-lowercase": true, "enable": true},
+				"suspicious": map[string]interface{}{"enable": true},
+			},
+		},
+		{
+			name:       "list",
+			transforms: "transforms:\n  - user-privacy:\n      hash-ip: true\n  - filtering:\n      log-queries: false\n  - user-privacy:\n      minimaze-qname: true\n",
+			expected: map[string]interface{}{
+				"pipeline": []interface{}{
+					map[string]interface{}{"user-privacy": map[string]interface{}{"hash-ip": true, "enable": true}},
+					map[string]interface{}{"filtering": map[string]interface{}{"log-queries": false, "enable": true}},
+					map[string]interface{}{"user-privacy": map[string]interface{}{"minimaze-qname": true, "enable": true}},
+				},
+			},
+		},
+		{
+			name:       "list-invalid",
+			transforms: "transforms:\n  - user-privacy:\n      hash-ip: true\n    filtering:\n      log-queries: false\n",
+			err:        true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			inout := MultiplexInOut{Name: "test"}
+			if err := yaml.Unmarshal([]byte(tc.transforms), &inout); err != nil {
+				t.Fatalf("unable to decode yaml: %v", err)
+			}
+
+			cfg, err := inout.GetTransformersConfig()
+			if tc.err {
+				if err == nil {
+					t.Errorf("error expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, tc.expected) {
+				t.Errorf("want %v, got %v", tc.expected, cfg)
+			}
+		})
+	}
+}
+
+func TestConfig_TransformersPipeline_Defaults(t *testing.T) {
+	config := &ConfigTransformers{}
+	config.SetDefault()
+
+	data := "pipeline:\n  - suspicious:\n      enable: true\n  - suspicious:\n      enable: true\n      threshold-qname-len: 4\n"
+	if err := yaml.Unmarshal([]byte(data), config); err != nil {
+		t.Fatalf("unable to decode yaml: %v", err)
+	}
+
+	if len(config.Pipeline) != 2 {
+		t.Fatalf("two transformers expected, got %d", len(config.Pipeline))
+	}
+	if config.Pipeline[0].Suspicious.ThresholdQnameLen != 100 || config.Pipeline[1].Suspicious.ThresholdQnameLen != 4 {
+		t.Errorf("invalid thresholds: %d, %d", config.Pipeline[0].Suspicious.ThresholdQnameLen, config.Pipeline[1].Suspicious.ThresholdQnameLen)
+	}
+	if len(config.Pipeline[0].Suspicious.CommonQtypes) == 0 {
+		t.Errorf("default values expected in the pipeline")
+	}
+}
+
+func TestConfig_LabelName(t *testing.T) {
+	testcases := []struct {
+		name      string
+		valid     bool
+		sanitized string
+	}{
+		{"site", true, "site"},
+		{"device_owner2", true, "device_owner2"},
+		{"device-owner", false, "device_owner"},
+		{"site.name", false, "site_name"},
+		{"1vlan", false, "_vlan"},
+	}
+	for _, tc := range testcases {
+		if IsValidLabelName(tc.name) != tc.valid {
+			t.Errorf("%s: valid label name want %v", tc.name, tc.valid)
+		}
+		if sanitized := SanitizeLabelName(tc.name); sanitized != tc.sanitized || !IsValidLabelName(sanitized) {
+			t.Errorf("%s: sanitized label name want %s, got %s", tc.name, tc.sanitized, sanitized)
+		}
+	}
+}
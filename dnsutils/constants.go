@@ -23,6 +23,7 @@ const (
 	MODE_FLATJSON = "flat-json"
 	MODE_PCAP     = "pcap"
 	MODE_DNSTAP   = "dnstap"
+	MODE_PROTOBUF = "protobuf"
 
 	SASL_MECHANISM_PLAIN = "PLAIN"
 	SASL_MECHANISM_SCRAM = "SCRAM-SHA-512"
@@ -31,6 +32,10 @@ const (
 	DNS_RCODE_SERVFAIL = "SERVFAIL"
 	DNS_RCODE_TIMEOUT  = "TIMEOUT"
 
+	OPCODE_QUERY  = 0
+	OPCODE_NOTIFY = 4
+	OPCODE_UPDATE = 5
+
 	DNSTAP_OPERATION_QUERY = "QUERY"
 	DNSTAP_OPERATION_REPLY = "REPLY"
 
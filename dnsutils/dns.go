@@ -1,11 +1,16 @@
 package dnsutils
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
+	"time"
 )
 
 const DnsLen = 12
@@ -61,7 +66,7 @@ var (
 		49:    "DHCID",
 		50:    "NSEC3",
 		51:    "NSEC3PARAM",
-		52:    "TSLA",
+		52:    "TLSA",
 		53:    "SMIMEA",
 		55:    "HIP",
 		56:    "NINFO",
@@ -69,6 +74,7 @@ var (
 		60:    "CDNSKEY",
 		61:    "OPENPGPKEY",
 		62:    "CSYNC",
+		63:    "ZONEMD",
 		64:    "SVCB",
 		65:    "HTTPS",
 		99:    "SPF",
@@ -113,6 +119,16 @@ var (
 	}
 )
 
+var (
+	Classes = map[int]string{
+		1:   "IN",
+		3:   "CH",
+		4:   "HS",
+		254: "NONE",
+		255: "ANY",
+	}
+)
+
 var ErrDecodeDnsHeaderTooShort = errors.New("malformed pkt, dns payload too short to decode header")
 var ErrDecodeDnsLabelTooLong = errors.New("malformed pkt, label too long")
 var ErrDecodeDnsLabelInvalidData = errors.New("malformed pkt, invalid label length byte")
@@ -122,6 +138,7 @@ var ErrDecodeDnsLabelTooShort = errors.New("malformed pkt, dns payload too short
 var ErrDecodeQuestionQtypeTooShort = errors.New("malformed pkt, not enough data to decode qtype")
 var ErrDecodeDnsAnswerTooShort = errors.New("malformed pkt, not enough data to decode answer")
 var ErrDecodeDnsAnswerRdataTooShort = errors.New("malformed pkt, not enough data to decode rdata answer")
+var ErrDecodeDnsAnswerRdataInvalid = errors.New("malformed pkt, invalid rdata answer")
 
 func RdatatypeToString(rrtype int) string {
 	if value, ok := Rdatatypes[rrtype]; ok {
@@ -130,6 +147,15 @@ func RdatatypeToString(rrtype int) string {
 	return UNKNOWN
 }
 
+// ClassToString returns the mnemonic of the class or the generic
+// CLASSnnn notation (RFC 3597) for unknown classes
+func ClassToString(class int) string {
+	if value, ok := Classes[class]; ok {
+		return value
+	}
+	return fmt.Sprintf("CLASS%d", class)
+}
+
 func RcodeToString(rcode int) string {
 	if value, ok := Rcodes[rcode]; ok {
 		return value
@@ -137,6 +163,34 @@ func RcodeToString(rcode int) string {
 	return UNKNOWN
 }
 
+// symbols interns the names of the types, classes, rcodes, protocols and operations,
+// repeated in every message: they are shared instead of allocated for each decoded message
+var symbols = func() map[string]string {
+	m := map[string]string{}
+	for _, table := range []map[int]string{Rdatatypes, Rcodes, Classes} {
+		for _, v := range table {
+			m[v] = v
+		}
+	}
+	for _, v := range []string{UNKNOWN, "-", DnsQuery, DnsReply,
+		DNSTAP_CLIENT_QUERY, DNSTAP_CLIENT_RESPONSE, DNSTAP_OPERATION_QUERY, DNSTAP_OPERATION_REPLY,
+		"AUTH_QUERY", "AUTH_RESPONSE", "RESOLVER_QUERY", "RESOLVER_RESPONSE", "FORWARDER_QUERY", "FORWARDER_RESPONSE",
+		"STUB_QUERY", "STUB_RESPONSE", "TOOL_QUERY", "TOOL_RESPONSE", "UPDATE_QUERY", "UPDATE_RESPONSE",
+		PROTO_INET, PROTO_INET6, PROTO_IPV4, PROTO_IPV6, PROTO_UDP, PROTO_TCP, PROTO_DOT, PROTO_DOH} {
+		m[v] = v
+	}
+	return m
+}()
+
+// internSymbol returns the shared string of a known symbol (qtype, rcode, ...),
+// the value is copied otherwise
+func internSymbol(b []byte) string {
+	if v, ok := symbols[string(b)]; ok {
+		return v
+	}
+	return string(b)
+}
+
 // error returned if decoding of DNS packet payload fails.
 type decodingError struct {
 	part string
@@ -228,6 +282,18 @@ func DecodeDns(payload []byte) (DnsHeader, error) {
 // Error is returned if packet can not be parsed. Returned error wraps the
 // original error returned by relevant decoding operation.
 func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
+	return decodePayload(dm, header, false)
+}
+
+// DecodePayloadLazy decodes the header and the questions like DecodePayload, the
+// resource records are only checked to be well delimited in the payload. The
+// records and the EDNS options are decoded by DecodeSections, when a consumer needs
+// them. Errors in the rdata or in the EDNS options are detected at this time.
+func DecodePayloadLazy(dm *DnsMessage, header *DnsHeader, config *Config) error {
+	return decodePayload(dm, header, true)
+}
+
+func decodePayload(dm *DnsMessage, header *DnsHeader, lazy bool) error {
 	if dm.DNS.MalformedPacket {
 		// do not continue if packet is malformed, the header can not be
 		// trusted.
@@ -239,10 +305,10 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	dm.DNS.Opcode = header.Opcode
 
 	// update dnstap operation if the opcode is equal to 5 (dns update)
-	if dm.DNS.Opcode == 5 && header.Qr == 1 {
+	if dm.DNS.Opcode == OPCODE_UPDATE && header.Qr == 0 {
 		dm.DnsTap.Operation = "UPDATE_QUERY"
 	}
-	if dm.DNS.Opcode == 5 && header.Qr == 0 {
+	if dm.DNS.Opcode == OPCODE_UPDATE && header.Qr == 1 {
 		dm.DnsTap.Operation = "UPDATE_RESPONSE"
 	}
 
@@ -261,28 +327,126 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	if header.Ad == 1 {
 		dm.DNS.Flags.AD = true
 	}
+	if header.Rd == 1 {
+		dm.DNS.Flags.RD = true
+	}
+	if header.Cd == 1 {
+		dm.DNS.Flags.CD = true
+	}
+	if header.Z == 1 {
+		dm.DNS.Flags.Z = true
+	}
+
+	dm.DNS.Qdcount = header.Qdcount
+	dm.DNS.Ancount = header.Ancount
+	dm.DNS.Nscount = header.Nscount
+	dm.DNS.Arcount = header.Arcount
 
 	var payload_offset int
-	// decode DNS question
+	// decode DNS question, qname/qtype/qclass are taken from the last one
+	// all of them are available in the questions list
 	if header.Qdcount > 0 {
-		dns_qname, dns_rrtype, offsetrr, err := DecodeQuestion(header.Qdcount, dm.DNS.Payload)
+		questions, offsetrr, err := DecodeQuestions(header.Qdcount, dm.DNS.Payload)
 		if err != nil {
 			dm.DNS.MalformedPacket = true
 			return &decodingError{part: "query", err: err}
 		}
 
-		dm.DNS.Qname = dns_qname
-		dm.DNS.Qtype = RdatatypeToString(dns_rrtype)
+		last := questions[len(questions)-1]
+		dm.DNS.Questions = questions
+		dm.DNS.Qname = last.Qname
+		dm.DNS.Qtype = last.Qtype
+		dm.DNS.Qclass = last.Qclass
 		payload_offset = offsetrr
 	}
 
+	// the summaries of dynamic updates, notifies and zone transfers need the records
+	summary := dm.DNS.Opcode == OPCODE_UPDATE || dm.DNS.Opcode == OPCODE_NOTIFY ||
+		dm.DNS.Qtype == "AXFR" || dm.DNS.Qtype == "IXFR"
+
+	if header.Ancount+header.Nscount+header.Arcount > 0 {
+		if lazy && !summary {
+			if err := checkSections(dm, header, payload_offset); err != nil {
+				return err
+			}
+			dm.sections = &dnsSections{
+				counts:  [3]uint16{uint16(header.Ancount), uint16(header.Nscount), uint16(header.Arcount)},
+				opcode:  uint8(header.Opcode),
+				tc:      header.Tc == 1,
+				offset:  payload_offset,
+				payload: dm.DNS.Payload,
+			}
+			return nil
+		}
+		if err := decodeSections(dm, header, payload_offset); err != nil {
+			return err
+		}
+	}
+
+	// summarize dynamic updates, notifies and zone transfers
+	switch {
+	case dm.DNS.Opcode == OPCODE_UPDATE:
+		dm.DNS.Update = DecodeUpdate(dm)
+	case dm.DNS.Opcode == OPCODE_NOTIFY:
+		dm.DNS.Notify = DecodeNotify(dm)
+	case dm.DNS.Qtype == "AXFR" || dm.DNS.Qtype == "IXFR":
+		dm.DNS.Transfer = DecodeTransfer(dm)
+	}
+	return nil
+}
+
+// isTruncatedError returns true for the errors expected when the end of a truncated packet is decoded
+func isTruncatedError(err error) bool {
+	return errors.Is(err, ErrDecodeDnsAnswerTooShort) ||
+		errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) ||
+		errors.Is(err, ErrDecodeDnsLabelTooShort)
+}
+
+// checkSections checks that the resource records are well delimited in the payload,
+// without decoding them. The truncated packets are marked as malformed.
+func checkSections(dm *DnsMessage, header *DnsHeader, offset int) error {
+	parts := []struct {
+		name  string
+		count int
+	}{
+		{"answer records", header.Ancount},
+		{"authority records", header.Nscount},
+		{"additional records", header.Arcount},
+	}
+
+	var err error
+	for _, part := range parts {
+		if part.count == 0 {
+			continue
+		}
+		offset, err = skipResourceRecords(part.count, offset, dm.DNS.Payload)
+		if err == nil {
+			continue
+		}
+		dm.DNS.MalformedPacket = true
+		if dm.DNS.Flags.TC && isTruncatedError(err) {
+			return nil
+		}
+		return &decodingError{part: part.name, err: err}
+	}
+	return nil
+}
+
+// decodeSections decodes the answer, authority and additional sections and the EDNS options
+func decodeSections(dm *DnsMessage, header *DnsHeader, payload_offset int) error {
+	// the UPDATE message allows empty rdata in the prerequisite and update sections
+	decodeRecords := DecodeAnswer
+	if header.Opcode == OPCODE_UPDATE {
+		decodeRecords = DecodeUpdateRecords
+	}
+
 	// decode DNS answers
 	if header.Ancount > 0 {
-		answers, offset, err := DecodeAnswer(header.Ancount, payload_offset, dm.DNS.Payload)
+		answers, offset, err := decodeRecords(header.Ancount, payload_offset, dm.DNS.Payload)
 		if err == nil {
 			dm.DNS.DnsRRs.Answers = answers
 			payload_offset = offset
-		} else if dm.DNS.Flags.TC && (errors.Is(err, ErrDecodeDnsAnswerTooShort) || errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) || errors.Is(err, ErrDecodeDnsLabelTooShort)) {
+		} else if dm.DNS.Flags.TC && isTruncatedError(err) {
 			dm.DNS.MalformedPacket = true
 			dm.DNS.DnsRRs.Answers = answers
 			payload_offset = offset
@@ -294,10 +458,10 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 
 	// decode authoritative answers
 	if header.Nscount > 0 {
-		if answers, offsetrr, err := DecodeAnswer(header.Nscount, payload_offset, dm.DNS.Payload); err == nil {
+		if answers, offsetrr, err := decodeRecords(header.Nscount, payload_offset, dm.DNS.Payload); err == nil {
 			dm.DNS.DnsRRs.Nameservers = answers
 			payload_offset = offsetrr
-		} else if dm.DNS.Flags.TC && (errors.Is(err, ErrDecodeDnsAnswerTooShort) || errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) || errors.Is(err, ErrDecodeDnsLabelTooShort)) {
+		} else if dm.DNS.Flags.TC && isTruncatedError(err) {
 			dm.DNS.MalformedPacket = true
 			dm.DNS.DnsRRs.Nameservers = answers
 			payload_offset = offsetrr
@@ -308,10 +472,10 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	}
 	if header.Arcount > 0 {
 		// decode additional answers
-		answers, _, err := DecodeAnswer(header.Arcount, payload_offset, dm.DNS.Payload)
+		answers, _, err := decodeRecords(header.Arcount, payload_offset, dm.DNS.Payload)
 		if err == nil {
 			dm.DNS.DnsRRs.Records = answers
-		} else if dm.DNS.Flags.TC && (errors.Is(err, ErrDecodeDnsAnswerTooShort) || errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) || errors.Is(err, ErrDecodeDnsLabelTooShort)) {
+		} else if dm.DNS.Flags.TC && isTruncatedError(err) {
 			dm.DNS.MalformedPacket = true
 			dm.DNS.DnsRRs.Records = answers
 		} else {
@@ -322,9 +486,7 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 		edns, _, err := DecodeEDNS(header.Arcount, payload_offset, dm.DNS.Payload)
 		if err == nil {
 			dm.EDNS = edns
-		} else if dm.DNS.Flags.TC && (errors.Is(err, ErrDecodeDnsAnswerTooShort) ||
-			errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) ||
-			errors.Is(err, ErrDecodeDnsLabelTooShort) ||
+		} else if dm.DNS.Flags.TC && (isTruncatedError(err) ||
 			errors.Is(err, ErrDecodeEdnsDataTooShort) ||
 			errors.Is(err, ErrDecodeEdnsOptionTooShort)) {
 			dm.DNS.MalformedPacket = true
@@ -360,22 +522,51 @@ func DecodeQuestion(qdcount int, payload []byte) (string, int, int, error) {
 		// If there are more than one query, we will return only the last
 		// qname, qtype for now. We will parse them all to allow further
 		// processing the packet from right offset.
+		// Use DecodeQuestions to get all of them.
 		var err error
-		// Decode QNAME
-		qname, offset, err = ParseLabels(offset, payload)
+		qname, qtype, _, offset, err = parseQuestion(offset, payload)
 		if err != nil {
 			return "", 0, 0, err
 		}
+	}
+	return qname, qtype, offset, nil
+}
 
-		// decode QTYPE and support invalid packet, some abuser sends it...
-		if len(payload[offset:]) < 4 {
-			return "", 0, 0, ErrDecodeQuestionQtypeTooShort
-		} else {
-			qtype = int(binary.BigEndian.Uint16(payload[offset : offset+2]))
-			offset += 4
+// DecodeQuestions decodes all the questions of the packet and
+// returns the offset of the answer section
+func DecodeQuestions(qdcount int, payload []byte) ([]DnsQuestion, int, error) {
+	offset := DnsLen
+	questions := make([]DnsQuestion, 0, 1)
+
+	for i := 0; i < qdcount; i++ {
+		qname, qtype, qclass, next, err := parseQuestion(offset, payload)
+		if err != nil {
+			return questions, offset, err
 		}
+		questions = append(questions, DnsQuestion{
+			Qname:  qname,
+			Qtype:  RdatatypeToString(qtype),
+			Qclass: ClassToString(qclass),
+		})
+		offset = next
 	}
-	return qname, qtype, offset, nil
+	return questions, offset, nil
+}
+
+func parseQuestion(offset int, payload []byte) (string, int, int, int, error) {
+	// Decode QNAME
+	qname, offset, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+
+	// decode QTYPE and QCLASS and support invalid packet, some abuser sends it...
+	if len(payload[offset:]) < 4 {
+		return "", 0, 0, 0, ErrDecodeQuestionQtypeTooShort
+	}
+	qtype := int(binary.BigEndian.Uint16(payload[offset : offset+2]))
+	qclass := int(binary.BigEndian.Uint16(payload[offset+2 : offset+4]))
+	return qname, qtype, qclass, offset + 4, nil
 }
 
 /*
@@ -406,8 +597,27 @@ PTR can be used on NAME for compression
 */
 
 func DecodeAnswer(ancount int, start_offset int, payload []byte) ([]DnsAnswer, int, error) {
+	return decodeResourceRecords(ancount, start_offset, payload, false)
+}
+
+// DecodeUpdateRecords decodes resource records of an UPDATE message (RFC 2136),
+// empty rdata are allowed and rendered with the "-" value
+func DecodeUpdateRecords(count int, start_offset int, payload []byte) ([]DnsAnswer, int, error) {
+	return decodeResourceRecords(count, start_offset, payload, true)
+}
+
+func decodeResourceRecords(ancount int, start_offset int, payload []byte, allowEmptyRdata bool) ([]DnsAnswer, int, error) {
 	offset := start_offset
-	answers := []DnsAnswer{}
+	// preallocate the records, the count from the header can not be trusted
+	// so it is bounded by the minimal size of a record (11 bytes)
+	size := ancount
+	if maxRecords := (len(payload) - offset) / 11; size > maxRecords {
+		size = maxRecords
+	}
+	if size < 0 {
+		size = 0
+	}
+	answers := make([]DnsAnswer, 0, size)
 
 	for i := 0; i < ancount; i++ {
 		// Decode NAME
@@ -443,9 +653,12 @@ func DecodeAnswer(ancount int, start_offset int, payload []byte) ([]DnsAnswer, i
 		}
 		// parse rdata
 		rdatatype := RdatatypeToString(int(t))
-		parsed, err := ParseRdata(rdatatype, rdata, payload[:offset_next+10+int(rdlength)], offset_next+10)
-		if err != nil {
-			return answers, offset, err
+		parsed := "-"
+		if !allowEmptyRdata || rdlength > 0 {
+			parsed, err = ParseRdata(rdatatype, rdata, payload[:offset_next+10+int(rdlength)], offset_next+10)
+			if err != nil {
+				return answers, offset, err
+			}
 		}
 
 		// finnally append answer to the list
@@ -464,12 +677,51 @@ func DecodeAnswer(ancount int, start_offset int, payload []byte) ([]DnsAnswer, i
 	return answers, offset, nil
 }
 
+// skipResourceRecords returns the offset after the records, the names and the
+// lengths are checked but the records are not decoded
+func skipResourceRecords(count int, offset int, payload []byte) (int, error) {
+	for i := 0; i < count; i++ {
+		offset_next, err := skipLabels(offset, payload)
+		if err != nil {
+			return offset, err
+		}
+		if len(payload[offset_next:]) < 10 {
+			return offset, ErrDecodeDnsAnswerTooShort
+		}
+		rdlength := int(binary.BigEndian.Uint16(payload[offset_next+8 : offset_next+10]))
+		if len(payload[offset_next+10:]) < rdlength {
+			return offset, ErrDecodeDnsAnswerRdataTooShort
+		}
+		offset = offset_next + 10 + rdlength
+	}
+	return offset, nil
+}
+
 func ParseLabels(offset int, payload []byte) (string, int, error) {
+	// The name is built in a fixed size buffer (maximum name length, see below)
+	// to have a single allocation when converting to string.
+	var name [255]byte
+	nameLength, endOffset, err := parseLabels(offset, payload, name[:])
+	if err != nil {
+		return "", 0, err
+	}
+	return string(name[:nameLength]), endOffset, nil
+}
+
+// skipLabels returns the offset after the name, the labels are checked but not copied
+func skipLabels(offset int, payload []byte) (int, error) {
+	_, endOffset, err := parseLabels(offset, payload, nil)
+	return endOffset, err
+}
+
+// parseLabels copies the labels of the name in the buffer when provided,
+// returns the length of the name and the offset after the name
+func parseLabels(offset int, payload []byte, name []byte) (int, int, error) {
 	if offset < 0 {
-		return "", 0, ErrDecodeDnsLabelInvalidOffset
+		return 0, 0, ErrDecodeDnsLabelInvalidOffset
 	}
 
-	labels := make([]string, 0, 8)
+	nameLength := 0
 	// Where the current decoding run has started. Set after on every pointer jump.
 	startOffset := offset
 	// Track where the current decoding run is allowed to advance. Set after every pointer jump.
@@ -482,9 +734,9 @@ func ParseLabels(offset int, payload []byte) (string, int, error) {
 
 	for {
 		if offset >= len(payload) {
-			return "", 0, ErrDecodeDnsLabelTooShort
+			return 0, 0, ErrDecodeDnsLabelTooShort
 		} else if offset >= maxOffset {
-			return "", 0, ErrDecodeDnsLabelInvalidPointer
+			return 0, 0, ErrDecodeDnsLabelInvalidPointer
 		}
 
 		length := int(payload[offset])
@@ -495,15 +747,15 @@ func ParseLabels(offset int, payload []byte) (string, int, error) {
 			break
 		} else if length&0xc0 == 0xc0 {
 			if offset+2 > len(payload) {
-				return "", 0, ErrDecodeDnsLabelTooShort
+				return 0, 0, ErrDecodeDnsLabelTooShort
 			} else if offset+2 > maxOffset {
-				return "", 0, ErrDecodeDnsLabelInvalidPointer
+				return 0, 0, ErrDecodeDnsLabelInvalidPointer
 			}
 
 			ptr := int(binary.BigEndian.Uint16(payload[offset:offset+2]) & 16383)
 			if ptr >= startOffset {
 				// Require pointers to always point to prior data (based on a reading of RFC 1035, section 4.1.4).
-				return "", 0, ErrDecodeDnsLabelInvalidPointer
+				return 0, 0, ErrDecodeDnsLabelInvalidPointer
 			}
 
 			if endOffset == -1 {
@@ -514,25 +766,30 @@ func ParseLabels(offset int, payload []byte) (string, int, error) {
 			offset = ptr
 		} else if length&0xc0 == 0x00 {
 			if offset+length+1 > len(payload) {
-				return "", 0, ErrDecodeDnsLabelTooShort
+				return 0, 0, ErrDecodeDnsLabelTooShort
 			} else if offset+length+1 > maxOffset {
-				return "", 0, ErrDecodeDnsLabelInvalidPointer
+				return 0, 0, ErrDecodeDnsLabelInvalidPointer
 			}
 
 			totalLength += length + 1
 			if totalLength > 254 {
-				return "", 0, ErrDecodeDnsLabelTooLong
+				return 0, 0, ErrDecodeDnsLabelTooLong
 			}
 
-			label := payload[offset+1 : offset+length+1]
-			labels = append(labels, string(label))
+			if name != nil {
+				if nameLength > 0 {
+					name[nameLength] = '.'
+					nameLength++
+				}
+				nameLength += copy(name[nameLength:], payload[offset+1:offset+length+1])
+			}
 			offset += length + 1
 		} else {
-			return "", 0, ErrDecodeDnsLabelInvalidData
+			return 0, 0, ErrDecodeDnsLabelInvalidData
 		}
 	}
 
-	return strings.Join(labels[:], "."), endOffset, nil
+	return nameLength, endOffset, nil
 }
 
 func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdata_offset int) (string, error) {
@@ -559,6 +816,38 @@ func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdata_offset int
 		ret, err = ParseSOA(rdata_offset, payload)
 	case "HTTPS", "SVCB":
 		ret, err = ParseSVCB(rdata)
+	case "DS", "CDS":
+		ret, err = ParseDS(rdata)
+	case "DNSKEY", "CDNSKEY":
+		ret, err = ParseDNSKEY(rdata)
+	case "RRSIG":
+		ret, err = ParseRRSIG(rdata_offset, payload)
+	case "NSEC":
+		ret, err = ParseNSEC(rdata_offset, payload)
+	case "NSEC3":
+		ret, err = ParseNSEC3(rdata)
+	case "NSEC3PARAM":
+		ret, err = ParseNSEC3PARAM(rdata)
+	case "CAA":
+		ret, err = ParseCAA(rdata)
+	case "NAPTR":
+		ret, err = ParseNAPTR(rdata_offset, payload)
+	case "SSHFP":
+		ret, err = ParseSSHFP(rdata)
+	case "TLSA", "SMIMEA":
+		ret, err = ParseTLSA(rdata)
+	case "DNAME":
+		ret, err = ParseDNAME(rdata_offset, payload)
+	case "LOC":
+		ret, err = ParseLOC(rdata)
+	case "HINFO":
+		ret, err = ParseHINFO(rdata)
+	case "URI":
+		ret, err = ParseURI(rdata)
+	case "ZONEMD":
+		ret, err = ParseZONEMD(rdata)
+	case "OPENPGPKEY":
+		ret, err = ParseOPENPGPKEY(rdata)
 	default:
 		ret = "-"
 		err = nil
@@ -630,9 +919,7 @@ func ParseA(r []byte) (string, error) {
 	if len(r) < net.IPv4len {
 		return "", ErrDecodeDnsAnswerRdataTooShort
 	}
-	addr := make(net.IP, net.IPv4len)
-	copy(addr, r[:net.IPv4len])
-	return addr.String(), nil
+	return netip.AddrFrom4([4]byte(r[:net.IPv4len])).String(), nil
 }
 
 /*
@@ -652,9 +939,8 @@ func ParseAAAA(rdata []byte) (string, error) {
 	if len(rdata) < net.IPv6len {
 		return "", ErrDecodeDnsAnswerRdataTooShort
 	}
-	addr := make(net.IP, net.IPv6len)
-	copy(addr, rdata[:net.IPv6len])
-	return addr.String(), nil
+	// IPv4-mapped addresses are rendered as IPv4, like net.IP
+	return netip.AddrFrom16([16]byte(rdata[:net.IPv6len])).Unmap().String(), nil
 }
 
 /*
@@ -930,6 +1216,570 @@ func ParseSVCParam(svcParamKey uint16, paramData []byte) (string, error) {
 	}
 }
 
+/*
+DS / CDS
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           Key Tag     | Algorithm | Digest Type |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    DIGEST                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDS(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	keytag := binary.BigEndian.Uint16(rdata[0:2])
+	algorithm := rdata[2]
+	digestType := rdata[3]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[4:]))
+
+	ds := fmt.Sprintf("%d %d %d %s", keytag, algorithm, digestType, digest)
+	return ds, nil
+}
+
+/*
+DNSKEY / CDNSKEY
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|         Flags         | Protocol  | Algorithm   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  PUBLIC KEY                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNSKEY(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := binary.BigEndian.Uint16(rdata[0:2])
+	protocol := rdata[2]
+	algorithm := rdata[3]
+	publicKey := base64.StdEncoding.EncodeToString(rdata[4:])
+
+	dnskey := fmt.Sprintf("%d %d %d %s", flags, protocol, algorithm, publicKey)
+	return dnskey, nil
+}
+
+/*
+RRSIG
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        Type Covered   | Algorithm |   Labels    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                 Original TTL                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             Signature Expiration              |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             Signature Inception               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|            Key Tag    |                       /
++--+--+--+--+--+--+--+--+     Signer's Name     /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SIGNATURE                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRRSIG(rdata_offset int, payload []byte) (string, error) {
+	// ensure there is enough data for the fixed fields and at least
+	// one byte for the signer's name
+	if len(payload) < rdata_offset+19 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	rdata := payload[rdata_offset : rdata_offset+18]
+
+	typeCovered := rdatatypeToPresentation(int(binary.BigEndian.Uint16(rdata[0:2])))
+	algorithm := rdata[2]
+	labels := rdata[3]
+	originalTtl := binary.BigEndian.Uint32(rdata[4:8])
+	expiration := rrsigTimeToString(binary.BigEndian.Uint32(rdata[8:12]))
+	inception := rrsigTimeToString(binary.BigEndian.Uint32(rdata[12:16]))
+	keytag := binary.BigEndian.Uint16(rdata[16:18])
+
+	signer, offset, err := ParseLabels(rdata_offset+18, payload)
+	if err != nil {
+		return "", err
+	}
+	signature := base64.StdEncoding.EncodeToString(payload[offset:])
+
+	rrsig := fmt.Sprintf("%s %d %d %d %s %s %d %s %s", typeCovered, algorithm, labels, originalTtl,
+		expiration, inception, keytag, nameToPresentation(signer), signature)
+	return rrsig, nil
+}
+
+/*
+NSEC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/              Next Domain Name                 /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               Type Bit Maps                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC(rdata_offset int, payload []byte) (string, error) {
+	next, offset, err := ParseLabels(rdata_offset, payload)
+	if err != nil {
+		return "", err
+	}
+	types, err := ParseTypeBitMap(payload[offset:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec := nameToPresentation(next)
+	if len(types) > 0 {
+		nsec += " " + strings.Join(types, " ")
+	}
+	return nsec, nil
+}
+
+/*
+NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Hash Alg.  |   Flags   |     Iterations     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Salt Length |            Salt                /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Hash Length |      Next Hashed Owner Name    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                Type Bit Maps                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3(rdata []byte) (string, error) {
+	hashAlg, flags, iterations, salt, offset, err := parseNSEC3Params(rdata)
+	if err != nil {
+		return "", err
+	}
+
+	if len(rdata) < offset+1 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	hashLength := int(rdata[offset])
+	offset++
+	if len(rdata) < offset+hashLength {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	nextHashed := base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(rdata[offset : offset+hashLength])
+	offset += hashLength
+
+	types, err := ParseTypeBitMap(rdata[offset:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec3 := fmt.Sprintf("%d %d %d %s %s", hashAlg, flags, iterations, salt, nextHashed)
+	if len(types) > 0 {
+		nsec3 += " " + strings.Join(types, " ")
+	}
+	return nsec3, nil
+}
+
+/*
+NSEC3PARAM
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Hash Alg.  |   Flags   |     Iterations     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Salt Length |            Salt                /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3PARAM(rdata []byte) (string, error) {
+	hashAlg, flags, iterations, salt, _, err := parseNSEC3Params(rdata)
+	if err != nil {
+		return "", err
+	}
+	nsec3param := fmt.Sprintf("%d %d %d %s", hashAlg, flags, iterations, salt)
+	return nsec3param, nil
+}
+
+// parseNSEC3Params decodes the fields shared by NSEC3 and NSEC3PARAM, an empty salt
+// is rendered as "-"
+func parseNSEC3Params(rdata []byte) (uint8, uint8, uint16, string, int, error) {
+	if len(rdata) < 5 {
+		return 0, 0, 0, "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	hashAlg := rdata[0]
+	flags := rdata[1]
+	iterations := binary.BigEndian.Uint16(rdata[2:4])
+	saltLength := int(rdata[4])
+	if len(rdata) < 5+saltLength {
+		return 0, 0, 0, "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	salt := "-"
+	if saltLength > 0 {
+		salt = strings.ToUpper(hex.EncodeToString(rdata[5 : 5+saltLength]))
+	}
+	return hashAlg, flags, iterations, salt, 5 + saltLength, nil
+}
+
+/*
+Type Bit Maps (RFC 4034 section 4.1.2)
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+| Window Block |  Bitmap Len |      Bitmap      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTypeBitMap(bitmap []byte) ([]string, error) {
+	types := []string{}
+	offset := 0
+	for offset < len(bitmap) {
+		if len(bitmap) < offset+2 {
+			return nil, ErrDecodeDnsAnswerRdataTooShort
+		}
+		window := int(bitmap[offset])
+		length := int(bitmap[offset+1])
+		offset += 2
+		if length == 0 || length > 32 {
+			return nil, ErrDecodeDnsAnswerRdataInvalid
+		}
+		if len(bitmap) < offset+length {
+			return nil, ErrDecodeDnsAnswerRdataTooShort
+		}
+		for i, b := range bitmap[offset : offset+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, rdatatypeToPresentation(window*256+i*8+bit))
+				}
+			}
+		}
+		offset += length
+	}
+	return types, nil
+}
+
+/*
+CAA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Flags     |   Tag Length  |      Tag      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     Value                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseCAA(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := rdata[0]
+	tagLength := int(rdata[1])
+	if len(rdata) < 2+tagLength {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	tag := string(rdata[2 : 2+tagLength])
+	value := characterStringToStr(rdata[2+tagLength:])
+
+	caa := fmt.Sprintf("%d %s %s", flags, tag, value)
+	return caa, nil
+}
+
+/*
+NAPTR
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     ORDER                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PREFERENCE                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     FLAGS                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SERVICES                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    REGEXP                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  REPLACEMENT                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNAPTR(rdata_offset int, payload []byte) (string, error) {
+	if len(payload) < rdata_offset+4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	order := binary.BigEndian.Uint16(payload[rdata_offset : rdata_offset+2])
+	preference := binary.BigEndian.Uint16(payload[rdata_offset+2 : rdata_offset+4])
+
+	offset := rdata_offset + 4
+	var fields [3]string
+	for i := range fields {
+		field, next, err := parseCharacterString(offset, payload)
+		if err != nil {
+			return "", err
+		}
+		fields[i] = characterStringToStr(field)
+		offset = next
+	}
+
+	replacement, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+
+	naptr := fmt.Sprintf("%d %d %s %s %s %s", order, preference, fields[0], fields[1], fields[2],
+		nameToPresentation(replacement))
+	return naptr, nil
+}
+
+/*
+SSHFP
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Algorithm   |    FP Type    |               /
++--+--+--+--+--+--+--+--+--+--+--+               /
+/                  Fingerprint                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseSSHFP(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	algorithm := rdata[0]
+	fpType := rdata[1]
+	fingerprint := strings.ToUpper(hex.EncodeToString(rdata[2:]))
+
+	sshfp := fmt.Sprintf("%d %d %s", algorithm, fpType, fingerprint)
+	return sshfp, nil
+}
+
+/*
+TLSA / SMIMEA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Cert. Usage  |   Selector    | Matching Type |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/       Certificate Association Data            /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTLSA(rdata []byte) (string, error) {
+	if len(rdata) < 3 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	usage := rdata[0]
+	selector := rdata[1]
+	matchingType := rdata[2]
+	certificate := strings.ToUpper(hex.EncodeToString(rdata[3:]))
+
+	tlsa := fmt.Sprintf("%d %d %d %s", usage, selector, matchingType, certificate)
+	return tlsa, nil
+}
+
+/*
+DNAME
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNAME(rdata_offset int, payload []byte) (string, error) {
+	target, _, err := ParseLabels(rdata_offset, payload)
+	if err != nil {
+		return "", err
+	}
+	return nameToPresentation(target), nil
+}
+
+/*
+LOC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        VERSION        |         SIZE          |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|       HORIZ PRE       |       VERT PRE        |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LATITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LONGITUDE                   |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   ALTITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseLOC(rdata []byte) (string, error) {
+	if len(rdata) < 16 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	// only the version 0 is defined
+	if rdata[0] != 0 {
+		return "", ErrDecodeDnsAnswerRdataInvalid
+	}
+	size := locPrecisionToStr(rdata[1])
+	horizPre := locPrecisionToStr(rdata[2])
+	vertPre := locPrecisionToStr(rdata[3])
+	latitude := locCoordinateToStr(binary.BigEndian.Uint32(rdata[4:8]), "N", "S")
+	longitude := locCoordinateToStr(binary.BigEndian.Uint32(rdata[8:12]), "E", "W")
+
+	// altitude is expressed in centimeters from a base of 100,000m below the WGS 84 ellipsoid
+	altitudeCm := binary.BigEndian.Uint32(rdata[12:16])
+	altitude := float64(altitudeCm)/100 - 100000
+	var alt string
+	if altitudeCm%100 != 0 {
+		alt = fmt.Sprintf("%.2fm", altitude)
+	} else {
+		alt = fmt.Sprintf("%.0fm", altitude)
+	}
+
+	loc := fmt.Sprintf("%s %s %s %sm %sm %sm", latitude, longitude, alt, size, horizPre, vertPre)
+	return loc, nil
+}
+
+// locCoordinateToStr converts a LOC latitude or longitude, expressed in thousandths
+// of a second of arc with 2^31 as the equator or prime meridian, to "DD MM SS.sss H"
+func locCoordinateToStr(coordinate uint32, positive string, negative string) string {
+	const equator = uint32(1 << 31)
+	const minutes = 60 * 1000
+	const degrees = 60 * minutes
+
+	hemisphere := positive
+	if coordinate > equator {
+		coordinate -= equator
+	} else {
+		hemisphere = negative
+		coordinate = equator - coordinate
+	}
+	d := coordinate / degrees
+	coordinate %= degrees
+	m := coordinate / minutes
+	coordinate %= minutes
+	return fmt.Sprintf("%02d %02d %0.3f %s", d, m, float64(coordinate)/1000, hemisphere)
+}
+
+// locPrecisionToStr converts a LOC size or precision, expressed in centimeters
+// with a mantissa/exponent encoding, to meters
+func locPrecisionToStr(x uint8) string {
+	mantissa := x & 0xf0 >> 4
+	exponent := x & 0x0f
+	if exponent < 2 {
+		if exponent == 1 {
+			mantissa *= 10
+		}
+		return fmt.Sprintf("0.%02d", mantissa)
+	}
+	return fmt.Sprintf("%d", mantissa) + strings.Repeat("0", int(exponent)-2)
+}
+
+/*
+HINFO
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                      CPU                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                       OS                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseHINFO(rdata []byte) (string, error) {
+	cpu, offset, err := parseCharacterString(0, rdata)
+	if err != nil {
+		return "", err
+	}
+	os, _, err := parseCharacterString(offset, rdata)
+	if err != nil {
+		return "", err
+	}
+	hinfo := fmt.Sprintf("%s %s", characterStringToStr(cpu), characterStringToStr(os))
+	return hinfo, nil
+}
+
+/*
+URI
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PRIORITY                    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                    WEIGHT                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseURI(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	priority := binary.BigEndian.Uint16(rdata[0:2])
+	weight := binary.BigEndian.Uint16(rdata[2:4])
+	target := characterStringToStr(rdata[4:])
+
+	uri := fmt.Sprintf("%d %d %s", priority, weight, target)
+	return uri, nil
+}
+
+/*
+ZONEMD
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                    SERIAL                     |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Scheme    |   Hash Alg    |               /
++--+--+--+--+--+--+--+--+--+--+--+               /
+/                    Digest                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseZONEMD(rdata []byte) (string, error) {
+	if len(rdata) < 6 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	serial := binary.BigEndian.Uint32(rdata[0:4])
+	scheme := rdata[4]
+	hashAlg := rdata[5]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[6:]))
+
+	zonemd := fmt.Sprintf("%d %d %d %s", serial, scheme, hashAlg, digest)
+	return zonemd, nil
+}
+
+/*
+OPENPGPKEY
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               OpenPGP Public Key              /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseOPENPGPKEY(rdata []byte) (string, error) {
+	if len(rdata) < 1 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	return base64.StdEncoding.EncodeToString(rdata), nil
+}
+
+// parseCharacterString reads a <character-string> (one length byte followed by data)
+// and returns the data with the offset of the next field
+func parseCharacterString(offset int, data []byte) ([]byte, int, error) {
+	if len(data) < offset+1 {
+		return nil, 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	length := int(data[offset])
+	if len(data) < offset+1+length {
+		return nil, 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	return data[offset+1 : offset+1+length], offset + 1 + length, nil
+}
+
+// characterStringToStr returns the quoted presentation format of a character string,
+// double quotes and backslashes are escaped and unprintable bytes use the \DDD form
+func characterStringToStr(s []byte) string {
+	var str strings.Builder
+	str.Grow(2 + len(s))
+	str.WriteByte('"')
+	for _, e := range s {
+		if ' ' <= e && e <= '~' {
+			if e == '"' || e == '\\' {
+				str.WriteByte('\\')
+			}
+			str.WriteByte(e)
+		} else {
+			str.WriteString(escapeByte(e))
+		}
+	}
+	str.WriteByte('"')
+	return str.String()
+}
+
+// nameToPresentation renders the root domain as "."
+func nameToPresentation(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// rdatatypeToPresentation returns the mnemonic of the type or the
+// generic TYPEnnn notation (RFC 3597) for unknown types
+func rdatatypeToPresentation(rrtype int) string {
+	if value, ok := Rdatatypes[rrtype]; ok {
+		return value
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
+
+// rrsigTimeToString returns the YYYYMMDDHHmmSS format (UTC) of a signature
+// expiration or inception
+func rrsigTimeToString(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
 // These functions and consts have been taken from miekg/dns
 const (
 	escapedByteSmall = "" +
@@ -3,6 +3,7 @@ package dnsutils
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -2319,3 +2320,316 @@ func TestDecodePayload_Truncated(t *testing.T) {
 	}
 
 }
+
+func TestDecodeRdata_Dnssec_Modern(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	vectors := []struct {
+		rrtype string
+		rdata  string
+		want   string
+	}{
+		{"DS", "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118", "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"},
+		{"CDS", "60485 13 2 0A2D1C1A", "60485 13 2 0A2D1C1A"},
+		{"DNSKEY", "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+			"257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="},
+		{"RRSIG", "A 13 2 3600 20231115000000 20231025000000 12345 dnstapcollector.test. c2lnbmF0dXJl",
+			"A 13 2 3600 20231115000000 20231025000000 12345 dnstapcollector.test c2lnbmF0dXJl"},
+		{"NSEC", "host.dnstapcollector.test. A AAAA RRSIG NSEC TYPE1234", "host.dnstapcollector.test A AAAA RRSIG NSEC TYPE1234"},
+		{"NSEC3", "1 1 0 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG", "1 1 0 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG"},
+		{"NSEC3PARAM", "1 0 0 -", "1 0 0 -"},
+		{"CAA", `0 issue "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		{"NAPTR", `100 10 "S" "SIP+D2U" "" _sip._udp.dnstapcollector.test.`, `100 10 "S" "SIP+D2U" "" _sip._udp.dnstapcollector.test`},
+		{"SSHFP", "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789AB", "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789AB"},
+		{"TLSA", "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6", "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6"},
+		{"DNAME", "dnscollector.dev.", "dnscollector.dev"},
+		{"LOC", "52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m", "52 22 23.000 N 04 53 32.000 E -2m 0.00m 10000m 10m"},
+		{"HINFO", `"INTEL-386" "Unix"`, `"INTEL-386" "Unix"`},
+		{"URI", `10 1 "ftp://ftp1.example.com/public"`, `10 1 "ftp://ftp1.example.com/public"`},
+		{"ZONEMD", "2018031900 1 1 FEBE3D4CE2EC2FFA4BA9", "2018031900 1 1 FEBE3D4CE2EC2FFA4BA9"},
+		{"OPENPGPKEY", "mQENBFEB3ocBCAC0", "mQENBFEB3ocBCAC0"},
+	}
+
+	for _, v := range vectors {
+		dm := new(dns.Msg)
+		dm.SetQuestion(fqdn, dns.TypeA)
+		rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, v.rrtype, v.rdata))
+		if err != nil {
+			t.Fatalf("invalid test vector for %s: %v", v.rrtype, err)
+		}
+		dm.Answer = append(dm.Answer, rr1)
+		payload, _ := dm.Pack()
+
+		_, _, offset_rr, _ := DecodeQuestion(1, payload)
+		answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+		if err != nil {
+			t.Errorf("decode error for rdata %s: %v", v.rrtype, err)
+			continue
+		}
+		if answer[0].Rdatatype != v.rrtype {
+			t.Errorf("invalid rdatatype, want %s, got: %s", v.rrtype, answer[0].Rdatatype)
+		}
+		if answer[0].Rdata != v.want {
+			t.Errorf("invalid decode for rdata %s, want %s, got: %s", v.rrtype, v.want, answer[0].Rdata)
+		}
+	}
+}
+
+func TestDecodeRdata_Dnssec_Modern_Short(t *testing.T) {
+	vectors := map[string][]byte{
+		"DS":         {0xec, 0x45, 0x05},
+		"DNSKEY":     {0x01, 0x01, 0x03},
+		"RRSIG":      {0x00, 0x01, 0x0d, 0x02, 0x00, 0x00, 0x0e, 0x10},
+		"NSEC":       {0x00, 0x00, 0x06},
+		"NSEC3":      {0x01, 0x01, 0x00, 0x00, 0x04, 0xaa},
+		"NSEC3PARAM": {0x01, 0x00, 0x00},
+		"CAA":        {0x00, 0x05, 0x69, 0x73},
+		"NAPTR":      {0x00, 0x64, 0x00, 0x0a, 0x01},
+		"SSHFP":      {0x04},
+		"TLSA":       {0x03, 0x01},
+		"LOC":        {0x00, 0x12, 0x16, 0x13},
+		"HINFO":      {0x09, 0x49, 0x4e},
+		"URI":        {0x00, 0x0a},
+		"ZONEMD":     {0x78, 0x45, 0x60, 0x2c, 0x01},
+		"OPENPGPKEY": {},
+	}
+
+	for rrtype, rdata := range vectors {
+		_, err := ParseRdata(rrtype, rdata, rdata, 0)
+		if !errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) {
+			t.Errorf("bad error returned for %s: %v", rrtype, err)
+		}
+	}
+}
+
+func TestDecodePayload_HeaderAndQuestions(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion("version.bind.", dns.TypeTXT)
+	dm.Question[0].Qclass = dns.ClassCHAOS
+	dm.Question = append(dm.Question, dns.Question{Name: TEST_QNAME, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	dm.RecursionDesired = false
+	dm.CheckingDisabled = true
+	dm.Zero = true
+	payload, _ := dm.Pack()
+
+	dnsMsg := DnsMessage{}
+	dnsMsg.Init()
+	dnsMsg.DNS.Payload = payload
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Fatalf("unexpected error when decoding header: %v", err)
+	}
+	if err = DecodePayload(&dnsMsg, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error while decoding payload: %v", err)
+	}
+
+	if dnsMsg.DNS.Flags.RD || !dnsMsg.DNS.Flags.CD || !dnsMsg.DNS.Flags.Z {
+		t.Errorf("invalid flags: %v", dnsMsg.DNS.Flags)
+	}
+	if dnsMsg.DNS.Qdcount != 2 || dnsMsg.DNS.Ancount != 0 || dnsMsg.DNS.Nscount != 0 || dnsMsg.DNS.Arcount != 0 {
+		t.Errorf("invalid counters: %d %d %d %d", dnsMsg.DNS.Qdcount, dnsMsg.DNS.Ancount, dnsMsg.DNS.Nscount, dnsMsg.DNS.Arcount)
+	}
+	if dnsMsg.DNS.Qname != "dnstapcollector.test" || dnsMsg.DNS.Qtype != "A" || dnsMsg.DNS.Qclass != "IN" {
+		t.Errorf("invalid last question: %s %s %s", dnsMsg.DNS.Qname, dnsMsg.DNS.Qtype, dnsMsg.DNS.Qclass)
+	}
+	if len(dnsMsg.DNS.Questions) != 2 {
+		t.Fatalf("expected 2 questions, got %d", len(dnsMsg.DNS.Questions))
+	}
+	expected := DnsQuestion{Qname: "version.bind", Qtype: "TXT", Qclass: "CH"}
+	if dnsMsg.DNS.Questions[0] != expected {
+		t.Errorf("invalid first question, want %v, got %v", expected, dnsMsg.DNS.Questions[0])
+	}
+}
+
+func TestClassToString(t *testing.T) {
+	if class := ClassToString(3); class != "CH" {
+		t.Errorf("class CH expected: %s", class)
+	}
+	if class := ClassToString(42); class != "CLASS42" {
+		t.Errorf("class CLASS42 expected: %s", class)
+	}
+}
+
+func TestParseLabels_Allocs(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	payload, _ := dm.Pack()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ParseLabels(DnsLen, payload)
+	})
+	if allocs > 1 {
+		t.Errorf("expected at most one allocation to parse labels, got %v", allocs)
+	}
+}
+
+func BenchmarkDecodePayload_Query(b *testing.B) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	q.SetEdns0(1232, true)
+	payload, _ := q.Pack()
+	config := GetFakeConfig()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dm := DnsMessage{}
+		dm.Init()
+		dm.DNS.Payload = payload
+		header, _ := DecodeDns(payload)
+		DecodePayload(&dm, &header, config)
+	}
+}
+
+func BenchmarkDecodePayload_Reply(b *testing.B) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr1, _ := dns.NewRR("www.dnscollector.dev. 300 IN CNAME edge.dnscollector.dev.")
+	rr2, _ := dns.NewRR("edge.dnscollector.dev. 300 IN A 192.0.2.1")
+	rr3, _ := dns.NewRR("edge.dnscollector.dev. 300 IN AAAA 2001:db8::1")
+	m.Answer = append(m.Answer, rr1, rr2, rr3)
+	m.SetEdns0(1232, true)
+	payload, _ := m.Pack()
+	config := GetFakeConfig()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dm := DnsMessage{}
+		dm.Init()
+		dm.DNS.Payload = payload
+		header, _ := DecodeDns(payload)
+		DecodePayload(&dm, &header, config)
+	}
+}
+
+func BenchmarkDecodePayloadLazy_Reply(b *testing.B) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr1, _ := dns.NewRR("www.dnscollector.dev. 300 IN CNAME edge.dnscollector.dev.")
+	rr2, _ := dns.NewRR("edge.dnscollector.dev. 300 IN A 192.0.2.1")
+	rr3, _ := dns.NewRR("edge.dnscollector.dev. 300 IN AAAA 2001:db8::1")
+	m.Answer = append(m.Answer, rr1, rr2, rr3)
+	m.SetEdns0(1232, true)
+	payload, _ := m.Pack()
+	config := GetFakeConfig()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dm := DnsMessage{}
+		dm.Init()
+		dm.DNS.Payload = payload
+		header, _ := DecodeDns(payload)
+		DecodePayloadLazy(&dm, &header, config)
+	}
+}
+
+func TestDecodePayloadLazy(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr1, _ := dns.NewRR("www.dnscollector.dev. 300 IN CNAME edge.dnscollector.dev.")
+	rr2, _ := dns.NewRR("edge.dnscollector.dev. 300 IN A 192.0.2.1")
+	m.Answer = append(m.Answer, rr1, rr2)
+	m.SetEdns0(1232, true)
+	payload, _ := m.Pack()
+	header, _ := DecodeDns(payload)
+
+	// decoded immediately
+	expected := DnsMessage{}
+	expected.Init()
+	expected.DNS.Payload = payload
+	if err := DecodePayload(&expected, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// decoded on demand
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	if err := DecodePayloadLazy(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm.DNS.Qname != "www.dnscollector.dev" || dm.DNS.Rcode != "NOERROR" {
+		t.Errorf("invalid question: %s %s", dm.DNS.Qname, dm.DNS.Rcode)
+	}
+	if len(dm.DNS.DnsRRs.Answers) != 0 || dm.EDNS.UdpSize != 0 {
+		t.Errorf("records decoded before use")
+	}
+
+	// the copies of the message share the decoding
+	copied := dm
+	dm.DecodeSections()
+	copied.DecodeSections()
+	for _, d := range []DnsMessage{dm, copied} {
+		if !reflect.DeepEqual(d.DNS.DnsRRs, expected.DNS.DnsRRs) {
+			t.Errorf("invalid records: %v", d.DNS.DnsRRs)
+		}
+		if !reflect.DeepEqual(d.EDNS, expected.EDNS) {
+			t.Errorf("invalid edns: %v", d.EDNS)
+		}
+	}
+}
+
+func TestDecodePayloadLazy_Malformed(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr, _ := dns.NewRR("www.dnscollector.dev. 300 IN A 192.0.2.1")
+	m.Answer = append(m.Answer, rr)
+	payload, _ := m.Pack()
+
+	// A record with 3 bytes of rdata
+	payload[len(payload)-5] = 3
+	payload = payload[:len(payload)-1]
+	header, _ := DecodeDns(payload)
+
+	// the records are well delimited, the rdata is checked when decoded
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	if err := DecodePayloadLazy(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dm.DNS.MalformedPacket {
+		t.Errorf("packet should not be malformed before decoding the records")
+	}
+	if err := dm.DecodeSections(); err == nil {
+		t.Errorf("decoding error expected")
+	}
+	if !dm.DNS.MalformedPacket {
+		t.Errorf("packet should be malformed")
+	}
+	if err := dm.DecodeSections(); err != nil {
+		t.Errorf("decoding error reported twice: %v", err)
+	}
+
+	// records not well delimited
+	dm = DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload[:len(payload)-1]
+	if err := DecodePayloadLazy(&dm, &header, GetFakeConfig()); err == nil || !dm.DNS.MalformedPacket {
+		t.Errorf("error expected with truncated records")
+	}
+}
+
+func TestInternSymbol(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		if internSymbol([]byte("AAAA")) != "AAAA" || internSymbol([]byte("NXDOMAIN")) != "NXDOMAIN" {
+			t.Fatalf("invalid symbol")
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("known symbols must not be allocated: %v", allocs)
+	}
+	if internSymbol([]byte("TYPE65000")) != "TYPE65000" {
+		t.Errorf("unknown symbols must be copied")
+	}
+}
@@ -0,0 +1,152 @@
+package dnsutils
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+DNS UPDATE message (RFC 2136)
++---------------------+
+|        Header       |
++---------------------+
+|         Zone        | specifies the zone to be updated
++---------------------+
+|     Prerequisite    | RRs or RRsets which must (not) preexist
++---------------------+
+|        Update       | RRs or RRsets to be added or deleted
++---------------------+
+|   Additional Data   | additional data
++---------------------+
+
+The zone, prerequisite and update sections are decoded with the
+question, answer and authority sections.
+*/
+func DecodeUpdate(dm *DnsMessage) *DnsUpdate {
+	update := &DnsUpdate{
+		Zone:          dm.DNS.Qname,
+		ZoneClass:     dm.DNS.Qclass,
+		Prerequisites: []DnsUpdateRecord{},
+		Updates:       []DnsUpdateRecord{},
+	}
+	if len(dm.DNS.Questions) > 0 {
+		update.Zone = dm.DNS.Questions[0].Qname
+		update.ZoneClass = dm.DNS.Questions[0].Qclass
+	}
+
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		record := newDnsUpdateRecord(rr)
+		record.Operation = updatePrerequisiteOperation(record, update.ZoneClass)
+		update.Prerequisites = append(update.Prerequisites, record)
+	}
+	for _, rr := range dm.DNS.DnsRRs.Nameservers {
+		record := newDnsUpdateRecord(rr)
+		record.Operation = updateOperation(record, update.ZoneClass)
+		update.Updates = append(update.Updates, record)
+	}
+	return update
+}
+
+func newDnsUpdateRecord(rr DnsAnswer) DnsUpdateRecord {
+	return DnsUpdateRecord{
+		Name:      rr.Name,
+		Rdatatype: rr.Rdatatype,
+		Class:     ClassToString(rr.Class),
+		Ttl:       rr.Ttl,
+		Rdata:     rr.Rdata,
+	}
+}
+
+// updatePrerequisiteOperation returns the meaning of a prerequisite (RFC 2136 section 2.4)
+func updatePrerequisiteOperation(rr DnsUpdateRecord, zoneClass string) string {
+	switch {
+	case rr.Class == "ANY" && rr.Rdatatype == "ANY":
+		return "name-in-use"
+	case rr.Class == "ANY":
+		return "rrset-exists"
+	case rr.Class == "NONE" && rr.Rdatatype == "ANY":
+		return "name-not-in-use"
+	case rr.Class == "NONE":
+		return "rrset-not-exists"
+	case rr.Class == zoneClass:
+		return "rrset-exists-value"
+	default:
+		return STR_UNKNOWN
+	}
+}
+
+// updateOperation returns the meaning of an update (RFC 2136 section 2.5)
+func updateOperation(rr DnsUpdateRecord, zoneClass string) string {
+	switch {
+	case rr.Class == "ANY" && rr.Rdatatype == "ANY":
+		return "delete-all-rrsets"
+	case rr.Class == "ANY":
+		return "delete-rrset"
+	case rr.Class == "NONE":
+		return "delete-rr"
+	case rr.Class == zoneClass:
+		return "add"
+	default:
+		return STR_UNKNOWN
+	}
+}
+
+// DecodeNotify returns the zone and the serial of a NOTIFY message (RFC 1996),
+// the serial is equal to -1 when the SOA is not provided in the answer section
+func DecodeNotify(dm *DnsMessage) *DnsNotify {
+	notify := &DnsNotify{
+		Zone:   dm.DNS.Qname,
+		Qtype:  dm.DNS.Qtype,
+		Serial: -1,
+	}
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		if serial, ok := soaSerial(rr); ok {
+			notify.Serial = serial
+			break
+		}
+	}
+	return notify
+}
+
+// DecodeTransfer summarizes a message of an AXFR or IXFR zone transfer: number of
+// records by type and the SOA serials in order of appearance. For IXFR queries, the
+// serial of the client is taken from the authority section (RFC 1995).
+func DecodeTransfer(dm *DnsMessage) *DnsTransfer {
+	transfer := &DnsTransfer{
+		Zone:    dm.DNS.Qname,
+		Type:    dm.DNS.Qtype,
+		Records: len(dm.DNS.DnsRRs.Answers),
+		Rrtypes: make(map[string]int),
+		Serials: []int64{},
+	}
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		transfer.Rrtypes[rr.Rdatatype]++
+		if serial, ok := soaSerial(rr); ok {
+			transfer.Serials = append(transfer.Serials, serial)
+		}
+	}
+	if !dm.DNS.Flags.QR {
+		for _, rr := range dm.DNS.DnsRRs.Nameservers {
+			if serial, ok := soaSerial(rr); ok {
+				transfer.Serials = append(transfer.Serials, serial)
+			}
+		}
+	}
+	return transfer
+}
+
+// soaSerial extracts the serial from a decoded SOA record
+func soaSerial(rr DnsAnswer) (int64, bool) {
+	if rr.Rdatatype != "SOA" {
+		return 0, false
+	}
+	fields := strings.Fields(rr.Rdata)
+	if len(fields) < 3 {
+		return 0, false
+	}
+	serial, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return serial, true
+}
@@ -0,0 +1,157 @@
+package dnsutils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func decodeTestPayload(t *testing.T, m *dns.Msg) DnsMessage {
+	payload, err := m.Pack()
+	if err != nil {
+		t.Fatalf("unable to pack message: %v", err)
+	}
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Fatalf("unexpected error when decoding header: %v", err)
+	}
+	if err = DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error while decoding payload: %v", err)
+	}
+	return dm
+}
+
+func TestDecodePayload_Update(t *testing.T) {
+	zone := "dnscollector.dev."
+	rrA, _ := dns.NewRR(fmt.Sprintf("www.%s 300 IN A 192.0.2.1", zone))
+	rrTxt, _ := dns.NewRR(fmt.Sprintf("txt.%s 300 IN TXT hello", zone))
+	rrAAAA, _ := dns.NewRR(fmt.Sprintf("www.%s 300 IN AAAA 2001:db8::1", zone))
+	rrOld, _ := dns.NewRR(fmt.Sprintf("old.%s 300 IN A 192.0.2.2", zone))
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.NameUsed([]dns.RR{rrA})
+	m.RRsetNotUsed([]dns.RR{rrAAAA})
+	m.Insert([]dns.RR{rrA})
+	m.RemoveRRset([]dns.RR{rrTxt})
+	m.Remove([]dns.RR{rrOld})
+	m.RemoveName([]dns.RR{rrAAAA})
+
+	dm := decodeTestPayload(t, m)
+
+	if dm.DnsTap.Operation != "UPDATE_QUERY" {
+		t.Errorf("invalid operation: %s", dm.DnsTap.Operation)
+	}
+	if dm.DNS.Update == nil {
+		t.Fatalf("update not decoded")
+	}
+	if dm.DNS.Update.Zone != "dnscollector.dev" || dm.DNS.Update.ZoneClass != "IN" {
+		t.Errorf("invalid zone: %s %s", dm.DNS.Update.Zone, dm.DNS.Update.ZoneClass)
+	}
+
+	prerequisites := []string{"name-in-use", "rrset-not-exists"}
+	if len(dm.DNS.Update.Prerequisites) != len(prerequisites) {
+		t.Fatalf("expected %d prerequisites, got %d", len(prerequisites), len(dm.DNS.Update.Prerequisites))
+	}
+	for i, op := range prerequisites {
+		if dm.DNS.Update.Prerequisites[i].Operation != op {
+			t.Errorf("invalid prerequisite %d, want %s, got %s", i, op, dm.DNS.Update.Prerequisites[i].Operation)
+		}
+	}
+
+	updates := []DnsUpdateRecord{
+		{Name: "www.dnscollector.dev", Rdatatype: "A", Class: "IN", Ttl: 300, Rdata: "192.0.2.1", Operation: "add"},
+		{Name: "txt.dnscollector.dev", Rdatatype: "TXT", Class: "ANY", Ttl: 0, Rdata: "-", Operation: "delete-rrset"},
+		{Name: "old.dnscollector.dev", Rdatatype: "A", Class: "NONE", Ttl: 0, Rdata: "192.0.2.2", Operation: "delete-rr"},
+		{Name: "www.dnscollector.dev", Rdatatype: "ANY", Class: "ANY", Ttl: 0, Rdata: "-", Operation: "delete-all-rrsets"},
+	}
+	if len(dm.DNS.Update.Updates) != len(updates) {
+		t.Fatalf("expected %d updates, got %d", len(updates), len(dm.DNS.Update.Updates))
+	}
+	for i, rr := range updates {
+		if dm.DNS.Update.Updates[i] != rr {
+			t.Errorf("invalid update %d, want %v, got %v", i, rr, dm.DNS.Update.Updates[i])
+		}
+	}
+}
+
+func TestDecodePayload_UpdateResponse(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetUpdate("dnscollector.dev.")
+	m.Response = true
+
+	dm := decodeTestPayload(t, m)
+	if dm.DnsTap.Operation != "UPDATE_RESPONSE" {
+		t.Errorf("invalid operation: %s", dm.DnsTap.Operation)
+	}
+}
+
+func TestDecodePayload_Notify(t *testing.T) {
+	zone := "dnscollector.dev."
+	soa, _ := dns.NewRR(fmt.Sprintf("%s 3600 IN SOA ns1.%s hostmaster.%s 2023102601 900 900 1800 60", zone, zone, zone))
+
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	m.Answer = append(m.Answer, soa)
+
+	dm := decodeTestPayload(t, m)
+	if dm.DNS.Notify == nil {
+		t.Fatalf("notify not decoded")
+	}
+	expected := DnsNotify{Zone: "dnscollector.dev", Qtype: "SOA", Serial: 2023102601}
+	if *dm.DNS.Notify != expected {
+		t.Errorf("invalid notify, want %v, got %v", expected, *dm.DNS.Notify)
+	}
+
+	// without SOA
+	m.Answer = nil
+	dm = decodeTestPayload(t, m)
+	if dm.DNS.Notify == nil || dm.DNS.Notify.Serial != -1 {
+		t.Errorf("invalid notify without serial: %v", dm.DNS.Notify)
+	}
+}
+
+func TestDecodePayload_Transfer(t *testing.T) {
+	zone := "dnscollector.dev."
+	soa, _ := dns.NewRR(fmt.Sprintf("%s 3600 IN SOA ns1.%s hostmaster.%s 2023102601 900 900 1800 60", zone, zone, zone))
+	ns, _ := dns.NewRR(fmt.Sprintf("%s 3600 IN NS ns1.%s", zone, zone))
+	rrA, _ := dns.NewRR(fmt.Sprintf("www.%s 300 IN A 192.0.2.1", zone))
+	rrB, _ := dns.NewRR(fmt.Sprintf("api.%s 300 IN A 192.0.2.2", zone))
+
+	q := new(dns.Msg)
+	q.SetAxfr(zone)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Answer = []dns.RR{soa, ns, rrA, rrB, soa}
+
+	dm := decodeTestPayload(t, m)
+	if dm.DNS.Transfer == nil {
+		t.Fatalf("transfer not decoded")
+	}
+	transfer := dm.DNS.Transfer
+	if transfer.Zone != "dnscollector.dev" || transfer.Type != "AXFR" || transfer.Records != 5 {
+		t.Errorf("invalid transfer summary: %v", transfer)
+	}
+	if transfer.Rrtypes["SOA"] != 2 || transfer.Rrtypes["NS"] != 1 || transfer.Rrtypes["A"] != 2 {
+		t.Errorf("invalid records by type: %v", transfer.Rrtypes)
+	}
+	if len(transfer.Serials) != 2 || transfer.Serials[0] != 2023102601 {
+		t.Errorf("invalid serials: %v", transfer.Serials)
+	}
+
+	// ixfr query with the serial of the client
+	q = new(dns.Msg)
+	q.SetIxfr(zone, 2023102500, "ns1."+zone, "hostmaster."+zone)
+	dm = decodeTestPayload(t, q)
+	if dm.DNS.Transfer == nil || dm.DNS.Transfer.Type != "IXFR" {
+		t.Fatalf("ixfr not decoded: %v", dm.DNS.Transfer)
+	}
+	if len(dm.DNS.Transfer.Serials) != 1 || dm.DNS.Transfer.Serials[0] != 2023102500 {
+		t.Errorf("invalid ixfr serials: %v", dm.DNS.Transfer.Serials)
+	}
+}
@@ -0,0 +1,95 @@
+package dnsutils
+
+import (
+	"strings"
+)
+
+// DomainTrie is a suffix trie of domain names, indexed by labels from the right
+// (com -> example -> www), to match a name and all its subdomains against
+// very large lists with a cost depending only on the number of labels of the name
+type DomainTrie struct {
+	root domainTrieNode
+	size int
+}
+
+type domainTrieNode struct {
+	children map[string]*domainTrieNode
+	terminal bool
+}
+
+func NewDomainTrie() *DomainTrie {
+	return &DomainTrie{}
+}
+
+// Len returns the number of domains in the trie
+func (t *DomainTrie) Len() int {
+	return t.size
+}
+
+// Add inserts the domain, the name is expected in lowercase
+func (t *DomainTrie) Add(domain string) {
+	domain = strings.TrimSuffix(domain, ".")
+	if len(domain) == 0 {
+		return
+	}
+
+	node := &t.root
+	end := len(domain)
+	for end > 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[start:end]
+
+		child, ok := node.children[label]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*domainTrieNode, 1)
+			}
+			child = &domainTrieNode{}
+			node.children[label] = child
+		}
+		node = child
+		end = start - 1
+	}
+
+	if !node.terminal {
+		node.terminal = true
+		t.size++
+	}
+}
+
+// Match returns true if the name or one of its parent domains is in the trie
+func (t *DomainTrie) Match(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+
+	node := &t.root
+	end := len(name)
+	for end > 0 {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		child, ok := node.children[name[start:end]]
+		if !ok {
+			return false
+		}
+		if child.terminal {
+			return true
+		}
+		node = child
+		end = start - 1
+	}
+	return false
+}
+
+// IsDomainName returns true if the string contains only letters, digits,
+// hyphens, underscores and dots, so it can be added in a DomainTrie
+func IsDomainName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') &&
+			c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
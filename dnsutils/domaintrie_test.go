@@ -0,0 +1,68 @@
+package dnsutils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDomainTrie_Match(t *testing.T) {
+	trie := NewDomainTrie()
+	trie.Add("github.com")
+	trie.Add("mail.google.com.")
+	trie.Add("github.com")
+
+	if trie.Len() != 2 {
+		t.Errorf("invalid number of domains: %d", trie.Len())
+	}
+
+	testcases := []struct {
+		name  string
+		match bool
+	}{
+		{"github.com", true},
+		{"github.com.", true},
+		{"test.github.com", true},
+		{"a.b.github.com", true},
+		{"mygithub.com", false},
+		{"github.com.evil.org", false},
+		{"com", false},
+		{"mail.google.com", true},
+		{"smtp.mail.google.com", true},
+		{"google.com", false},
+		{"", false},
+		{".", false},
+	}
+
+	for _, tc := range testcases {
+		if trie.Match(tc.name) != tc.match {
+			t.Errorf("%s: want %v", tc.name, tc.match)
+		}
+	}
+}
+
+func TestDomainTrie_IsDomainName(t *testing.T) {
+	for _, s := range []string{"github.com", "_dmarc.example-1.com", "com"} {
+		if !IsDomainName(s) {
+			t.Errorf("%s should be a domain name", s)
+		}
+	}
+	for _, s := range []string{"", "(mail|www).google.com", `test\.github\.com$`, ".+.google.com"} {
+		if IsDomainName(s) {
+			t.Errorf("%s should not be a domain name", s)
+		}
+	}
+}
+
+func BenchmarkDomainTrie_Match1M(b *testing.B) {
+	trie := NewDomainTrie()
+	for i := 0; i < 1000000; i++ {
+		trie.Add(fmt.Sprintf("domain%d.example%d.com", i, i%100))
+	}
+	names := []string{"www.domain424242.example42.com", "www.unknown.example42.com", "www.notfound.org"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Match(names[i%len(names)])
+	}
+}
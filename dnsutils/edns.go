@@ -2,9 +2,12 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
 var ErrDecodeEdnsBadRootDomain = errors.New("edns, name MUST be 0 (root domain)")
@@ -16,12 +19,20 @@ var ErrDecodeEdnsTooManyOpts = errors.New("edns, packet contained too many OPT R
 var (
 	OptCodes = map[int]string{
 		3:  "NSID",
+		5:  "DAU",
+		6:  "DHU",
+		7:  "N3U",
 		8:  "CSUBNET",
 		9:  "EXPIRE",
 		10: "COOKIE",
 		11: "KEEPALIVE",
 		12: "PADDING",
+		13: "CHAIN",
+		14: "KEY-TAG",
 		15: "ERRORS",
+		16: "CLIENT-TAG",
+		17: "SERVER-TAG",
+		19: "ZONEVERSION",
 	}
 	ErrorCodeToString = map[int]string{
 		0:  "Other",
@@ -175,6 +186,8 @@ func DecodeEDNS(arcount int, start_offset int, payload []byte) (DnsExtended, int
 	return edns, offset, nil
 }
 
+// ParseOption renders the data of an option. The data of the options below which can't be
+// decoded is rendered with the "-" value, an invalid option doesn't make the packet malformed
 func ParseOption(optName string, optData []byte) (string, error) {
 	var ret string
 	var err error
@@ -183,6 +196,26 @@ func ParseOption(optName string, optData []byte) (string, error) {
 		ret, err = ParseErrors(optData)
 	case "CSUBNET":
 		ret, err = ParseCsubnet(optData)
+	case "NSID":
+		ret, err = ParseNsid(optData)
+	case "COOKIE":
+		ret, err = ParseCookie(optData)
+	case "KEEPALIVE":
+		ret, err = ParseKeepalive(optData)
+	case "PADDING":
+		ret, err = ParsePadding(optData)
+	case "EXPIRE":
+		ret, err = ParseExpire(optData)
+	case "CHAIN":
+		ret, err = ParseChain(optData)
+	case "KEY-TAG":
+		ret, err = ParseKeyTag(optData)
+	case "DAU", "DHU", "N3U":
+		ret, err = ParseAlgorithms(optData)
+	case "CLIENT-TAG", "SERVER-TAG":
+		ret, err = ParseTag(optData)
+	case "ZONEVERSION":
+		ret, err = ParseZoneVersion(optData)
 	default:
 		ret = "-"
 		err = nil
@@ -260,3 +293,209 @@ func ParseCsubnet(d []byte) (string, error) {
 		return "-", ErrDecodeEdnsOptionCsubnetBadFamily
 	}
 }
+
+/*
+https://datatracker.ietf.org/doc/html/rfc5001
+
+NSID EDNS0 option format, rendered as "<hex> <ascii>"
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                          NSID                                 /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseNsid(d []byte) (string, error) {
+	// empty in queries
+	if len(d) == 0 {
+		return "-", nil
+	}
+	// unprintable bytes, spaces and backslashes use the \DDD form
+	var ascii strings.Builder
+	for _, b := range d {
+		if b > ' ' && b <= '~' && b != '\\' {
+			ascii.WriteByte(b)
+		} else {
+			ascii.WriteString(fmt.Sprintf("\\%03d", b))
+		}
+	}
+	nsid := fmt.Sprintf("%s %s", hex.EncodeToString(d), ascii.String())
+	return nsid, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7873
+
+Cookie EDNS0 option format, rendered as "<client> <server>"
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                  Client Cookie (8 bytes)                      /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/            Server Cookie (8 to 32 bytes, optional)            /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseCookie(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	// be tolerant with bad cookie lengths, the data is kept as the client cookie
+	clientLength := 8
+	if len(d) < clientLength {
+		clientLength = len(d)
+	}
+	client := hex.EncodeToString(d[:clientLength])
+	server := "-"
+	if len(d) > clientLength {
+		server = hex.EncodeToString(d[clientLength:])
+	}
+	cookie := fmt.Sprintf("%s %s", client, server)
+	return cookie, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7828
+
+TCP keepalive EDNS0 option format, the timeout is rendered in seconds
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                 TIMEOUT (units of 100 ms, optional)           |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeepalive(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 2:
+		timeout := float64(binary.BigEndian.Uint16(d)) / 10
+		return strconv.FormatFloat(timeout, 'f', 1, 64), nil
+	default:
+		return "-", nil
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7830
+
+Padding EDNS0 option format, rendered as the padding length
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                   PADDING OCTETS                              /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParsePadding(d []byte) (string, error) {
+	return strconv.Itoa(len(d)), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7314
+
+Expire EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                 EXPIRE (seconds, optional)                    |
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseExpire(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 4:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(d)), 10), nil
+	default:
+		return "-", nil
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7901
+
+Chain EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                 Closest trust point                           /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseChain(d []byte) (string, error) {
+	name, _, err := ParseLabels(0, d)
+	if err != nil {
+		return "-", nil
+	}
+	return nameToPresentation(name), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc8145
+
+Key tag EDNS0 option format, rendered as a comma separated list
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           KEY-TAG                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                           ...                                 /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeyTag(d []byte) (string, error) {
+	if len(d) == 0 || len(d)%2 != 0 {
+		return "-", nil
+	}
+	keytags := make([]string, 0, len(d)/2)
+	for offset := 0; offset < len(d); offset += 2 {
+		keytags = append(keytags, strconv.Itoa(int(binary.BigEndian.Uint16(d[offset:offset+2]))))
+	}
+	return strings.Join(keytags, ","), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc6975
+
+DAU, DHU and N3U EDNS0 options format, rendered as a comma separated list
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|    ALG-CODE   |                    ...                        /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseAlgorithms(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	algorithms := make([]string, 0, len(d))
+	for _, alg := range d {
+		algorithms = append(algorithms, strconv.Itoa(int(alg)))
+	}
+	return strings.Join(algorithms, ","), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/draft-bellis-dnsop-edns-tags
+
+Client tag and server tag EDNS0 options format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                              TAG                              |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseTag(d []byte) (string, error) {
+	if len(d) != 2 {
+		return "-", nil
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(d[:2]))), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc9660
+
+Zone version EDNS0 option format, rendered as "<label-count> <type> <version>"
+the version is a decimal serial for the type 0 (SOA-SERIAL), hexadecimal otherwise
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|  LABELCOUNT   |     TYPE      |                               /
++---+---+---+---+---+---+---+---+                               /
+/                           VERSION                             /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseZoneVersion(d []byte) (string, error) {
+	// empty in queries
+	if len(d) < 2 {
+		return "-", nil
+	}
+	labelCount := d[0]
+	versionType := d[1]
+	version := hex.EncodeToString(d[2:])
+	if versionType == 0 {
+		if len(d) != 6 {
+			return "-", nil
+		}
+		version = strconv.FormatUint(uint64(binary.BigEndian.Uint32(d[2:6])), 10)
+	}
+	zoneversion := fmt.Sprintf("%d %d %s", labelCount, versionType, version)
+	return zoneversion, nil
+}
@@ -863,3 +863,125 @@ func TestDecodeEdns_invalidRRFollows(t *testing.T) {
 		t.Errorf("bad error received: %v", err)
 	}
 }
+
+func TestDecodeEdns_Options(t *testing.T) {
+	testcases := []struct {
+		option dns.EDNS0
+		name   string
+		want   string
+	}{
+		{&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73312e616d7331"}, "NSID", "6e73312e616d7331 ns1.ams1"},
+		{&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73312061"}, "NSID", `6e73312061 ns1\032a`},
+		{&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: ""}, "NSID", "-"},
+		{&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223a3b6d2"}, "COOKIE", "24a5ac1223a3b6d2 -"},
+		{&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223a3b6d201000000645b7c1f87e9a1d4b2c3e4f5"},
+			"COOKIE", "24a5ac1223a3b6d2 01000000645b7c1f87e9a1d4b2c3e4f5"},
+		{&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 125}, "KEEPALIVE", "12.5"},
+		{&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE}, "KEEPALIVE", "-"},
+		{&dns.EDNS0_PADDING{Padding: make([]byte, 128)}, "PADDING", "128"},
+		{&dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: 604800}, "EXPIRE", "604800"},
+		{&dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true}, "EXPIRE", "-"},
+		{&dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: []uint8{8, 13, 15}}, "DAU", "8,13,15"},
+		{&dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: []uint8{1, 2}}, "DHU", "1,2"},
+		{&dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: []uint8{1}}, "N3U", "1"},
+		{&dns.EDNS0_LOCAL{Code: 13, Data: []byte{0x04, 0x74, 0x65, 0x73, 0x74, 0x00}}, "CHAIN", "test"},
+		{&dns.EDNS0_LOCAL{Code: 14, Data: []byte{0x4f, 0x66, 0x4e, 0xbc}}, "KEY-TAG", "20326,20156"},
+		{&dns.EDNS0_LOCAL{Code: 16, Data: []byte{0x00, 0x2a}}, "CLIENT-TAG", "42"},
+		{&dns.EDNS0_LOCAL{Code: 17, Data: []byte{0x01, 0x00}}, "SERVER-TAG", "256"},
+		{&dns.EDNS0_LOCAL{Code: 19, Data: []byte{0x02, 0x00, 0x78, 0x8d, 0x6d, 0xca}}, "ZONEVERSION", "2 0 2022534602"},
+		{&dns.EDNS0_LOCAL{Code: 19, Data: []byte{0x02, 0x01, 0xca, 0xfe}}, "ZONEVERSION", "2 1 cafe"},
+	}
+
+	for _, tc := range testcases {
+		dm := new(dns.Msg)
+		dm.SetQuestion("dnstapcollector.test.", dns.TypeA)
+
+		e := &dns.OPT{}
+		e.Hdr.Name = "."
+		e.Hdr.Rrtype = dns.TypeOPT
+		e.Option = append(e.Option, tc.option)
+		dm.Extra = append(dm.Extra, e)
+
+		payload, err := dm.Pack()
+		if err != nil {
+			t.Fatalf("unable to pack option %s: %v", tc.name, err)
+		}
+
+		_, _, offset_rr, _ := DecodeQuestion(1, payload)
+		edns, _, err := DecodeEDNS(len(dm.Extra), offset_rr, payload)
+		if err != nil {
+			t.Errorf("edns error returned for %s: %v", tc.name, err)
+			continue
+		}
+		if len(edns.Options) != 1 {
+			t.Errorf("expected one EDNS option for %s, got %d", tc.name, len(edns.Options))
+			continue
+		}
+		if edns.Options[0].Name != tc.name || edns.Options[0].Data != tc.want {
+			t.Errorf("bad option parsed, want %s %s, got %s %s", tc.name, tc.want, edns.Options[0].Name, edns.Options[0].Data)
+		}
+	}
+}
+
+func TestDecodeEdns_Options_Invalid(t *testing.T) {
+	testcases := []struct {
+		name string
+		data []byte
+	}{
+		{"COOKIE", []byte{}},
+		{"KEEPALIVE", []byte{0x01}},
+		{"EXPIRE", []byte{0x00, 0x01}},
+		{"CHAIN", []byte{0x04, 0x74}},
+		{"KEY-TAG", []byte{}},
+		{"KEY-TAG", []byte{0x4f, 0x66, 0x4e}},
+		{"CLIENT-TAG", []byte{0x01}},
+		{"SERVER-TAG", []byte{0x00, 0x01, 0x02}},
+		{"ZONEVERSION", []byte{0x02}},
+		{"ZONEVERSION", []byte{0x02, 0x00, 0x01}},
+	}
+
+	// the undecodable data is not an error, the packet is not malformed
+	for _, tc := range testcases {
+		data, err := ParseOption(tc.name, tc.data)
+		if err != nil {
+			t.Errorf("error returned for %s: %v", tc.name, err)
+		}
+		if data != "-" {
+			t.Errorf("bad data for %s, want -, got %s", tc.name, data)
+		}
+	}
+}
+
+func TestDecodeEdns_Options_InvalidNotMalformed(t *testing.T) {
+	dm := new(dns.Msg)
+	dm.SetQuestion("dnstapcollector.test.", dns.TypeA)
+
+	e := new(dns.OPT)
+	e.Hdr.Name = "."
+	e.Hdr.Rrtype = dns.TypeOPT
+	e.Option = append(e.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE, Data: []byte{0x01}})
+	dm.Extra = append(dm.Extra, e)
+
+	payload, err := dm.Pack()
+	if err != nil {
+		t.Fatalf("unable to pack message: %v", err)
+	}
+
+	msg := DnsMessage{}
+	msg.Init()
+	msg.DNS.Payload = payload
+	msg.DNS.Length = len(payload)
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Fatalf("unable to decode header: %v", err)
+	}
+	if err := DecodePayload(&msg, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.DNS.MalformedPacket {
+		t.Errorf("packet should not be malformed")
+	}
+	if len(msg.EDNS.Options) != 1 || msg.EDNS.Options[0].Data != "-" {
+		t.Errorf("bad option decoded: %+v", msg.EDNS.Options)
+	}
+}
@@ -0,0 +1,252 @@
+package dnsutils
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	ErrEncodeDnsNoQuestion     = errors.New("encode, no question to encode")
+	ErrEncodeDnsRecordsIgnored = errors.New("encode, resource records ignored")
+)
+
+// GetPayload returns the DNS payload of the message, the payload is encoded from
+// the decoded fields when it is not available (tail collector, replayed json, ...).
+// The resource records which can not be encoded are missing from the payload.
+func (dm *DnsMessage) GetPayload() ([]byte, error) {
+	if len(dm.DNS.Payload) > 0 {
+		return dm.DNS.Payload, nil
+	}
+	payload, err := EncodeDns(dm)
+	if errors.Is(err, ErrEncodeDnsRecordsIgnored) {
+		return payload, nil
+	}
+	return payload, err
+}
+
+// EncodeDns builds a DNS message in wire format from the decoded fields:
+// header, questions, resource records and EDNS options.
+// Records with undecoded rdata and options which can not be encoded are ignored.
+// The records with an invalid rdata are ignored too, the payload is then returned
+// with an error wrapping ErrEncodeDnsRecordsIgnored.
+func EncodeDns(dm *DnsMessage) ([]byte, error) {
+	m := new(dns.Msg)
+
+	// header
+	m.Id = uint16(dm.DNS.Id)
+	m.Response = dm.DNS.Flags.QR || dm.DNS.Type == DnsReply
+	m.Opcode = dm.DNS.Opcode
+	m.Authoritative = dm.DNS.Flags.AA
+	m.Truncated = dm.DNS.Flags.TC
+	m.RecursionDesired = dm.DNS.Flags.RD
+	m.RecursionAvailable = dm.DNS.Flags.RA
+	m.Zero = dm.DNS.Flags.Z
+	m.AuthenticatedData = dm.DNS.Flags.AD
+	m.CheckingDisabled = dm.DNS.Flags.CD
+	m.Rcode = rcodeFromString(dm.DNS.Rcode) | dm.EDNS.ExtendedRcode
+
+	// question section
+	questions := dm.DNS.Questions
+	if len(questions) == 0 && dm.DNS.Qname != "-" {
+		questions = []DnsQuestion{{Qname: dm.DNS.Qname, Qtype: dm.DNS.Qtype, Qclass: dm.DNS.Qclass}}
+	}
+	if len(questions) == 0 {
+		return nil, ErrEncodeDnsNoQuestion
+	}
+	for _, q := range questions {
+		m.Question = append(m.Question, dns.Question{
+			Name:   dns.Fqdn(q.Qname),
+			Qtype:  uint16(rdatatypeFromString(q.Qtype)),
+			Qclass: uint16(classFromString(q.Qclass)),
+		})
+	}
+
+	// resource records
+	var invalid []string
+	m.Answer = encodeResourceRecords(dm.DNS.DnsRRs.Answers, &invalid)
+	m.Ns = encodeResourceRecords(dm.DNS.DnsRRs.Nameservers, &invalid)
+	m.Extra = encodeResourceRecords(dm.DNS.DnsRRs.Records, &invalid)
+
+	// extended dns
+	if dm.EDNS.UdpSize > 0 || len(dm.EDNS.Options) > 0 {
+		m.Extra = append(m.Extra, encodeEDNS(&dm.EDNS))
+	}
+
+	payload, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if len(invalid) > 0 {
+		return payload, fmt.Errorf("%w: %s", ErrEncodeDnsRecordsIgnored, strings.Join(invalid, ", "))
+	}
+	return payload, nil
+}
+
+// encodeResourceRecords converts back the decoded records, the records with an invalid
+// rdata are appended to the invalid list
+func encodeResourceRecords(records []DnsAnswer, invalid *[]string) []dns.RR {
+	rrs := []dns.RR{}
+	for _, rr := range records {
+		if rr.Rdata == "-" {
+			continue
+		}
+		class := "IN"
+		if rr.Class > 0 {
+			class = ClassToString(rr.Class)
+		}
+		rdata := rr.Rdata
+		if rr.Rdatatype == "TXT" || rr.Rdatatype == "SPF" {
+			rdata = characterStringToStr([]byte(rdata))
+		}
+		r, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s", dns.Fqdn(rr.Name), rr.Ttl, class, rr.Rdatatype, rdata))
+		if err != nil || r == nil {
+			*invalid = append(*invalid, fmt.Sprintf("%s %s %s", rr.Name, rr.Rdatatype, rr.Rdata))
+			continue
+		}
+		rrs = append(rrs, r)
+	}
+	return rrs
+}
+
+func encodeEDNS(edns *DnsExtended) *dns.OPT {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(uint16(edns.UdpSize))
+	opt.SetVersion(uint8(edns.Version))
+	opt.SetZ(uint16(edns.Z))
+	if edns.Do == 1 {
+		opt.SetDo()
+	}
+	for _, o := range edns.Options {
+		if option := encodeOption(o); option != nil {
+			opt.Option = append(opt.Option, option)
+		}
+	}
+	return opt
+}
+
+// encodeOption converts back the decoded data of an EDNS option, nil is
+// returned for options without data or not supported
+func encodeOption(o DnsOption) dns.EDNS0 {
+	fields := strings.Fields(o.Data)
+	if len(fields) == 0 || o.Data == "-" {
+		return nil
+	}
+
+	switch o.Name {
+	case "CSUBNET":
+		_, subnet, err := net.ParseCIDR(strings.NewReplacer("[", "", "]", "").Replace(o.Data))
+		if err != nil {
+			return nil
+		}
+		ones, _ := subnet.Mask.Size()
+		e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, SourceNetmask: uint8(ones), Address: subnet.IP, Family: 2}
+		if subnet.IP.To4() != nil {
+			e.Family = 1
+		}
+		return e
+	case "NSID":
+		return &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: fields[0]}
+	case "COOKIE":
+		cookie := fields[0]
+		if len(fields) > 1 && fields[1] != "-" {
+			cookie += fields[1]
+		}
+		return &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie}
+	case "ERRORS":
+		code, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil
+		}
+		// the data is "<code> <purpose> <extra text>"
+		prefix := fmt.Sprintf("%d -", code)
+		if purpose, ok := ErrorCodeToString[code]; ok {
+			prefix = fmt.Sprintf("%d %s", code, purpose)
+		}
+		extraText := strings.TrimPrefix(strings.TrimPrefix(o.Data, prefix), " ")
+		if extraText == "-" {
+			extraText = ""
+		}
+		return &dns.EDNS0_EDE{InfoCode: uint16(code), ExtraText: extraText}
+	case "KEEPALIVE":
+		timeout, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil
+		}
+		return &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: uint16(timeout * 10)}
+	case "PADDING":
+		length, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil
+		}
+		return &dns.EDNS0_PADDING{Padding: make([]byte, length)}
+	case "EXPIRE":
+		expire, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil
+		}
+		return &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: uint32(expire)}
+	case "DAU", "DHU", "N3U":
+		algorithms := []uint8{}
+		for _, alg := range strings.Split(fields[0], ",") {
+			v, err := strconv.ParseUint(alg, 10, 8)
+			if err != nil {
+				return nil
+			}
+			algorithms = append(algorithms, uint8(v))
+		}
+		switch o.Name {
+		case "DAU":
+			return &dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: algorithms}
+		case "DHU":
+			return &dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: algorithms}
+		default:
+			return &dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: algorithms}
+		}
+	}
+
+	// fallback on the raw data when the option is kept as hex
+	if data, err := hex.DecodeString(o.Data); err == nil && o.Name == STR_UNKNOWN {
+		return &dns.EDNS0_LOCAL{Code: uint16(o.Code), Data: data}
+	}
+	return nil
+}
+
+func rcodeFromString(rcode string) int {
+	for k, v := range Rcodes {
+		if v == rcode {
+			return k
+		}
+	}
+	return 0
+}
+
+func rdatatypeFromString(rdatatype string) int {
+	for k, v := range Rdatatypes {
+		if v == rdatatype {
+			return k
+		}
+	}
+	if v, err := strconv.Atoi(strings.TrimPrefix(rdatatype, "TYPE")); err == nil {
+		return v
+	}
+	return 0
+}
+
+func classFromString(class string) int {
+	for k, v := range Classes {
+		if v == class {
+			return k
+		}
+	}
+	if v, err := strconv.Atoi(strings.TrimPrefix(class, "CLASS")); err == nil {
+		return v
+	}
+	// default to the internet class
+	return 1
+}
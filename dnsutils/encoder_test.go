@@ -0,0 +1,161 @@
+package dnsutils
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestEncodeDns_RoundTrip(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m.Id = 4242
+	m.Response = true
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	m.AuthenticatedData = true
+
+	for _, rr := range []string{
+		"www.dnscollector.dev. 300 IN CNAME web.dnscollector.dev.",
+		"web.dnscollector.dev. 300 IN A 192.0.2.1",
+		"web.dnscollector.dev. 300 IN TXT \"hello world\"",
+	} {
+		r, _ := dns.NewRR(rr)
+		m.Answer = append(m.Answer, r)
+	}
+	ns, _ := dns.NewRR("dnscollector.dev. 3600 IN NS ns1.dnscollector.dev.")
+	m.Ns = append(m.Ns, ns)
+	glue, _ := dns.NewRR("ns1.dnscollector.dev. 3600 IN AAAA 2001:db8::1")
+	m.Extra = append(m.Extra, glue)
+
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(1232)
+	opt.SetDo()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223b2b6d5f1c2a3b4c5d6e7f8"},
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer, ExtraText: "stale data"},
+		&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 150},
+	)
+	m.Extra = append(m.Extra, opt)
+
+	dm := decodeTestPayload(t, m)
+
+	// encode from the decoded fields only
+	dm.DNS.Payload = []byte{}
+	payload, err := EncodeDns(&dm)
+	if err != nil {
+		t.Fatalf("unexpected error when encoding: %v", err)
+	}
+
+	dmEncoded := DnsMessage{}
+	dmEncoded.Init()
+	dmEncoded.DNS.Payload = payload
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Fatalf("unexpected error when decoding header: %v", err)
+	}
+	if err = DecodePayload(&dmEncoded, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error while decoding payload: %v", err)
+	}
+
+	if dmEncoded.DNS.Id != dm.DNS.Id || dmEncoded.DNS.Rcode != dm.DNS.Rcode ||
+		dmEncoded.DNS.Qname != dm.DNS.Qname || dmEncoded.DNS.Qtype != dm.DNS.Qtype {
+		t.Errorf("invalid header or question, got %+v", dmEncoded.DNS)
+	}
+	if !reflect.DeepEqual(dmEncoded.DNS.Flags, dm.DNS.Flags) {
+		t.Errorf("invalid flags, want %+v got %+v", dm.DNS.Flags, dmEncoded.DNS.Flags)
+	}
+	if !reflect.DeepEqual(dmEncoded.DNS.DnsRRs, dm.DNS.DnsRRs) {
+		t.Errorf("invalid records, want %+v got %+v", dm.DNS.DnsRRs, dmEncoded.DNS.DnsRRs)
+	}
+	if !reflect.DeepEqual(dmEncoded.EDNS, dm.EDNS) {
+		t.Errorf("invalid edns, want %+v got %+v", dm.EDNS, dmEncoded.EDNS)
+	}
+}
+
+func TestEncodeDns_FromFields(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Type = DnsReply
+	dm.DNS.Qname = "dnscollector.dev"
+	dm.DNS.Qtype = "AAAA"
+	dm.DNS.Rcode = DNS_RCODE_NXDOMAIN
+
+	payload, err := EncodeDns(&dm)
+	if err != nil {
+		t.Fatalf("unexpected error when encoding: %v", err)
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if !m.Response || m.Rcode != dns.RcodeNameError {
+		t.Errorf("invalid header: %s", m.MsgHdr.String())
+	}
+	if len(m.Question) != 1 || m.Question[0].Name != "dnscollector.dev." ||
+		m.Question[0].Qtype != dns.TypeAAAA || m.Question[0].Qclass != dns.ClassINET {
+		t.Errorf("invalid question: %v", m.Question)
+	}
+}
+
+func TestEncodeDns_NoQuestion(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+
+	if _, err := EncodeDns(&dm); err != ErrEncodeDnsNoQuestion {
+		t.Errorf("error expected, got %v", err)
+	}
+}
+
+func TestEncodeDns_InvalidRecord(t *testing.T) {
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Type = DnsReply
+	dm.DNS.Qname = "dnscollector.dev"
+	dm.DNS.Qtype = "A"
+	dm.DNS.DnsRRs.Answers = []DnsAnswer{
+		{Name: "dnscollector.dev", Rdatatype: "A", Class: 1, Ttl: 300, Rdata: "not-an-ip"},
+		{Name: "dnscollector.dev", Rdatatype: "A", Class: 1, Ttl: 300, Rdata: "192.0.2.1"},
+	}
+
+	// the valid records are encoded, the invalid one is reported
+	payload, err := EncodeDns(&dm)
+	if !errors.Is(err, ErrEncodeDnsRecordsIgnored) || !strings.Contains(err.Error(), "not-an-ip") {
+		t.Errorf("ignored record not reported, got %v", err)
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if len(m.Answer) != 1 {
+		t.Errorf("invalid answers: %v", m.Answer)
+	}
+
+	// the partial payload is used by the loggers
+	if _, err := dm.GetPayload(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDnsMessage_ToPacketLayer_WithoutPayload(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.NetworkInfo.Family = PROTO_IPV4
+
+	for _, proto := range []string{PROTO_UDP, PROTO_TCP} {
+		dm.NetworkInfo.Protocol = proto
+		pkt, err := dm.ToPacketLayer()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", proto, err)
+		}
+		if len(pkt) != 4 {
+			t.Errorf("%s: invalid number of layers: %d", proto, len(pkt))
+		}
+	}
+}
@@ -0,0 +1,947 @@
+package dnsutils
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expression is a boolean expression evaluated against a dns message,
+// fields are referenced with the keys of the flattened json message (dns.qname, network.query-ip, ...)
+//
+// Supported syntax:
+//   - logical operators: and, or, not, parenthesis
+//   - comparisons: ==, !=, <, <=, >, >=
+//   - string and list operators: in, contains, startswith, endswith, matches (regular expression)
+//   - literals: "string", 'string', numbers, true, false and lists ["A", "AAAA"]
+//
+// Example: dns.qtype in ["ANY", "TXT"] and network.protocol == "UDP" and dns.length > 512
+type Expression struct {
+	source   string
+	root     exprNode
+	sections bool
+}
+
+// CompileExpression parses the expression and resolves all fields against the dns message structure
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := exprTokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected %s", p.peek())
+	}
+	return &Expression{source: source, root: root, sections: p.sections}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Match returns true if the dns message matches the expression
+func (e *Expression) Match(dm *DnsMessage) bool {
+	if e.sections {
+		dm.DecodeSections()
+	}
+	return e.root.eval(reflect.ValueOf(dm).Elem()).truth()
+}
+
+// Field is a field of the dns message, referenced with the key of the flattened json message
+type Field struct {
+	node *fieldNode
+}
+
+// CompileField resolves the field against the dns message structure
+func CompileField(name string) (*Field, error) {
+	node, err := compileField(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Field{node: node}, nil
+}
+
+// CompileSection resolves the field or a section of the dns message (geoip, edns.options, ...),
+// a section can be deleted but its value is not readable as text
+func CompileSection(name string) (*Field, error) {
+	node, _, err := compileFieldPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Field{node: node}, nil
+}
+
+// decodeSections decodes the resource records of the message when the field is read from them
+func (f *Field) decodeSections(dm *DnsMessage) {
+	if f.node.sections {
+		dm.DecodeSections()
+	}
+}
+
+// Name returns the key of the field
+func (f *Field) Name() string {
+	return f.node.name
+}
+
+// Value returns the value of the field formatted as text, "-" if the field is not set
+func (f *Field) Value(dm *DnsMessage) string {
+	f.decodeSections(dm)
+	return f.node.eval(reflect.ValueOf(dm).Elem()).text()
+}
+
+// Lookup returns the value of the field formatted as text, ok is false if the field is not set
+func (f *Field) Lookup(dm *DnsMessage) (string, bool) {
+	f.decodeSections(dm)
+	v := f.node.eval(reflect.ValueOf(dm).Elem())
+	return v.text(), v.kind != valueNil
+}
+
+// Set updates the field with the text value converted to the type of the field,
+// lists of strings are separated by a comma. The sections, lists and maps on the path
+// are copied before the update, they are shared with the others loggers.
+func (f *Field) Set(dm *DnsMessage, value string) error {
+	f.decodeSections(dm)
+	return f.node.update(reflect.ValueOf(dm).Elem(), &value)
+}
+
+// CheckValue returns an error if the text value can not be converted to the type of the field
+func (f *Field) CheckValue(value string) error {
+	if f.node.typ == nil {
+		return fmt.Errorf("field %s is not a value", f.node.name)
+	}
+	if err := setReflectValue(reflect.New(f.node.typ).Elem(), value); err != nil {
+		return fmt.Errorf("invalid value for field %s: %v", f.node.name, err)
+	}
+	return nil
+}
+
+// Accepts returns true if any value of the src field can be set to the field: the text
+// and list fields accept all the values, the others a field of the same kind.
+// The integers are also accepted by the floats.
+func (f *Field) Accepts(src *Field) bool {
+	if f.node.typ == nil || src.node.typ == nil {
+		return false
+	}
+	dst, from := valueClass(f.node.typ), valueClass(src.node.typ)
+	switch {
+	case dst == reflect.String, dst == reflect.Slice:
+		return true
+	case dst == reflect.Float64:
+		return from == reflect.Float64 || from == reflect.Int || from == reflect.Uint
+	}
+	return dst == from
+}
+
+// valueClass returns the kind of the values of the type, sized kinds are merged
+func valueClass(t reflect.Type) reflect.Kind {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return t.Kind()
+}
+
+// Delete resets the field to its zero value, the sections are removed and the keys deleted from the maps
+func (f *Field) Delete(dm *DnsMessage) {
+	f.decodeSections(dm)
+	f.node.update(reflect.ValueOf(dm).Elem(), nil)
+}
+
+// values
+
+type exprKind int
+
+const (
+	valueNil exprKind = iota
+	valueBool
+	valueNumber
+	valueString
+	valueList
+)
+
+type exprValue struct {
+	kind exprKind
+	b    bool
+	num  float64
+	str  string
+	list []exprValue
+}
+
+func boolValue(b bool) exprValue {
+	return exprValue{kind: valueBool, b: b}
+}
+
+func (v exprValue) truth() bool {
+	switch v.kind {
+	case valueBool:
+		return v.b
+	case valueNumber:
+		return v.num != 0
+	case valueString:
+		return v.str != ""
+	case valueList:
+		return len(v.list) > 0
+	}
+	return false
+}
+
+// number returns the numeric value, strings are converted if possible
+func (v exprValue) number() (float64, bool) {
+	switch v.kind {
+	case valueNumber:
+		return v.num, true
+	case valueString:
+		n, err := strconv.ParseFloat(v.str, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// compare returns -1, 0 or 1, ok is false if the values can not be compared
+func (v exprValue) compare(o exprValue) (int, bool) {
+	if v.kind == valueNumber || o.kind == valueNumber {
+		a, okA := v.number()
+		b, okB := o.number()
+		if !okA || !okB {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch {
+	case v.kind == valueString && o.kind == valueString:
+		return strings.Compare(v.str, o.str), true
+	case v.kind == valueBool && o.kind == valueBool:
+		if v.b == o.b {
+			return 0, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func (v exprValue) equal(o exprValue) bool {
+	c, ok := v.compare(o)
+	return ok && c == 0
+}
+
+func (v exprValue) contains(o exprValue) bool {
+	switch v.kind {
+	case valueList:
+		for _, item := range v.list {
+			if item.equal(o) {
+				return true
+			}
+		}
+	case valueString:
+		return o.kind == valueString && strings.Contains(v.str, o.str)
+	}
+	return false
+}
+
+func (v exprValue) text() string {
+	switch v.kind {
+	case valueBool:
+		return strconv.FormatBool(v.b)
+	case valueNumber:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case valueString:
+		return v.str
+	case valueList:
+		items := make([]string, len(v.list))
+		for i := range v.list {
+			items[i] = v.list[i].text()
+		}
+		return strings.Join(items, ",")
+	}
+	return "-"
+}
+
+// nodes
+
+type exprNode interface {
+	eval(dm reflect.Value) exprValue
+}
+
+type literalNode struct {
+	value exprValue
+}
+
+func (n *literalNode) eval(dm reflect.Value) exprValue {
+	return n.value
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n *notNode) eval(dm reflect.Value) exprValue {
+	return boolValue(!n.operand.eval(dm).truth())
+}
+
+type andNode struct {
+	left, right exprNode
+}
+
+func (n *andNode) eval(dm reflect.Value) exprValue {
+	return boolValue(n.left.eval(dm).truth() && n.right.eval(dm).truth())
+}
+
+type orNode struct {
+	left, right exprNode
+}
+
+func (n *orNode) eval(dm reflect.Value) exprValue {
+	return boolValue(n.left.eval(dm).truth() || n.right.eval(dm).truth())
+}
+
+type compareNode struct {
+	op          string
+	negate      bool
+	left, right exprNode
+	re          *regexp.Regexp
+}
+
+func (n *compareNode) eval(dm reflect.Value) exprValue {
+	left := n.left.eval(dm)
+	right := n.right.eval(dm)
+
+	var ret bool
+	switch n.op {
+	case "==":
+		ret = left.equal(right)
+	case "!=":
+		ret = !left.equal(right)
+	case "<", "<=", ">", ">=":
+		c, ok := left.compare(right)
+		if ok {
+			switch n.op {
+			case "<":
+				ret = c < 0
+			case "<=":
+				ret = c <= 0
+			case ">":
+				ret = c > 0
+			case ">=":
+				ret = c >= 0
+			}
+		}
+	case "in":
+		ret = right.contains(left)
+	case "contains":
+		ret = left.contains(right)
+	case "startswith":
+		ret = left.kind == valueString && right.kind == valueString && strings.HasPrefix(left.str, right.str)
+	case "endswith":
+		ret = left.kind == valueString && right.kind == valueString && strings.HasSuffix(left.str, right.str)
+	case "matches":
+		ret = left.kind == valueString && n.re.MatchString(left.str)
+	}
+
+	if n.negate {
+		return boolValue(!ret)
+	}
+	return boolValue(ret)
+}
+
+// fields
+
+type fieldStep struct {
+	kind  reflect.Kind
+	index int
+	key   reflect.Value
+}
+
+type fieldNode struct {
+	name     string
+	steps    []fieldStep
+	sections bool
+	typ      reflect.Type // type of the value, pointers removed
+}
+
+var dnsMessageType = reflect.TypeOf(DnsMessage{})
+
+// isSectionsField returns true for the fields decoded with the resource records, see DecodePayloadLazy
+func isSectionsField(name string) bool {
+	for _, prefix := range []string{"dns.resource-records", "dns.malformed-packet", "edns"} {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return name == "dns"
+}
+
+// fieldAliases references the fields hidden from the json message by their go names
+var fieldAliases = map[string][]string{
+	"dns.id": {"DNS", "Id"},
+}
+
+func compileField(name string) (*fieldNode, error) {
+	node, t, err := compileFieldPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	node.typ = t
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Ptr:
+		return nil, fmt.Errorf("field %s is not a value", name)
+	}
+	return node, nil
+}
+
+// compileFieldPath resolves the steps of the field and returns the type of the field
+func compileFieldPath(name string) (*fieldNode, reflect.Type, error) {
+	node := &fieldNode{name: name, sections: isSectionsField(name)}
+	t := dnsMessageType
+
+	if path, ok := fieldAliases[name]; ok {
+		for _, goName := range path {
+			f, _ := t.FieldByName(goName)
+			node.steps = append(node.steps, fieldStep{kind: reflect.Struct, index: f.Index[0]})
+			t = f.Type
+		}
+		return node, t, nil
+	}
+
+	for _, part := range strings.Split(name, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			index := -1
+			for i := 0; i < t.NumField(); i++ {
+				tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+				if tag != "" && tag != "-" && tag == part {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				return nil, nil, fmt.Errorf("unknown field %s", name)
+			}
+			node.steps = append(node.steps, fieldStep{kind: reflect.Struct, index: index})
+			t = t.Field(index).Type
+
+		case reflect.Slice:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || t.Elem().Kind() == reflect.Uint8 {
+				return nil, nil, fmt.Errorf("invalid index %s in field %s", part, name)
+			}
+			node.steps = append(node.steps, fieldStep{kind: reflect.Slice, index: index})
+			t = t.Elem()
+
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return nil, nil, fmt.Errorf("unsupported field %s", name)
+			}
+			node.steps = append(node.steps, fieldStep{kind: reflect.Map, key: reflect.ValueOf(part).Convert(t.Key())})
+			t = t.Elem()
+
+		default:
+			return nil, nil, fmt.Errorf("unknown field %s", name)
+		}
+	}
+
+	return node, t, nil
+}
+
+func (n *fieldNode) eval(dm reflect.Value) exprValue {
+	v := dm
+	for _, step := range n.steps {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return exprValue{}
+			}
+			v = v.Elem()
+		}
+
+		switch step.kind {
+		case reflect.Struct:
+			v = v.Field(step.index)
+		case reflect.Slice:
+			if step.index >= v.Len() {
+				return exprValue{}
+			}
+			v = v.Index(step.index)
+		case reflect.Map:
+			v = v.MapIndex(step.key)
+			if !v.IsValid() {
+				return exprValue{}
+			}
+		}
+	}
+	return reflectValue(v)
+}
+
+// update sets or deletes (nil value) the field, the sections, lists and maps
+// on the path are copied before the update
+func (n *fieldNode) update(v reflect.Value, value *string) error {
+	for i, step := range n.steps {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() && value == nil {
+				return nil
+			}
+			section := reflect.New(v.Type().Elem())
+			if !v.IsNil() {
+				section.Elem().Set(v.Elem())
+			}
+			v.Set(section)
+			v = section.Elem()
+		}
+
+		switch step.kind {
+		case reflect.Struct:
+			v = v.Field(step.index)
+		case reflect.Slice:
+			if step.index >= v.Len() {
+				if value == nil {
+					return nil
+				}
+				return fmt.Errorf("index %d out of range for field %s", step.index, n.name)
+			}
+			items := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			reflect.Copy(items, v)
+			v.Set(items)
+			v = items.Index(step.index)
+		case reflect.Map:
+			if i != len(n.steps)-1 {
+				return fmt.Errorf("unsupported field %s", n.name)
+			}
+			if v.IsNil() && value == nil {
+				return nil
+			}
+			items := reflect.MakeMapWithSize(v.Type(), v.Len()+1)
+			iter := v.MapRange()
+			for iter.Next() {
+				items.SetMapIndex(iter.Key(), iter.Value())
+			}
+			v.Set(items)
+			if value == nil {
+				items.SetMapIndex(step.key, reflect.Value{})
+				return nil
+			}
+			item := reflect.New(v.Type().Elem()).Elem()
+			if err := setReflectValue(item, *value); err != nil {
+				return fmt.Errorf("invalid value for field %s: %v", n.name, err)
+			}
+			items.SetMapIndex(step.key, item)
+			return nil
+		}
+	}
+
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if err := setReflectValue(v, *value); err != nil {
+		return fmt.Errorf("invalid value for field %s: %v", n.name, err)
+	}
+	return nil
+}
+
+// setReflectValue converts the text to the type of the value
+func setReflectValue(v reflect.Value, text string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		item := reflect.New(v.Type().Elem())
+		if err := setReflectValue(item.Elem(), text); err != nil {
+			return err
+		}
+		v.Set(item)
+	case reflect.Slice:
+		switch v.Type().Elem().Kind() {
+		case reflect.Uint8:
+			v.SetBytes([]byte(text))
+		case reflect.String:
+			items := reflect.MakeSlice(v.Type(), 0, 0)
+			for _, item := range strings.Split(text, ",") {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(v.Type().Elem()))
+			}
+			v.Set(items)
+		default:
+			return fmt.Errorf("not a value")
+		}
+	default:
+		return fmt.Errorf("not a value")
+	}
+	return nil
+}
+
+func reflectValue(v reflect.Value) exprValue {
+	switch v.Kind() {
+	case reflect.String:
+		return exprValue{kind: valueString, str: v.String()}
+	case reflect.Bool:
+		return boolValue(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return exprValue{kind: valueNumber, num: float64(v.Int())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return exprValue{kind: valueNumber, num: float64(v.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return exprValue{kind: valueNumber, num: v.Float()}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return exprValue{}
+		}
+		return reflectValue(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return exprValue{kind: valueString, str: string(v.Bytes())}
+		}
+		list := make([]exprValue, v.Len())
+		for i := range list {
+			list[i] = reflectValue(v.Index(i))
+		}
+		return exprValue{kind: valueList, list: list}
+	}
+	return exprValue{}
+}
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type exprToken struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '-' || c == '.' || c == '+'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func exprTokenize(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',':
+			tokens = append(tokens, exprToken{kind: tokenPunct, value: string(c), pos: i})
+			i++
+
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("invalid operator %q at position %d", op, i)
+			}
+			tokens = append(tokens, exprToken{kind: tokenOperator, value: op, pos: i})
+			i += len(op)
+
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			start := i
+			i++
+			closed := false
+			for i < len(s) {
+				if s[i] == c {
+					closed = true
+					i++
+					break
+				}
+				// only the quote and backslash are escaped, to keep regular expressions readable
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == c || s[i+1] == '\\') {
+					i++
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, value: sb.String(), pos: start})
+
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			start := i
+			i++
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, value: s[start:i], pos: start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, value: s[start:i], pos: start})
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(s)}), nil
+}
+
+// parser
+
+var exprKeywordOperators = map[string]bool{
+	"in": true, "contains": true, "startswith": true, "endswith": true, "matches": true,
+}
+
+type exprParser struct {
+	tokens   []exprToken
+	pos      int
+	sections bool
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && t.value == word
+}
+
+func (p *exprParser) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf(format+" at position %d", append(v, p.peek().pos)...)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	negate := false
+	if p.isKeyword("not") && p.pos+1 < len(p.tokens) &&
+		p.tokens[p.pos+1].kind == tokenIdent && exprKeywordOperators[p.tokens[p.pos+1].value] {
+		p.next()
+		negate = true
+	}
+
+	t := p.peek()
+	if t.kind != tokenOperator && !(t.kind == tokenIdent && exprKeywordOperators[t.value]) {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	node := &compareNode{op: t.value, negate: negate, left: left, right: right}
+
+	if node.op == "matches" {
+		lit, ok := right.(*literalNode)
+		if !ok || lit.value.kind != valueString {
+			return nil, fmt.Errorf("matches expects a string at position %d", t.pos)
+		}
+		if node.re, err = regexp.Compile(lit.value.str); err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %v", t.pos, err)
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenPunct && t.value == "(":
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.value != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis at position %d", t.pos)
+		}
+		return node, nil
+
+	case t.kind == tokenPunct && t.value == "[":
+		p.next()
+		list := []exprValue{}
+		for !(p.peek().kind == tokenPunct && p.peek().value == "]") {
+			if len(list) > 0 {
+				if t := p.next(); t.value != "," {
+					return nil, fmt.Errorf("expected \",\" at position %d", t.pos)
+				}
+			}
+			item, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		p.next()
+		return &literalNode{value: exprValue{kind: valueList, list: list}}, nil
+
+	case t.kind == tokenIdent && t.value != "true" && t.value != "false":
+		if t.value == "and" || t.value == "or" || t.value == "not" || exprKeywordOperators[t.value] {
+			return nil, p.errorf("unexpected %s", t)
+		}
+		p.next()
+		field, err := compileField(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("%v at position %d", err, t.pos)
+		}
+		p.sections = p.sections || field.sections
+		return field, nil
+	}
+
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return &literalNode{value: value}, nil
+}
+
+func (p *exprParser) parseLiteral() (exprValue, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		return exprValue{kind: valueString, str: t.value}, nil
+	case t.kind == tokenNumber:
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return exprValue{}, p.errorf("invalid number %s", t)
+		}
+		p.next()
+		return exprValue{kind: valueNumber, num: n}, nil
+	case t.kind == tokenIdent && (t.value == "true" || t.value == "false"):
+		p.next()
+		return boolValue(t.value == "true"), nil
+	}
+	return exprValue{}, p.errorf("unexpected %s", t)
+}
@@ -0,0 +1,217 @@
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestExpression_Match(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.NetworkInfo.Protocol = PROTO_UDP
+	dm.DNS.Qtype = "TXT"
+	dm.DNS.Length = 600
+	dm.DNS.Flags.RD = true
+	dm.DNS.DnsRRs.Answers = []DnsAnswer{{Name: "dns.collector", Rdatatype: "A", Rdata: "10.0.0.1"}}
+	dm.PowerDns = &PowerDns{Tags: []string{"malware"}, Metadata: map[string]string{"client": "laptop"}}
+
+	testcases := []struct {
+		expr  string
+		match bool
+	}{
+		{`dns.qtype in ["ANY", "TXT"] and network.protocol == "UDP" and dns.length > 512`, true},
+		{`dns.qtype in ["ANY", "TXT"] and dns.length > 1000`, false},
+		{`dns.qtype not in ["A", "AAAA"]`, true},
+		{`not (dns.qtype == "TXT")`, false},
+		{`dns.qname == "example.com" or dns.rcode == 'NOERROR'`, true},
+		{`network.query-port == 1234`, true},
+		{`network.query-port >= "2000"`, false},
+		{`dns.flags.rd and not dns.flags.qr`, true},
+		{`dns.qname endswith ".collector"`, true},
+		{`dns.qname startswith "dns."`, true},
+		{`dns.qname contains "coll"`, true},
+		{`dns.qname matches "^[a-z]+\.collector$"`, true},
+		{`dns.qname not matches "\.com$"`, true},
+		{`dns.resource-records.an.0.rdata == "10.0.0.1"`, true},
+		{`dns.resource-records.an.1.rdata == "10.0.0.1"`, false},
+		{`powerdns.tags contains "malware"`, true},
+		{`powerdns.metadata.client == "laptop"`, true},
+		{`powerdns.metadata.server == "laptop"`, false},
+		{`geoip.country-isocode == "FR"`, false},
+		{`geoip.country-isocode != "FR"`, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := CompileExpression(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expr.Match(&dm) != tc.match {
+				t.Errorf("want %v, got %v", tc.match, !tc.match)
+			}
+		})
+	}
+}
+
+func TestExpression_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`dns.qname = "a"`,
+		`dns.unknown == "a"`,
+		`dns.resource-records.an == "a"`,
+		`dns.qname == "a`,
+		`(dns.qname == "a"`,
+		`dns.qtype in ["A" "AAAA"]`,
+		`dns.qname matches "(["`,
+		`dns.qname matches dns.qtype`,
+		`dns.qname == "a" and`,
+		`dns.qname == "a" dns.qtype`,
+	} {
+		if _, err := CompileExpression(expr); err == nil {
+			t.Errorf("error expected for %s", expr)
+		}
+	}
+}
+
+func TestField_Value(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.Flags.AA = true
+	dm.DNS.Length = 42
+	dm.DNS.Id = 4660
+	dm.DNS.DnsRRs.Answers = []DnsAnswer{{Rdatatype: "A", Rdata: "192.0.2.1"}}
+
+	for name, expected := range map[string]string{
+		"dns.qname":                       "dns.collector",
+		"dns.id":                          "4660",
+		"network.query-ip":                "1.2.3.4",
+		"dns.length":                      "42",
+		"dns.flags.aa":                    "true",
+		"dns.resource-records.an.0.rdata": "192.0.2.1",
+		"dns.resource-records.an.1.rdata": "-",
+		"geoip.city":                      "-",
+	} {
+		field, err := CompileField(name)
+		if err != nil {
+			t.Fatalf("unable to compile field %s: %v", name, err)
+		}
+		if value := field.Value(&dm); value != expected {
+			t.Errorf("field %s, expected %s, got %s", name, expected, value)
+		}
+	}
+
+	field, _ := CompileField("geoip.city")
+	if _, ok := field.Lookup(&dm); ok {
+		t.Errorf("field geoip.city is not set")
+	}
+
+	if _, err := CompileField("dns.flags"); err == nil {
+		t.Errorf("error expected for a structure")
+	}
+}
+
+func TestField_Set(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = []DnsAnswer{{Rdatatype: "A", Rdata: "192.0.2.1"}}
+	dm.Labels = map[string]string{"site": "paris"}
+
+	// shared with the others loggers
+	shared := dm
+	dm.Geo = &TransformDnsGeo{City: "Paris"}
+	shared.Geo = dm.Geo
+
+	for name, value := range map[string]string{
+		"dns.qname":                       "www.dns.collector",
+		"dns.length":                      "42",
+		"dns.flags.aa":                    "true",
+		"dns.resource-records.an.0.rdata": "192.0.2.2",
+		"geoip.city":                      "Lyon",
+		"labels.vlan":                     "10",
+	} {
+		field, err := CompileField(name)
+		if err != nil {
+			t.Fatalf("unable to compile field %s: %v", name, err)
+		}
+		if err := field.Set(&dm, value); err != nil {
+			t.Errorf("unable to set field %s: %v", name, err)
+		}
+		if v := field.Value(&dm); v != value {
+			t.Errorf("field %s, expected %s, got %s", name, value, v)
+		}
+	}
+
+	if shared.Geo.City != "Paris" || shared.DNS.DnsRRs.Answers[0].Rdata != "192.0.2.1" || len(shared.Labels) != 1 {
+		t.Errorf("shared message updated")
+	}
+
+	// invalid values
+	field, _ := CompileField("dns.length")
+	if err := field.Set(&dm, "large"); err == nil {
+		t.Errorf("error expected for an invalid number")
+	}
+	field, _ = CompileField("dns.resource-records.an.5.rdata")
+	if err := field.Set(&dm, "192.0.2.3"); err == nil {
+		t.Errorf("error expected for an index out of range")
+	}
+}
+
+func TestField_Delete(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.Geo = &TransformDnsGeo{City: "Paris"}
+	dm.Labels = map[string]string{"site": "paris", "vlan": "10"}
+	shared := dm
+
+	for _, name := range []string{"labels.site", "network.query-ip", "geoip", "labels.unknown"} {
+		field, err := CompileSection(name)
+		if err != nil {
+			t.Fatalf("unable to compile field %s: %v", name, err)
+		}
+		field.Delete(&dm)
+	}
+
+	if dm.Geo != nil || dm.NetworkInfo.QueryIp != "" || len(dm.Labels) != 1 || dm.Labels["vlan"] != "10" {
+		t.Errorf("fields not deleted: %v %v %v", dm.Geo, dm.NetworkInfo.QueryIp, dm.Labels)
+	}
+	if shared.Geo == nil || len(shared.Labels) != 2 {
+		t.Errorf("shared message updated")
+	}
+}
+
+func BenchmarkExpression_Match(b *testing.B) {
+	dm := GetFakeDnsMessage()
+	expr, err := CompileExpression(`dns.qtype in ["ANY", "TXT"] and network.protocol == "UDP" and dns.length > 512`)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		expr.Match(&dm)
+	}
+}
+
+func TestField_DecodeSections(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.dnscollector.dev.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr, _ := dns.NewRR("www.dnscollector.dev. 300 IN A 192.0.2.1")
+	m.Answer = append(m.Answer, rr)
+	payload, _ := m.Pack()
+	header, _ := DecodeDns(payload)
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	DecodePayloadLazy(&dm, &header, GetFakeConfig())
+
+	// the records are decoded when read by the expression
+	expr, err := CompileExpression(`dns.resource-records.an.0.rdata == "192.0.2.1"`)
+	if err != nil {
+		t.Fatalf("unable to compile expression: %v", err)
+	}
+	if !expr.Match(&dm) {
+		t.Errorf("expression should match the decoded records")
+	}
+}
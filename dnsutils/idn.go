@@ -0,0 +1,123 @@
+package dnsutils
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// scripts checked first to detect the script of a character,
+// the full list of unicode scripts is used as fallback
+var idnScripts = []string{"Latin", "Cyrillic", "Greek", "Han", "Hiragana", "Katakana",
+	"Hangul", "Bopomofo", "Arabic", "Hebrew", "Armenian", "Georgian", "Thai", "Devanagari", "Cherokee"}
+
+// combinations of scripts commonly mixed in one label (UTS #39 highly restrictive level)
+var idnAllowedScripts = []map[string]bool{
+	{"Latin": true, "Han": true, "Hiragana": true, "Katakana": true},
+	{"Latin": true, "Han": true, "Bopomofo": true},
+	{"Latin": true, "Han": true, "Hangul": true},
+}
+
+// non latin characters visually confusable with latin letters
+var idnLatinConfusables = map[rune]bool{
+	// cyrillic
+	'а': true, 'в': true, 'е': true, 'і': true, 'ј': true, 'к': true, 'м': true, 'н': true,
+	'о': true, 'р': true, 'с': true, 'т': true, 'у': true, 'х': true, 'ѕ': true, 'ԁ': true,
+	'ԛ': true, 'ԝ': true, 'һ': true, 'ӏ': true, 'ѵ': true, 'ԍ': true, 'ү': true,
+	// greek
+	'α': true, 'ι': true, 'κ': true, 'ν': true, 'ο': true, 'ρ': true, 'τ': true, 'υ': true,
+	'χ': true, 'ε': true,
+}
+
+// IdnToUnicode converts the punycode labels (xn--) of a domain name to unicode,
+// the name is returned unchanged if the conversion fails
+func IdnToUnicode(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	u, err := idna.Display.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return u
+}
+
+// LabelScripts returns the sorted list of unicode scripts used by the letters of a label,
+// characters common to all scripts like digits or hyphen are ignored
+func LabelScripts(label string) []string {
+	found := make(map[string]bool)
+	for _, r := range label {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if script := runeScript(r); script != "" {
+			found[script] = true
+		}
+	}
+
+	scripts := make([]string, 0, len(found))
+	for s := range found {
+		scripts = append(scripts, s)
+	}
+	sort.Strings(scripts)
+	return scripts
+}
+
+// IsHomograph returns true if one label of the domain name, once converted to unicode,
+// mixes several scripts or uses only characters confusable with latin letters
+func IsHomograph(name string) bool {
+	for _, label := range strings.Split(IdnToUnicode(name), ".") {
+		scripts := LabelScripts(label)
+		switch {
+		case len(scripts) > 1 && !allowedScripts(scripts):
+			return true
+		case len(scripts) == 1 && scripts[0] != "Latin" && latinConfusable(label):
+			return true
+		}
+	}
+	return false
+}
+
+func runeScript(r rune) string {
+	for _, name := range idnScripts {
+		if unicode.Is(unicode.Scripts[name], r) {
+			return name
+		}
+	}
+	for name, table := range unicode.Scripts {
+		if name == "Common" || name == "Inherited" {
+			continue
+		}
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+func allowedScripts(scripts []string) bool {
+	for _, allowed := range idnAllowedScripts {
+		ok := true
+		for _, s := range scripts {
+			if !allowed[s] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func latinConfusable(label string) bool {
+	for _, r := range label {
+		if unicode.IsLetter(r) && !idnLatinConfusables[r] {
+			return false
+		}
+	}
+	return true
+}
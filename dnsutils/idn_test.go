@@ -0,0 +1,59 @@
+package dnsutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdnToUnicode(t *testing.T) {
+	testcases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "www.dnscollector.dev", expected: "www.dnscollector.dev"},
+		{name: "xn--bcher-kva.de", expected: "bücher.de"},
+		{name: "www.xn--fiqs8s", expected: "www.中国"},
+		{name: "xn--zz9999.com", expected: "xn--zz9999.com"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IdnToUnicode(tc.name); got != tc.expected {
+				t.Errorf("want %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestIdnLabelScripts(t *testing.T) {
+	if scripts := LabelScripts("аpple-42"); !reflect.DeepEqual(scripts, []string{"Cyrillic", "Latin"}) {
+		t.Errorf("invalid scripts: %v", scripts)
+	}
+	if scripts := LabelScripts("42-"); len(scripts) != 0 {
+		t.Errorf("no script expected: %v", scripts)
+	}
+}
+
+func TestIdnIsHomograph(t *testing.T) {
+	testcases := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "www.apple.com", expected: false},
+		{name: "xn--bcher-kva.de", expected: false},
+		{name: "www.xn--fiqs8s", expected: false},
+		{name: "xn--eckwd4c7c.xn--zckzah", expected: false},
+		// cyrillic "а" mixed with latin letters
+		{name: "xn--pple-43d.com", expected: true},
+		// only cyrillic letters looking like latin ones
+		{name: "xn--80ak6aa92e.com", expected: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsHomograph(tc.name); got != tc.expected {
+				t.Errorf("%s (%s): want %v, got %v", tc.name, IdnToUnicode(tc.name), tc.expected, got)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnstap-protobuf"
@@ -28,9 +29,15 @@ var (
 	GeoIPDirectives           = regexp.MustCompile(`^geoip-*`)
 	SuspiciousDirectives      = regexp.MustCompile(`^suspicious-*`)
 	PublicSuffixDirectives    = regexp.MustCompile(`^publixsuffix-*`)
+	IdnDirectives             = regexp.MustCompile(`^idn-*`)
+	RpzDirectives             = regexp.MustCompile(`^rpz-*`)
+	TransactionDirectives     = regexp.MustCompile(`^transaction-*`)
 	ExtractedDirectives       = regexp.MustCompile(`^extracted-*`)
 	ReducerDirectives         = regexp.MustCompile(`^reducer-*`)
 	MachineLearningDirectives = regexp.MustCompile(`^ml-*`)
+	EdnsDirectives            = regexp.MustCompile(`^edns-*`)
+	ClientDirectives          = regexp.MustCompile(`^client-*`)
+	FilteringDirectives       = regexp.MustCompile(`^filtering-*`)
 )
 
 func GetIpPort(dm *DnsMessage) (string, int, string, int) {
@@ -66,12 +73,21 @@ type DnsAnswer struct {
 	Rdata     string `json:"rdata" msgpack:"rdata"`
 }
 
+type DnsQuestion struct {
+	Qname  string `json:"qname" msgpack:"qname"`
+	Qtype  string `json:"qtype" msgpack:"qtype"`
+	Qclass string `json:"qclass" msgpack:"qclass"`
+}
+
 type DnsFlags struct {
 	QR bool `json:"qr" msgpack:"qr"`
 	TC bool `json:"tc" msgpack:"tc"`
 	AA bool `json:"aa" msgpack:"aa"`
 	RA bool `json:"ra" msgpack:"ra"`
 	AD bool `json:"ad" msgpack:"ad"`
+	RD bool `json:"rd" msgpack:"rd"`
+	CD bool `json:"cd" msgpack:"cd"`
+	Z  bool `json:"z" msgpack:"z"`
 }
 
 type DnsNetInfo struct {
@@ -91,6 +107,36 @@ type DnsRRs struct {
 	Records     []DnsAnswer `json:"ar" msgpack:"ar"`
 }
 
+type DnsUpdateRecord struct {
+	Name      string `json:"name" msgpack:"name"`
+	Rdatatype string `json:"rdatatype" msgpack:"rdatatype"`
+	Class     string `json:"class" msgpack:"class"`
+	Ttl       int    `json:"ttl" msgpack:"ttl"`
+	Rdata     string `json:"rdata" msgpack:"rdata"`
+	Operation string `json:"operation" msgpack:"operation"`
+}
+
+type DnsUpdate struct {
+	Zone          string            `json:"zone" msgpack:"zone"`
+	ZoneClass     string            `json:"zone-class" msgpack:"zone-class"`
+	Prerequisites []DnsUpdateRecord `json:"prerequisites" msgpack:"prerequisites"`
+	Updates       []DnsUpdateRecord `json:"updates" msgpack:"updates"`
+}
+
+type DnsNotify struct {
+	Zone   string `json:"zone" msgpack:"zone"`
+	Qtype  string `json:"qtype" msgpack:"qtype"`
+	Serial int64  `json:"serial" msgpack:"serial"`
+}
+
+type DnsTransfer struct {
+	Zone    string         `json:"zone" msgpack:"zone"`
+	Type    string         `json:"type" msgpack:"type"`
+	Records int            `json:"records" msgpack:"records"`
+	Rrtypes map[string]int `json:"rrtypes" msgpack:"rrtypes"`
+	Serials []int64        `json:"soa-serials" msgpack:"soa-serials"`
+}
+
 type Dns struct {
 	Type    string `json:"-" msgpack:"-"`
 	Payload []byte `json:"-" msgpack:"-"`
@@ -100,10 +146,19 @@ type Dns struct {
 	Rcode   string `json:"rcode" msgpack:"rcode"`
 	Qname   string `json:"qname" msgpack:"qname"`
 
-	Qtype           string   `json:"qtype" msgpack:"qtype"`
-	Flags           DnsFlags `json:"flags" msgpack:"flags"`
-	DnsRRs          DnsRRs   `json:"resource-records" msgpack:"resource-records"`
-	MalformedPacket bool     `json:"malformed-packet" msgpack:"malformed-packet"`
+	Qtype           string        `json:"qtype" msgpack:"qtype"`
+	Qclass          string        `json:"qclass" msgpack:"qclass"`
+	Qdcount         int           `json:"qdcount" msgpack:"qdcount"`
+	Ancount         int           `json:"ancount" msgpack:"ancount"`
+	Nscount         int           `json:"nscount" msgpack:"nscount"`
+	Arcount         int           `json:"arcount" msgpack:"arcount"`
+	Questions       []DnsQuestion `json:"questions" msgpack:"questions"`
+	Flags           DnsFlags      `json:"flags" msgpack:"flags"`
+	DnsRRs          DnsRRs        `json:"resource-records" msgpack:"resource-records"`
+	MalformedPacket bool          `json:"malformed-packet" msgpack:"malformed-packet"`
+	Update          *DnsUpdate    `json:"update,omitempty" msgpack:"update"`
+	Notify          *DnsNotify    `json:"notify,omitempty" msgpack:"notify"`
+	Transfer        *DnsTransfer  `json:"transfer,omitempty" msgpack:"transfer"`
 }
 
 type DnsOption struct {
@@ -143,11 +198,28 @@ type PowerDns struct {
 }
 
 type TransformDnsGeo struct {
-	City                   string `json:"city" msgpack:"city"`
-	Continent              string `json:"continent" msgpack:"continent"`
-	CountryIsoCode         string `json:"country-isocode" msgpack:"country-isocode"`
-	AutonomousSystemNumber string `json:"as-number" msgpack:"as-number"`
-	AutonomousSystemOrg    string `json:"as-owner" msgpack:"as-owner"`
+	City                   string                  `json:"city" msgpack:"city"`
+	Continent              string                  `json:"continent" msgpack:"continent"`
+	CountryIsoCode         string                  `json:"country-isocode" msgpack:"country-isocode"`
+	SubdivisionIsoCode     string                  `json:"subdivision-isocode" msgpack:"subdivision-isocode"`
+	Latitude               float64                 `json:"latitude" msgpack:"latitude"`
+	Longitude              float64                 `json:"longitude" msgpack:"longitude"`
+	AutonomousSystemNumber string                  `json:"as-number" msgpack:"as-number"`
+	AutonomousSystemOrg    string                  `json:"as-owner" msgpack:"as-owner"`
+	ResponseIp             *TransformDnsGeo        `json:"response-ip,omitempty" msgpack:"response-ip"`
+	Answers                []TransformDnsGeoAnswer `json:"answers,omitempty" msgpack:"answers"`
+}
+
+type TransformDnsGeoAnswer struct {
+	Rdata                  string  `json:"rdata" msgpack:"rdata"`
+	City                   string  `json:"city" msgpack:"city"`
+	Continent              string  `json:"continent" msgpack:"continent"`
+	CountryIsoCode         string  `json:"country-isocode" msgpack:"country-isocode"`
+	SubdivisionIsoCode     string  `json:"subdivision-isocode" msgpack:"subdivision-isocode"`
+	Latitude               float64 `json:"latitude" msgpack:"latitude"`
+	Longitude              float64 `json:"longitude" msgpack:"longitude"`
+	AutonomousSystemNumber string  `json:"as-number" msgpack:"as-number"`
+	AutonomousSystemOrg    string  `json:"as-owner" msgpack:"as-owner"`
 }
 
 type TransformSuspicious struct {
@@ -159,6 +231,12 @@ type TransformSuspicious struct {
 	UnallowedChars        bool    `json:"unallowed-chars" msgpack:"unallowed-chars"`
 	UncommonQtypes        bool    `json:"uncommon-qtypes" msgpack:"uncommon-qtypes"`
 	ExcessiveNumberLabels bool    `json:"excessive-number-labels" msgpack:"excessive-number-labels"`
+	HomographDomain       bool    `json:"homograph-domain" msgpack:"homograph-domain"`
+	UniqueSubdomains      bool    `json:"unique-subdomains" msgpack:"unique-subdomains"`
+	TxtNullVolume         bool    `json:"txt-null-volume" msgpack:"txt-null-volume"`
+	RandomSubdomains      bool    `json:"random-subdomains" msgpack:"random-subdomains"`
+	HighEntropy           bool    `json:"high-entropy" msgpack:"high-entropy"`
+	UnlikelyNgrams        bool    `json:"unlikely-ngrams" msgpack:"unlikely-ngrams"`
 	Domain                string  `json:"domain,omitempty" msgpack:"-"`
 }
 
@@ -167,13 +245,50 @@ type TransformPublicSuffix struct {
 	QnameEffectiveTLDPlusOne string `json:"etld+1" msgpack:"qname-effective-tld-plus-one"`
 }
 
+type TransformIdn struct {
+	QnameUnicode   string   `json:"qname-unicode" msgpack:"qname-unicode"`
+	AnswersUnicode []string `json:"answers-unicode" msgpack:"answers-unicode"`
+}
+
+type TransformRpz struct {
+	Zone    string `json:"zone" msgpack:"zone"`
+	Rule    string `json:"rule" msgpack:"rule"`
+	Trigger string `json:"trigger" msgpack:"trigger"`
+	Action  string `json:"action" msgpack:"action"`
+}
+
+type TransformClient struct {
+	Hostname string `json:"hostname" msgpack:"hostname"`
+	Mac      string `json:"mac" msgpack:"mac"`
+	Source   string `json:"source" msgpack:"source"` // dhcp, hosts or ptr
+}
+
+type TransformFiltering struct {
+	SamplingRate float64 `json:"sampling-rate" msgpack:"sampling-rate"` // fraction of the traffic kept
+}
+
+type TransformTransaction struct {
+	Status            string  `json:"status" msgpack:"status"`
+	QueryTimestamp    string  `json:"query-timestamp" msgpack:"query-timestamp"`
+	ResponseTimestamp string  `json:"response-timestamp" msgpack:"response-timestamp"`
+	Latency           float64 `json:"latency" msgpack:"latency"`
+	QueryLength       int     `json:"query-length" msgpack:"query-length"`
+	ResponseLength    int     `json:"response-length" msgpack:"response-length"`
+}
+
 type TransformExtracted struct {
 	Base64Payload []byte `json:"dns_payload" msgpack:"dns_payload"`
 }
 
 type TransformReducer struct {
-	Occurences       int `json:"occurences" msgpack:"occurences"`
-	CumulativeLength int `json:"cumulative-length" msgpack:"cumulative-length"`
+	Occurences       int     `json:"occurences" msgpack:"occurences"`
+	CumulativeLength int     `json:"cumulative-length" msgpack:"cumulative-length"`
+	FirstSeen        string  `json:"first-seen" msgpack:"first-seen"`
+	LastSeen         string  `json:"last-seen" msgpack:"last-seen"`
+	DistinctClients  int     `json:"distinct-clients" msgpack:"distinct-clients"`
+	LatencyMin       float64 `json:"latency-min" msgpack:"latency-min"`
+	LatencyMax       float64 `json:"latency-max" msgpack:"latency-max"`
+	LatencyAvg       float64 `json:"latency-avg" msgpack:"latency-avg"`
 }
 
 type TransformML struct {
@@ -196,6 +311,8 @@ type TransformML struct {
 	Size                  int     `json:"size" msgpack:"size"`
 	Occurences            int     `json:"occurences" msgpack:"occurences"`
 	UncommonQtypes        int     `json:"uncommon-qtypes" msgpack:"uncommon-qtypes"`
+	Probability           float64 `json:"probability" msgpack:"probability"` // Probability computed by the model
+	Class                 string  `json:"class" msgpack:"class"`             // Class label according to the probability
 }
 
 type DnsMessage struct {
@@ -207,9 +324,86 @@ type DnsMessage struct {
 	PowerDns        *PowerDns              `json:"powerdns,omitempty" msgpack:"powerdns"`
 	Suspicious      *TransformSuspicious   `json:"suspicious,omitempty" msgpack:"suspicious"`
 	PublicSuffix    *TransformPublicSuffix `json:"publicsuffix,omitempty" msgpack:"publicsuffix"`
+	Idn             *TransformIdn          `json:"idn,omitempty" msgpack:"idn"`
+	Rpz             *TransformRpz          `json:"rpz,omitempty" msgpack:"rpz"`
+	Client          *TransformClient       `json:"client,omitempty" msgpack:"client"`
+	Filtering       *TransformFiltering    `json:"filtering,omitempty" msgpack:"filtering"`
+	Transaction     *TransformTransaction  `json:"transaction,omitempty" msgpack:"transaction"`
 	Extracted       *TransformExtracted    `json:"extracted,omitempty" msgpack:"extracted"`
 	Reducer         *TransformReducer      `json:"reducer,omitempty" msgpack:"reducer"`
 	MachineLearning *TransformML           `json:"ml,omitempty" msgpack:"ml"`
+	Labels          map[string]string      `json:"labels,omitempty" msgpack:"labels"`
+
+	// resource records and EDNS options not decoded yet, see DecodePayloadLazy
+	sections *dnsSections
+}
+
+// dnsSections holds the position of the resource records in the payload, they are decoded
+// on demand and shared by the copies of the message sent to the loggers to decode them once
+type dnsSections struct {
+	once    sync.Once
+	counts  [3]uint16 // answer, authority and additional records
+	opcode  uint8
+	tc      bool
+	offset  int
+	payload []byte
+	decoded *decodedSections
+}
+
+type decodedSections struct {
+	rrs       DnsRRs
+	edns      DnsExtended
+	malformed bool
+	err       error
+}
+
+// DecodeSections decodes the resource records and the EDNS options of a
+// message decoded with DecodePayloadLazy, nothing is done if they are already decoded.
+// The packet is marked as malformed if the records can not be decoded, the decoding
+// error is returned by the first call only.
+func (dm *DnsMessage) DecodeSections() error {
+	s := dm.sections
+	if s == nil {
+		return nil
+	}
+	dm.sections = nil
+
+	s.once.Do(func() {
+		header := DnsHeader{Opcode: int(s.opcode), Ancount: int(s.counts[0]), Nscount: int(s.counts[1]), Arcount: int(s.counts[2])}
+		decoded := DnsMessage{}
+		decoded.DNS.Payload = s.payload
+		decoded.DNS.Flags.TC = s.tc
+		decoded.DNS.DnsRRs = dm.DNS.DnsRRs
+		decoded.EDNS = dm.EDNS
+		err := decodeSections(&decoded, &header, s.offset)
+
+		s.decoded = &decodedSections{rrs: decoded.DNS.DnsRRs, edns: decoded.EDNS, malformed: decoded.DNS.MalformedPacket, err: err}
+		s.payload = nil
+	})
+
+	dm.DNS.DnsRRs = s.decoded.rrs
+	dm.EDNS = s.decoded.edns
+	if s.decoded.malformed {
+		dm.DNS.MalformedPacket = true
+	}
+	return s.decoded.err
+}
+
+var dnsMessagePool = sync.Pool{
+	New: func() interface{} { return new(DnsMessage) },
+}
+
+// AcquireDnsMessage returns an empty message from the pool, to work on a message
+// by pointer without allocating it for each packet
+func AcquireDnsMessage() *DnsMessage {
+	return dnsMessagePool.Get().(*DnsMessage)
+}
+
+// ReleaseDnsMessage resets the message and returns it to the pool,
+// the message must not be used after
+func ReleaseDnsMessage(dm *DnsMessage) {
+	*dm = DnsMessage{}
+	dnsMessagePool.Put(dm)
 }
 
 func (dm *DnsMessage) Init() {
@@ -239,6 +433,8 @@ func (dm *DnsMessage) Init() {
 		Rcode:           "-",
 		Qtype:           "-",
 		Qname:           "-",
+		Qclass:          "-",
+		Questions:       []DnsQuestion{},
 		DnsRRs:          DnsRRs{Answers: []DnsAnswer{}, Nameservers: []DnsAnswer{}, Records: []DnsAnswer{}},
 	}
 
@@ -252,6 +448,74 @@ func (dm *DnsMessage) Init() {
 	}
 }
 
+// ednsOption returns the data of the first EDNS option with the provided name
+func (dm *DnsMessage) ednsOption(name string) (string, bool) {
+	for _, opt := range dm.EDNS.Options {
+		if opt.Name == name {
+			return opt.Data, true
+		}
+	}
+	return "", false
+}
+
+func (dm *DnsMessage) handleEdnsDirectives(directives []string, s *strings.Builder) {
+	// directive name to the option name and the field to extract from the
+	// option data (-1 for the whole data)
+	var optName string
+	field := -1
+	switch directive := directives[0]; {
+	case directive == "edns-csubnet":
+		optName = "CSUBNET"
+	case directive == "edns-nsid":
+		optName, field = "NSID", 1
+	case directive == "edns-nsid-hex":
+		optName, field = "NSID", 0
+	case directive == "edns-cookie-client":
+		optName, field = "COOKIE", 0
+	case directive == "edns-cookie-server":
+		optName, field = "COOKIE", 1
+	case directive == "edns-keepalive":
+		optName = "KEEPALIVE"
+	case directive == "edns-padding":
+		optName = "PADDING"
+	case directive == "edns-expire":
+		optName = "EXPIRE"
+	case directive == "edns-chain":
+		optName = "CHAIN"
+	case directive == "edns-keytag":
+		optName = "KEY-TAG"
+	case directive == "edns-dau":
+		optName = "DAU"
+	case directive == "edns-dhu":
+		optName = "DHU"
+	case directive == "edns-n3u":
+		optName = "N3U"
+	case directive == "edns-client-tag":
+		optName = "CLIENT-TAG"
+	case directive == "edns-server-tag":
+		optName = "SERVER-TAG"
+	case directive == "edns-zoneversion":
+		optName, field = "ZONEVERSION", 2
+	default:
+		log.Fatalf("unsupport directive for text format: %s", directive)
+	}
+
+	data, found := dm.ednsOption(optName)
+	if !found {
+		s.WriteString("-")
+		return
+	}
+	if field >= 0 {
+		fields := strings.Fields(data)
+		if field >= len(fields) {
+			s.WriteString("-")
+			return
+		}
+		data = fields[field]
+	}
+	s.WriteString(data)
+}
+
 func (dm *DnsMessage) handleGeoIPDirectives(directives []string, s *strings.Builder) {
 	if dm.Geo == nil {
 		s.WriteString("-")
@@ -267,10 +531,56 @@ func (dm *DnsMessage) handleGeoIPDirectives(directives []string, s *strings.Buil
 			s.WriteString(dm.Geo.AutonomousSystemNumber)
 		case directive == "geoip-as-owner":
 			s.WriteString(dm.Geo.AutonomousSystemOrg)
+		case directive == "geoip-subdivision":
+			s.WriteString(dm.Geo.SubdivisionIsoCode)
+		case directive == "geoip-latitude":
+			s.WriteString(strconv.FormatFloat(dm.Geo.Latitude, 'f', -1, 64))
+		case directive == "geoip-longitude":
+			s.WriteString(strconv.FormatFloat(dm.Geo.Longitude, 'f', -1, 64))
+		case strings.HasPrefix(directive, "geoip-response-"):
+			dm.handleGeoIPResponseDirectives(directive, s)
+		case directive == "geoip-answers-country":
+			dm.handleGeoIPAnswersDirectives(func(a TransformDnsGeoAnswer) string { return a.CountryIsoCode }, s)
+		case directive == "geoip-answers-as-number":
+			dm.handleGeoIPAnswersDirectives(func(a TransformDnsGeoAnswer) string { return a.AutonomousSystemNumber }, s)
 		}
 	}
 }
 
+func (dm *DnsMessage) handleGeoIPResponseDirectives(directive string, s *strings.Builder) {
+	geo := dm.Geo.ResponseIp
+	if geo == nil {
+		s.WriteString("-")
+		return
+	}
+	switch directive {
+	case "geoip-response-continent":
+		s.WriteString(geo.Continent)
+	case "geoip-response-country":
+		s.WriteString(geo.CountryIsoCode)
+	case "geoip-response-city":
+		s.WriteString(geo.City)
+	case "geoip-response-as-number":
+		s.WriteString(geo.AutonomousSystemNumber)
+	case "geoip-response-as-owner":
+		s.WriteString(geo.AutonomousSystemOrg)
+	}
+}
+
+// handleGeoIPAnswersDirectives writes the value of each answer, separated by a comma
+func (dm *DnsMessage) handleGeoIPAnswersDirectives(value func(a TransformDnsGeoAnswer) string, s *strings.Builder) {
+	if len(dm.Geo.Answers) == 0 {
+		s.WriteString("-")
+		return
+	}
+	for i := range dm.Geo.Answers {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(value(dm.Geo.Answers[i]))
+	}
+}
+
 func (dm *DnsMessage) handlePdnsDirectives(directives []string, s *strings.Builder) {
 	if dm.PowerDns == nil {
 		s.WriteString("-")
@@ -362,6 +672,87 @@ func (dm *DnsMessage) handlePublicSuffixDirectives(directives []string, s *strin
 	}
 }
 
+func (dm *DnsMessage) handleIdnDirectives(directives []string, s *strings.Builder) {
+	if dm.Idn == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "idn-qname":
+			s.WriteString(dm.Idn.QnameUnicode)
+		case directive == "idn-answers":
+			if len(dm.Idn.AnswersUnicode) > 0 {
+				s.WriteString(strings.Join(dm.Idn.AnswersUnicode, ","))
+			} else {
+				s.WriteString("-")
+			}
+		}
+	}
+}
+
+func (dm *DnsMessage) handleRpzDirectives(directives []string, s *strings.Builder) {
+	if dm.Rpz == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "rpz-zone":
+			s.WriteString(dm.Rpz.Zone)
+		case directive == "rpz-rule":
+			s.WriteString(dm.Rpz.Rule)
+		case directive == "rpz-trigger":
+			s.WriteString(dm.Rpz.Trigger)
+		case directive == "rpz-action":
+			s.WriteString(dm.Rpz.Action)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleClientDirectives(directives []string, s *strings.Builder) {
+	if dm.Client == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "client-hostname":
+			s.WriteString(dm.Client.Hostname)
+		case directive == "client-mac":
+			s.WriteString(dm.Client.Mac)
+		case directive == "client-source":
+			s.WriteString(dm.Client.Source)
+		}
+	}
+}
+
+func (dm *DnsMessage) handleFilteringDirectives(directives []string, s *strings.Builder) {
+	if dm.Filtering == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "filtering-sampling-rate":
+			s.WriteString(strconv.FormatFloat(dm.Filtering.SamplingRate, 'f', -1, 64))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleTransactionDirectives(directives []string, s *strings.Builder) {
+	if dm.Transaction == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "transaction-status":
+			s.WriteString(dm.Transaction.Status)
+		case directive == "transaction-query-timestamp":
+			s.WriteString(dm.Transaction.QueryTimestamp)
+		case directive == "transaction-response-timestamp":
+			s.WriteString(dm.Transaction.ResponseTimestamp)
+		case directive == "transaction-latency":
+			s.WriteString(strconv.FormatFloat(dm.Transaction.Latency, 'f', 6, 64))
+		case directive == "transaction-query-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.QueryLength) + "b")
+		case directive == "transaction-response-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.ResponseLength) + "b")
+		}
+	}
+}
+
 func (dm *DnsMessage) handleExtractedDirectives(directives []string, s *strings.Builder) {
 	if dm.Extracted == nil {
 		s.WriteString("-")
@@ -388,6 +779,18 @@ func (dm *DnsMessage) handleReducerDirectives(directives []string, s *strings.Bu
 			s.WriteString(strconv.Itoa(dm.Reducer.Occurences))
 		case directive == "reducer-cumulative-length":
 			s.WriteString(strconv.Itoa(dm.Reducer.CumulativeLength))
+		case directive == "reducer-first-seen":
+			s.WriteString(dm.Reducer.FirstSeen)
+		case directive == "reducer-last-seen":
+			s.WriteString(dm.Reducer.LastSeen)
+		case directive == "reducer-distinct-clients":
+			s.WriteString(strconv.Itoa(dm.Reducer.DistinctClients))
+		case directive == "reducer-latency-min":
+			s.WriteString(strconv.FormatFloat(dm.Reducer.LatencyMin, 'f', 6, 64))
+		case directive == "reducer-latency-max":
+			s.WriteString(strconv.FormatFloat(dm.Reducer.LatencyMax, 'f', 6, 64))
+		case directive == "reducer-latency-avg":
+			s.WriteString(strconv.FormatFloat(dm.Reducer.LatencyAvg, 'f', 6, 64))
 		}
 	}
 }
@@ -435,10 +838,26 @@ func (dm *DnsMessage) handleMachineLearningDirectives(directives []string, s *st
 			s.WriteString(strconv.Itoa(dm.MachineLearning.Occurences))
 		case directive == "ml-uncommon-qtypes":
 			s.WriteString(strconv.Itoa(dm.MachineLearning.UncommonQtypes))
+		case directive == "ml-probability":
+			s.WriteString(strconv.FormatFloat(dm.MachineLearning.Probability, 'f', 3, 64))
+		case directive == "ml-class":
+			s.WriteString(dm.MachineLearning.Class)
 		}
 	}
 }
 
+func (dm *DnsMessage) handleLabelDirectives(directives []string, s *strings.Builder) {
+	if len(directives) != 2 {
+		s.WriteString("-")
+		return
+	}
+	if value, ok := dm.Labels[directives[1]]; ok && len(value) > 0 {
+		s.WriteString(strings.Replace(value, " ", "_", -1))
+	} else {
+		s.WriteString("-")
+	}
+}
+
 func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundary string) []byte {
 	//var s bytes.Buffer
 	var s strings.Builder
@@ -448,29 +867,21 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 		switch directive := directives[0]; {
 		// default directives
 		case directive == "ttl":
+			dm.DecodeSections()
 			if len(dm.DNS.DnsRRs.Answers) > 0 {
 				s.WriteString(strconv.Itoa(dm.DNS.DnsRRs.Answers[0].Ttl))
 			} else {
 				s.WriteByte('-')
 			}
 		case directive == "answer":
+			dm.DecodeSections()
 			if len(dm.DNS.DnsRRs.Answers) > 0 {
 				s.WriteString(dm.DNS.DnsRRs.Answers[0].Rdata)
 			} else {
 				s.WriteByte('-')
 			}
-		case directive == "edns-csubnet":
-			if len(dm.EDNS.Options) > 0 {
-				for _, opt := range dm.EDNS.Options {
-					if opt.Name == "CSUBNET" {
-						s.WriteString(opt.Data)
-						break
-					}
-				}
-			} else {
-				s.WriteByte('-')
-			}
 		case directive == "answercount":
+			dm.DecodeSections()
 			s.WriteString(strconv.Itoa(len(dm.DNS.DnsRRs.Answers)))
 		case directive == "id":
 			s.WriteString(strconv.Itoa(dm.DNS.Id))
@@ -525,9 +936,31 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			}
 		case directive == "qtype":
 			s.WriteString(dm.DNS.Qtype)
+		case directive == "qclass":
+			s.WriteString(dm.DNS.Qclass)
+		case directive == "qdcount":
+			s.WriteString(strconv.Itoa(dm.DNS.Qdcount))
+		case directive == "ancount":
+			s.WriteString(strconv.Itoa(dm.DNS.Ancount))
+		case directive == "nscount":
+			s.WriteString(strconv.Itoa(dm.DNS.Nscount))
+		case directive == "arcount":
+			s.WriteString(strconv.Itoa(dm.DNS.Arcount))
+		case directive == "questions":
+			if len(dm.DNS.Questions) > 0 {
+				for j, q := range dm.DNS.Questions {
+					if j > 0 {
+						s.WriteByte(',')
+					}
+					s.WriteString(q.Qname + "/" + q.Qtype + "/" + q.Qclass)
+				}
+			} else {
+				s.WriteByte('-')
+			}
 		case directive == "latency":
 			s.WriteString(dm.DnsTap.LatencySec)
 		case directive == "malformed":
+			dm.DecodeSections()
 			if dm.DNS.MalformedPacket {
 				s.WriteString("PKTERR")
 			} else {
@@ -573,6 +1006,27 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			} else {
 				s.WriteByte('-')
 			}
+		case directive == "rd":
+			if dm.DNS.Flags.RD {
+				s.WriteString("RD")
+			} else {
+				s.WriteByte('-')
+			}
+		case directive == "cd":
+			if dm.DNS.Flags.CD {
+				s.WriteString("CD")
+			} else {
+				s.WriteByte('-')
+			}
+		case directive == "z":
+			if dm.DNS.Flags.Z {
+				s.WriteString("Z")
+			} else {
+				s.WriteByte('-')
+			}
+		case EdnsDirectives.MatchString(directive):
+			dm.DecodeSections()
+			dm.handleEdnsDirectives(directives, &s)
 		// more directives from collectors
 		case PdnsDirectives.MatchString(directive):
 			dm.handlePdnsDirectives(directives, &s)
@@ -585,10 +1039,22 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			dm.handleSuspiciousDirectives(directives, &s)
 		case PublicSuffixDirectives.MatchString(directive):
 			dm.handlePublicSuffixDirectives(directives, &s)
+		case IdnDirectives.MatchString(directive):
+			dm.handleIdnDirectives(directives, &s)
+		case RpzDirectives.MatchString(directive):
+			dm.handleRpzDirectives(directives, &s)
+		case ClientDirectives.MatchString(directive):
+			dm.handleClientDirectives(directives, &s)
+		case FilteringDirectives.MatchString(directive):
+			dm.handleFilteringDirectives(directives, &s)
+		case TransactionDirectives.MatchString(directive):
+			dm.handleTransactionDirectives(directives, &s)
 		case ExtractedDirectives.MatchString(directive):
 			dm.handleExtractedDirectives(directives, &s)
 		case MachineLearningDirectives.MatchString(directive):
 			dm.handleMachineLearningDirectives(directives, &s)
+		case directive == "label":
+			dm.handleLabelDirectives(directives, &s)
 		// error unsupport directive for text format
 		default:
 			log.Fatalf("unsupport directive for text format: %s", word)
@@ -607,6 +1073,7 @@ func (dm *DnsMessage) String(format []string, fieldDelimiter string, fieldBounda
 }
 
 func (dm *DnsMessage) ToJson() string {
+	dm.DecodeSections()
 	buffer := new(bytes.Buffer)
 	json.NewEncoder(buffer).Encode(dm)
 	return buffer.String()
@@ -682,14 +1149,20 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	}
 	msg.ResponsePort = &rport
 
+	// encode the dns message from the decoded fields if the payload is missing
+	payload, err := dm.GetPayload()
+	if err != nil {
+		return nil, err
+	}
+
 	if dm.DNS.Type == DnsQuery {
-		msg.QueryMessage = dm.DNS.Payload
+		msg.QueryMessage = payload
 		msg.QueryTimeSec = &tsec
 		msg.QueryTimeNsec = &tnsec
 	} else {
 		msg.ResponseTimeSec = &tsec
 		msg.ResponseTimeNsec = &tnsec
-		msg.ResponseMessage = dm.DNS.Payload
+		msg.ResponseMessage = payload
 	}
 
 	dt.Message = msg
@@ -702,8 +1175,10 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 }
 
 func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
-	if len(dm.DNS.Payload) == 0 {
-		return nil, errors.New("payload is empty")
+	// encode the dns message from the decoded fields if the payload is missing
+	payload, err := dm.GetPayload()
+	if err != nil {
+		return nil, errors.New("payload is empty and can not be encoded: " + err.Error())
 	}
 
 	eth := &layers.Ethernet{
@@ -747,11 +1222,11 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip4)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip6)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip6)
 		}
 
 	// DNS over TCP
@@ -763,18 +1238,18 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 
 		// dns length
 		dnsLengthField := make([]byte, 2)
-		binary.BigEndian.PutUint16(dnsLengthField[0:], uint16(dm.DNS.Length))
+		binary.BigEndian.PutUint16(dnsLengthField[0:], uint16(len(payload)))
 
 		// update iplayer
 		switch dm.NetworkInfo.Family {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolTCP
 			tcp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, dm.DNS.Payload...)), tcp, ip4)
+			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, payload...)), tcp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolTCP
 			tcp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, dm.DNS.Payload...)), tcp, ip6)
+			pkt = append(pkt, gopacket.Payload(append(dnsLengthField, payload...)), tcp, ip6)
 		}
 
 	// DNS over HTTPS and DNS over TLS
@@ -788,11 +1263,11 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 		case PROTO_IPV4:
 			ip4.Protocol = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip4)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip4)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip4)
 		case PROTO_IPV6:
 			ip6.NextHeader = layers.IPProtocolUDP
 			udp.SetNetworkLayerForChecksum(ip6)
-			pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip6)
+			pkt = append(pkt, gopacket.Payload(payload), udp, ip6)
 		}
 
 	default:
@@ -806,6 +1281,7 @@ func (dm *DnsMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 
 func (dm *DnsMessage) Flatten() (ret map[string]interface{}, err error) {
 	// TODO perhaps panic when flattening fails, as it should always work.
+	dm.DecodeSections()
 	var tmp []byte
 	if tmp, err = json.Marshal(dm); err != nil {
 		return
@@ -29,12 +29,21 @@ func TestDnsMessage_Json_Reference(t *testing.T) {
 				  "rcode": "-",
 				  "qname": "-",
 				  "qtype": "-",
+				  "qclass": "-",
+				  "qdcount": 0,
+				  "ancount": 0,
+				  "nscount": 0,
+				  "arcount": 0,
+				  "questions": [],
 				  "flags": {
 					"qr": false,
 					"tc": false,
 					"aa": false,
 					"ra": false,
-					"ad": false
+					"ad": false,
+					"rd": false,
+					"cd": false,
+					"z": false
 				  },
 				  "resource-records": {
 					"an": [],
@@ -87,14 +96,23 @@ func TestDnsMessage_Json_Flatten_Reference(t *testing.T) {
 				{
 					"dns.flags.aa": false,
 					"dns.flags.ad": false,
+					"dns.flags.cd": false,
 					"dns.flags.qr": false,
 					"dns.flags.ra": false,
+					"dns.flags.rd": false,
 					"dns.flags.tc": false,
+					"dns.flags.z": false,
 					"dns.length": 0,
 					"dns.malformed-packet": false,
 					"dns.opcode": 0,
 					"dns.qname": "-",
 					"dns.qtype": "-",
+					"dns.qclass": "-",
+					"dns.qdcount": 0,
+					"dns.ancount": 0,
+					"dns.nscount": 0,
+					"dns.arcount": 0,
+					"dns.questions": [],
 					"dns.rcode": "-",
 					"dns.resource-records.an": [],
 					"dns.resource-records.ar": [],
@@ -261,6 +279,22 @@ func TestDnsMessage_TextFormat_DefaultDirectives(t *testing.T) {
 			dm:       DnsMessage{DNS: Dns{Flags: DnsFlags{TC: true, AA: true, RA: true, AD: true}}},
 			expected: "TC AA RA AD",
 		},
+		{
+			format:   "rd cd z",
+			dm:       DnsMessage{DNS: Dns{Flags: DnsFlags{RD: true, CD: true, Z: false}}},
+			expected: "RD CD -",
+		},
+		{
+			format:   "qclass qdcount ancount nscount arcount",
+			dm:       DnsMessage{DNS: Dns{Qclass: "CH", Qdcount: 1, Ancount: 2, Nscount: 3, Arcount: 4}},
+			expected: "CH 1 2 3 4",
+		},
+		{
+			format: "questions",
+			dm: DnsMessage{DNS: Dns{Questions: []DnsQuestion{{Qname: "version.bind", Qtype: "TXT", Qclass: "CH"},
+				{Qname: "dnscollector.dev", Qtype: "A", Qclass: "IN"}}}},
+			expected: "version.bind/TXT/CH,dnscollector.dev/A/IN",
+		},
 		{
 			format:   "df tr",
 			dm:       DnsMessage{NetworkInfo: DnsNetInfo{IpDefragmented: true, TcpReassembled: true}},
@@ -325,6 +359,49 @@ func TestDnsMessage_TextFormat_Directives_PublicSuffix(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_Edns(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "edns-csubnet edns-nsid edns-cookie-client",
+			dm:       DnsMessage{},
+			expected: "- - -",
+		},
+		{
+			name:   "default",
+			format: "edns-csubnet edns-nsid edns-nsid-hex edns-cookie-client edns-cookie-server edns-keepalive edns-zoneversion",
+			dm: DnsMessage{EDNS: DnsExtended{Options: []DnsOption{
+				{Code: 8, Name: "CSUBNET", Data: "10.0.0.0/24"},
+				{Code: 3, Name: "NSID", Data: "6e73312e616d7331 ns1.ams1"},
+				{Code: 10, Name: "COOKIE", Data: "24a5ac1223a3b6d2 -"},
+				{Code: 11, Name: "KEEPALIVE", Data: "12.5"},
+				{Code: 19, Name: "ZONEVERSION", Data: "2 0 2022534602"},
+			}}},
+			expected: "10.0.0.0/24 ns1.ams1 6e73312e616d7331 24a5ac1223a3b6d2 - 12.5 2022534602",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
 func TestDnsMessage_TextFormat_Directives_Geo(t *testing.T) {
 	config := GetFakeConfig()
 
@@ -347,6 +424,27 @@ func TestDnsMessage_TextFormat_Directives_Geo(t *testing.T) {
 				CountryIsoCode: "FR", AutonomousSystemNumber: "AS1", AutonomousSystemOrg: "Google"}},
 			expected: "Europe FR Paris AS1 Google",
 		},
+		{
+			name:     "location",
+			format:   "geoip-subdivision geoip-latitude geoip-longitude",
+			dm:       DnsMessage{Geo: &TransformDnsGeo{SubdivisionIsoCode: "IDF", Latitude: 48.8582, Longitude: 2.3387}},
+			expected: "IDF 48.8582 2.3387",
+		},
+		{
+			name:     "response-undefined",
+			format:   "geoip-response-country geoip-answers-country",
+			dm:       DnsMessage{Geo: &TransformDnsGeo{CountryIsoCode: "FR"}},
+			expected: "- -",
+		},
+		{
+			name:   "response-answers",
+			format: "geoip-response-country geoip-response-as-number geoip-answers-country geoip-answers-as-number",
+			dm: DnsMessage{Geo: &TransformDnsGeo{
+				ResponseIp: &TransformDnsGeo{CountryIsoCode: "US", AutonomousSystemNumber: "15169"},
+				Answers: []TransformDnsGeoAnswer{{Rdata: "192.0.2.1", CountryIsoCode: "DE", AutonomousSystemNumber: "3320"},
+					{Rdata: "192.0.2.2", CountryIsoCode: "NL", AutonomousSystemNumber: "1136"}}}},
+			expected: "US 15169 DE,NL 3320,1136",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -448,6 +546,203 @@ func TestDnsMessage_TextFormat_Directives_Pdns(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_Idn(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "idn-qname",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "idn-qname",
+			dm:       DnsMessage{Idn: &TransformIdn{QnameUnicode: "bücher.de"}},
+			expected: "bücher.de",
+		},
+		{
+			name:     "answers",
+			format:   "idn-answers",
+			dm:       DnsMessage{Idn: &TransformIdn{AnswersUnicode: []string{"bücher.de", "www.bücher.de"}}},
+			expected: "bücher.de,www.bücher.de",
+		},
+		{
+			name:     "no answers",
+			format:   "idn-answers",
+			dm:       DnsMessage{Idn: &TransformIdn{QnameUnicode: "bücher.de", AnswersUnicode: []string{}}},
+			expected: "-",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Rpz(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "rpz-action",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "rpz-zone rpz-rule rpz-trigger rpz-action",
+			dm:       DnsMessage{Rpz: &TransformRpz{Zone: "rpz.local", Rule: "*.example.com", Trigger: "QNAME", Action: "NXDOMAIN"}},
+			expected: "rpz.local *.example.com QNAME NXDOMAIN",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Client(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "client-hostname",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "client-hostname client-mac client-source",
+			dm:       DnsMessage{Client: &TransformClient{Hostname: "laptop", Mac: "00:11:22:33:44:55", Source: "dhcp"}},
+			expected: "laptop 00:11:22:33:44:55 dhcp",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Filtering(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "filtering-sampling-rate",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "filtering-sampling-rate",
+			dm:       DnsMessage{Filtering: &TransformFiltering{SamplingRate: 0.001}},
+			expected: "0.001",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Transaction(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "transaction-status",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "transaction-status transaction-latency transaction-query-length transaction-response-length",
+			dm:       DnsMessage{Transaction: &TransformTransaction{Status: "ANSWERED", Latency: 0.0125, QueryLength: 42, ResponseLength: 58}},
+			expected: "ANSWERED 0.012500 42b 58b",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
 func TestDnsMessage_TextFormat_Directives_Suspicious(t *testing.T) {
 	config := GetFakeConfig()
 
@@ -485,6 +780,80 @@ func TestDnsMessage_TextFormat_Directives_Suspicious(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_MachineLearning(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "ml-class",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "ml-entropy ml-probability ml-class",
+			dm:       DnsMessage{MachineLearning: &TransformML{Entropy: 2.5, Probability: 0.8734, Class: "malicious"}},
+			expected: "2.5 0.873 malicious",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Label(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "label:site",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "label:site label:vlan label:owner",
+			dm:       DnsMessage{Labels: map[string]string{"site": "paris dc1", "vlan": "10"}},
+			expected: "paris_dc1 10 -",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
 func TestDnsMessage_TextFormat_Directives_Reducer(t *testing.T) {
 	config := GetFakeConfig()
 
@@ -506,6 +875,13 @@ func TestDnsMessage_TextFormat_Directives_Reducer(t *testing.T) {
 			dm:       DnsMessage{Reducer: &TransformReducer{Occurences: 1}},
 			expected: "1",
 		},
+		{
+			name:   "aggregates",
+			format: "reducer-first-seen reducer-last-seen reducer-distinct-clients reducer-latency-min reducer-latency-max reducer-latency-avg",
+			dm: DnsMessage{Reducer: &TransformReducer{FirstSeen: "t1", LastSeen: "t2", DistinctClients: 2,
+				LatencyMin: 0.001, LatencyMax: 0.003, LatencyAvg: 0.002}},
+			expected: "t1 t2 2 0.001000 0.003000 0.002000",
+		},
 	}
 
 	for _, tc := range testcases {
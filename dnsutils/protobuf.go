@@ -0,0 +1,1117 @@
+package dnsutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// PROTOBUF_VERSION is the version of the schema described in dnsmessage.proto,
+// incremented only on breaking changes
+const (
+	PROTOBUF_VERSION = 1
+	PROTOBUF_MAXSIZE = 4 * 1024 * 1024
+)
+
+var (
+	ErrProtobufMalformed = errors.New("protobuf, malformed message")
+	ErrProtobufVersion   = errors.New("protobuf, unsupported version")
+	ErrProtobufTooLarge  = errors.New("protobuf, message too large")
+)
+
+// pbEncoder appends the fields of a protobuf message, string fields are always
+// written to keep the difference between empty and default values
+type pbEncoder struct {
+	b []byte
+}
+
+func (e *pbEncoder) string(num protowire.Number, v string) {
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendString(e.b, v)
+}
+
+func (e *pbEncoder) bytes(num protowire.Number, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendBytes(e.b, v)
+}
+
+func (e *pbEncoder) int(num protowire.Number, v int64) {
+	if v == 0 {
+		return
+	}
+	e.b = protowire.AppendTag(e.b, num, protowire.VarintType)
+	e.b = protowire.AppendVarint(e.b, uint64(v))
+}
+
+func (e *pbEncoder) bool(num protowire.Number, v bool) {
+	if !v {
+		return
+	}
+	e.b = protowire.AppendTag(e.b, num, protowire.VarintType)
+	e.b = protowire.AppendVarint(e.b, 1)
+}
+
+func (e *pbEncoder) double(num protowire.Number, v float64) {
+	if v == 0 {
+		return
+	}
+	e.b = protowire.AppendTag(e.b, num, protowire.Fixed64Type)
+	e.b = protowire.AppendFixed64(e.b, math.Float64bits(v))
+}
+
+// message encodes the sub message in place, then moves it to insert its length
+func (e *pbEncoder) message(num protowire.Number, fn func(e *pbEncoder)) {
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	start := len(e.b)
+	fn(e)
+	size := len(e.b) - start
+	n := protowire.SizeVarint(uint64(size))
+	e.b = append(e.b, make([]byte, n)...)
+	copy(e.b[start+n:], e.b[start:start+size])
+	protowire.AppendVarint(e.b[:start], uint64(size))
+}
+
+// stringMap writes the entries of a map<string, string> field, sorted by key
+func (e *pbEncoder) stringMap(num protowire.Number, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.message(num, func(e *pbEncoder) {
+			e.string(1, k)
+			e.string(2, m[k])
+		})
+	}
+}
+
+// pbField is a decoded field, v is set for the bytes type and x for the others
+type pbField struct {
+	num protowire.Number
+	v   []byte
+	x   uint64
+}
+
+func (f pbField) string() string  { return string(f.v) }
+func (f pbField) symbol() string  { return internSymbol(f.v) }
+func (f pbField) bytes() []byte   { return append([]byte{}, f.v...) }
+func (f pbField) int() int        { return int(int64(f.x)) }
+func (f pbField) int64() int64    { return int64(f.x) }
+func (f pbField) bool() bool      { return f.x != 0 }
+func (f pbField) double() float64 { return math.Float64frombits(f.x) }
+func (f pbField) isMessage() bool { return f.v != nil }
+
+// stringMapEntry decodes an entry of a map<string, string> field into the map
+func (f pbField) stringMapEntry(m map[string]string) error {
+	var key, value string
+	err := pbDecode(f.v, func(f pbField) error {
+		switch f.num {
+		case 1:
+			key = f.string()
+		case 2:
+			value = f.string()
+		}
+		return nil
+	})
+	m[key] = value
+	return err
+}
+
+// pbDecode iterates over the fields of a protobuf message, unknown fields are ignored
+// by the callbacks to stay compatible with newer versions of the schema
+func pbDecode(b []byte, fn func(f pbField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrProtobufMalformed
+		}
+		b = b[n:]
+
+		f := pbField{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.x, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.v, n = protowire.ConsumeBytes(b)
+			if f.v == nil && n >= 0 {
+				f.v = []byte{}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return ErrProtobufMalformed
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToProtobuf encodes the DNS message according to the dnsmessage.proto schema
+func (dm *DnsMessage) ToProtobuf() ([]byte, error) {
+	dm.DecodeSections()
+	e := pbEncoder{b: make([]byte, 0, 512)}
+	e.int(1, PROTOBUF_VERSION)
+	e.message(2, dm.NetworkInfo.encodeProtobuf)
+	e.message(3, dm.DNS.encodeProtobuf)
+	e.message(4, dm.EDNS.encodeProtobuf)
+	e.message(5, dm.DnsTap.encodeProtobuf)
+	if dm.Geo != nil {
+		e.message(6, dm.Geo.encodeProtobuf)
+	}
+	if dm.PowerDns != nil {
+		e.message(7, dm.PowerDns.encodeProtobuf)
+	}
+	if dm.Suspicious != nil {
+		e.message(8, dm.Suspicious.encodeProtobuf)
+	}
+	if dm.PublicSuffix != nil {
+		e.message(9, func(e *pbEncoder) {
+			e.string(1, dm.PublicSuffix.QnamePublicSuffix)
+			e.string(2, dm.PublicSuffix.QnameEffectiveTLDPlusOne)
+		})
+	}
+	if dm.Extracted != nil {
+		e.message(10, func(e *pbEncoder) { e.bytes(1, dm.Extracted.Base64Payload) })
+	}
+	if dm.Reducer != nil {
+		e.message(11, func(e *pbEncoder) {
+			e.int(1, int64(dm.Reducer.Occurences))
+			e.int(2, int64(dm.Reducer.CumulativeLength))
+			e.string(3, dm.Reducer.FirstSeen)
+			e.string(4, dm.Reducer.LastSeen)
+			e.int(5, int64(dm.Reducer.DistinctClients))
+			e.double(6, dm.Reducer.LatencyMin)
+			e.double(7, dm.Reducer.LatencyMax)
+			e.double(8, dm.Reducer.LatencyAvg)
+		})
+	}
+	if dm.MachineLearning != nil {
+		e.message(12, dm.MachineLearning.encodeProtobuf)
+	}
+	if dm.Idn != nil {
+		e.message(13, func(e *pbEncoder) {
+			e.string(1, dm.Idn.QnameUnicode)
+			for _, name := range dm.Idn.AnswersUnicode {
+				e.string(2, name)
+			}
+		})
+	}
+	if dm.Rpz != nil {
+		e.message(14, func(e *pbEncoder) {
+			e.string(1, dm.Rpz.Zone)
+			e.string(2, dm.Rpz.Rule)
+			e.string(3, dm.Rpz.Trigger)
+			e.string(4, dm.Rpz.Action)
+		})
+	}
+	if dm.Transaction != nil {
+		e.message(15, func(e *pbEncoder) {
+			e.string(1, dm.Transaction.Status)
+			e.string(2, dm.Transaction.QueryTimestamp)
+			e.string(3, dm.Transaction.ResponseTimestamp)
+			e.double(4, dm.Transaction.Latency)
+			e.int(5, int64(dm.Transaction.QueryLength))
+			e.int(6, int64(dm.Transaction.ResponseLength))
+		})
+	}
+	e.stringMap(16, dm.Labels)
+	if dm.Client != nil {
+		e.message(17, func(e *pbEncoder) {
+			e.string(1, dm.Client.Hostname)
+			e.string(2, dm.Client.Mac)
+			e.string(3, dm.Client.Source)
+		})
+	}
+	if dm.Filtering != nil {
+		e.message(18, func(e *pbEncoder) {
+			e.double(1, dm.Filtering.SamplingRate)
+		})
+	}
+	return e.b, nil
+}
+
+// ToProtobufDelimited encodes the DNS message prefixed by its length as a varint,
+// to write it on a stream
+func (dm *DnsMessage) ToProtobufDelimited() ([]byte, error) {
+	data, err := dm.ToProtobuf()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(data)+binary.MaxVarintLen32)
+	out = protowire.AppendVarint(out, uint64(len(data)))
+	return append(out, data...), nil
+}
+
+// FromProtobuf decodes a DNS message encoded with ToProtobuf
+func (dm *DnsMessage) FromProtobuf(data []byte) error {
+	dm.Init()
+	dm.Geo, dm.PowerDns, dm.Suspicious, dm.PublicSuffix = nil, nil, nil, nil
+	dm.Extracted, dm.Reducer, dm.MachineLearning, dm.Idn, dm.Rpz = nil, nil, nil, nil, nil
+	dm.Transaction, dm.Labels, dm.Client, dm.Filtering = nil, nil, nil, nil
+
+	return pbDecode(data, func(f pbField) error {
+		switch f.num {
+		case 1:
+			if f.int() > PROTOBUF_VERSION {
+				return ErrProtobufVersion
+			}
+		case 2:
+			return dm.NetworkInfo.decodeProtobuf(f.v)
+		case 3:
+			return dm.DNS.decodeProtobuf(f.v)
+		case 4:
+			return dm.EDNS.decodeProtobuf(f.v)
+		case 5:
+			return dm.DnsTap.decodeProtobuf(f.v)
+		case 6:
+			dm.Geo = &TransformDnsGeo{}
+			return dm.Geo.decodeProtobuf(f.v)
+		case 7:
+			dm.PowerDns = &PowerDns{Tags: []string{}, Metadata: map[string]string{}}
+			return dm.PowerDns.decodeProtobuf(f.v)
+		case 8:
+			dm.Suspicious = &TransformSuspicious{}
+			return dm.Suspicious.decodeProtobuf(f.v)
+		case 9:
+			dm.PublicSuffix = &TransformPublicSuffix{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.PublicSuffix.QnamePublicSuffix = f.string()
+				case 2:
+					dm.PublicSuffix.QnameEffectiveTLDPlusOne = f.string()
+				}
+				return nil
+			})
+		case 10:
+			dm.Extracted = &TransformExtracted{}
+			return pbDecode(f.v, func(f pbField) error {
+				if f.num == 1 {
+					dm.Extracted.Base64Payload = f.bytes()
+				}
+				return nil
+			})
+		case 11:
+			dm.Reducer = &TransformReducer{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.Reducer.Occurences = f.int()
+				case 2:
+					dm.Reducer.CumulativeLength = f.int()
+				case 3:
+					dm.Reducer.FirstSeen = f.string()
+				case 4:
+					dm.Reducer.LastSeen = f.string()
+				case 5:
+					dm.Reducer.DistinctClients = f.int()
+				case 6:
+					dm.Reducer.LatencyMin = f.double()
+				case 7:
+					dm.Reducer.LatencyMax = f.double()
+				case 8:
+					dm.Reducer.LatencyAvg = f.double()
+				}
+				return nil
+			})
+		case 12:
+			dm.MachineLearning = &TransformML{}
+			return dm.MachineLearning.decodeProtobuf(f.v)
+		case 13:
+			dm.Idn = &TransformIdn{AnswersUnicode: []string{}}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.Idn.QnameUnicode = f.string()
+				case 2:
+					dm.Idn.AnswersUnicode = append(dm.Idn.AnswersUnicode, f.string())
+				}
+				return nil
+			})
+		case 14:
+			dm.Rpz = &TransformRpz{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.Rpz.Zone = f.string()
+				case 2:
+					dm.Rpz.Rule = f.string()
+				case 3:
+					dm.Rpz.Trigger = f.string()
+				case 4:
+					dm.Rpz.Action = f.string()
+				}
+				return nil
+			})
+		case 15:
+			dm.Transaction = &TransformTransaction{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.Transaction.Status = f.string()
+				case 2:
+					dm.Transaction.QueryTimestamp = f.string()
+				case 3:
+					dm.Transaction.ResponseTimestamp = f.string()
+				case 4:
+					dm.Transaction.Latency = f.double()
+				case 5:
+					dm.Transaction.QueryLength = f.int()
+				case 6:
+					dm.Transaction.ResponseLength = f.int()
+				}
+				return nil
+			})
+		case 16:
+			if dm.Labels == nil {
+				dm.Labels = make(map[string]string)
+			}
+			return f.stringMapEntry(dm.Labels)
+		case 17:
+			dm.Client = &TransformClient{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					dm.Client.Hostname = f.string()
+				case 2:
+					dm.Client.Mac = f.string()
+				case 3:
+					dm.Client.Source = f.string()
+				}
+				return nil
+			})
+		case 18:
+			dm.Filtering = &TransformFiltering{}
+			return pbDecode(f.v, func(f pbField) error {
+				if f.num == 1 {
+					dm.Filtering.SamplingRate = f.double()
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// ProtobufReader reads DNS messages prefixed by their length from a stream
+type ProtobufReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewProtobufReader(r io.Reader) *ProtobufReader {
+	return &ProtobufReader{r: bufio.NewReader(r)}
+}
+
+// Read decodes the next DNS message of the stream, io.EOF is returned at the end of the stream
+func (p *ProtobufReader) Read(dm *DnsMessage) error {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return err
+	}
+	if size > PROTOBUF_MAXSIZE {
+		return ErrProtobufTooLarge
+	}
+	if uint64(cap(p.buf)) < size {
+		p.buf = make([]byte, size)
+	}
+	p.buf = p.buf[:size]
+	if _, err := io.ReadFull(p.r, p.buf); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return dm.FromProtobuf(p.buf)
+}
+
+func (n *DnsNetInfo) encodeProtobuf(e *pbEncoder) {
+	e.string(1, n.Family)
+	e.string(2, n.Protocol)
+	e.string(3, n.QueryIp)
+	e.string(4, n.QueryPort)
+	e.string(5, n.ResponseIp)
+	e.string(6, n.ResponsePort)
+	e.bool(7, n.IpDefragmented)
+	e.bool(8, n.TcpReassembled)
+}
+
+func (n *DnsNetInfo) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			n.Family = f.symbol()
+		case 2:
+			n.Protocol = f.symbol()
+		case 3:
+			n.QueryIp = f.string()
+		case 4:
+			n.QueryPort = f.string()
+		case 5:
+			n.ResponseIp = f.string()
+		case 6:
+			n.ResponsePort = f.string()
+		case 7:
+			n.IpDefragmented = f.bool()
+		case 8:
+			n.TcpReassembled = f.bool()
+		}
+		return nil
+	})
+}
+
+func encodeProtobufRecord(rr DnsAnswer) func(e *pbEncoder) {
+	return func(e *pbEncoder) {
+		e.string(1, rr.Name)
+		e.string(2, rr.Rdatatype)
+		e.int(3, int64(rr.Class))
+		e.int(4, int64(rr.Ttl))
+		e.string(5, rr.Rdata)
+	}
+}
+
+func decodeProtobufRecord(b []byte) (DnsAnswer, error) {
+	rr := DnsAnswer{}
+	err := pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			rr.Name = f.string()
+		case 2:
+			rr.Rdatatype = f.symbol()
+		case 3:
+			rr.Class = f.int()
+		case 4:
+			rr.Ttl = f.int()
+		case 5:
+			rr.Rdata = f.string()
+		}
+		return nil
+	})
+	return rr, err
+}
+
+func encodeProtobufUpdateRecord(rr DnsUpdateRecord) func(e *pbEncoder) {
+	return func(e *pbEncoder) {
+		e.string(1, rr.Name)
+		e.string(2, rr.Rdatatype)
+		e.string(3, rr.Class)
+		e.int(4, int64(rr.Ttl))
+		e.string(5, rr.Rdata)
+		e.string(6, rr.Operation)
+	}
+}
+
+func decodeProtobufUpdateRecord(b []byte) (DnsUpdateRecord, error) {
+	rr := DnsUpdateRecord{}
+	err := pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			rr.Name = f.string()
+		case 2:
+			rr.Rdatatype = f.symbol()
+		case 3:
+			rr.Class = f.symbol()
+		case 4:
+			rr.Ttl = f.int()
+		case 5:
+			rr.Rdata = f.string()
+		case 6:
+			rr.Operation = f.symbol()
+		}
+		return nil
+	})
+	return rr, err
+}
+
+func (d *Dns) encodeProtobuf(e *pbEncoder) {
+	e.string(1, d.Type)
+	e.bytes(2, d.Payload)
+	e.int(3, int64(d.Length))
+	e.int(4, int64(d.Id))
+	e.int(5, int64(d.Opcode))
+	e.string(6, d.Rcode)
+	e.string(7, d.Qname)
+	e.string(8, d.Qtype)
+	e.string(9, d.Qclass)
+	e.int(10, int64(d.Qdcount))
+	e.int(11, int64(d.Ancount))
+	e.int(12, int64(d.Nscount))
+	e.int(13, int64(d.Arcount))
+	for _, q := range d.Questions {
+		e.message(14, func(e *pbEncoder) {
+			e.string(1, q.Qname)
+			e.string(2, q.Qtype)
+			e.string(3, q.Qclass)
+		})
+	}
+	e.message(15, func(e *pbEncoder) {
+		e.bool(1, d.Flags.QR)
+		e.bool(2, d.Flags.TC)
+		e.bool(3, d.Flags.AA)
+		e.bool(4, d.Flags.RA)
+		e.bool(5, d.Flags.AD)
+		e.bool(6, d.Flags.RD)
+		e.bool(7, d.Flags.CD)
+		e.bool(8, d.Flags.Z)
+	})
+	for _, rr := range d.DnsRRs.Answers {
+		e.message(16, encodeProtobufRecord(rr))
+	}
+	for _, rr := range d.DnsRRs.Nameservers {
+		e.message(17, encodeProtobufRecord(rr))
+	}
+	for _, rr := range d.DnsRRs.Records {
+		e.message(18, encodeProtobufRecord(rr))
+	}
+	e.bool(19, d.MalformedPacket)
+	if d.Update != nil {
+		e.message(20, func(e *pbEncoder) {
+			e.string(1, d.Update.Zone)
+			e.string(2, d.Update.ZoneClass)
+			for _, rr := range d.Update.Prerequisites {
+				e.message(3, encodeProtobufUpdateRecord(rr))
+			}
+			for _, rr := range d.Update.Updates {
+				e.message(4, encodeProtobufUpdateRecord(rr))
+			}
+		})
+	}
+	if d.Notify != nil {
+		e.message(21, func(e *pbEncoder) {
+			e.string(1, d.Notify.Zone)
+			e.string(2, d.Notify.Qtype)
+			e.int(3, d.Notify.Serial)
+		})
+	}
+	if d.Transfer != nil {
+		e.message(22, func(e *pbEncoder) {
+			e.string(1, d.Transfer.Zone)
+			e.string(2, d.Transfer.Type)
+			e.int(3, int64(d.Transfer.Records))
+			rrtypes := make([]string, 0, len(d.Transfer.Rrtypes))
+			for k := range d.Transfer.Rrtypes {
+				rrtypes = append(rrtypes, k)
+			}
+			sort.Strings(rrtypes)
+			for _, k := range rrtypes {
+				e.message(4, func(e *pbEncoder) {
+					e.string(1, k)
+					e.int(2, int64(d.Transfer.Rrtypes[k]))
+				})
+			}
+			for _, serial := range d.Transfer.Serials {
+				// repeated field not packed, accepted by all protobuf parsers
+				e.b = protowire.AppendTag(e.b, 5, protowire.VarintType)
+				e.b = protowire.AppendVarint(e.b, uint64(serial))
+			}
+		})
+	}
+}
+
+func (d *Dns) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			d.Type = f.symbol()
+		case 2:
+			d.Payload = f.bytes()
+		case 3:
+			d.Length = f.int()
+		case 4:
+			d.Id = f.int()
+		case 5:
+			d.Opcode = f.int()
+		case 6:
+			d.Rcode = f.symbol()
+		case 7:
+			d.Qname = f.string()
+		case 8:
+			d.Qtype = f.symbol()
+		case 9:
+			d.Qclass = f.symbol()
+		case 10:
+			d.Qdcount = f.int()
+		case 11:
+			d.Ancount = f.int()
+		case 12:
+			d.Nscount = f.int()
+		case 13:
+			d.Arcount = f.int()
+		case 14:
+			q := DnsQuestion{}
+			err := pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					q.Qname = f.string()
+				case 2:
+					q.Qtype = f.symbol()
+				case 3:
+					q.Qclass = f.symbol()
+				}
+				return nil
+			})
+			d.Questions = append(d.Questions, q)
+			return err
+		case 15:
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					d.Flags.QR = f.bool()
+				case 2:
+					d.Flags.TC = f.bool()
+				case 3:
+					d.Flags.AA = f.bool()
+				case 4:
+					d.Flags.RA = f.bool()
+				case 5:
+					d.Flags.AD = f.bool()
+				case 6:
+					d.Flags.RD = f.bool()
+				case 7:
+					d.Flags.CD = f.bool()
+				case 8:
+					d.Flags.Z = f.bool()
+				}
+				return nil
+			})
+		case 16, 17, 18:
+			rr, err := decodeProtobufRecord(f.v)
+			switch f.num {
+			case 16:
+				d.DnsRRs.Answers = append(d.DnsRRs.Answers, rr)
+			case 17:
+				d.DnsRRs.Nameservers = append(d.DnsRRs.Nameservers, rr)
+			default:
+				d.DnsRRs.Records = append(d.DnsRRs.Records, rr)
+			}
+			return err
+		case 19:
+			d.MalformedPacket = f.bool()
+		case 20:
+			d.Update = &DnsUpdate{Prerequisites: []DnsUpdateRecord{}, Updates: []DnsUpdateRecord{}}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					d.Update.Zone = f.string()
+				case 2:
+					d.Update.ZoneClass = f.string()
+				case 3, 4:
+					rr, err := decodeProtobufUpdateRecord(f.v)
+					if f.num == 3 {
+						d.Update.Prerequisites = append(d.Update.Prerequisites, rr)
+					} else {
+						d.Update.Updates = append(d.Update.Updates, rr)
+					}
+					return err
+				}
+				return nil
+			})
+		case 21:
+			d.Notify = &DnsNotify{}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					d.Notify.Zone = f.string()
+				case 2:
+					d.Notify.Qtype = f.symbol()
+				case 3:
+					d.Notify.Serial = f.int64()
+				}
+				return nil
+			})
+		case 22:
+			d.Transfer = &DnsTransfer{Rrtypes: map[string]int{}, Serials: []int64{}}
+			return pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					d.Transfer.Zone = f.string()
+				case 2:
+					d.Transfer.Type = f.string()
+				case 3:
+					d.Transfer.Records = f.int()
+				case 4:
+					var key string
+					var value int
+					err := pbDecode(f.v, func(f pbField) error {
+						switch f.num {
+						case 1:
+							key = f.string()
+						case 2:
+							value = f.int()
+						}
+						return nil
+					})
+					d.Transfer.Rrtypes[key] = value
+					return err
+				case 5:
+					if f.isMessage() {
+						// packed encoding
+						for b := f.v; len(b) > 0; {
+							v, n := protowire.ConsumeVarint(b)
+							if n < 0 {
+								return ErrProtobufMalformed
+							}
+							d.Transfer.Serials = append(d.Transfer.Serials, int64(v))
+							b = b[n:]
+						}
+					} else {
+						d.Transfer.Serials = append(d.Transfer.Serials, f.int64())
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func (d *DnsExtended) encodeProtobuf(e *pbEncoder) {
+	e.int(1, int64(d.UdpSize))
+	e.int(2, int64(d.ExtendedRcode))
+	e.int(3, int64(d.Version))
+	e.int(4, int64(d.Do))
+	e.int(5, int64(d.Z))
+	for _, o := range d.Options {
+		e.message(6, func(e *pbEncoder) {
+			e.int(1, int64(o.Code))
+			e.string(2, o.Name)
+			e.string(3, o.Data)
+		})
+	}
+}
+
+func (d *DnsExtended) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			d.UdpSize = f.int()
+		case 2:
+			d.ExtendedRcode = f.int()
+		case 3:
+			d.Version = f.int()
+		case 4:
+			d.Do = f.int()
+		case 5:
+			d.Z = f.int()
+		case 6:
+			o := DnsOption{}
+			err := pbDecode(f.v, func(f pbField) error {
+				switch f.num {
+				case 1:
+					o.Code = f.int()
+				case 2:
+					o.Name = f.string()
+				case 3:
+					o.Data = f.string()
+				}
+				return nil
+			})
+			d.Options = append(d.Options, o)
+			return err
+		}
+		return nil
+	})
+}
+
+func (d *DnsTap) encodeProtobuf(e *pbEncoder) {
+	e.string(1, d.Operation)
+	e.string(2, d.Identity)
+	e.string(3, d.Version)
+	e.string(4, d.TimestampRFC3339)
+	e.int(5, d.Timestamp)
+	e.int(6, int64(d.TimeSec))
+	e.int(7, int64(d.TimeNsec))
+	e.double(8, d.Latency)
+	e.string(9, d.LatencySec)
+	e.bytes(10, d.Payload)
+	e.string(11, d.Extra)
+}
+
+func (d *DnsTap) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			d.Operation = f.symbol()
+		case 2:
+			d.Identity = f.string()
+		case 3:
+			d.Version = f.string()
+		case 4:
+			d.TimestampRFC3339 = f.string()
+		case 5:
+			d.Timestamp = f.int64()
+		case 6:
+			d.TimeSec = f.int()
+		case 7:
+			d.TimeNsec = f.int()
+		case 8:
+			d.Latency = f.double()
+		case 9:
+			d.LatencySec = f.string()
+		case 10:
+			d.Payload = f.bytes()
+		case 11:
+			d.Extra = f.string()
+		}
+		return nil
+	})
+}
+
+func (p *PowerDns) encodeProtobuf(e *pbEncoder) {
+	for _, tag := range p.Tags {
+		e.string(1, tag)
+	}
+	e.string(2, p.OriginalRequestSubnet)
+	e.string(3, p.AppliedPolicy)
+	e.stringMap(4, p.Metadata)
+}
+
+func (p *PowerDns) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			p.Tags = append(p.Tags, f.string())
+		case 2:
+			p.OriginalRequestSubnet = f.string()
+		case 3:
+			p.AppliedPolicy = f.string()
+		case 4:
+			return f.stringMapEntry(p.Metadata)
+		}
+		return nil
+	})
+}
+
+func (g *TransformDnsGeo) encodeProtobuf(e *pbEncoder) {
+	e.string(1, g.City)
+	e.string(2, g.Continent)
+	e.string(3, g.CountryIsoCode)
+	e.string(4, g.AutonomousSystemNumber)
+	e.string(5, g.AutonomousSystemOrg)
+	e.string(6, g.SubdivisionIsoCode)
+	e.double(7, g.Latitude)
+	e.double(8, g.Longitude)
+	if g.ResponseIp != nil {
+		e.message(9, g.ResponseIp.encodeProtobuf)
+	}
+	for i := range g.Answers {
+		e.message(10, g.Answers[i].encodeProtobuf)
+	}
+}
+
+func (g *TransformDnsGeo) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			g.City = f.string()
+		case 2:
+			g.Continent = f.string()
+		case 3:
+			g.CountryIsoCode = f.string()
+		case 4:
+			g.AutonomousSystemNumber = f.string()
+		case 5:
+			g.AutonomousSystemOrg = f.string()
+		case 6:
+			g.SubdivisionIsoCode = f.string()
+		case 7:
+			g.Latitude = f.double()
+		case 8:
+			g.Longitude = f.double()
+		case 9:
+			g.ResponseIp = &TransformDnsGeo{}
+			return g.ResponseIp.decodeProtobuf(f.v)
+		case 10:
+			var a TransformDnsGeoAnswer
+			if err := a.decodeProtobuf(f.v); err != nil {
+				return err
+			}
+			g.Answers = append(g.Answers, a)
+		}
+		return nil
+	})
+}
+
+func (a *TransformDnsGeoAnswer) encodeProtobuf(e *pbEncoder) {
+	e.string(1, a.Rdata)
+	e.string(2, a.City)
+	e.string(3, a.Continent)
+	e.string(4, a.CountryIsoCode)
+	e.string(5, a.SubdivisionIsoCode)
+	e.double(6, a.Latitude)
+	e.double(7, a.Longitude)
+	e.string(8, a.AutonomousSystemNumber)
+	e.string(9, a.AutonomousSystemOrg)
+}
+
+func (a *TransformDnsGeoAnswer) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			a.Rdata = f.string()
+		case 2:
+			a.City = f.string()
+		case 3:
+			a.Continent = f.string()
+		case 4:
+			a.CountryIsoCode = f.string()
+		case 5:
+			a.SubdivisionIsoCode = f.string()
+		case 6:
+			a.Latitude = f.double()
+		case 7:
+			a.Longitude = f.double()
+		case 8:
+			a.AutonomousSystemNumber = f.string()
+		case 9:
+			a.AutonomousSystemOrg = f.string()
+		}
+		return nil
+	})
+}
+
+func (s *TransformSuspicious) encodeProtobuf(e *pbEncoder) {
+	e.double(1, s.Score)
+	e.bool(2, s.MalformedPacket)
+	e.bool(3, s.LargePacket)
+	e.bool(4, s.LongDomain)
+	e.bool(5, s.SlowDomain)
+	e.bool(6, s.UnallowedChars)
+	e.bool(7, s.UncommonQtypes)
+	e.bool(8, s.ExcessiveNumberLabels)
+	e.bool(9, s.HomographDomain)
+	e.string(10, s.Domain)
+	e.bool(11, s.UniqueSubdomains)
+	e.bool(12, s.TxtNullVolume)
+	e.bool(13, s.RandomSubdomains)
+	e.bool(14, s.HighEntropy)
+	e.bool(15, s.UnlikelyNgrams)
+}
+
+func (s *TransformSuspicious) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			s.Score = f.double()
+		case 2:
+			s.MalformedPacket = f.bool()
+		case 3:
+			s.LargePacket = f.bool()
+		case 4:
+			s.LongDomain = f.bool()
+		case 5:
+			s.SlowDomain = f.bool()
+		case 6:
+			s.UnallowedChars = f.bool()
+		case 7:
+			s.UncommonQtypes = f.bool()
+		case 8:
+			s.ExcessiveNumberLabels = f.bool()
+		case 9:
+			s.HomographDomain = f.bool()
+		case 10:
+			s.Domain = f.string()
+		case 11:
+			s.UniqueSubdomains = f.bool()
+		case 12:
+			s.TxtNullVolume = f.bool()
+		case 13:
+			s.RandomSubdomains = f.bool()
+		case 14:
+			s.HighEntropy = f.bool()
+		case 15:
+			s.UnlikelyNgrams = f.bool()
+		}
+		return nil
+	})
+}
+
+func (m *TransformML) encodeProtobuf(e *pbEncoder) {
+	e.double(1, m.Entropy)
+	e.int(2, int64(m.Length))
+	e.int(3, int64(m.Labels))
+	e.int(4, int64(m.Digits))
+	e.int(5, int64(m.Lowers))
+	e.int(6, int64(m.Uppers))
+	e.int(7, int64(m.Specials))
+	e.int(8, int64(m.Others))
+	e.double(9, m.RatioDigits)
+	e.double(10, m.RatioLetters)
+	e.double(11, m.RatioSpecials)
+	e.double(12, m.RatioOthers)
+	e.int(13, int64(m.ConsecutiveChars))
+	e.int(14, int64(m.ConsecutiveVowels))
+	e.int(15, int64(m.ConsecutiveDigits))
+	e.int(16, int64(m.ConsecutiveConsonants))
+	e.int(17, int64(m.Size))
+	e.int(18, int64(m.Occurences))
+	e.int(19, int64(m.UncommonQtypes))
+	e.double(20, m.Probability)
+	e.string(21, m.Class)
+}
+
+func (m *TransformML) decodeProtobuf(b []byte) error {
+	return pbDecode(b, func(f pbField) error {
+		switch f.num {
+		case 1:
+			m.Entropy = f.double()
+		case 2:
+			m.Length = f.int()
+		case 3:
+			m.Labels = f.int()
+		case 4:
+			m.Digits = f.int()
+		case 5:
+			m.Lowers = f.int()
+		case 6:
+			m.Uppers = f.int()
+		case 7:
+			m.Specials = f.int()
+		case 8:
+			m.Others = f.int()
+		case 9:
+			m.RatioDigits = f.double()
+		case 10:
+			m.RatioLetters = f.double()
+		case 11:
+			m.RatioSpecials = f.double()
+		case 12:
+			m.RatioOthers = f.double()
+		case 13:
+			m.ConsecutiveChars = f.int()
+		case 14:
+			m.ConsecutiveVowels = f.int()
+		case 15:
+			m.ConsecutiveDigits = f.int()
+		case 16:
+			m.ConsecutiveConsonants = f.int()
+		case 17:
+			m.Size = f.int()
+		case 18:
+			m.Occurences = f.int()
+		case 19:
+			m.UncommonQtypes = f.int()
+		case 20:
+			m.Probability = f.double()
+		case 21:
+			m.Class = f.string()
+		}
+		return nil
+	})
+}
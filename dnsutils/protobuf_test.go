@@ -0,0 +1,173 @@
+package dnsutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func getFakeEnrichedDnsMessage() DnsMessage {
+	dm := GetFakeDnsMessage()
+	dm.NetworkInfo.Family = PROTO_IPV4
+	dm.NetworkInfo.Protocol = PROTO_UDP
+	dm.NetworkInfo.TcpReassembled = true
+	dm.DNS.Type = DnsReply
+	dm.DNS.Payload = []byte{0x01, 0x02, 0x03}
+	dm.DNS.Length = 3
+	dm.DNS.Id = 4242
+	dm.DNS.Opcode = OPCODE_NOTIFY
+	dm.DNS.Qclass = "IN"
+	dm.DNS.Qdcount, dm.DNS.Ancount = 1, 2
+	dm.DNS.Questions = []DnsQuestion{{Qname: "dns.collector", Qtype: "A", Qclass: "IN"}}
+	dm.DNS.Flags = DnsFlags{QR: true, AA: true, RD: true, CD: true}
+	dm.DNS.DnsRRs.Answers = []DnsAnswer{
+		{Name: "dns.collector", Rdatatype: "A", Class: 1, Ttl: 300, Rdata: "192.0.2.1"},
+		{Name: "dns.collector", Rdatatype: "TXT", Class: 1, Ttl: 0, Rdata: ""},
+	}
+	dm.DNS.DnsRRs.Nameservers = []DnsAnswer{{Name: "collector", Rdatatype: "NS", Class: 1, Ttl: 3600, Rdata: "ns1.collector"}}
+	dm.DNS.Update = &DnsUpdate{Zone: "collector", ZoneClass: "IN",
+		Prerequisites: []DnsUpdateRecord{},
+		Updates:       []DnsUpdateRecord{{Name: "dns.collector", Rdatatype: "A", Class: "IN", Ttl: 300, Rdata: "192.0.2.1", Operation: "add"}},
+	}
+	dm.DNS.Notify = &DnsNotify{Zone: "collector", Qtype: "SOA", Serial: -1}
+	dm.DNS.Transfer = &DnsTransfer{Zone: "collector", Type: "AXFR", Records: 3,
+		Rrtypes: map[string]int{"SOA": 2, "A": 1}, Serials: []int64{2023102601, 2023102601}}
+	dm.EDNS = DnsExtended{UdpSize: 1232, ExtendedRcode: 16, Version: 0, Do: 1, Z: 2,
+		Options: []DnsOption{{Code: 10, Name: "COOKIE", Data: "24a5ac1223b2b6d5 -"}}}
+	dm.DnsTap.Timestamp = 1700000000123456789
+	dm.DnsTap.TimeSec = 1700000000
+	dm.DnsTap.TimeNsec = 123456789
+	dm.DnsTap.Latency = 0.000123
+	dm.DnsTap.LatencySec = "0.000123"
+	dm.DnsTap.Payload = []byte{0xff}
+	dm.Geo = &TransformDnsGeo{City: "Paris", Continent: "EU", CountryIsoCode: "FR", SubdivisionIsoCode: "IDF",
+		Latitude: 48.8582, Longitude: 2.3387, AutonomousSystemNumber: "AS64496", AutonomousSystemOrg: "Example",
+		ResponseIp: &TransformDnsGeo{CountryIsoCode: "US", AutonomousSystemNumber: "AS15169"},
+		Answers:    []TransformDnsGeoAnswer{{Rdata: "192.0.2.1", CountryIsoCode: "DE", Latitude: 51.2993}}}
+	dm.PowerDns = &PowerDns{Tags: []string{"tag1", "tag2"}, OriginalRequestSubnet: "192.0.2.0/24",
+		AppliedPolicy: "rpz", Metadata: map[string]string{"k1": "v1", "k2": ""}}
+	dm.Suspicious = &TransformSuspicious{Score: 2.5, LongDomain: true, HomographDomain: true, Domain: "dns.collector",
+		UniqueSubdomains: true, UnlikelyNgrams: true}
+	dm.PublicSuffix = &TransformPublicSuffix{QnamePublicSuffix: "collector", QnameEffectiveTLDPlusOne: "dns.collector"}
+	dm.Extracted = &TransformExtracted{Base64Payload: []byte{0x01}}
+	dm.Reducer = &TransformReducer{Occurences: 10, CumulativeLength: 1000, FirstSeen: "2023-11-14T22:13:20Z",
+		LastSeen: "2023-11-14T22:13:25Z", DistinctClients: 3, LatencyMin: 0.001, LatencyMax: 0.1, LatencyAvg: 0.02}
+	dm.MachineLearning = &TransformML{Entropy: 3.2, Length: 13, Labels: 2, RatioLetters: 0.9, UncommonQtypes: 1,
+		Probability: 0.87, Class: "malicious"}
+	dm.Idn = &TransformIdn{QnameUnicode: "dns.collector", AnswersUnicode: []string{"dns.collector"}}
+	dm.Rpz = &TransformRpz{Zone: "rpz.local", Rule: "*.collector", Trigger: "QNAME", Action: "NXDOMAIN"}
+	dm.Transaction = &TransformTransaction{Status: "ANSWERED", QueryTimestamp: "2023-11-14T22:13:20.100000000Z",
+		ResponseTimestamp: "2023-11-14T22:13:20.123456789Z", Latency: 0.023456789, QueryLength: 42, ResponseLength: 3}
+	dm.Labels = map[string]string{"site": "paris", "vlan": "10"}
+	dm.Client = &TransformClient{Hostname: "laptop", Mac: "00:11:22:33:44:55", Source: "dhcp"}
+	dm.Filtering = &TransformFiltering{SamplingRate: 0.25}
+	return dm
+}
+
+func TestDnsMessage_Protobuf_RoundTrip(t *testing.T) {
+	testcases := []struct {
+		name string
+		dm   DnsMessage
+	}{
+		{name: "default", dm: GetFakeDnsMessage()},
+		{name: "enriched", dm: getFakeEnrichedDnsMessage()},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.dm.ToProtobuf()
+			if err != nil {
+				t.Fatalf("unexpected error when encoding: %v", err)
+			}
+
+			dm := DnsMessage{}
+			if err := dm.FromProtobuf(data); err != nil {
+				t.Fatalf("unexpected error when decoding: %v", err)
+			}
+
+			if !reflect.DeepEqual(dm, tc.dm) {
+				t.Errorf("decoded message is different\nwant: %+v\ngot:  %+v", tc.dm, dm)
+			}
+			if dm.ToJson() != tc.dm.ToJson() {
+				t.Errorf("json is different\nwant: %s\ngot:  %s", tc.dm.ToJson(), dm.ToJson())
+			}
+		})
+	}
+}
+
+func TestDnsMessage_Protobuf_Reader(t *testing.T) {
+	var stream bytes.Buffer
+	dms := []DnsMessage{GetFakeDnsMessage(), getFakeEnrichedDnsMessage()}
+	for _, dm := range dms {
+		data, err := dm.ToProtobufDelimited()
+		if err != nil {
+			t.Fatalf("unexpected error when encoding: %v", err)
+		}
+		stream.Write(data)
+	}
+
+	reader := NewProtobufReader(&stream)
+	for i := range dms {
+		dm := DnsMessage{}
+		if err := reader.Read(&dm); err != nil {
+			t.Fatalf("unexpected error when reading message %d: %v", i, err)
+		}
+		if dm.DNS.Qname != dms[i].DNS.Qname || dm.DNS.Id != dms[i].DNS.Id {
+			t.Errorf("invalid message %d: %+v", i, dm.DNS)
+		}
+	}
+
+	dm := DnsMessage{}
+	if err := reader.Read(&dm); err != io.EOF {
+		t.Errorf("end of stream expected, got %v", err)
+	}
+}
+
+func TestDnsMessage_Protobuf_Invalid(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	data, _ := dm.ToProtobuf()
+
+	// truncated message
+	if err := dm.FromProtobuf(data[:len(data)-1]); err != ErrProtobufMalformed {
+		t.Errorf("malformed error expected, got %v", err)
+	}
+
+	// newer version of the schema
+	future := protowire.AppendTag(nil, 1, protowire.VarintType)
+	future = protowire.AppendVarint(future, PROTOBUF_VERSION+1)
+	if err := dm.FromProtobuf(future); err != ErrProtobufVersion {
+		t.Errorf("version error expected, got %v", err)
+	}
+
+	// unknown fields are ignored
+	unknown := protowire.AppendTag(data, 1000, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "new field")
+	if err := dm.FromProtobuf(unknown); err != nil {
+		t.Errorf("unexpected error with unknown field: %v", err)
+	}
+
+	// truncated stream
+	stream, _ := dm.ToProtobufDelimited()
+	reader := NewProtobufReader(bytes.NewReader(stream[:len(stream)-1]))
+	if err := reader.Read(&dm); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected eof expected, got %v", err)
+	}
+}
+
+func BenchmarkDnsMessage_ToProtobuf(b *testing.B) {
+	dm := getFakeEnrichedDnsMessage()
+	for i := 0; i < b.N; i++ {
+		dm.ToProtobuf()
+	}
+}
+
+func BenchmarkDnsMessage_ToJson(b *testing.B) {
+	dm := getFakeEnrichedDnsMessage()
+	for i := 0; i < b.N; i++ {
+		json.Marshal(dm)
+	}
+}
@@ -113,8 +113,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -100,8 +100,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -131,6 +130,7 @@ PROCESS_LOOP:
 			}
 
 			// encode
+			dm.DecodeSections()
 			json.NewEncoder(buffer).Encode(dm)
 
 			req, _ := http.NewRequest("POST", f.url, buffer)
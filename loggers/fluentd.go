@@ -148,6 +148,7 @@ func (o *FluentdClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 	for _, dm := range *buf {
 		// prepare event
 		tm, _ := msgpack.Marshal(dm.DnsTap.TimeSec)
+		dm.DecodeSections()
 		record, err := msgpack.Marshal(dm)
 		if err != nil {
 			o.LogError("msgpack error:", err.Error())
@@ -208,8 +209,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -183,6 +183,7 @@ func (o *KafkaProducer) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 		case dnsutils.MODE_TEXT:
 			strDm = dm.String(o.textFormat, o.config.Global.TextFormatDelimiter, o.config.Global.TextFormatBoundary)
 		case dnsutils.MODE_JSON:
+			dm.DecodeSections()
 			json.NewEncoder(buffer).Encode(dm)
 			strDm = buffer.String()
 			buffer.Reset()
@@ -194,6 +195,13 @@ func (o *KafkaProducer) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 			json.NewEncoder(buffer).Encode(flat)
 			strDm = buffer.String()
 			buffer.Reset()
+		case dnsutils.MODE_PROTOBUF:
+			data, err := dm.ToProtobuf()
+			if err != nil {
+				o.LogError("failed to encode to protobuf: %s", err)
+				continue
+			}
+			strDm = string(data)
 		}
 
 		msg := kafka.Message{
@@ -244,8 +252,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -37,7 +37,8 @@ func IsValidMode(mode string) bool {
 		dnsutils.MODE_JSON,
 		dnsutils.MODE_FLATJSON,
 		dnsutils.MODE_PCAP,
-		dnsutils.MODE_DNSTAP:
+		dnsutils.MODE_DNSTAP,
+		dnsutils.MODE_PROTOBUF:
 		return true
 	}
 	return false
@@ -202,7 +203,7 @@ func (l *LogFile) OpenFile() error {
 	l.fileSize = fileinfo.Size()
 
 	switch l.config.Loggers.LogFile.Mode {
-	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_PROTOBUF:
 		bufferSize := 4096
 		l.writerPlain = bufio.NewWriterSize(fd, bufferSize)
 
@@ -333,7 +334,7 @@ func (l *LogFile) CompressPostRotateCommand(filename string) {
 
 func (l *LogFile) FlushWriters() {
 	switch l.config.Loggers.LogFile.Mode {
-	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	case dnsutils.MODE_TEXT, dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON, dnsutils.MODE_PROTOBUF:
 		l.writerPlain.Flush()
 	case dnsutils.MODE_DNSTAP:
 		l.writerDnstap.Flush()
@@ -474,8 +475,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -549,6 +549,7 @@ PROCESS_LOOP:
 
 			// with json mode
 			case dnsutils.MODE_JSON:
+				dm.DecodeSections()
 				json.NewEncoder(buffer).Encode(dm)
 				l.WriteToPlain(buffer.Bytes())
 				buffer.Reset()
@@ -562,6 +563,15 @@ PROCESS_LOOP:
 				}
 				l.WriteToDnstap(data)
 
+			// with protobuf mode, length-delimited messages
+			case dnsutils.MODE_PROTOBUF:
+				data, err = dm.ToProtobufDelimited()
+				if err != nil {
+					l.LogError("failed to encode to protobuf: %s", err)
+					continue
+				}
+				l.WriteToPlain(data)
+
 			// with pcap mode
 			case dnsutils.MODE_PCAP:
 				pkt, err := dm.ToPacketLayer()
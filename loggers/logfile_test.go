@@ -131,3 +131,42 @@ func Test_LogFileWrite_PcapMode(t *testing.T) {
 		t.Errorf("no data in pcap file")
 	}
 }
+
+func Test_LogFileWrite_ProtobufMode(t *testing.T) {
+	// create a temp file
+	f, err := os.CreateTemp("", "temp_protobuffile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name()) // clean up
+
+	// config
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = f.Name()
+	config.Loggers.LogFile.Mode = dnsutils.MODE_PROTOBUF
+	config.Loggers.LogFile.FlushInterval = 0
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// start the logger
+	go g.Run()
+
+	// send fake dns message to logger
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.Suspicious = &dnsutils.TransformSuspicious{Score: 2.0}
+	g.Channel() <- dm
+
+	time.Sleep(time.Second)
+	g.Stop()
+
+	// read temp file and decode the message
+	reader := dnsutils.NewProtobufReader(f)
+	dmDecoded := dnsutils.DnsMessage{}
+	if err := reader.Read(&dmDecoded); err != nil {
+		t.Fatalf("unable to decode protobuf message: %v", err)
+	}
+	if dmDecoded.DNS.Qname != dm.DNS.Qname || dmDecoded.Suspicious == nil || dmDecoded.Suspicious.Score != 2.0 {
+		t.Errorf("invalid decoded message: %+v", dmDecoded)
+	}
+}
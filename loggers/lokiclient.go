@@ -117,6 +117,13 @@ func (o *LokiClient) ReadConfig() {
 		o.logger.Fatal("logger=loki - invalid tls min version")
 	}
 
+	// the labels of the messages are sanitized by the subnet labels transformer
+	for _, label := range o.config.Loggers.LokiClient.StreamLabels {
+		if !dnsutils.IsValidLabelName(label) {
+			o.logger.Fatal("logger=loki - invalid stream label ", label)
+		}
+	}
+
 	if len(o.config.Loggers.LokiClient.TextFormat) > 0 {
 		o.textFormat = strings.Fields(o.config.Loggers.LokiClient.TextFormat)
 	} else {
@@ -210,8 +217,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -250,6 +256,16 @@ PROCESS_LOOP:
 				labels.Label{Name: "identity", Value: dm.DnsTap.Identity},
 				labels.Label{Name: "job", Value: o.config.Loggers.LokiClient.JobName},
 			}
+			if len(o.config.Loggers.LokiClient.StreamLabels) > 0 {
+				// labels of the message (subnet-labels transformer) added to the stream
+				lb := labels.NewBuilder(lbls)
+				for _, key := range o.config.Loggers.LokiClient.StreamLabels {
+					if value, ok := dm.Labels[key]; ok {
+						lb.Set(key, value)
+					}
+				}
+				lbls = lb.Labels(lbls)
+			}
 			var err error
 			var flat map[string]interface{}
 			if len(o.config.Loggers.LokiClient.RelabelConfigs) > 0 {
@@ -294,6 +310,7 @@ PROCESS_LOOP:
 					o.config.Global.TextFormatDelimiter,
 					o.config.Global.TextFormatBoundary))
 			case dnsutils.MODE_JSON:
+				dm.DecodeSections()
 				json.NewEncoder(buffer).Encode(dm)
 				entry.Line = buffer.String()
 				buffer.Reset()
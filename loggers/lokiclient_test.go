@@ -97,6 +97,7 @@ func Test_LokiClientRun(t *testing.T) {
 func Test_LokiClientRelabel(t *testing.T) {
 	testcases := []struct {
 		relabel_config []*relabel.Config
+		stream_labels  []string
 		labels_pattern string
 	}{
 		{
@@ -134,6 +135,10 @@ func Test_LokiClientRelabel(t *testing.T) {
 			},
 			labels_pattern: "{identity=\"test_id\"}",
 		},
+		{
+			stream_labels:  []string{"site", "unknown"},
+			labels_pattern: "{identity=\"test_id\", job=\"dnscollector\", site=\"paris\"}",
+		},
 	}
 
 	// fake msgpack receiver
@@ -151,6 +156,7 @@ func Test_LokiClientRelabel(t *testing.T) {
 				cfg.Loggers.LokiClient.Mode = m
 				cfg.Loggers.LokiClient.BatchSize = 0
 				cfg.Loggers.LokiClient.RelabelConfigs = tc.relabel_config
+				cfg.Loggers.LokiClient.StreamLabels = tc.stream_labels
 				g := NewLokiClient(cfg, logger.New(false), "test")
 
 				// start the logger
@@ -159,6 +165,7 @@ func Test_LokiClientRelabel(t *testing.T) {
 				// send fake dns message to logger
 				dm := dnsutils.GetFakeDnsMessage()
 				dm.DnsTap.Identity = dnsutils.DNSTAP_IDENTITY_TEST
+				dm.Labels = map[string]string{"site": "paris"}
 				g.Channel() <- dm
 
 				// accept conn
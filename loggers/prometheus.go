@@ -9,6 +9,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +29,7 @@ var metricNameRegex = regexp.MustCompile(`_*[^0-9A-Za-z_]+_*`)
 This is the list of available label values selectors.
 Configuration may specifiy a list of lables to use for metrics.
 Any label in this catalogueSelectors can be specidied in config (prometheus-labels stanza)
+The labels of the message (subnet-labels transformer) are selected with the label_<key> name
 */
 var catalogueSelectors map[string]func(*dnsutils.DnsMessage) string = map[string]func(*dnsutils.DnsMessage) string{
 	"stream_id": GetStreamID,
@@ -57,6 +59,7 @@ type EpsCounters struct {
 	TotalQtypes        map[string]float64
 	TotalIPVersion     map[string]float64
 	TotalIPProtocol    map[string]float64
+	TotalMlClasses     map[string]float64
 	TotalDnsMessages   float64
 	TotalQueries       int
 	TotalReplies       int
@@ -157,6 +160,24 @@ func GetResolverIP(dm *dnsutils.DnsMessage) string {
 	return dm.NetworkInfo.ResponseIp
 }
 
+// GetLabel returns the selector of a label of the message, "-" if the label is missing
+func GetLabel(key string) func(*dnsutils.DnsMessage) string {
+	return func(dm *dnsutils.DnsMessage) string {
+		if value, ok := dm.Labels[key]; ok {
+			return value
+		}
+		return "-"
+	}
+}
+
+func getCatalogueSelector(name string) (func(*dnsutils.DnsMessage) string, bool) {
+	if key := strings.TrimPrefix(name, "label_"); key != name && dnsutils.IsValidLabelName(key) {
+		return GetLabel(key), true
+	}
+	sel, ok := catalogueSelectors[name]
+	return sel, ok
+}
+
 type Prometheus struct {
 	doneApi      chan bool
 	stopProcess  chan bool
@@ -199,6 +220,7 @@ type Prometheus struct {
 	gaugeEpsMax *prometheus.Desc
 
 	counterQtypes      *prometheus.Desc
+	counterMlClasses   *prometheus.Desc
 	counterRcodes      *prometheus.Desc
 	counterIPProtocol  *prometheus.Desc
 	counterIPVersion   *prometheus.Desc
@@ -245,6 +267,7 @@ func newPrometheusCounterSet(p *Prometheus, labels prometheus.Labels) *Prometheu
 			TotalQtypes:     make(map[string]float64),
 			TotalIPVersion:  make(map[string]float64),
 			TotalIPProtocol: make(map[string]float64),
+			TotalMlClasses:  make(map[string]float64),
 		},
 
 		topRequesters: topmap.NewTopMap(p.config.Loggers.Prometheus.TopN),
@@ -291,6 +314,7 @@ func (c *PrometheusCountersSet) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.prom.gaugeEpsMax
 
 	ch <- c.prom.counterQtypes
+	ch <- c.prom.counterMlClasses
 	ch <- c.prom.counterRcodes
 	ch <- c.prom.counterIPProtocol
 	ch <- c.prom.counterIPVersion
@@ -421,6 +445,10 @@ func (c *PrometheusCountersSet) Record(dm dnsutils.DnsMessage) {
 		c.epsCounters.TotalQtypes[dm.DNS.Qtype]++
 	}
 
+	if dm.MachineLearning != nil && dm.MachineLearning.Class != "-" && len(dm.MachineLearning.Class) > 0 {
+		c.epsCounters.TotalMlClasses[dm.MachineLearning.Class]++
+	}
+
 	if _, exists := c.epsCounters.TotalRcodes[dm.DNS.Rcode]; !exists {
 		c.epsCounters.TotalRcodes[dm.DNS.Rcode] = 1
 	} else {
@@ -544,6 +572,13 @@ func (o *PrometheusCountersSet) Collect(ch chan<- prometheus.Metric) {
 		)
 	}
 
+	// Update machine learning classes counter
+	for k, v := range o.epsCounters.TotalMlClasses {
+		ch <- prometheus.MustNewConstMetric(o.prom.counterMlClasses, prometheus.CounterValue,
+			v, k,
+		)
+	}
+
 	// Update Return Codes counter
 	for k, v := range o.epsCounters.TotalRcodes {
 		ch <- prometheus.MustNewConstMetric(o.prom.counterRcodes, prometheus.CounterValue,
@@ -620,7 +655,7 @@ func NewPromCounterCatalogueContainer(p *Prometheus, sel_labels []string, l map[
 	if len(sel_labels) == 0 {
 		panic("Cannot create a new PromCounterCatalogueContainer with empty list of sel_labels")
 	}
-	sel, ok := catalogueSelectors[sel_labels[0]]
+	sel, ok := getCatalogueSelector(sel_labels[0])
 	if !ok {
 		panic(fmt.Sprintf("No selector for %v label", sel_labels[0]))
 	}
@@ -741,6 +776,8 @@ func NewPrometheus(config *dnsutils.Config, logger *logger.Logger, version strin
 		name: name,
 	}
 
+	o.ReadConfig()
+
 	// This will create a catalogue of counters indexed by fileds requested by config
 	o.catalogueLabels, o.counters = CreateSystemCatalogue(o)
 
@@ -903,6 +940,12 @@ func (o *Prometheus) InitProm() {
 		nil, nil,
 	)
 
+	o.counterMlClasses = prometheus.NewDesc(
+		fmt.Sprintf("%s_ml_classes_total", prom_prefix),
+		"Counter of DNS messages per class predicted by the machine learning model",
+		[]string{"class"}, nil,
+	)
+
 	o.counterQtypes = prometheus.NewDesc(
 		fmt.Sprintf("%s_qtypes_total", prom_prefix),
 		"Counter of queries per qtypes",
@@ -1044,12 +1087,73 @@ func (o *Prometheus) InitProm() {
 		o.catalogueLabels,
 	)
 	o.promRegistry.MustRegister(o.histogramLatencies)
+
+	// counters of the queries trackers of the latency transformer
+	o.promRegistry.MustRegister(newQueriesTrackersCollector(prom_prefix))
+}
+
+// queriesTrackersCollector exports the counters of the queries trackers, they are shared
+// by all the collectors and not partitioned by stream
+type queriesTrackersCollector struct {
+	tracked *prometheus.Desc
+	evicted *prometheus.Desc
+	expired *prometheus.Desc
+	dropped *prometheus.Desc
+}
+
+func newQueriesTrackersCollector(prefix string) *queriesTrackersCollector {
+	labels := []string{"collector", "tracker"}
+	return &queriesTrackersCollector{
+		tracked: prometheus.NewDesc(
+			fmt.Sprintf("%s_tracked_queries", prefix),
+			"Number of queries waiting for their reply",
+			labels, nil,
+		),
+		evicted: prometheus.NewDesc(
+			fmt.Sprintf("%s_tracked_queries_evicted_total", prefix),
+			"The total number of queries evicted when the max-tracked-queries limit is reached",
+			labels, nil,
+		),
+		expired: prometheus.NewDesc(
+			fmt.Sprintf("%s_tracked_queries_expired_total", prefix),
+			"The total number of queries without reply before the timeout",
+			labels, nil,
+		),
+		dropped: prometheus.NewDesc(
+			fmt.Sprintf("%s_tracked_queries_dropped_total", prefix),
+			"The total number of timeout events dropped, the loggers channels were full",
+			labels, nil,
+		),
+	}
+}
+
+func (c *queriesTrackersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tracked
+	ch <- c.evicted
+	ch <- c.expired
+	ch <- c.dropped
+}
+
+func (c *queriesTrackersCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range transformers.QueriesTrackersStats() {
+		ch <- prometheus.MustNewConstMetric(c.tracked, prometheus.GaugeValue, float64(stats.Tracked), stats.Collector, stats.Kind)
+		ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(stats.Evicted), stats.Collector, stats.Kind)
+		ch <- prometheus.MustNewConstMetric(c.expired, prometheus.CounterValue, float64(stats.Expired), stats.Collector, stats.Kind)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(stats.Dropped), stats.Collector, stats.Kind)
+	}
 }
 
 func (o *Prometheus) ReadConfig() {
 	if !dnsutils.IsValidTLS(o.config.Loggers.Prometheus.TlsMinVersion) {
 		o.logger.Fatal("logger prometheus - invalid tls min version")
 	}
+
+	// the label_<key> labels must be valid prometheus label names
+	for _, label := range o.config.Loggers.Prometheus.LabelsList {
+		if _, ok := getCatalogueSelector(label); !ok {
+			o.logger.Fatal("logger prometheus - invalid label ", label)
+		}
+	}
 }
 
 func (o *Prometheus) LogInfo(msg string, v ...interface{}) {
@@ -1079,6 +1183,9 @@ func (o *Prometheus) Stop() {
 }
 
 func (o *Prometheus) Record(dm dnsutils.DnsMessage) {
+	// decode the records to count the packets with rdata or edns errors as malformed
+	dm.DecodeSections()
+
 	// record stream identity
 	o.Lock()
 
@@ -1193,8 +1300,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -5,10 +5,13 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
 
+	"github.com/miekg/dns"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
@@ -87,6 +90,7 @@ func getMetricsTestCase(config *dnsutils.Config, labels map[string]string) func(
 		noerror_record.NetworkInfo.Protocol = UDP
 		noerror_record.NetworkInfo.Family = IPv4
 		noerror_record.DNS.Length = 123
+		noerror_record.MachineLearning = &dnsutils.TransformML{Probability: 0.9, Class: "malicious"}
 
 		g.Record(noerror_record)
 
@@ -147,6 +151,9 @@ func getMetricsTestCase(config *dnsutils.Config, labels map[string]string) func(
 		labels["query_type"] = "A"
 		ensureMetricValue(t, mf, "dnscollector_qtypes_total", labels, 3)
 		delete(labels, "query_type")
+		labels["class"] = "malicious"
+		ensureMetricValue(t, mf, "dnscollector_ml_classes_total", labels, 1)
+		delete(labels, "class")
 		labels["net_transport"] = "UDP"
 		ensureMetricValue(t, mf, "dnscollector_ipprotocol_total", labels, 3)
 		delete(labels, "net_transport")
@@ -204,6 +211,36 @@ func TestPrometheus_EPS_Counters(t *testing.T) {
 
 }
 
+func TestPrometheus_MalformedLazy(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewPrometheus(config, logger.New(false), "dev", "test")
+
+	// reply with a truncated A rdata, only detected when the records are decoded
+	q := new(dns.Msg)
+	q.SetQuestion("dns.collector.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr, _ := dns.NewRR("dns.collector. 300 IN A 192.0.2.1")
+	m.Answer = append(m.Answer, rr)
+	payload, _ := m.Pack()
+	payload[len(payload)-5] = 3
+	payload = payload[:len(payload)-1]
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Type = dnsutils.DnsReply
+	dm.DNS.Payload = payload
+	header, _ := dnsutils.DecodeDns(payload)
+	if err := dnsutils.DecodePayloadLazy(&dm, &header, config); err != nil || dm.DNS.MalformedPacket {
+		t.Fatalf("the reply should be malformed after decoding the records only")
+	}
+	g.Record(dm)
+
+	mf := getMetrics(g, t)
+	if !ensureMetricValue(t, mf, "dnscollector_malformed_total", map[string]string{"stream_id": "collector"}, 1) {
+		t.Errorf("malformed packet not counted")
+	}
+}
+
 func TestPrometheus_BuildInfo(t *testing.T) {
 	config := dnsutils.GetFakeConfig()
 	// config.Loggers.Prometheus.HistogramMetricsEnabled = true
@@ -234,6 +271,40 @@ func TestPrometheus_ConfirmDifferentResolvers(t *testing.T) {
 	ensureMetricValue(t, mf, "dnscollector_bytes_total", map[string]string{"resolver": "10.10.10.10"}, 999)
 }
 
+func TestPrometheus_SubnetLabels(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Loggers.Prometheus.LabelsList = []string{"stream_id", "label_site"}
+	g := NewPrometheus(config, logger.New(false), "dev", "test")
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Length = 123
+	dm.Labels = map[string]string{"site": "paris"}
+	g.Record(dm)
+	dm.DNS.Length = 999
+	dm.Labels = nil
+	g.Record(dm)
+	mf := getMetrics(g, t)
+
+	ensureMetricValue(t, mf, "dnscollector_bytes_total", map[string]string{"label_site": "paris"}, 123)
+	ensureMetricValue(t, mf, "dnscollector_bytes_total", map[string]string{"label_site": "-"}, 999)
+}
+
+func TestPrometheus_QueriesTrackers(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewPrometheus(config, logger.New(false), "dev", "test")
+
+	// a tracker of the latency transformer, with one query waiting for its reply
+	tracker := transformers.NewQueriesTracker("collector", "transactions", time.Minute, 100, nil, nil,
+		func(msg string, v ...interface{}) {})
+	defer tracker.Stop()
+	tracker.Set(1, dnsutils.GetFakeDnsMessage())
+
+	mf := getMetrics(g, t)
+	labels := map[string]string{"collector": "collector", "tracker": "transactions"}
+	ensureMetricValue(t, mf, "dnscollector_tracked_queries", labels, 1)
+	ensureMetricValue(t, mf, "dnscollector_tracked_queries_evicted_total", labels, 0)
+}
+
 func ensureMetricValue(t *testing.T, mf map[string]*dto.MetricFamily, name string, labels map[string]string, value float64) bool {
 	m, found := mf[name]
 	if !found {
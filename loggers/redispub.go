@@ -192,8 +192,23 @@ func (o *RedisPub) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 	for _, dm := range *buf {
 		escape_buffer.Reset()
 
-		cmd := "PUBLISH " + strconv.Quote(o.config.Loggers.RedisPub.RedisChannel) + " "
-		o.transportWriter.WriteString(cmd)
+		// binary safe command with the redis protocol, the payload delimiter is not used
+		if o.config.Loggers.RedisPub.Mode == dnsutils.MODE_PROTOBUF {
+			data, err := dm.ToProtobuf()
+			if err != nil {
+				o.LogError("failed to encode to protobuf: %s", err)
+				continue
+			}
+			channel := o.config.Loggers.RedisPub.RedisChannel
+			o.transportWriter.WriteString("*3\r\n$7\r\nPUBLISH\r\n")
+			o.transportWriter.WriteString("$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n")
+			o.transportWriter.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+			o.transportWriter.Write(data)
+			o.transportWriter.WriteString("\r\n")
+		} else {
+			cmd := "PUBLISH " + strconv.Quote(o.config.Loggers.RedisPub.RedisChannel) + " "
+			o.transportWriter.WriteString(cmd)
+		}
 
 		if o.config.Loggers.RedisPub.Mode == dnsutils.MODE_TEXT {
 			o.transportWriter.WriteString(strconv.Quote(dm.String(o.textFormat, o.config.Global.TextFormatDelimiter, o.config.Global.TextFormatBoundary)))
@@ -201,6 +216,7 @@ func (o *RedisPub) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 		}
 
 		if o.config.Loggers.RedisPub.Mode == dnsutils.MODE_JSON {
+			dm.DecodeSections()
 			encoder.Encode(dm)
 			o.transportWriter.WriteString(strconv.Quote(escape_buffer.String()))
 			o.transportWriter.WriteString(o.config.Loggers.RedisPub.PayloadDelimiter)
@@ -260,8 +276,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -2,8 +2,12 @@ package loggers
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"net"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -84,3 +88,106 @@ func Test_RedisPubRun(t *testing.T) {
 		})
 	}
 }
+
+// readRespBulk reads a RESP bulk string, the payload can contain any byte
+func readRespBulk(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "$") || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid bulk string header: %q", line)
+	}
+	size, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	if string(data[size:]) != "\r\n" {
+		return nil, fmt.Errorf("bulk string not terminated by crlf")
+	}
+	return data[:size], nil
+}
+
+func Test_RedisPubRun_Protobuf(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.RedisPub.FlushInterval = 1
+	cfg.Loggers.RedisPub.BufferSize = 0
+	cfg.Loggers.RedisPub.Mode = dnsutils.MODE_PROTOBUF
+	cfg.Loggers.RedisPub.RedisChannel = "testons"
+
+	g := NewRedisPub(cfg, logger.New(false), "test")
+
+	// fake redis server
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// wait connection on logger
+	time.Sleep(time.Second)
+
+	// send fake dns messages to logger, the first one contains crlf in the encoded data
+	qnames := []string{"dns\r\ncollector", "dns.collector"}
+	for _, qname := range qnames {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		g.Channel() <- dm
+	}
+
+	// read the PUBLISH commands as a redis server
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, qname := range qnames {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "*3\r\n" {
+			t.Fatalf("redis error want array of 3 elements, got: %q", line)
+		}
+
+		cmd, err := readRespBulk(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cmd) != "PUBLISH" {
+			t.Errorf("redis error want PUBLISH, got: %s", cmd)
+		}
+
+		channel, err := readRespBulk(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(channel) != "testons" {
+			t.Errorf("redis error want channel testons, got: %s", channel)
+		}
+
+		data, err := readRespBulk(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm := dnsutils.DnsMessage{}
+		if err := dm.FromProtobuf(data); err != nil {
+			t.Fatalf("unable to decode protobuf message: %v", err)
+		}
+		if dm.DNS.Qname != qname {
+			t.Errorf("qname error want %q, got: %q", qname, dm.DNS.Qname)
+		}
+	}
+}
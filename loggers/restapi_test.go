@@ -227,7 +227,7 @@ func TestRestAPI_GetMethod(t *testing.T) {
 			uri:        "/suspicious",
 			handler:    g.GetSuspiciousHandler,
 			method:     http.MethodGet,
-			want:       `\[\{"score":1,"malformed-pkt":false,"large-pkt":false,"long-domain":false,"slow-domain":false,"unallowed-chars":false,"uncommon-qtypes":false,"excessive-number-labels":false,"domain":"dns:collector"\}\]`,
+			want:       `\[\{"score":1,"malformed-pkt":false,"large-pkt":false,"long-domain":false,"slow-domain":false,"unallowed-chars":false,"uncommon-qtypes":false,"excessive-number-labels":false,"homograph-domain":false,"unique-subdomains":false,"txt-null-volume":false,"random-subdomains":false,"high-entropy":false,"unlikely-ngrams":false,"domain":"dns:collector"\}\]`,
 			statusCode: http.StatusOK,
 			dm:         dnsutils.GetFakeDnsMessage(),
 			dmRcode:    "NOERROR",
@@ -128,8 +128,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -200,6 +199,7 @@ PROCESS_LOOP:
 					o.config.Global.TextFormatBoundary))
 
 			case dnsutils.MODE_JSON:
+				dm.DecodeSections()
 				json.NewEncoder(buffer).Encode(dm)
 				o.stdout.Print(buffer.String())
 				buffer.Reset()
@@ -166,8 +166,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -248,6 +247,7 @@ PROCESS_LOOP:
 				// o.syslogConn.Write(delimiter.Bytes())
 
 			case dnsutils.MODE_JSON:
+				dm.DecodeSections()
 				json.NewEncoder(buffer).Encode(dm)
 				o.syslogConn.Write(buffer.Bytes())
 				buffer.Reset()
@@ -160,6 +160,7 @@ func (o *TcpClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 		}
 
 		if o.config.Loggers.TcpClient.Mode == dnsutils.MODE_JSON {
+			dm.DecodeSections()
 			json.NewEncoder(o.transportWriter).Encode(dm)
 			o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
 		}
@@ -174,6 +175,16 @@ func (o *TcpClient) FlushBuffer(buf *[]dnsutils.DnsMessage) {
 			o.transportWriter.WriteString(o.config.Loggers.TcpClient.PayloadDelimiter)
 		}
 
+		// length-delimited protobuf, the payload delimiter is not used
+		if o.config.Loggers.TcpClient.Mode == dnsutils.MODE_PROTOBUF {
+			data, err := dm.ToProtobufDelimited()
+			if err != nil {
+				o.LogError("failed to encode to protobuf: %s", err)
+				continue
+			}
+			o.transportWriter.Write(data)
+		}
+
 		// flush the transport buffer
 		err := o.transportWriter.Flush()
 		if err != nil {
@@ -217,8 +228,7 @@ RUN_LOOP:
 			}
 
 			// apply tranforms, init dns message with additionnals parts if necessary
-			subprocessors.InitDnsMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
+			if subprocessors.ApplyTransforms(&dm) == transformers.RETURN_DROP {
 				continue
 			}
 
@@ -78,3 +78,54 @@ func Test_TcpClientRun(t *testing.T) {
 		})
 	}
 }
+
+func Test_TcpClientRun_Protobuf(t *testing.T) {
+	// init logger
+	cfg := dnsutils.GetFakeConfig()
+	cfg.Loggers.TcpClient.FlushInterval = 1
+	cfg.Loggers.TcpClient.BufferSize = 0
+	cfg.Loggers.TcpClient.Mode = dnsutils.MODE_PROTOBUF
+
+	g := NewTcpClient(cfg, logger.New(false), "test")
+
+	// fake protobuf receiver
+	fakeRcvr, err := net.Listen(dnsutils.SOCKET_TCP, ":9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// wait connection on logger
+	time.Sleep(time.Second)
+
+	// send two fake dns messages to logger, each one must be prefixed by its length
+	qnames := []string{"dns.collector", "dnscollector.dev"}
+	for _, qname := range qnames {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		g.Channel() <- dm
+	}
+
+	// read messages on server side and decode them
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := dnsutils.NewProtobufReader(conn)
+	for _, qname := range qnames {
+		dm := dnsutils.DnsMessage{}
+		if err := reader.Read(&dm); err != nil {
+			t.Fatalf("unable to read protobuf message: %v", err)
+		}
+		if dm.DNS.Qname != qname {
+			t.Errorf("qname error want %s, got: %s", qname, dm.DNS.Qname)
+		}
+	}
+}
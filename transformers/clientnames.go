@@ -0,0 +1,608 @@
+package transformers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"inet.af/netaddr"
+)
+
+var (
+	CLIENT_SOURCE_DHCP  = "dhcp"
+	CLIENT_SOURCE_HOSTS = "hosts"
+	CLIENT_SOURCE_PTR   = "ptr"
+)
+
+var (
+	CLIENT_FILE_DHCPD   = "dhcpd"
+	CLIENT_FILE_KEA     = "kea"
+	CLIENT_FILE_DNSMASQ = "dnsmasq"
+	CLIENT_FILE_HOSTS   = "hosts"
+)
+
+// number of pending ptr lookups, the lookups are ignored when the queue is full
+var clientNamesPtrQueueSize = 1024
+
+// interval to report the failed ptr lookups, the errors are not logged one by one
+var clientNamesPtrReportInterval = time.Minute
+
+type clientNamesFile struct {
+	name   string
+	format string
+}
+
+// clientNamesEntry is a client of the table, the expiration is zero for the hosts
+// files and the infinite leases. The mac address of a lease kept for a name of
+// a hosts file expires with the lease.
+type clientNamesEntry struct {
+	client    dnsutils.TransformClient
+	expire    time.Time
+	macExpire time.Time
+}
+
+type ptrCacheEntry struct {
+	hostname string
+	expire   time.Time
+}
+
+type ClientNamesProcessor struct {
+	sync.RWMutex
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	sources     []clientNamesFile
+	files       map[string]map[netaddr.IP]clientNamesEntry
+	table       map[netaddr.IP]clientNamesEntry
+	fileWatcher *FileWatcher
+	ptrLock     sync.Mutex
+	ptrCache    map[netaddr.IP]ptrCacheEntry
+	ptrPending  map[netaddr.IP]bool
+	ptrFailed   int
+	ptrQueries  chan netaddr.IP
+	ptrClient   *dns.Client
+	stopPtr     chan bool
+	name        string
+	instance    int
+	outChannels []chan dnsutils.DnsMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewClientNamesSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DnsMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *ClientNamesProcessor {
+	d := ClientNamesProcessor{
+		config:      config,
+		logger:      logger,
+		files:       make(map[string]map[netaddr.IP]clientNamesEntry),
+		table:       make(map[netaddr.IP]clientNamesEntry),
+		ptrCache:    make(map[netaddr.IP]ptrCacheEntry),
+		ptrPending:  make(map[netaddr.IP]bool),
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	// leases first, the names of the hosts files have the priority
+	for _, f := range []struct {
+		format string
+		files  []string
+	}{
+		{CLIENT_FILE_DHCPD, config.ClientNames.DhcpdLeaseFiles},
+		{CLIENT_FILE_KEA, config.ClientNames.KeaLeaseFiles},
+		{CLIENT_FILE_DNSMASQ, config.ClientNames.DnsmasqLeaseFiles},
+		{CLIENT_FILE_HOSTS, config.ClientNames.HostsFiles},
+	} {
+		for _, fname := range f.files {
+			d.sources = append(d.sources, clientNamesFile{name: filepath.Clean(fname), format: f.format})
+		}
+	}
+
+	if config.ClientNames.Enable {
+		d.LoadFiles()
+		d.WatchFiles()
+
+		if config.ClientNames.PtrLookup {
+			d.ptrClient = &dns.Client{Timeout: time.Duration(config.ClientNames.PtrTimeout) * time.Second}
+			d.ptrQueries = make(chan netaddr.IP, clientNamesPtrQueueSize)
+			d.stopPtr = make(chan bool)
+			go d.RunPtr()
+		}
+	}
+
+	return &d
+}
+
+func (p *ClientNamesProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=client-names#%d - ", p.instance)
+	p.logInfo(log+msg, v...)
+}
+
+func (p *ClientNamesProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=client-names#%d - ", p.instance)
+	p.logError(log+msg, v...)
+}
+
+func (p *ClientNamesProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	if dm.Client == nil {
+		dm.Client = &dnsutils.TransformClient{
+			Hostname: "-",
+			Mac:      "-",
+			Source:   "-",
+		}
+	}
+}
+
+func (p *ClientNamesProcessor) LoadFiles() {
+	for _, source := range p.sources {
+		if err := p.LoadFile(source.name); err != nil {
+			p.LogError("unable to load %s: %v", source.name, err)
+		}
+	}
+}
+
+// LoadFile loads the leases or hosts file and rebuilds the table, the previous
+// names of the file are kept if the file is invalid
+func (p *ClientNamesProcessor) LoadFile(fname string) error {
+	fname = filepath.Clean(fname)
+	format := ""
+	for _, source := range p.sources {
+		if source.name == fname {
+			format = source.format
+		}
+	}
+	if len(format) == 0 {
+		return fmt.Errorf("file not configured")
+	}
+
+	names, err := loadClientNamesFile(fname, format, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.files[fname] = names
+
+	// the files are merged in order, the mac address of the lease is kept
+	// when the name is defined in a hosts file
+	table := make(map[netaddr.IP]clientNamesEntry)
+	for _, source := range p.sources {
+		for ip, entry := range p.files[source.name] {
+			if previous, ok := table[ip]; ok && len(entry.client.Mac) == 0 {
+				entry.client.Mac = previous.client.Mac
+				entry.macExpire = previous.expire
+			}
+			table[ip] = entry
+		}
+	}
+	p.table = table
+
+	p.LogInfo("%s loaded with %d clients", fname, len(names))
+	return nil
+}
+
+func loadClientNamesFile(fname string, format string, now time.Time) (map[netaddr.IP]clientNamesEntry, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch format {
+	case CLIENT_FILE_DHCPD:
+		return parseDhcpdLeases(file, now)
+	case CLIENT_FILE_KEA:
+		return parseKeaLeases(file, now)
+	case CLIENT_FILE_DNSMASQ:
+		return parseDnsmasqLeases(file, now)
+	default:
+		return parseHostsFile(file)
+	}
+}
+
+// parseDhcpdLeases reads the lease blocks of an ISC dhcpd leases file, the file is a journal
+// and the last block of an address wins. Leases not active or expired are ignored, the
+// expiration of the others is checked on lookup.
+func parseDhcpdLeases(r io.Reader, now time.Time) (map[netaddr.IP]clientNamesEntry, error) {
+	names := make(map[netaddr.IP]clientNamesEntry)
+
+	var ip netaddr.IP
+	var client dnsutils.TransformClient
+	var ends time.Time
+	inLease, active := false, false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch {
+		case fields[0] == "lease" && len(fields) == 3 && fields[2] == "{":
+			addr, err := netaddr.ParseIP(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid lease address %s", fields[1])
+			}
+			ip, client, ends = addr, dnsutils.TransformClient{Source: CLIENT_SOURCE_DHCP}, time.Time{}
+			inLease, active = true, false
+		case !inLease:
+			continue
+		case fields[0] == "}":
+			inLease = false
+			if active && (ends.IsZero() || ends.After(now)) {
+				names[ip] = clientNamesEntry{client: client, expire: ends}
+			} else {
+				delete(names, ip)
+			}
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case fields[0] == "ends":
+			ends = parseDhcpdTime(fields[1:])
+		case fields[0] == "hardware" && len(fields) == 3:
+			client.Mac = strings.ToLower(fields[2])
+		case fields[0] == "client-hostname":
+			client.Hostname = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "client-hostname")), "\"")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// parseDhcpdTime parses the date of a lease (weekday date time in UTC or epoch),
+// a zero time is returned when the lease never expires
+func parseDhcpdTime(fields []string) time.Time {
+	switch {
+	case len(fields) == 2 && fields[0] == "epoch":
+		if sec, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	case len(fields) == 3:
+		if t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseKeaLeases reads a Kea memfile leases file (csv with a header), for IPv4 or IPv6.
+// The file is a journal and the last line of an address wins. Leases expired,
+// declined or reclaimed are ignored.
+func parseKeaLeases(r io.Reader, now time.Time) (map[netaddr.IP]clientNamesEntry, error) {
+	names := make(map[netaddr.IP]clientNamesEntry)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[column] = i
+	}
+	for _, column := range []string{"address", "hwaddr", "expire", "hostname", "state"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("invalid header: column %s is missing", column)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("invalid lease: %s", strings.Join(record, ","))
+		}
+
+		addr, err := netaddr.ParseIP(record[columns["address"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease address %s", record[columns["address"]])
+		}
+		expire, err := strconv.ParseInt(record[columns["expire"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease expiration %s", record[columns["expire"]])
+		}
+
+		// the state 0 is the default state, 1 declined and 2 expired-reclaimed
+		if record[columns["state"]] != "0" || time.Unix(expire, 0).Before(now) {
+			delete(names, addr)
+			continue
+		}
+		names[addr] = clientNamesEntry{
+			client: dnsutils.TransformClient{
+				Hostname: strings.TrimSuffix(record[columns["hostname"]], "."),
+				Mac:      strings.ToLower(record[columns["hwaddr"]]),
+				Source:   CLIENT_SOURCE_DHCP,
+			},
+			expire: time.Unix(expire, 0),
+		}
+	}
+	return names, nil
+}
+
+// parseDnsmasqLeases reads a dnsmasq leases file with one lease per line:
+// expiration, mac address (IAID for IPv6), address, hostname and client id
+func parseDnsmasqLeases(r io.Reader, now time.Time) (map[netaddr.IP]clientNamesEntry, error) {
+	names := make(map[netaddr.IP]clientNamesEntry)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("invalid lease: %s", scanner.Text())
+		}
+
+		expire, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease expiration %s", fields[0])
+		}
+		addr, err := netaddr.ParseIP(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease address %s", fields[2])
+		}
+
+		// 0 for an infinite lease
+		if expire != 0 && time.Unix(expire, 0).Before(now) {
+			continue
+		}
+		client := dnsutils.TransformClient{Source: CLIENT_SOURCE_DHCP}
+		if addr.Is4() {
+			client.Mac = strings.ToLower(fields[1])
+		}
+		if fields[3] != "*" {
+			client.Hostname = fields[3]
+		}
+		entry := clientNamesEntry{client: client}
+		if expire != 0 {
+			entry.expire = time.Unix(expire, 0)
+		}
+		names[addr] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// parseHostsFile reads a /etc/hosts like file, the first name of the first line
+// of an address is used
+func parseHostsFile(r io.Reader) (map[netaddr.IP]clientNamesEntry, error) {
+	names := make(map[netaddr.IP]clientNamesEntry)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line: %s", scanner.Text())
+		}
+
+		addr, err := netaddr.ParseIP(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s", fields[0])
+		}
+		if _, ok := names[addr]; !ok {
+			names[addr] = clientNamesEntry{client: dnsutils.TransformClient{Hostname: fields[1], Source: CLIENT_SOURCE_HOSTS}}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Lookup returns the client of the address from the leases and hosts files,
+// then from the ptr cache. A ptr lookup is scheduled on cache miss.
+// The leases expired since the last load of the files are ignored.
+func (p *ClientNamesProcessor) Lookup(addr string) (dnsutils.TransformClient, bool) {
+	ip, err := netaddr.ParseIP(addr)
+	if err != nil {
+		return dnsutils.TransformClient{}, false
+	}
+	ip = ip.Unmap()
+
+	p.RLock()
+	entry, ok := p.table[ip]
+	p.RUnlock()
+
+	now := time.Now()
+	if ok && (entry.expire.IsZero() || entry.expire.After(now)) {
+		client := entry.client
+		if !entry.macExpire.IsZero() && !entry.macExpire.After(now) {
+			client.Mac = ""
+		}
+		if len(client.Hostname) == 0 {
+			client.Hostname = "-"
+		}
+		if len(client.Mac) == 0 {
+			client.Mac = "-"
+		}
+		return client, true
+	}
+
+	if p.config.ClientNames.PtrLookup {
+		if hostname, ok := p.LookupPtr(ip); ok && len(hostname) > 0 {
+			return dnsutils.TransformClient{Hostname: hostname, Mac: "-", Source: CLIENT_SOURCE_PTR}, true
+		}
+	}
+	return dnsutils.TransformClient{}, false
+}
+
+// AddClient adds the hostname and the mac address of the query ip to the message
+func (p *ClientNamesProcessor) AddClient(dm *dnsutils.DnsMessage) {
+	if client, ok := p.Lookup(dm.NetworkInfo.QueryIp); ok {
+		dm.Client = &client
+	}
+}
+
+// LookupPtr returns the cached hostname of the address, the negative answers are cached too.
+// On cache miss, the lookup is done in background and the next messages are enriched.
+func (p *ClientNamesProcessor) LookupPtr(ip netaddr.IP) (string, bool) {
+	p.ptrLock.Lock()
+	defer p.ptrLock.Unlock()
+
+	if entry, ok := p.ptrCache[ip]; ok && entry.expire.After(time.Now()) {
+		return entry.hostname, true
+	}
+	if !p.ptrPending[ip] {
+		select {
+		case p.ptrQueries <- ip:
+			p.ptrPending[ip] = true
+		default:
+			// too many pending lookups, retried with the next message
+		}
+	}
+	return "", false
+}
+
+// RunPtr resolves the pending ptr lookups, the failed lookups are counted and
+// reported periodically with the last error
+func (p *ClientNamesProcessor) RunPtr() {
+	report := time.NewTicker(clientNamesPtrReportInterval)
+	defer report.Stop()
+
+	failed := 0
+	var lastErr error
+	for {
+		select {
+		case <-p.stopPtr:
+			return
+		case ip := <-p.ptrQueries:
+			hostname, err := p.resolvePtr(ip)
+			if err != nil {
+				failed++
+				lastErr = fmt.Errorf("%s: %v", ip, err)
+			}
+			p.storePtr(ip, hostname, err != nil)
+		case <-report.C:
+			if failed > 0 {
+				p.LogError("%d ptr lookup(s) failed, last error for %v", failed, lastErr)
+				failed = 0
+			}
+		}
+	}
+}
+
+// PtrFailed returns the total number of failed ptr lookups
+func (p *ClientNamesProcessor) PtrFailed() int {
+	p.ptrLock.Lock()
+	defer p.ptrLock.Unlock()
+	return p.ptrFailed
+}
+
+func (p *ClientNamesProcessor) resolvePtr(ip netaddr.IP) (string, error) {
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(arpa, dns.TypePTR)
+	r, _, err := p.ptrClient.Exchange(m, p.config.ClientNames.PtrResolver)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range r.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			return strings.TrimSuffix(ptr.Ptr, "."), nil
+		}
+	}
+	return "", nil
+}
+
+func (p *ClientNamesProcessor) storePtr(ip netaddr.IP, hostname string, failed bool) {
+	p.ptrLock.Lock()
+	defer p.ptrLock.Unlock()
+
+	delete(p.ptrPending, ip)
+	if failed {
+		p.ptrFailed++
+	}
+
+	// bounded cache, remove the expired entries then any entry if still full
+	if size := p.config.ClientNames.PtrCacheSize; size > 0 && len(p.ptrCache) >= size {
+		now := time.Now()
+		for k, entry := range p.ptrCache {
+			if !entry.expire.After(now) {
+				delete(p.ptrCache, k)
+			}
+		}
+		for k := range p.ptrCache {
+			if len(p.ptrCache) < size {
+				break
+			}
+			delete(p.ptrCache, k)
+		}
+	}
+
+	ttl := time.Duration(p.config.ClientNames.PtrCacheTtl) * time.Second
+	p.ptrCache[ip] = ptrCacheEntry{hostname: hostname, expire: time.Now().Add(ttl)}
+}
+
+// WatchFiles reloads the leases and hosts files when they are modified or replaced
+func (p *ClientNamesProcessor) WatchFiles() {
+	if len(p.sources) == 0 {
+		return
+	}
+
+	files := make([]string, 0, len(p.sources))
+	for _, source := range p.sources {
+		files = append(files, source.name)
+	}
+	watcher, err := NewFileWatcher(files, p.reloadFile, p.LogError)
+	if err != nil {
+		p.LogError("unable to watch files: %v", err)
+		return
+	}
+	p.fileWatcher = watcher
+}
+
+func (p *ClientNamesProcessor) reloadFile(fname string) {
+	if err := p.LoadFile(fname); err != nil {
+		p.LogError("unable to reload %s, previous names are kept: %v", fname, err)
+	}
+}
+
+func (p *ClientNamesProcessor) Close() {
+	if p.fileWatcher != nil {
+		p.fileWatcher.Close()
+	}
+	if p.stopPtr != nil {
+		close(p.stopPtr)
+	}
+}
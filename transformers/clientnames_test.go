@@ -0,0 +1,374 @@
+package transformers
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"inet.af/netaddr"
+)
+
+func TestClientNames_DhcpdLeases(t *testing.T) {
+	leases := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.10 {
+  starts 4 2023/11/16 10:00:00;
+  ends 4 2099/11/16 22:00:00;
+  binding state active;
+  next binding state free;
+  hardware ethernet 00:11:22:AA:BB:CC;
+  client-hostname "laptop";
+}
+lease 192.168.1.11 {
+  ends 4 2020/11/16 22:00:00;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:56;
+  client-hostname "expired";
+}
+lease 192.168.1.12 {
+  ends never;
+  binding state active;
+  hardware ethernet 00:11:22:33:44:57;
+}
+lease 192.168.1.13 {
+  ends epoch 4102444800; # Fri Jan 01 00:00:00 2100
+  binding state active;
+  client-hostname "printer";
+}
+lease 192.168.1.13 {
+  binding state free;
+}
+`
+	names, err := parseDhcpdLeases(strings.NewReader(leases), time.Now())
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	expected := map[netaddr.IP]clientNamesEntry{
+		netaddr.MustParseIP("192.168.1.10"): {
+			client: dnsutils.TransformClient{Hostname: "laptop", Mac: "00:11:22:aa:bb:cc", Source: CLIENT_SOURCE_DHCP},
+			expire: time.Date(2099, 11, 16, 22, 0, 0, 0, time.UTC),
+		},
+		netaddr.MustParseIP("192.168.1.12"): {client: dnsutils.TransformClient{Mac: "00:11:22:33:44:57", Source: CLIENT_SOURCE_DHCP}},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("want %v, got %v", expected, names)
+	}
+}
+
+func TestClientNames_KeaLeases(t *testing.T) {
+	leases := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.10,00:11:22:33:44:55,,3600,4102444800,1,0,0,laptop.home.lab.,0,
+192.168.1.11,00:11:22:33:44:56,,3600,1600000000,1,0,0,expired,0,
+192.168.1.12,00:11:22:33:44:57,,3600,4102444800,1,0,0,declined,1,
+192.168.1.13,00:11:22:33:44:58,,3600,4102444800,1,0,0,printer,0,
+192.168.1.13,00:11:22:33:44:58,,0,1600000000,1,0,0,printer,2,
+`
+	names, err := parseKeaLeases(strings.NewReader(leases), time.Now())
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	expected := map[netaddr.IP]clientNamesEntry{
+		netaddr.MustParseIP("192.168.1.10"): {
+			client: dnsutils.TransformClient{Hostname: "laptop.home.lab", Mac: "00:11:22:33:44:55", Source: CLIENT_SOURCE_DHCP},
+			expire: time.Unix(4102444800, 0),
+		},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("want %v, got %v", expected, names)
+	}
+
+	// missing column
+	if _, err := parseKeaLeases(strings.NewReader("address,hwaddr\n"), time.Now()); err == nil {
+		t.Errorf("invalid header not detected")
+	}
+}
+
+func TestClientNames_DnsmasqLeases(t *testing.T) {
+	leases := `4102444800 00:11:22:33:44:55 192.168.1.10 laptop 01:00:11:22:33:44:55
+1600000000 00:11:22:33:44:56 192.168.1.11 expired *
+0 00:11:22:33:44:57 192.168.1.12 * *
+duid 00:01:00:01:2c:00:00:00:00:11:22:33:44:55
+4102444800 1234 2001:db8::10 laptop6 00:01:00:01:2c:00:00:00:00:11:22:33:44:55
+`
+	names, err := parseDnsmasqLeases(strings.NewReader(leases), time.Now())
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	expected := map[netaddr.IP]clientNamesEntry{
+		netaddr.MustParseIP("192.168.1.10"): {
+			client: dnsutils.TransformClient{Hostname: "laptop", Mac: "00:11:22:33:44:55", Source: CLIENT_SOURCE_DHCP},
+			expire: time.Unix(4102444800, 0),
+		},
+		netaddr.MustParseIP("192.168.1.12"): {client: dnsutils.TransformClient{Mac: "00:11:22:33:44:57", Source: CLIENT_SOURCE_DHCP}},
+		netaddr.MustParseIP("2001:db8::10"): {
+			client: dnsutils.TransformClient{Hostname: "laptop6", Source: CLIENT_SOURCE_DHCP},
+			expire: time.Unix(4102444800, 0),
+		},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("want %v, got %v", expected, names)
+	}
+}
+
+func TestClientNames_HostsFile(t *testing.T) {
+	hosts := `127.0.0.1 localhost
+# servers
+192.168.1.1   router.home.lab router   # gateway
+192.168.1.1   other.home.lab
+2001:db8::1   nas.home.lab
+`
+	names, err := parseHostsFile(strings.NewReader(hosts))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	expected := map[netaddr.IP]clientNamesEntry{
+		netaddr.MustParseIP("127.0.0.1"):   {client: dnsutils.TransformClient{Hostname: "localhost", Source: CLIENT_SOURCE_HOSTS}},
+		netaddr.MustParseIP("192.168.1.1"): {client: dnsutils.TransformClient{Hostname: "router.home.lab", Source: CLIENT_SOURCE_HOSTS}},
+		netaddr.MustParseIP("2001:db8::1"): {client: dnsutils.TransformClient{Hostname: "nas.home.lab", Source: CLIENT_SOURCE_HOSTS}},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("want %v, got %v", expected, names)
+	}
+}
+
+func TestClientNames_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	leasesFile := filepath.Join(dir, "dnsmasq.leases")
+	hostsFile := filepath.Join(dir, "hosts")
+	writeTestFile(t, leasesFile, "0 00:11:22:33:44:55 192.168.1.10 laptop *\n0 00:11:22:33:44:56 192.168.1.11 phone *\n")
+	writeTestFile(t, hostsFile, "192.168.1.10 workstation\n192.168.1.20 printer\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.DnsmasqLeaseFiles = []string{leasesFile}
+	config.ClientNames.HostsFiles = []string{hostsFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	testcases := []struct {
+		ip     string
+		client dnsutils.TransformClient
+		found  bool
+	}{
+		// the hosts file has the priority, the mac address of the lease is kept
+		{ip: "192.168.1.10", client: dnsutils.TransformClient{Hostname: "workstation", Mac: "00:11:22:33:44:55", Source: CLIENT_SOURCE_HOSTS}, found: true},
+		{ip: "192.168.1.11", client: dnsutils.TransformClient{Hostname: "phone", Mac: "00:11:22:33:44:56", Source: CLIENT_SOURCE_DHCP}, found: true},
+		{ip: "192.168.1.20", client: dnsutils.TransformClient{Hostname: "printer", Mac: "-", Source: CLIENT_SOURCE_HOSTS}, found: true},
+		{ip: "192.168.1.30", found: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ip, func(t *testing.T) {
+			client, found := clients.Lookup(tc.ip)
+			if found != tc.found || client != tc.client {
+				t.Errorf("want %v (%v), got %v (%v)", tc.client, tc.found, client, found)
+			}
+		})
+	}
+}
+
+func TestClientNames_LookupExpired(t *testing.T) {
+	dir := t.TempDir()
+	leasesFile := filepath.Join(dir, "dnsmasq.leases")
+	hostsFile := filepath.Join(dir, "hosts")
+
+	// leases expiring after the load of the file
+	expire := time.Now().Add(time.Second).Unix()
+	writeTestFile(t, leasesFile, fmt.Sprintf("%d 00:11:22:33:44:55 192.168.1.10 laptop *\n%d 00:11:22:33:44:56 192.168.1.11 phone *\n", expire, expire))
+	writeTestFile(t, hostsFile, "192.168.1.11 workstation\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.DnsmasqLeaseFiles = []string{leasesFile}
+	config.ClientNames.HostsFiles = []string{hostsFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	if client, found := clients.Lookup("192.168.1.10"); !found || client.Hostname != "laptop" {
+		t.Fatalf("lease not found, got %v", client)
+	}
+
+	time.Sleep(time.Until(time.Unix(expire, 0)) + 10*time.Millisecond)
+
+	// the expired lease is ignored, the name of the hosts file is kept without the mac address
+	if client, found := clients.Lookup("192.168.1.10"); found {
+		t.Errorf("expired lease found, got %v", client)
+	}
+	expected := dnsutils.TransformClient{Hostname: "workstation", Mac: "-", Source: CLIENT_SOURCE_HOSTS}
+	if client, _ := clients.Lookup("192.168.1.11"); client != expected {
+		t.Errorf("want %v, got %v", expected, client)
+	}
+}
+
+func TestClientNames_InvalidFile(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	writeTestFile(t, hostsFile, "192.168.1.20 printer\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.HostsFiles = []string{hostsFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	// the previous names are kept
+	writeTestFile(t, hostsFile, "192.168.1.300 printer\n")
+	if err := clients.LoadFile(hostsFile); err == nil {
+		t.Errorf("invalid address not detected")
+	}
+	if client, _ := clients.Lookup("192.168.1.20"); client.Hostname != "printer" {
+		t.Errorf("previous names not kept: %v", client)
+	}
+}
+
+func TestClientNames_WatchFiles(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 10 * time.Millisecond
+
+	leasesFile := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	writeTestFile(t, leasesFile, "0 00:11:22:33:44:55 192.168.1.10 laptop *\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.DnsmasqLeaseFiles = []string{leasesFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	// new lease
+	writeTestFile(t, leasesFile, "0 00:11:22:33:44:55 192.168.1.10 laptop *\n0 00:11:22:33:44:56 192.168.1.11 phone *\n")
+
+	for i := 0; i < 100; i++ {
+		if client, _ := clients.Lookup("192.168.1.11"); client.Hostname == "phone" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("leases not reloaded")
+}
+
+func TestClientNames_PtrLookup(t *testing.T) {
+	// fake local resolver
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := make(chan string, 10)
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries <- r.Question[0].Name
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "10.1.168.192.in-addr.arpa." {
+			rr, _ := dns.NewRR("10.1.168.192.in-addr.arpa. 60 IN PTR laptop.home.lab.")
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.PtrLookup = true
+	config.ClientNames.PtrResolver = conn.LocalAddr().String()
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	// the first lookup is done in background
+	if _, found := clients.Lookup("192.168.1.10"); found {
+		t.Errorf("ptr lookup not done in background")
+	}
+
+	var client dnsutils.TransformClient
+	for i := 0; i < 100; i++ {
+		if client, _ = clients.Lookup("192.168.1.10"); client.Source == CLIENT_SOURCE_PTR {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if client != (dnsutils.TransformClient{Hostname: "laptop.home.lab", Mac: "-", Source: CLIENT_SOURCE_PTR}) {
+		t.Errorf("invalid ptr lookup, got %v", client)
+	}
+
+	// negative answers are cached too
+	for i := 0; i < 100; i++ {
+		clients.Lookup("192.168.1.11")
+		time.Sleep(time.Millisecond)
+	}
+	nb := 0
+	for len(queries) > 0 {
+		<-queries
+		nb++
+	}
+	if nb != 2 {
+		t.Errorf("ptr answers not cached, %d queries sent", nb)
+	}
+}
+
+func TestClientNames_PtrLookupFailed(t *testing.T) {
+	defer func(interval time.Duration) { clientNamesPtrReportInterval = interval }(clientNamesPtrReportInterval)
+	clientNamesPtrReportInterval = 10 * time.Millisecond
+
+	// fake local resolver without answer
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.PtrLookup = true
+	config.ClientNames.PtrResolver = conn.LocalAddr().String()
+	config.ClientNames.PtrTimeout = 1
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	clients := NewClientNamesSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer clients.Close()
+
+	for _, ip := range []string{"192.168.1.10", "192.168.1.11"} {
+		clients.Lookup(ip)
+	}
+	for i := 0; i < 200 && clients.PtrFailed() < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if nb := clients.PtrFailed(); nb != 2 {
+		t.Errorf("want 2 failed ptr lookups, got %d", nb)
+	}
+}
@@ -0,0 +1,60 @@
+package transformers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+var ErrCryptoPanKeySize = errors.New("crypto-pan key must be 32 bytes")
+
+// cryptoPan implements the Crypto-PAn prefix-preserving anonymization:
+// two addresses sharing a prefix of n bits are anonymized to two addresses
+// sharing a prefix of n bits too, the mapping is a bijection depending on the key
+// See https://en.wikipedia.org/wiki/Crypto-PAn
+type cryptoPan struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+// newCryptoPan creates the cipher from a 32 bytes key, the first half is
+// the AES key and the second half is encrypted to get the padding
+func newCryptoPan(key []byte) (*cryptoPan, error) {
+	if len(key) != 32 {
+		return nil, ErrCryptoPanKeySize
+	}
+
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cryptoPan{block: block}
+	block.Encrypt(c.pad[:], key[16:])
+	return c, nil
+}
+
+// Anonymize returns the anonymized address, addr is a 4 or 16 bytes address
+func (c *cryptoPan) Anonymize(addr []byte) []byte {
+	var input, output [aes.BlockSize]byte
+	result := make([]byte, len(addr))
+
+	for pos := 0; pos < len(addr)*8; pos++ {
+		// the first pos bits of the address, followed by the padding
+		input = c.pad
+		n := pos / 8
+		copy(input[:n], addr[:n])
+		if rem := pos % 8; rem != 0 {
+			mask := byte(0xff << (8 - rem))
+			input[n] = (addr[n] & mask) | (c.pad[n] &^ mask)
+		}
+
+		c.block.Encrypt(output[:], input[:])
+		result[pos/8] |= (output[0] >> 7) << (7 - pos%8)
+	}
+
+	for i := range result {
+		result[i] ^= addr[i]
+	}
+	return result
+}
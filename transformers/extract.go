@@ -44,6 +44,11 @@ func (s *ExtractProcessor) IsEnabled() bool {
 }
 
 func (s *ExtractProcessor) AddBase64Payload(dm *dnsutils.DnsMessage) []byte {
+	// the payload is encoded from the decoded fields if removed by a previous transformer
+	payload, err := dm.GetPayload()
+	if err != nil {
+		return []byte("-")
+	}
 	// Encode to base64 is done automatically by the json encoder ([]byte)
-	return dm.DNS.Payload
+	return payload
 }
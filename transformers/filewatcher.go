@@ -0,0 +1,118 @@
+package transformers
+
+import (
+	"path/filepath"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// delay to wait after the last change on a watched file before to reload it,
+// files are often written in several steps or replaced by a rename
+var fileWatcherReloadDelay = 2 * time.Second
+
+// FileWatcher calls the reload function when a file of the set is modified or replaced.
+// The directories are watched to detect files replaced by a rename, and the reload is
+// delayed until no more changes are detected.
+type FileWatcher struct {
+	watcher  *fsnotify.Watcher
+	files    map[string]bool
+	reload   func(fname string)
+	logError func(msg string, v ...interface{})
+	stop     chan bool
+	done     chan bool
+}
+
+func NewFileWatcher(files []string, reload func(fname string), logError func(msg string, v ...interface{})) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &FileWatcher{
+		watcher:  watcher,
+		files:    make(map[string]bool),
+		reload:   reload,
+		logError: logError,
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+
+	dirs := make(map[string]bool)
+	for _, fname := range files {
+		fname = filepath.Clean(fname)
+		w.files[fname] = true
+
+		dir := filepath.Dir(fname)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			logError("unable to watch directory %s: %v", dir, err)
+			continue
+		}
+		dirs[dir] = true
+	}
+
+	go w.Run()
+	return w, nil
+}
+
+func (w *FileWatcher) Run() {
+	defer close(w.done)
+
+	changed := make(map[string]bool)
+	timer := time.NewTimer(fileWatcherReloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+
+		// watch for events
+		case event, opened := <-w.watcher.Events:
+			if !opened {
+				return
+			}
+			fname := filepath.Clean(event.Name)
+			if !w.files[fname] {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			changed[fname] = true
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(fileWatcherReloadDelay)
+
+		// reload files when no more changes are detected
+		case <-timer.C:
+			for fname := range changed {
+				w.reload(fname)
+			}
+			changed = make(map[string]bool)
+
+		// watch for errors
+		case err, opened := <-w.watcher.Errors:
+			if !opened {
+				return
+			}
+			w.logError("files watcher error: %v", err)
+		}
+	}
+}
+
+// Close stops to watch the files, a pending reload is cancelled and a running one is
+// terminated when Close returns
+func (w *FileWatcher) Close() {
+	close(w.stop)
+	<-w.done
+	w.watcher.Close()
+}
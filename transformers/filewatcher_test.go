@@ -0,0 +1,86 @@
+package transformers
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher_Reload(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 200 * time.Millisecond
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(fname, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan string, 10)
+	watcher, err := NewFileWatcher([]string{fname}, func(f string) { reloaded <- f }, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// a file not in the set is ignored
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// several writes are reloaded once, a file replaced by a rename is detected
+	if err := os.WriteFile(fname, []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "list.tmp")
+	if err := os.WriteFile(tmp, []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, fname); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case f := <-reloaded:
+		if f != fname {
+			t.Errorf("invalid file reloaded: %s", f)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("file not reloaded")
+	}
+
+	time.Sleep(2 * fileWatcherReloadDelay)
+	if len(reloaded) != 0 {
+		t.Errorf("the file should be reloaded once, %d more reloads", len(reloaded))
+	}
+}
+
+func TestFileWatcher_CloseCancelsReload(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 200 * time.Millisecond
+
+	fname := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(fname, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reloads int32
+	watcher, err := NewFileWatcher([]string{fname}, func(f string) { atomic.AddInt32(&reloads, 1) }, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reload is pending when the watcher is closed
+	if err := os.WriteFile(fname, []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	watcher.Close()
+
+	time.Sleep(2 * fileWatcherReloadDelay)
+	if n := atomic.LoadInt32(&reloads); n != 0 {
+		t.Errorf("no reload expected after close, got %d", n)
+	}
+}
@@ -3,17 +3,27 @@ package transformers
 import (
 	"bufio"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
-	"gopkg.in/fsnotify.v1"
 	"inet.af/netaddr"
 )
 
+type filteringListFile struct {
+	option string
+	load   func() error
+}
+
 type FilteringProcessor struct {
+	sync.RWMutex
 	config               *dnsutils.ConfigTransformers
 	logger               *logger.Logger
 	dropDomains          bool
@@ -23,13 +33,21 @@ type FilteringProcessor struct {
 	ipsetKeep            *netaddr.IPSet
 	rDataIpsetKeep       *netaddr.IPSet
 	listFqdns            map[string]bool
+	listDomains          *dnsutils.DomainTrie
 	listDomainsRegex     map[string]*regexp.Regexp
 	listKeepFqdns        map[string]bool
+	listKeepDomains      *dnsutils.DomainTrie
 	listKeepDomainsRegex map[string]*regexp.Regexp
-	fileWatcher          *fsnotify.Watcher
+	listFiles            map[string]filteringListFile
+	fileWatcher          *FileWatcher
 	name                 string
 	downsample           int
 	downsampleCount      int
+	samplingEnabled      bool
+	samplingKeys         []*dnsutils.Field
+	samplingThreshold    uint64
+	dropExpression       *dnsutils.Expression
+	keepExpression       *dnsutils.Expression
 	activeFilters        []func(dm *dnsutils.DnsMessage) bool
 	instance             int
 	outChannels          []chan dnsutils.DnsMessage
@@ -40,14 +58,7 @@ type FilteringProcessor struct {
 func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
 	instance int, outChannels []chan dnsutils.DnsMessage,
 	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
-) FilteringProcessor {
-	// creates a new file watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		fmt.Println("ERROR", err)
-	}
-	defer watcher.Close()
-
+) *FilteringProcessor {
 	d := FilteringProcessor{
 		config:               config,
 		logger:               logger,
@@ -56,10 +67,12 @@ func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.L
 		ipsetKeep:            &netaddr.IPSet{},
 		rDataIpsetKeep:       &netaddr.IPSet{},
 		listFqdns:            make(map[string]bool),
+		listDomains:          dnsutils.NewDomainTrie(),
 		listDomainsRegex:     make(map[string]*regexp.Regexp),
 		listKeepFqdns:        make(map[string]bool),
+		listKeepDomains:      dnsutils.NewDomainTrie(),
 		listKeepDomainsRegex: make(map[string]*regexp.Regexp),
-		fileWatcher:          watcher,
+		listFiles:            make(map[string]filteringListFile),
 		name:                 name,
 		instance:             instance,
 		outChannels:          outChannels,
@@ -71,10 +84,19 @@ func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.L
 	d.LoadDomainsList()
 	d.LoadQueryIpList()
 	d.LoadrDataIpList()
+	if err := d.LoadExpressions(); err != nil {
+		// without the filter, an invalid keep expression would keep all the traffic
+		logger.Fatal(fmt.Sprintf("[%s] transformer=filtering#%d - ", name, instance), err)
+	}
+	d.LoadSampling()
 
 	d.LoadActiveFilters()
 
-	return d
+	if config.Filtering.Enable {
+		d.WatchListFiles()
+	}
+
+	return &d
 }
 
 func (p *FilteringProcessor) LogInfo(msg string, v ...interface{}) {
@@ -88,7 +110,9 @@ func (p *FilteringProcessor) LogError(msg string, v ...interface{}) {
 }
 
 func (p *FilteringProcessor) LoadActiveFilters() {
-	// TODO: Change to iteration through Filtering to add filters in custom order.
+	// filters are applied in this order, to use a custom order define several
+	// filtering transformers in the ordered list of transforms
+	p.activeFilters = nil
 
 	if !p.config.Filtering.LogQueries {
 		p.activeFilters = append(p.activeFilters, p.ignoreQueryFilter)
@@ -118,24 +142,35 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.activeFilters = append(p.activeFilters, p.dropFqdnFilter)
 	}
 
-	if len(p.listDomainsRegex) > 0 {
-		p.activeFilters = append(p.activeFilters, p.dropDomainRegexFilter)
+	if p.listDomains.Len() > 0 || len(p.listDomainsRegex) > 0 {
+		p.activeFilters = append(p.activeFilters, p.dropDomainFilter)
 	}
 
 	if len(p.listKeepFqdns) > 0 {
 		p.activeFilters = append(p.activeFilters, p.keepFqdnFilter)
 	}
 
-	if len(p.listKeepDomainsRegex) > 0 {
-		p.activeFilters = append(p.activeFilters, p.keepDomainRegexFilter)
+	if p.listKeepDomains.Len() > 0 || len(p.listKeepDomainsRegex) > 0 {
+		p.activeFilters = append(p.activeFilters, p.keepDomainFilter)
+	}
+
+	if p.dropExpression != nil {
+		p.activeFilters = append(p.activeFilters, p.dropExpressionFilter)
+	}
+
+	if p.keepExpression != nil {
+		p.activeFilters = append(p.activeFilters, p.keepExpressionFilter)
 	}
 
 	// set downsample if desired
 	if p.config.Filtering.Downsample > 0 {
 		p.downsample = p.config.Filtering.Downsample
-		p.downsampleCount = 0
 		p.activeFilters = append(p.activeFilters, p.downsampleFilter)
 	}
+
+	if p.samplingEnabled {
+		p.activeFilters = append(p.activeFilters, p.samplingFilter)
+	}
 }
 
 func (p *FilteringProcessor) LoadRcodes() {
@@ -144,177 +179,282 @@ func (p *FilteringProcessor) LoadRcodes() {
 	}
 }
 
-func (p *FilteringProcessor) loadQueryIpList(fname string, drop bool) (uint64, error) {
+// LoadExpressions compiles the drop and keep expressions, an error is returned
+// if one of them is invalid
+func (p *FilteringProcessor) LoadExpressions() error {
+	var err error
+	if len(p.config.Filtering.DropExpression) > 0 {
+		p.dropExpression, err = dnsutils.CompileExpression(p.config.Filtering.DropExpression)
+		if err != nil {
+			return fmt.Errorf("invalid drop expression: %v", err)
+		}
+		p.LogInfo("drop expression loaded: %s", p.dropExpression)
+	}
+
+	if len(p.config.Filtering.KeepExpression) > 0 {
+		p.keepExpression, err = dnsutils.CompileExpression(p.config.Filtering.KeepExpression)
+		if err != nil {
+			return fmt.Errorf("invalid keep expression: %v", err)
+		}
+		p.LogInfo("keep expression loaded: %s", p.keepExpression)
+	}
+	return nil
+}
+
+// LoadSampling compiles the keys hashed to sample the traffic, the sampling is random without keys.
+// The sampling is disabled with an invalid key, a random sampling would break the consistency expected with the keys.
+func (p *FilteringProcessor) LoadSampling() {
+	rate := p.config.Filtering.SamplingRate
+	if rate <= 0 || rate > 1 {
+		p.LogError("invalid sampling rate %v, must be greater than 0 and lower or equal to 1", rate)
+		return
+	}
+	if rate == 1 {
+		return
+	}
+
+	keys := []*dnsutils.Field{}
+	for _, name := range p.config.Filtering.SamplingKeys {
+		field, err := dnsutils.CompileField(name)
+		if err != nil {
+			p.LogError("invalid sampling key, sampling disabled: %v", err)
+			return
+		}
+		keys = append(keys, field)
+	}
+
+	p.samplingKeys = keys
+	p.samplingThreshold = uint64(rate * math.MaxUint64)
+	p.samplingEnabled = true
+	p.LogInfo("sampling rate %v loaded with %d key(s)", rate, len(keys))
+}
+
+// readIpList returns the set of ip addresses and prefixes of the file, one per line,
+// empty lines and comments starting with # are ignored
+func readIpList(fname string) (*netaddr.IPSet, int, error) {
 	file, err := os.Open(fname)
 	if err != nil {
-		return 0, err
+		return nil, 0, err
 	}
+	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
-	var read uint64
+	var read int
 	var ipsetbuilder netaddr.IPSetBuilder
 	for scanner.Scan() {
+		ipOrPrefix := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(ipOrPrefix) == 0 || strings.HasPrefix(ipOrPrefix, "#") {
+			continue
+		}
 		read++
-		ipOrPrefix := strings.ToLower(scanner.Text())
 		prefix, err := netaddr.ParseIPPrefix(ipOrPrefix)
 		if err != nil {
 			ip, err := netaddr.ParseIP(ipOrPrefix)
 			if err != nil {
-				p.LogError("%s in in %s is neither an IP address nor a prefix", ipOrPrefix, fname)
-				continue
+				return nil, 0, fmt.Errorf("%s in %s is neither an IP address nor a prefix", ipOrPrefix, fname)
 			}
 			ipsetbuilder.Add(ip)
 			continue
 		}
 		ipsetbuilder.AddPrefix(prefix)
 	}
-	if drop {
-		p.ipsetDrop, err = ipsetbuilder.IPSet()
-	} else {
-		p.ipsetKeep, err = ipsetbuilder.IPSet()
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return read, err
+	ipset, err := ipsetbuilder.IPSet()
+	return ipset, read, err
 }
 
-func (p *FilteringProcessor) loadKeepRdataIpList(fname string) (uint64, error) {
+// readFqdnList returns the domains of the file, one per line
+func readFqdnList(fname string) (map[string]bool, error) {
 	file, err := os.Open(fname)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer file.Close()
 
+	list := make(map[string]bool)
 	scanner := bufio.NewScanner(file)
-	var read uint64
-	var ipsetbuilder netaddr.IPSetBuilder
 	for scanner.Scan() {
-		read++
-		ipOrPrefix := strings.ToLower(scanner.Text())
-		prefix, err := netaddr.ParseIPPrefix(ipOrPrefix)
-		if err != nil {
-			ip, err := netaddr.ParseIP(ipOrPrefix)
-			if err != nil {
-				p.LogError("%s in in %s is neither an IP address nor a prefix", ipOrPrefix, fname)
-				continue
-			}
-			ipsetbuilder.Add(ip)
+		fqdn := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(fqdn) > 0 && !strings.HasPrefix(fqdn, "#") {
+			list[fqdn] = true
+		}
+	}
+	return list, scanner.Err()
+}
+
+// readDomainList returns the domains of the file, one per line, matched with all their subdomains,
+// lines which are not a domain name are compiled as regular expressions
+func readDomainList(fname string) (*dnsutils.DomainTrie, map[string]*regexp.Regexp, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	trie := dnsutils.NewDomainTrie()
+	list := make(map[string]*regexp.Regexp)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		domain := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(domain) == 0 || strings.HasPrefix(domain, "#") {
 			continue
 		}
-		ipsetbuilder.AddPrefix(prefix)
+		if dnsutils.IsDomainName(domain) {
+			trie.Add(domain)
+			continue
+		}
+		re, err := regexp.Compile(domain)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid regexp %s in %s: %v", domain, fname, err)
+		}
+		list[domain] = re
 	}
+	return trie, list, scanner.Err()
+}
 
-	p.rDataIpsetKeep, err = ipsetbuilder.IPSet()
+// addListFile registers the list file to reload it on changes,
+// the list is loaded immediately and an error is logged on failure
+func (p *FilteringProcessor) addListFile(fname string, option string, load func() error) {
+	p.listFiles[filepath.Clean(fname)] = filteringListFile{option: option, load: load}
+	if err := load(); err != nil {
+		p.LogError("unable to load %s: %v", option, err)
+	}
+}
 
-	return read, err
+// swapList replaces the lists while holding the lock and reloads the active filters
+func (p *FilteringProcessor) swapList(update func()) {
+	p.Lock()
+	defer p.Unlock()
+	update()
+	p.LoadActiveFilters()
 }
 
 func (p *FilteringProcessor) LoadQueryIpList() {
 	if len(p.config.Filtering.DropQueryIpFile) > 0 {
-		read, err := p.loadQueryIpList(p.config.Filtering.DropQueryIpFile, true)
-		if err != nil {
-			p.LogError("unable to open query ip file: ", err)
-		}
-		p.LogInfo("loaded with %d query ip to the drop list", read)
+		p.addListFile(p.config.Filtering.DropQueryIpFile, "drop-queryip-file", func() error {
+			ipset, read, err := readIpList(p.config.Filtering.DropQueryIpFile)
+			if err != nil {
+				return err
+			}
+			p.swapList(func() { p.ipsetDrop = ipset })
+			p.LogInfo("loaded with %d query ip to the drop list", read)
+			return nil
+		})
 	}
 
 	if len(p.config.Filtering.KeepQueryIpFile) > 0 {
-		read, err := p.loadQueryIpList(p.config.Filtering.KeepQueryIpFile, false)
-		if err != nil {
-			p.LogError("unable to open query ip file: ", err)
-		}
-		p.LogInfo("loaded with %d query ip to the keep list", read)
+		p.addListFile(p.config.Filtering.KeepQueryIpFile, "keep-queryip-file", func() error {
+			ipset, read, err := readIpList(p.config.Filtering.KeepQueryIpFile)
+			if err != nil {
+				return err
+			}
+			p.swapList(func() { p.ipsetKeep = ipset })
+			p.LogInfo("loaded with %d query ip to the keep list", read)
+			return nil
+		})
 	}
 }
 
 func (p *FilteringProcessor) LoadrDataIpList() {
 	if len(p.config.Filtering.KeepRdataFile) > 0 {
-		read, err := p.loadKeepRdataIpList(p.config.Filtering.KeepRdataFile)
-		if err != nil {
-			p.LogError("unable to open rdata ip file: ", err)
-		}
-		p.LogInfo("loaded with %d rdata ip to the keep list", read)
+		p.addListFile(p.config.Filtering.KeepRdataFile, "keep-rdata-file", func() error {
+			ipset, read, err := readIpList(p.config.Filtering.KeepRdataFile)
+			if err != nil {
+				return err
+			}
+			p.swapList(func() { p.rDataIpsetKeep = ipset })
+			p.LogInfo("loaded with %d rdata ip to the keep list", read)
+			return nil
+		})
 	}
 }
 
 func (p *FilteringProcessor) LoadDomainsList() {
 	if len(p.config.Filtering.DropFqdnFile) > 0 {
-		file, err := os.Open(p.config.Filtering.DropFqdnFile)
-		if err != nil {
-			p.LogError("unable to open fqdn file: ", err)
-			p.dropDomains = true
-		} else {
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				fqdn := strings.ToLower(scanner.Text())
-				p.listFqdns[fqdn] = true
+		p.dropDomains = true
+		p.addListFile(p.config.Filtering.DropFqdnFile, "drop-fqdn-file", func() error {
+			list, err := readFqdnList(p.config.Filtering.DropFqdnFile)
+			if err != nil {
+				return err
 			}
-			p.LogInfo("loaded with %d fqdn to the drop list", len(p.listFqdns))
-			p.dropDomains = true
-		}
-
+			p.swapList(func() { p.listFqdns = list })
+			p.LogInfo("loaded with %d fqdn to the drop list", len(list))
+			return nil
+		})
 	}
 
 	if len(p.config.Filtering.DropDomainFile) > 0 {
-		file, err := os.Open(p.config.Filtering.DropDomainFile)
-		if err != nil {
-			p.LogError("unable to open regex list file: ", err)
-			p.dropDomains = true
-		} else {
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				domain := strings.ToLower(scanner.Text())
-				p.listDomainsRegex[domain] = regexp.MustCompile(domain)
+		p.dropDomains = true
+		p.addListFile(p.config.Filtering.DropDomainFile, "drop-domain-file", func() error {
+			trie, list, err := readDomainList(p.config.Filtering.DropDomainFile)
+			if err != nil {
+				return err
 			}
-			p.LogInfo("loaded with %d domains to the drop list", len(p.listDomainsRegex))
-			p.dropDomains = true
-		}
+			p.swapList(func() { p.listDomains, p.listDomainsRegex = trie, list })
+			p.LogInfo("loaded with %d domains and %d regexp to the drop list", trie.Len(), len(list))
+			return nil
+		})
 	}
 
 	if len(p.config.Filtering.KeepFqdnFile) > 0 {
-		file, err := os.Open(p.config.Filtering.KeepFqdnFile)
-		if err != nil {
-			p.LogError("unable to open KeepFqdnFile file: ", err)
-			p.keepDomains = false
-		} else {
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				keepDomain := strings.ToLower(scanner.Text())
-				p.listKeepFqdns[keepDomain] = true
+		p.addListFile(p.config.Filtering.KeepFqdnFile, "keep-fqdn-file", func() error {
+			list, err := readFqdnList(p.config.Filtering.KeepFqdnFile)
+			if err != nil {
+				return err
 			}
-			p.LogInfo("loaded with %d fqdns to the keep list", len(p.listKeepFqdns))
-			p.keepDomains = true
-		}
+			p.swapList(func() { p.listKeepFqdns, p.keepDomains = list, true })
+			p.LogInfo("loaded with %d fqdns to the keep list", len(list))
+			return nil
+		})
 	}
 
 	if len(p.config.Filtering.KeepDomainFile) > 0 {
-		file, err := os.Open(p.config.Filtering.KeepDomainFile)
-		if err != nil {
-			p.LogError("unable to open KeepDomainFile file: ", err)
-			p.keepDomains = false
-		} else {
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				keepDomain := strings.ToLower(scanner.Text())
-				p.listKeepDomainsRegex[keepDomain] = regexp.MustCompile(keepDomain)
+		p.addListFile(p.config.Filtering.KeepDomainFile, "keep-domain-file", func() error {
+			trie, list, err := readDomainList(p.config.Filtering.KeepDomainFile)
+			if err != nil {
+				return err
 			}
-			p.LogInfo("loaded with %d domains to the keep list", len(p.listKeepDomainsRegex))
-			p.keepDomains = true
-		}
+			p.swapList(func() {
+				p.listKeepDomains, p.listKeepDomainsRegex = trie, list
+				p.keepDomains = true
+			})
+			p.LogInfo("loaded with %d domains and %d regexp to the keep list", trie.Len(), len(list))
+			return nil
+		})
 	}
 }
 
-func (p *FilteringProcessor) Run() {
-	for {
-		select {
-		// watch for events
-		case event := <-p.fileWatcher.Events:
-			fmt.Printf("EVENT! %#v\n", event)
+// WatchListFiles reloads the list files when they are modified or replaced
+func (p *FilteringProcessor) WatchListFiles() {
+	if len(p.listFiles) == 0 {
+		return
+	}
 
-			// watch for errors
-		case err := <-p.fileWatcher.Errors:
-			fmt.Println("ERROR", err)
-		}
+	files := make([]string, 0, len(p.listFiles))
+	for fname := range p.listFiles {
+		files = append(files, fname)
+	}
+	watcher, err := NewFileWatcher(files, p.reloadListFile, p.LogError)
+	if err != nil {
+		p.LogError("unable to watch list files: %v", err)
+		return
+	}
+	p.fileWatcher = watcher
+}
+
+func (p *FilteringProcessor) reloadListFile(fname string) {
+	listFile := p.listFiles[fname]
+	if err := listFile.load(); err != nil {
+		p.LogError("unable to reload %s, previous list is kept: %v", listFile.option, err)
+	}
+}
+
+func (p *FilteringProcessor) Close() {
+	if p.fileWatcher != nil {
+		p.fileWatcher.Close()
 	}
 }
 
@@ -345,6 +485,7 @@ func (p *FilteringProcessor) DropQueryIpFilter(dm *dnsutils.DnsMessage) bool {
 }
 
 func (p *FilteringProcessor) keepRdataFilter(dm *dnsutils.DnsMessage) bool {
+	dm.DecodeSections()
 	if len(dm.DNS.DnsRRs.Answers) > 0 {
 		// If even one exists in filter list then pass through filter
 		for _, answer := range dm.DNS.DnsRRs.Answers {
@@ -366,7 +507,12 @@ func (p *FilteringProcessor) dropFqdnFilter(dm *dnsutils.DnsMessage) bool {
 	return false
 }
 
-func (p *FilteringProcessor) dropDomainRegexFilter(dm *dnsutils.DnsMessage) bool {
+func (p *FilteringProcessor) dropDomainFilter(dm *dnsutils.DnsMessage) bool {
+	// domain and all subdomains
+	if p.listDomains.Match(dm.DNS.Qname) {
+		return true
+	}
+
 	// partial fqdn with regexp
 	for _, d := range p.listDomainsRegex {
 		if d.MatchString(dm.DNS.Qname) {
@@ -383,7 +529,12 @@ func (p *FilteringProcessor) keepFqdnFilter(dm *dnsutils.DnsMessage) bool {
 	return true
 }
 
-func (p *FilteringProcessor) keepDomainRegexFilter(dm *dnsutils.DnsMessage) bool {
+func (p *FilteringProcessor) keepDomainFilter(dm *dnsutils.DnsMessage) bool {
+	// domain and all subdomains
+	if p.listKeepDomains.Match(dm.DNS.Qname) {
+		return false
+	}
+
 	// partial fqdn with regexp
 	for _, d := range p.listKeepDomainsRegex {
 		if d.MatchString(dm.DNS.Qname) {
@@ -393,6 +544,14 @@ func (p *FilteringProcessor) keepDomainRegexFilter(dm *dnsutils.DnsMessage) bool
 	return true
 }
 
+func (p *FilteringProcessor) dropExpressionFilter(dm *dnsutils.DnsMessage) bool {
+	return p.dropExpression.Match(dm)
+}
+
+func (p *FilteringProcessor) keepExpressionFilter(dm *dnsutils.DnsMessage) bool {
+	return !p.keepExpression.Match(dm)
+}
+
 func (p *FilteringProcessor) downsampleFilter(dm *dnsutils.DnsMessage) bool {
 	// drop all except every nth entry
 	p.downsampleCount += 1
@@ -400,12 +559,59 @@ func (p *FilteringProcessor) downsampleFilter(dm *dnsutils.DnsMessage) bool {
 		return true
 	} else if p.downsampleCount%p.downsample == 0 {
 		p.downsampleCount = 0
+		setSamplingRate(dm, 1/float64(p.downsample))
 		return false
 	}
 	return true
 }
 
+// samplingFilter keeps a fraction of the traffic, all the messages with the same
+// keys (client, transaction, ...) are kept or dropped together
+func (p *FilteringProcessor) samplingFilter(dm *dnsutils.DnsMessage) bool {
+	if len(p.samplingKeys) == 0 {
+		if rand.Float64() >= p.config.Filtering.SamplingRate {
+			return true
+		}
+	} else {
+		values := make([]string, len(p.samplingKeys))
+		for i, key := range p.samplingKeys {
+			values[i] = key.Value(dm)
+		}
+		hashfnv := fnv.New64a()
+		hashfnv.Write([]byte(strings.Join(values, "+")))
+		if mixHash(hashfnv.Sum64()) >= p.samplingThreshold {
+			return true
+		}
+	}
+
+	setSamplingRate(dm, p.config.Filtering.SamplingRate)
+	return false
+}
+
+// mixHash spreads the bits of the fnv hash (murmur3 finalizer), the keys differing only
+// by their last characters (transaction id, port) are not uniformly distributed otherwise
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// setSamplingRate adds the sampling rate to the message, the rates of several samplings are multiplied.
+// The metadata is copied before the update, shared with the others loggers.
+func setSamplingRate(dm *dnsutils.DnsMessage, rate float64) {
+	if dm.Filtering != nil {
+		rate *= dm.Filtering.SamplingRate
+	}
+	dm.Filtering = &dnsutils.TransformFiltering{SamplingRate: rate}
+}
+
 func (p *FilteringProcessor) CheckIfDrop(dm *dnsutils.DnsMessage) bool {
+	p.RLock()
+	defer p.RUnlock()
+
 	if len(p.activeFilters) == 0 {
 		return false
 	}
@@ -1,7 +1,12 @@
 package transformers
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -391,6 +396,154 @@ func TestFilteringByDownsample(t *testing.T) {
 
 }
 
+func TestFilteringByDownsample_Reload(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Downsample = 2
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	dm := dnsutils.GetFakeDnsMessage()
+
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped! downsampled should exclude first hit.")
+	}
+
+	// reloading a list file does not reset the downsampling
+	filtering.swapList(func() {})
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped! the downsample count should be kept after a reload")
+	}
+}
+
+func TestFilteringByDownsample_SamplingRate(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Downsample = 4
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	for i := 0; i < 4; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		if !filtering.CheckIfDrop(&dm) && (dm.Filtering == nil || dm.Filtering.SamplingRate != 0.25) {
+			t.Errorf("invalid sampling rate: %v", dm.Filtering)
+		}
+	}
+}
+
+func TestFilteringBySampling_Keys(t *testing.T) {
+	// config, sampling per transaction
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.SamplingRate = 0.5
+	config.Filtering.SamplingKeys = []string{"network.query-ip", "network.query-port", "dns.id"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if !filtering.samplingEnabled || len(filtering.samplingKeys) != 3 {
+		t.Fatalf("sampling keys not loaded: %d", len(filtering.samplingKeys))
+	}
+
+	// same client, only the transaction id changes
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		query := dnsutils.GetFakeDnsMessage()
+		query.NetworkInfo.QueryIp = "10.0.0.1"
+		query.NetworkInfo.QueryPort = "53000"
+		query.DNS.Id = i
+		reply := query
+		reply.DNS.Type = dnsutils.DnsReply
+
+		// the query and the reply are kept or dropped together
+		dropQuery, dropReply := filtering.CheckIfDrop(&query), filtering.CheckIfDrop(&reply)
+		if dropQuery != dropReply {
+			t.Fatalf("query and reply not sampled together: %v %v", dropQuery, dropReply)
+		}
+		if dropQuery {
+			continue
+		}
+		kept++
+		if query.Filtering == nil || query.Filtering.SamplingRate != 0.5 {
+			t.Fatalf("invalid sampling rate: %v", query.Filtering)
+		}
+	}
+
+	if kept < 400 || kept > 600 {
+		t.Errorf("invalid number of transactions kept: %d", kept)
+	}
+}
+
+func TestFilteringBySampling_InvalidKey(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.SamplingRate = 0.5
+	config.Filtering.SamplingKeys = []string{"network.query-ip", "dns.unknown"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if filtering.samplingEnabled {
+		t.Fatalf("sampling must be disabled with an invalid key")
+	}
+
+	for i := 0; i < 100; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		if filtering.CheckIfDrop(&dm) {
+			t.Fatalf("dns query should not be dropped, sampling disabled")
+		}
+	}
+}
+
+func TestFilteringBySampling_Random(t *testing.T) {
+	// config, random sampling without keys
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.SamplingRate = 0.2
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		dm := dnsutils.GetFakeDnsMessage()
+		if !filtering.CheckIfDrop(&dm) {
+			kept++
+		}
+	}
+	if kept < 1500 || kept > 2500 {
+		t.Errorf("invalid number of messages kept: %d", kept)
+	}
+}
+
+func TestFilteringBySampling_MultiplyRates(t *testing.T) {
+	dm := dnsutils.GetFakeDnsMessage()
+	setSamplingRate(&dm, 0.5)
+
+	// shared with the others loggers
+	shared := dm
+	setSamplingRate(&dm, 0.1)
+
+	if dm.Filtering.SamplingRate != 0.05 {
+		t.Errorf("invalid sampling rate: %v", dm.Filtering.SamplingRate)
+	}
+	if shared.Filtering.SamplingRate != 0.5 {
+		t.Errorf("shared message updated: %v", shared.Filtering.SamplingRate)
+	}
+}
+
 func TestFilteringMultipleFilters(t *testing.T) {
 	// config
 	config := dnsutils.GetFakeConfigTransformers()
@@ -429,3 +582,190 @@ func TestFilteringMultipleFilters(t *testing.T) {
 		t.Errorf("dns query should be dropped!")
 	}
 }
+
+func TestFilteringByExpression(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropExpression = `dns.qtype in ["ANY", "TXT"] and dns.length > 512`
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qtype = "TXT"
+	dm.DNS.Length = 1000
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped")
+	}
+
+	dm.DNS.Length = 100
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped")
+	}
+}
+
+func TestFilteringByKeepExpression(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.KeepExpression = `network.query-ip == "1.2.3.4"`
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped")
+	}
+
+	dm.NetworkInfo.QueryIp = "192.168.1.1"
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped")
+	}
+}
+
+func TestFilteringInvalidExpression(t *testing.T) {
+	log := logger.New(false)
+
+	for _, keep := range []bool{false, true} {
+		config := dnsutils.GetFakeConfigTransformers()
+		if keep {
+			config.Filtering.KeepExpression = `network.query-ip = "1.2.3.4"`
+		} else {
+			config.Filtering.DropExpression = `dns.qtype in ["ANY"`
+		}
+
+		// the constructor refuses to start with this error
+		filtering := &FilteringProcessor{config: config, logInfo: log.Info, logError: log.Error}
+		if err := filtering.LoadExpressions(); err == nil {
+			t.Errorf("invalid expression not detected (keep=%v)", keep)
+		}
+	}
+}
+
+func TestFilteringReloadFqdnFile(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 100 * time.Millisecond
+
+	fname := filepath.Join(t.TempDir(), "fqdn.txt")
+	if err := os.WriteFile(fname, []byte(TEST_URL1+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.DropFqdnFile = fname
+	config.Filtering.DropDomainFile = filepath.Join(filepath.Dir(fname), "regex.txt")
+	if err := os.WriteFile(config.Filtering.DropDomainFile, []byte("gitlab\\.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer filtering.Close()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = TEST_URL2
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped!")
+	}
+
+	// replace the file like a cron job, the new list is loaded
+	tmpname := fname + ".tmp"
+	if err := os.WriteFile(tmpname, []byte(TEST_URL1+"\n"+TEST_URL2+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpname, fname); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped after reload!")
+	}
+
+	// invalid regexp, the previous list is kept
+	if err := os.WriteFile(config.Filtering.DropDomainFile, []byte("github\\.com\n(invalid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	dm.DNS.Qname = "www.github.com"
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped with an invalid list!")
+	}
+}
+
+func TestFilteringByDomainAndRegex(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(fname, []byte("# blocklist\ngithub.com\n(mail|www)\\.google\\.com$\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropDomainFile = fname
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	for qname, drop := range map[string]bool{
+		"github.com":          true,
+		"a.b.github.com":      true,
+		"mygithub.com":        false,
+		"github.com.evil.org": false,
+		"mail.google.com":     true,
+		"docs.google.com":     false,
+	} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		if filtering.CheckIfDrop(&dm) != drop {
+			t.Errorf("%s: drop expected %v", qname, drop)
+		}
+	}
+}
+
+func BenchmarkFilteringByDomain1M(b *testing.B) {
+	fname := filepath.Join(b.TempDir(), "domains.txt")
+	f, err := os.Create(fname)
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	for i := 0; i < 1000000; i++ {
+		fmt.Fprintf(w, "domain%d.example%d.com\n", i, i%100)
+	}
+	w.Flush()
+	f.Close()
+
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.DropDomainFile = fname
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "www.domain424242.example42.com"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filtering.CheckIfDrop(&dm)
+	}
+}
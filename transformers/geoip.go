@@ -1,15 +1,20 @@
 package transformers
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"path/filepath"
 	"strconv"
+	"sync"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
 	"github.com/oschwald/maxminddb-golang"
 )
 
+var ErrGeoIpClosed = errors.New("geoip processor closed")
+
 type MaxminddbRecord struct {
 	Continent struct {
 		Code string `maxminddb:"code"`
@@ -17,9 +22,16 @@ type MaxminddbRecord struct {
 	Country struct {
 		ISOCode string `maxminddb:"iso_code"`
 	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
 	City struct {
 		Names map[string]string `maxminddb:"names"`
 	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
 	AutonomousSystemNumber       int    `maxminddb:"autonomous_system_number"`
 	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
 }
@@ -27,18 +39,24 @@ type MaxminddbRecord struct {
 type GeoRecord struct {
 	Continent      string
 	CountryISOCode string
+	Subdivision    string
 	City           string
+	Latitude       float64
+	Longitude      float64
 	ASN            string
 	ASO            string
 }
 
 type GeoIpProcessor struct {
+	sync.RWMutex
 	config      *dnsutils.ConfigTransformers
 	logger      *logger.Logger
 	dbCountry   *maxminddb.Reader
 	dbCity      *maxminddb.Reader
 	dbAsn       *maxminddb.Reader
+	fileWatcher *FileWatcher
 	enabled     bool
+	closed      bool
 	name        string
 	instance    int
 	outChannels []chan dnsutils.DnsMessage
@@ -49,7 +67,7 @@ type GeoIpProcessor struct {
 func NewDnsGeoIpProcessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
 	instance int, outChannels []chan dnsutils.DnsMessage,
 	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
-) GeoIpProcessor {
+) *GeoIpProcessor {
 	d := GeoIpProcessor{
 		config:      config,
 		logger:      logger,
@@ -60,7 +78,7 @@ func NewDnsGeoIpProcessor(config *dnsutils.ConfigTransformers, logger *logger.Lo
 		logError:    logError,
 	}
 
-	return d
+	return &d
 }
 
 func (p *GeoIpProcessor) LogInfo(msg string, v ...interface{}) {
@@ -77,6 +95,7 @@ func (p *GeoIpProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 	if dm.Geo == nil {
 		dm.Geo = &dnsutils.TransformDnsGeo{
 			CountryIsoCode:         "-",
+			SubdivisionIsoCode:     "-",
 			City:                   "-",
 			Continent:              "-",
 			AutonomousSystemNumber: "-",
@@ -85,6 +104,21 @@ func (p *GeoIpProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 	}
 }
 
+// databases returns the pointers to the readers with the path of the files
+func (p *GeoIpProcessor) databases() map[string]**maxminddb.Reader {
+	dbs := make(map[string]**maxminddb.Reader)
+	if len(p.config.GeoIP.DbCountryFile) > 0 {
+		dbs[filepath.Clean(p.config.GeoIP.DbCountryFile)] = &p.dbCountry
+	}
+	if len(p.config.GeoIP.DbCityFile) > 0 {
+		dbs[filepath.Clean(p.config.GeoIP.DbCityFile)] = &p.dbCity
+	}
+	if len(p.config.GeoIP.DbAsnFile) > 0 {
+		dbs[filepath.Clean(p.config.GeoIP.DbAsnFile)] = &p.dbAsn
+	}
+	return dbs
+}
+
 func (p *GeoIpProcessor) Open() (err error) {
 	if len(p.config.GeoIP.DbCountryFile) > 0 {
 		p.dbCountry, err = maxminddb.Open(p.config.GeoIP.DbCountryFile)
@@ -118,19 +152,85 @@ func (p *GeoIpProcessor) Open() (err error) {
 	return nil
 }
 
+// Reopen replaces the database by the new version of the file,
+// the previous database is kept if the file is invalid
+func (p *GeoIpProcessor) Reopen(fname string) error {
+	db, ok := p.databases()[filepath.Clean(fname)]
+	if !ok {
+		return nil
+	}
+
+	reader, err := maxminddb.Open(fname)
+	if err != nil {
+		return err
+	}
+	if err := reader.Verify(); err != nil {
+		reader.Close()
+		return err
+	}
+
+	// the processor can be closed while the file is opened
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		reader.Close()
+		return ErrGeoIpClosed
+	}
+	previous := *db
+	*db = reader
+	p.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	p.LogInfo("database %s reloaded (%d records)", fname, reader.Metadata.NodeCount)
+	return nil
+}
+
+// WatchFiles reopens the databases when they are replaced, geoipupdate writes
+// a temporary file and renames it
+func (p *GeoIpProcessor) WatchFiles() {
+	dbs := p.databases()
+	if len(dbs) == 0 {
+		return
+	}
+
+	files := make([]string, 0, len(dbs))
+	for fname := range dbs {
+		files = append(files, fname)
+	}
+	watcher, err := NewFileWatcher(files, p.reloadDatabase, p.LogError)
+	if err != nil {
+		p.LogError("unable to watch databases: %v", err)
+		return
+	}
+	p.fileWatcher = watcher
+}
+
+func (p *GeoIpProcessor) reloadDatabase(fname string) {
+	if err := p.Reopen(fname); err != nil {
+		p.LogError("unable to reload %s, previous database is kept: %v", fname, err)
+	}
+}
+
 func (p *GeoIpProcessor) IsEnabled() bool {
 	return p.enabled
 }
 
+// Close stops the watcher, a pending reload is cancelled, then closes the databases
 func (p *GeoIpProcessor) Close() {
-	if p.dbCountry != nil {
-		p.dbCountry.Close()
-	}
-	if p.dbCity != nil {
-		p.dbCity.Close()
+	if p.fileWatcher != nil {
+		p.fileWatcher.Close()
 	}
-	if p.dbAsn != nil {
-		p.dbAsn.Close()
+
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+	for _, db := range []**maxminddb.Reader{&p.dbCountry, &p.dbCity, &p.dbAsn} {
+		if *db != nil {
+			(*db).Close()
+			*db = nil
+		}
 	}
 }
 
@@ -138,10 +238,14 @@ func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
 	record := &MaxminddbRecord{}
 	rec := GeoRecord{Continent: "-",
 		CountryISOCode: "-",
+		Subdivision:    "-",
 		City:           "-",
 		ASN:            "-",
 		ASO:            "-"}
 
+	p.RLock()
+	defer p.RUnlock()
+
 	if p.dbAsn != nil {
 		err := p.dbAsn.Lookup(net.ParseIP(ip), &record)
 		if err != nil {
@@ -159,6 +263,11 @@ func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
 		rec.City = record.City.Names["en"]
 		rec.CountryISOCode = record.Country.ISOCode
 		rec.Continent = record.Continent.Code
+		if len(record.Subdivisions) > 0 {
+			rec.Subdivision = record.Subdivisions[0].ISOCode
+		}
+		rec.Latitude = record.Location.Latitude
+		rec.Longitude = record.Location.Longitude
 
 	} else {
 		if p.dbCountry != nil {
@@ -173,3 +282,56 @@ func (p *GeoIpProcessor) Lookup(ip string) (GeoRecord, error) {
 
 	return rec, nil
 }
+
+// LookupResponseIp returns the geo of the response ip, nil if the ip is not valid
+func (p *GeoIpProcessor) LookupResponseIp(dm *dnsutils.DnsMessage) (*dnsutils.TransformDnsGeo, error) {
+	if net.ParseIP(dm.NetworkInfo.ResponseIp) == nil {
+		return nil, nil
+	}
+
+	rec, err := p.Lookup(dm.NetworkInfo.ResponseIp)
+	if err != nil {
+		return nil, err
+	}
+	return &dnsutils.TransformDnsGeo{
+		City:                   rec.City,
+		Continent:              rec.Continent,
+		CountryIsoCode:         rec.CountryISOCode,
+		SubdivisionIsoCode:     rec.Subdivision,
+		Latitude:               rec.Latitude,
+		Longitude:              rec.Longitude,
+		AutonomousSystemNumber: rec.ASN,
+		AutonomousSystemOrg:    rec.ASO,
+	}, nil
+}
+
+// LookupAnswers returns the geo of the addresses of the A and AAAA answers
+func (p *GeoIpProcessor) LookupAnswers(dm *dnsutils.DnsMessage) ([]dnsutils.TransformDnsGeoAnswer, error) {
+	dm.DecodeSections()
+	var answers []dnsutils.TransformDnsGeoAnswer
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		if rr.Rdatatype != "A" && rr.Rdatatype != "AAAA" {
+			continue
+		}
+		if net.ParseIP(rr.Rdata) == nil {
+			continue
+		}
+
+		rec, err := p.Lookup(rr.Rdata)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, dnsutils.TransformDnsGeoAnswer{
+			Rdata:                  rr.Rdata,
+			City:                   rec.City,
+			Continent:              rec.Continent,
+			CountryIsoCode:         rec.CountryISOCode,
+			SubdivisionIsoCode:     rec.Subdivision,
+			Latitude:               rec.Latitude,
+			Longitude:              rec.Longitude,
+			AutonomousSystemNumber: rec.ASN,
+			AutonomousSystemOrg:    rec.ASO,
+		})
+	}
+	return answers, nil
+}
@@ -1,9 +1,15 @@
 package transformers
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
@@ -35,6 +41,9 @@ func TestGeoIP_Json(t *testing.T) {
 					"city":"-",
 					"continent":"-",
 					"country-isocode":"-",
+					"subdivision-isocode":"-",
+					"latitude":0,
+					"longitude":0,
 					"as-number":"-",
 					"as-owner":"-"
 				}
@@ -123,3 +132,229 @@ func TestGeoIP_LookupAsn(t *testing.T) {
 		t.Errorf("asn organisation invalid want: XX got: %s", geoInfo.ASO)
 	}
 }
+
+// mmdbEncode writes the value with the maxmind db data section format,
+// only the types and sizes needed by the tests are supported
+func mmdbEncode(buf *bytes.Buffer, v interface{}) {
+	control := func(typ int, size int) {
+		// sizes from 29 to 284 are stored in the next byte
+		low := size
+		if size >= 29 {
+			low = 29
+		}
+		if typ <= 7 {
+			buf.WriteByte(byte(typ<<5 | low))
+		} else {
+			buf.WriteByte(byte(low))
+			buf.WriteByte(byte(typ - 7))
+		}
+		if size >= 29 {
+			buf.WriteByte(byte(size - 29))
+		}
+	}
+	unsigned := func(typ int, v uint64) {
+		var b []byte
+		for ; v > 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		control(typ, len(b))
+		buf.Write(b)
+	}
+
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(3, 8)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case uint64:
+		unsigned(9, v)
+	case map[string]interface{}:
+		control(7, len(v))
+		for key, value := range v {
+			mmdbEncode(buf, key)
+			mmdbEncode(buf, value)
+		}
+	case []interface{}:
+		control(11, len(v))
+		for _, value := range v {
+			mmdbEncode(buf, value)
+		}
+	}
+}
+
+// writeTestMmdb writes an ipv4 database where all addresses resolve to the record,
+// the file is written then renamed like geoipupdate
+func writeTestMmdb(t *testing.T, fname string, record map[string]interface{}) {
+	var buf bytes.Buffer
+
+	// search tree with one node, the two records point to the data section
+	buf.Write([]byte{0, 0, 17, 0, 0, 17})
+	buf.Write(make([]byte, 16))
+	mmdbEncode(&buf, record)
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	mmdbEncode(&buf, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{"en": "test"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(1),
+		"record_size":                 uint16(24),
+	})
+
+	tmp := fname + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write mmdb file: %v", err)
+	}
+	if err := os.Rename(tmp, fname); err != nil {
+		t.Fatalf("unable to rename mmdb file: %v", err)
+	}
+}
+
+func testMmdbCity(country string) map[string]interface{} {
+	return map[string]interface{}{
+		"continent":    map[string]interface{}{"code": "EU"},
+		"country":      map[string]interface{}{"iso_code": country},
+		"subdivisions": []interface{}{map[string]interface{}{"iso_code": "IDF"}},
+		"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Paris"}},
+		"location":     map[string]interface{}{"latitude": 48.8582, "longitude": 2.3387},
+	}
+}
+
+func TestGeoIP_LookupCity(t *testing.T) {
+	// enable geoip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.DbCityFile = filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("FR"))
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	geoip := NewDnsGeoIpProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if err := geoip.Open(); err != nil {
+		t.Fatalf("geoip init failed: %v", err)
+	}
+	defer geoip.Close()
+
+	// lookup
+	geoInfo, err := geoip.Lookup("83.112.146.176")
+	if err != nil {
+		t.Fatalf("geoip loopkup failed: %v", err)
+	}
+
+	expected := GeoRecord{Continent: "EU", CountryISOCode: "FR", Subdivision: "IDF", City: "Paris",
+		Latitude: 48.8582, Longitude: 2.3387, ASN: "-", ASO: "-"}
+	if geoInfo != expected {
+		t.Errorf("geo record invalid want: %+v got: %+v", expected, geoInfo)
+	}
+}
+
+func TestGeoIP_Reopen(t *testing.T) {
+	// enable geoip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.DbCityFile = filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("FR"))
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	geoip := NewDnsGeoIpProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if err := geoip.Open(); err != nil {
+		t.Fatalf("geoip init failed: %v", err)
+	}
+	defer geoip.Close()
+
+	// new version of the database
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("DE"))
+	if err := geoip.Reopen(config.GeoIP.DbCityFile); err != nil {
+		t.Fatalf("geoip reopen failed: %v", err)
+	}
+	if geoInfo, _ := geoip.Lookup("83.112.146.176"); geoInfo.CountryISOCode != "DE" {
+		t.Errorf("country invalid want: DE got: %s", geoInfo.CountryISOCode)
+	}
+
+	// invalid file, the previous database is kept
+	invalid := filepath.Join(filepath.Dir(config.GeoIP.DbCityFile), "invalid.mmdb")
+	if err := os.WriteFile(invalid, []byte("invalid"), 0644); err != nil {
+		t.Fatalf("unable to write mmdb file: %v", err)
+	}
+	if err := os.Rename(invalid, config.GeoIP.DbCityFile); err != nil {
+		t.Fatalf("unable to rename mmdb file: %v", err)
+	}
+	if err := geoip.Reopen(config.GeoIP.DbCityFile); err == nil {
+		t.Errorf("geoip reopen should fail")
+	}
+	if geoInfo, _ := geoip.Lookup("83.112.146.176"); geoInfo.CountryISOCode != "DE" {
+		t.Errorf("country invalid want: DE got: %s", geoInfo.CountryISOCode)
+	}
+}
+
+func TestGeoIP_WatchFiles(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 10 * time.Millisecond
+
+	// enable geoip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.DbCityFile = filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("FR"))
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	geoip := NewDnsGeoIpProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if err := geoip.Open(); err != nil {
+		t.Fatalf("geoip init failed: %v", err)
+	}
+	geoip.WatchFiles()
+	defer geoip.Close()
+
+	// update the database like geoipupdate
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("DE"))
+
+	for i := 0; i < 100; i++ {
+		if geoInfo, _ := geoip.Lookup("83.112.146.176"); geoInfo.CountryISOCode == "DE" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("database not reloaded")
+}
+
+func TestGeoIP_ReopenAfterClose(t *testing.T) {
+	// enable geoip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.DbCityFile = filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("FR"))
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	geoip := NewDnsGeoIpProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if err := geoip.Open(); err != nil {
+		t.Fatalf("geoip init failed: %v", err)
+	}
+	geoip.WatchFiles()
+	geoip.Close()
+
+	// a reload after close must not open the database again
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("DE"))
+	if err := geoip.Reopen(config.GeoIP.DbCityFile); !errors.Is(err, ErrGeoIpClosed) {
+		t.Errorf("reopen after close should fail, got: %v", err)
+	}
+	if geoInfo, _ := geoip.Lookup("83.112.146.176"); geoInfo.CountryISOCode != "-" {
+		t.Errorf("no country expected after close, got: %s", geoInfo.CountryISOCode)
+	}
+}
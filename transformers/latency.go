@@ -1,6 +1,7 @@
 package transformers
 
 import (
+	"fmt"
 	"hash/fnv"
 	"strconv"
 	"strings"
@@ -11,50 +12,6 @@ import (
 	"github.com/dmachard/go-logger"
 )
 
-// queries map
-type MapQueries struct {
-	sync.RWMutex
-	ttl      time.Duration
-	kv       map[uint64]dnsutils.DnsMessage
-	channels []chan dnsutils.DnsMessage
-}
-
-func NewMapQueries(ttl time.Duration, channels []chan dnsutils.DnsMessage) MapQueries {
-	return MapQueries{
-		ttl:      ttl,
-		kv:       make(map[uint64]dnsutils.DnsMessage),
-		channels: channels,
-	}
-}
-
-func (mp *MapQueries) Exists(key uint64) (ok bool) {
-	mp.RLock()
-	defer mp.RUnlock()
-	_, ok = mp.kv[key]
-	return ok
-}
-
-func (mp *MapQueries) Set(key uint64, dm dnsutils.DnsMessage) {
-	mp.Lock()
-	defer mp.Unlock()
-	mp.kv[key] = dm
-	time.AfterFunc(mp.ttl, func() {
-		if mp.Exists(key) {
-			dm.DNS.Rcode = "TIMEOUT"
-			for i := range mp.channels {
-				mp.channels[i] <- dm
-			}
-		}
-		mp.Delete(key)
-	})
-}
-
-func (mp *MapQueries) Delete(key uint64) {
-	mp.Lock()
-	defer mp.Unlock()
-	delete(mp.kv, key)
-}
-
 // hash queries map
 type HashQueries struct {
 	sync.RWMutex
@@ -91,17 +48,24 @@ func (mp *HashQueries) Delete(key uint64) {
 	delete(mp.kv, key)
 }
 
+var (
+	TRANSACTION_ANSWERED  = "ANSWERED"
+	TRANSACTION_TIMEOUT   = "TIMEOUT"
+	TRANSACTION_UNMATCHED = "UNMATCHED"
+)
+
 // latency processor
 type LatencyProcessor struct {
-	config      *dnsutils.ConfigTransformers
-	logger      *logger.Logger
-	name        string
-	instance    int
-	hashQueries HashQueries
-	mapQueries  MapQueries
-	outChannels []chan dnsutils.DnsMessage
-	logInfo     func(msg string, v ...interface{})
-	logError    func(msg string, v ...interface{})
+	config            *dnsutils.ConfigTransformers
+	logger            *logger.Logger
+	name              string
+	instance          int
+	hashQueries       HashQueries
+	unansweredQueries *QueriesTracker
+	transactions      *QueriesTracker
+	outChannels       []chan dnsutils.DnsMessage
+	logInfo           func(msg string, v ...interface{})
+	logError          func(msg string, v ...interface{})
 }
 
 func NewLatencySubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
@@ -119,12 +83,44 @@ func NewLatencySubprocessor(config *dnsutils.ConfigTransformers, logger *logger.
 	}
 
 	s.hashQueries = NewHashQueries(time.Duration(config.Latency.QueriesTimeout) * time.Second)
-	s.mapQueries = NewMapQueries(time.Duration(config.Latency.QueriesTimeout)*time.Second, outChannels)
+
+	// the timing wheels are started only when used
+	if config.Latency.Enable {
+		timeout := time.Duration(config.Latency.QueriesTimeout) * time.Second
+		if config.Latency.Transaction {
+			s.transactions = NewQueriesTracker(name, "transactions", timeout, config.Latency.MaxTrackedQueries, outChannels, s.expireTransaction, s.LogInfo)
+		} else if config.Latency.UnansweredQueries {
+			s.unansweredQueries = NewQueriesTracker(name, "unanswered-queries", timeout, config.Latency.MaxTrackedQueries, outChannels, s.expireQuery, s.LogInfo)
+		}
+	}
 
 	return &s
 }
 
+func (s *LatencyProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=latency#%d - ", s.instance)
+	s.logInfo(log+msg, v...)
+}
+
+func (s *LatencyProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=latency#%d - ", s.instance)
+	s.logError(log+msg, v...)
+}
+
+// Close stops the timing wheels
+func (s *LatencyProcessor) Close() {
+	if s.unansweredQueries != nil {
+		s.unansweredQueries.Stop()
+	}
+	if s.transactions != nil {
+		s.transactions.Stop()
+	}
+}
+
 func (s *LatencyProcessor) MeasureLatency(dm *dnsutils.DnsMessage) {
+	// decode the records to detect the rdata errors of the malformed packets
+	dm.DecodeSections()
+
 	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
 	if len(dm.NetworkInfo.QueryIp) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
 		// compute the hash of the query
@@ -148,6 +144,8 @@ func (s *LatencyProcessor) MeasureLatency(dm *dnsutils.DnsMessage) {
 }
 
 func (s *LatencyProcessor) DetectEvictedTimeout(dm *dnsutils.DnsMessage) {
+	// decode the records to detect the rdata errors of the malformed packets
+	dm.DecodeSections()
 
 	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
 	if len(dm.NetworkInfo.QueryIp) > 0 && queryport > 0 && !dm.DNS.MalformedPacket {
@@ -159,11 +157,80 @@ func (s *LatencyProcessor) DetectEvictedTimeout(dm *dnsutils.DnsMessage) {
 		key := hashfnv.Sum64()
 
 		if dm.DNS.Type == dnsutils.DnsQuery {
-			s.mapQueries.Set(key, *dm)
+			s.unansweredQueries.Set(key, *dm)
 		} else {
-			if s.mapQueries.Exists(key) {
-				s.mapQueries.Delete(key)
-			}
+			s.unansweredQueries.Delete(key)
 		}
 	}
 }
+
+func (s *LatencyProcessor) expireQuery(dm *dnsutils.DnsMessage) {
+	dm.DNS.Rcode = "TIMEOUT"
+}
+
+func (s *LatencyProcessor) expireTransaction(dm *dnsutils.DnsMessage) {
+	dm.DNS.Rcode = "TIMEOUT"
+	dm.Transaction = &dnsutils.TransformTransaction{
+		Status:            TRANSACTION_TIMEOUT,
+		QueryTimestamp:    dm.DnsTap.TimestampRFC3339,
+		ResponseTimestamp: "-",
+		QueryLength:       dm.DNS.Length,
+	}
+}
+
+func (s *LatencyProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	if dm.Transaction == nil {
+		dm.Transaction = &dnsutils.TransformTransaction{
+			Status:            "-",
+			QueryTimestamp:    "-",
+			ResponseTimestamp: "-",
+		}
+	}
+}
+
+// transactionKey identifies a query and its reply, the qname, qtype and identity
+// are added to the client address and the dns id to avoid collisions
+func transactionKey(dm *dnsutils.DnsMessage) uint64 {
+	hash_data := []string{dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.Id),
+		dm.DNS.Qname, dm.DNS.Qtype, dm.DnsTap.Identity}
+
+	hashfnv := fnv.New64a()
+	hashfnv.Write([]byte(strings.Join(hash_data[:], "+")))
+	return hashfnv.Sum64()
+}
+
+// PairTransaction keeps the queries until the reply is received, the reply is enriched
+// with the query and sent as one transaction. Queries without reply are sent after the timeout.
+func (s *LatencyProcessor) PairTransaction(dm *dnsutils.DnsMessage) int {
+	// decode the records to detect the rdata errors of the malformed packets
+	dm.DecodeSections()
+
+	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
+	if len(dm.NetworkInfo.QueryIp) == 0 || queryport <= 0 || dm.DNS.MalformedPacket {
+		return RETURN_SUCCESS
+	}
+	s.InitDnsMessage(dm)
+
+	key := transactionKey(dm)
+	if dm.DNS.Type == dnsutils.DnsQuery {
+		s.transactions.Set(key, *dm)
+		return RETURN_DROP
+	}
+
+	dm.Transaction.ResponseTimestamp = dm.DnsTap.TimestampRFC3339
+	dm.Transaction.ResponseLength = dm.DNS.Length
+
+	query, ok := s.transactions.Pop(key)
+	if !ok {
+		dm.Transaction.Status = TRANSACTION_UNMATCHED
+		return RETURN_SUCCESS
+	}
+
+	latency := float64(dm.DnsTap.Timestamp-query.DnsTap.Timestamp) / float64(1000000000)
+	dm.DnsTap.Latency = latency
+	dm.Transaction.Status = TRANSACTION_ANSWERED
+	dm.Transaction.QueryTimestamp = query.DnsTap.TimestampRFC3339
+	dm.Transaction.QueryLength = query.DNS.Length
+	dm.Transaction.Latency = latency
+	return RETURN_SUCCESS
+}
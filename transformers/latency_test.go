@@ -5,6 +5,10 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
 )
 
 func Test_HashQueries(t *testing.T) {
@@ -103,3 +107,133 @@ func Benchmark_HashQueries_ConcurrentGet(b *testing.B) {
 
 	wg.Wait()
 }
+
+func TestLatency_Transaction(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.Transaction = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	latency := NewLatencySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer latency.Close()
+
+	// the query is kept
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Length = 42
+	query.DnsTap.Timestamp = 1000000000
+	query.DnsTap.TimestampRFC3339 = "query-ts"
+	if ret := latency.PairTransaction(&query); ret != RETURN_DROP {
+		t.Errorf("query should be kept until the reply, got %d", ret)
+	}
+
+	// reply of an other qname is not paired
+	reply := dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DNS.Qname = "other.collector"
+	if ret := latency.PairTransaction(&reply); ret != RETURN_SUCCESS || reply.Transaction.Status != TRANSACTION_UNMATCHED {
+		t.Errorf("reply should be unmatched, got %v", reply.Transaction)
+	}
+
+	// the reply is enriched with the query
+	reply = dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DNS.Length = 58
+	reply.DnsTap.Timestamp = 1500000000
+	reply.DnsTap.TimestampRFC3339 = "reply-ts"
+	if ret := latency.PairTransaction(&reply); ret != RETURN_SUCCESS {
+		t.Errorf("reply should be sent, got %d", ret)
+	}
+	tr := reply.Transaction
+	if tr.Status != TRANSACTION_ANSWERED || tr.QueryTimestamp != "query-ts" || tr.ResponseTimestamp != "reply-ts" ||
+		tr.QueryLength != 42 || tr.ResponseLength != 58 || tr.Latency != 0.5 || reply.DnsTap.Latency != 0.5 {
+		t.Errorf("invalid transaction: %v", tr)
+	}
+}
+
+func TestLatency_TransactionTimeout(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.Transaction = true
+	config.Latency.QueriesTimeout = 1
+
+	log := logger.New(false)
+	outChan := make(chan dnsutils.DnsMessage, 1)
+
+	// init the processor
+	latency := NewLatencySubprocessor(config, logger.New(false), "test", 0, []chan dnsutils.DnsMessage{outChan}, log.Info, log.Error)
+	defer latency.Close()
+
+	query := dnsutils.GetFakeDnsMessage()
+	query.DNS.Length = 42
+	latency.PairTransaction(&query)
+
+	select {
+	case dm := <-outChan:
+		if dm.DNS.Rcode != "TIMEOUT" || dm.Transaction.Status != TRANSACTION_TIMEOUT || dm.Transaction.QueryLength != 42 {
+			t.Errorf("invalid unanswered transaction: %s %v", dm.DNS.Rcode, dm.Transaction)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("unanswered transaction not sent")
+	}
+}
+
+// lazyMalformedReply returns a reply decoded with DecodePayloadLazy, the rdata of the
+// answer is truncated and only detected when the records are decoded
+func lazyMalformedReply(t *testing.T) dnsutils.DnsMessage {
+	q := new(dns.Msg)
+	q.SetQuestion("dns.collector.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr, _ := dns.NewRR("dns.collector. 300 IN A 192.0.2.1")
+	m.Answer = append(m.Answer, rr)
+	payload, _ := m.Pack()
+	payload[len(payload)-5] = 3
+	payload = payload[:len(payload)-1]
+
+	reply := dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DNS.Payload = payload
+	header, _ := dnsutils.DecodeDns(payload)
+	if err := dnsutils.DecodePayloadLazy(&reply, &header, dnsutils.GetFakeConfig()); err != nil || reply.DNS.MalformedPacket {
+		t.Fatalf("the reply should be malformed after decoding the records only")
+	}
+	return reply
+}
+
+func TestLatency_MalformedLazyReply(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.MeasureLatency = true
+	config.Latency.Transaction = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	latency := NewLatencySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer latency.Close()
+
+	query := dnsutils.GetFakeDnsMessage()
+	query.DnsTap.Timestamp = 1000000000
+	latency.MeasureLatency(&query)
+	latency.PairTransaction(&query)
+
+	// the malformed reply is ignored
+	reply := lazyMalformedReply(t)
+	reply.DnsTap.Timestamp = 1500000000
+	latency.MeasureLatency(&reply)
+	if !reply.DNS.MalformedPacket || reply.DnsTap.Latency != 0 {
+		t.Errorf("latency measured with a malformed reply: %v", reply.DnsTap.Latency)
+	}
+
+	reply = lazyMalformedReply(t)
+	if ret := latency.PairTransaction(&reply); ret != RETURN_SUCCESS || reply.Transaction != nil {
+		t.Errorf("malformed reply paired: %v", reply.Transaction)
+	}
+}
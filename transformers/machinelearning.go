@@ -21,10 +21,35 @@ func isConsonant(char rune) bool {
 	return true
 }
 
+// shannonEntropy returns the entropy of the string in bits per character
+func shannonEntropy(s string) float64 {
+	n := float64(len(s))
+	if n == 0 {
+		n = 1
+	}
+
+	// count number of unique chars
+	uniq := make(map[rune]int)
+	for _, c := range s {
+		uniq[c]++
+	}
+
+	// calculate the probability of occurrence for each unique character.
+	var entropy float64
+	for _, count := range uniq {
+		prob := float64(count) / n
+		if prob > 0 {
+			entropy -= prob * math.Log2(prob)
+		}
+	}
+	return entropy
+}
+
 type MlProcessor struct {
 	config      *dnsutils.ConfigTransformers
 	instance    int
 	outChannels []chan dnsutils.DnsMessage
+	model       *mlModel
 	logInfo     func(msg string, v ...interface{})
 	logError    func(msg string, v ...interface{})
 }
@@ -41,9 +66,24 @@ func NewMachineLearningSubprocessor(config *dnsutils.ConfigTransformers, logger
 		logError:    logError,
 	}
 
+	s.LoadModel()
 	return s
 }
 
+func (p *MlProcessor) LoadModel() {
+	if len(p.config.MachineLearning.ModelFile) == 0 {
+		return
+	}
+
+	model, err := loadMlModel(p.config.MachineLearning.ModelFile)
+	if err != nil {
+		p.LogError("unable to load the model: %v", err)
+		return
+	}
+	p.model = model
+	p.LogInfo("model loaded from %s", p.config.MachineLearning.ModelFile)
+}
+
 func (p *MlProcessor) LogInfo(msg string, v ...interface{}) {
 	log := fmt.Sprintf("transformer=ml#%d - ", p.instance)
 	p.logInfo(log+msg, v...)
@@ -75,6 +115,8 @@ func (p *MlProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 			Size:                  0,
 			Occurences:            0,
 			UncommonQtypes:        0,
+			Probability:           0,
+			Class:                 "-",
 		}
 	}
 }
@@ -92,25 +134,8 @@ func (p *MlProcessor) AddFeatures(dm *dnsutils.DnsMessage) {
 		n = 1
 	}
 
-	// count number of unique chars
-	uniq := make(map[rune]int)
-	for _, c := range dm.DNS.Qname {
-		uniq[c]++
-	}
-
-	// calculate the probability of occurrence for each unique character.
-	probs := make(map[rune]float64)
-	for char, count := range uniq {
-		probs[char] = float64(count) / n
-	}
-
 	// calculate the entropy
-	var entropy float64
-	for _, prob := range probs {
-		if prob > 0 {
-			entropy -= prob * math.Log2(prob)
-		}
-	}
+	entropy := shannonEntropy(dm.DNS.Qname)
 
 	// count digit
 	countDigits := 0
@@ -223,3 +248,14 @@ func (p *MlProcessor) AddFeatures(dm *dnsutils.DnsMessage) {
 	dm.MachineLearning.ConsecutiveDigits = consecutiveDigitCount
 	dm.MachineLearning.ConsecutiveConsonants = consecutiveConsonantCount
 }
+
+// Score computes the probability and the class of the message with the model,
+// the features must be added before
+func (p *MlProcessor) Score(dm *dnsutils.DnsMessage) {
+	if dm.MachineLearning == nil || p.model == nil {
+		return
+	}
+
+	dm.MachineLearning.Probability = p.model.Score(dm.MachineLearning)
+	dm.MachineLearning.Class = p.model.Class(dm.MachineLearning.Probability)
+}
@@ -0,0 +1,171 @@
+package transformers
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func writeMlModel(t *testing.T, model string) string {
+	fname := filepath.Join(t.TempDir(), "model.json")
+	if err := os.WriteFile(fname, []byte(model), 0600); err != nil {
+		t.Fatalf("unable to write model file: %v", err)
+	}
+	return fname
+}
+
+func TestMachineLearning_LogisticRegression(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.MachineLearning.Enable = true
+	config.MachineLearning.ModelFile = writeMlModel(t, `{
+		"type": "logistic-regression",
+		"features": ["entropy", "ratio-digits"],
+		"weights": [2.0, 4.0],
+		"intercept": -7.0,
+		"threshold": 0.6,
+		"labels": ["legit", "dga"]
+	}`)
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	ml := NewMachineLearningSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	testcases := []struct {
+		qname string
+		class string
+	}{
+		{qname: "www.google.com", class: "legit"},
+		{qname: "x1z9q8k7w2j5v4.com", class: "dga"},
+	}
+	for _, tc := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = tc.qname
+		ml.InitDnsMessage(&dm)
+		ml.AddFeatures(&dm)
+		ml.Score(&dm)
+
+		raw := -7.0 + 2.0*dm.MachineLearning.Entropy + 4.0*dm.MachineLearning.RatioDigits
+		expected := 1 / (1 + math.Exp(-raw))
+		if math.Abs(dm.MachineLearning.Probability-expected) > 1e-9 {
+			t.Errorf("%s: probability should be %v, got %v", tc.qname, expected, dm.MachineLearning.Probability)
+		}
+		if dm.MachineLearning.Class != tc.class {
+			t.Errorf("%s: class should be %s, got %s", tc.qname, tc.class, dm.MachineLearning.Class)
+		}
+	}
+}
+
+func TestMachineLearning_GradientBoostedTrees(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.MachineLearning.Enable = true
+	config.MachineLearning.ModelFile = writeMlModel(t, `{
+		"type": "gradient-boosted-trees",
+		"features": ["length", "digits"],
+		"base-score": -1.0,
+		"trees": [
+			[
+				{"feature": 0, "threshold": 16, "left": 1, "right": 2},
+				{"leaf": -1.0},
+				{"leaf": 1.5}
+			],
+			[
+				{"feature": 1, "threshold": 3, "left": 1, "right": 2},
+				{"leaf": -0.75},
+				{"leaf": 1.0}
+			]
+		]
+	}`)
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	ml := NewMachineLearningSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	testcases := []struct {
+		qname string
+		raw   float64
+		class string
+	}{
+		{qname: "dns.collector", raw: -1.0 - 1.0 - 0.75, class: "benign"},
+		{qname: "verylongdomainname.com", raw: -1.0 + 1.5 - 0.75, class: "benign"},
+		{qname: "a1b2c3d4e5f6g7h8.com", raw: -1.0 + 1.5 + 1.0, class: "malicious"},
+	}
+	for _, tc := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = tc.qname
+		ml.InitDnsMessage(&dm)
+		ml.AddFeatures(&dm)
+		ml.Score(&dm)
+
+		expected := 1 / (1 + math.Exp(-tc.raw))
+		if math.Abs(dm.MachineLearning.Probability-expected) > 1e-9 {
+			t.Errorf("%s: probability should be %v, got %v", tc.qname, expected, dm.MachineLearning.Probability)
+		}
+		if dm.MachineLearning.Class != tc.class {
+			t.Errorf("%s: class should be %s, got %s", tc.qname, tc.class, dm.MachineLearning.Class)
+		}
+	}
+}
+
+func TestMachineLearning_InvalidModel(t *testing.T) {
+	testcases := map[string]string{
+		"unknown type":     `{"type": "svm", "features": ["length"]}`,
+		"unknown feature":  `{"type": "logistic-regression", "features": ["foo"], "weights": [1.0]}`,
+		"missing weights":  `{"type": "logistic-regression", "features": ["length", "digits"], "weights": [1.0]}`,
+		"invalid children": `{"type": "gradient-boosted-trees", "features": ["length"], "trees": [[{"feature": 0, "left": 0, "right": 1}, {"leaf": 1.0}]]}`,
+		"invalid feature":  `{"type": "gradient-boosted-trees", "features": ["length"], "trees": [[{"feature": 1, "left": 1, "right": 2}, {"leaf": 1.0}, {"leaf": 0.0}]]}`,
+		"invalid labels":   `{"type": "logistic-regression", "features": ["length"], "weights": [1.0], "labels": ["benign"]}`,
+		"invalid json":     `{"type": `,
+	}
+	for name, model := range testcases {
+		if _, err := loadMlModel(writeMlModel(t, model)); err == nil {
+			t.Errorf("%s: an error is expected", name)
+		}
+	}
+}
+
+func TestTransformsMachineLearningFilterClass(t *testing.T) {
+	// the model is applied before the filtering, in a pipeline
+	ml := dnsutils.GetFakeConfigTransformers()
+	ml.MachineLearning.Enable = true
+	ml.MachineLearning.ModelFile = writeMlModel(t, `{
+		"type": "logistic-regression",
+		"features": ["digits"],
+		"weights": [1.0],
+		"intercept": -3.5
+	}`)
+
+	filtering := dnsutils.GetFakeConfigTransformers()
+	filtering.Filtering.Enable = true
+	filtering.Filtering.DropExpression = `ml.class == "malicious"`
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Pipeline = []dnsutils.ConfigTransformers{*ml, *filtering}
+
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	subprocessors := NewTransforms(config, logger.New(false), "test", outChans, 0)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	subprocessors.InitDnsMessageFormat(&dm)
+	if subprocessors.ProcessMessage(&dm) != RETURN_SUCCESS {
+		t.Errorf("benign message should be kept")
+	}
+
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "4f2a9c1e.example.com"
+	subprocessors.InitDnsMessageFormat(&dm)
+	if subprocessors.ProcessMessage(&dm) != RETURN_DROP {
+		t.Errorf("malicious message should be dropped")
+	}
+}
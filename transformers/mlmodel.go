@@ -0,0 +1,188 @@
+package transformers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+const (
+	ML_MODEL_LOGISTIC_REGRESSION    = "logistic-regression"
+	ML_MODEL_GRADIENT_BOOSTED_TREES = "gradient-boosted-trees"
+)
+
+// features available to the models, named as the json fields of the ml section
+var mlFeatures = map[string]func(ml *dnsutils.TransformML) float64{
+	"entropy":                func(ml *dnsutils.TransformML) float64 { return ml.Entropy },
+	"length":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Length) },
+	"labels":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Labels) },
+	"digits":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Digits) },
+	"lowers":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Lowers) },
+	"uppers":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Uppers) },
+	"specials":               func(ml *dnsutils.TransformML) float64 { return float64(ml.Specials) },
+	"others":                 func(ml *dnsutils.TransformML) float64 { return float64(ml.Others) },
+	"ratio-digits":           func(ml *dnsutils.TransformML) float64 { return ml.RatioDigits },
+	"ratio-letters":          func(ml *dnsutils.TransformML) float64 { return ml.RatioLetters },
+	"ratio-specials":         func(ml *dnsutils.TransformML) float64 { return ml.RatioSpecials },
+	"ratio-others":           func(ml *dnsutils.TransformML) float64 { return ml.RatioOthers },
+	"consecutive-chars":      func(ml *dnsutils.TransformML) float64 { return float64(ml.ConsecutiveChars) },
+	"consecutive-vowels":     func(ml *dnsutils.TransformML) float64 { return float64(ml.ConsecutiveVowels) },
+	"consecutive-digits":     func(ml *dnsutils.TransformML) float64 { return float64(ml.ConsecutiveDigits) },
+	"consecutive-consonants": func(ml *dnsutils.TransformML) float64 { return float64(ml.ConsecutiveConsonants) },
+	"size":                   func(ml *dnsutils.TransformML) float64 { return float64(ml.Size) },
+	"occurences":             func(ml *dnsutils.TransformML) float64 { return float64(ml.Occurences) },
+	"uncommon-qtypes":        func(ml *dnsutils.TransformML) float64 { return float64(ml.UncommonQtypes) },
+}
+
+// node of a decision tree, a node without children is a leaf
+type mlTreeNode struct {
+	Feature   int      `json:"feature"`
+	Threshold float64  `json:"threshold"`
+	Left      int      `json:"left"`
+	Right     int      `json:"right"`
+	Leaf      *float64 `json:"leaf"`
+}
+
+// format of the model file
+type mlModelFile struct {
+	Type      string         `json:"type"`
+	Features  []string       `json:"features"`
+	Weights   []float64      `json:"weights"`
+	Intercept float64        `json:"intercept"`
+	BaseScore float64        `json:"base-score"`
+	Trees     [][]mlTreeNode `json:"trees"`
+	Threshold *float64       `json:"threshold"`
+	Labels    []string       `json:"labels"`
+}
+
+// mlModel is a binary classifier, the probability of the positive class is computed
+// with a sigmoid of the raw score of the logistic regression or of the sum of the trees
+type mlModel struct {
+	kind      string
+	features  []func(ml *dnsutils.TransformML) float64
+	weights   []float64
+	intercept float64
+	trees     [][]mlTreeNode
+	threshold float64
+	negative  string
+	positive  string
+}
+
+func loadMlModel(fname string) (*mlModel, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var f mlModelFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid model %s: %v", fname, err)
+	}
+	return newMlModel(f)
+}
+
+func newMlModel(f mlModelFile) (*mlModel, error) {
+	m := &mlModel{kind: f.Type, threshold: 0.5, negative: "benign", positive: "malicious"}
+
+	if len(f.Features) == 0 {
+		return nil, fmt.Errorf("no features defined")
+	}
+	for _, name := range f.Features {
+		feature, ok := mlFeatures[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		m.features = append(m.features, feature)
+	}
+
+	if f.Threshold != nil {
+		if *f.Threshold < 0 || *f.Threshold > 1 {
+			return nil, fmt.Errorf("threshold must be between 0 and 1")
+		}
+		m.threshold = *f.Threshold
+	}
+	if f.Labels != nil {
+		if len(f.Labels) != 2 {
+			return nil, fmt.Errorf("two labels expected, got %d", len(f.Labels))
+		}
+		m.negative, m.positive = f.Labels[0], f.Labels[1]
+	}
+
+	switch f.Type {
+	case ML_MODEL_LOGISTIC_REGRESSION:
+		if len(f.Weights) != len(f.Features) {
+			return nil, fmt.Errorf("%d weights defined for %d features", len(f.Weights), len(f.Features))
+		}
+		m.weights = f.Weights
+		m.intercept = f.Intercept
+	case ML_MODEL_GRADIENT_BOOSTED_TREES:
+		if len(f.Trees) == 0 {
+			return nil, fmt.Errorf("no trees defined")
+		}
+		for i, tree := range f.Trees {
+			if err := checkMlTree(tree, len(f.Features)); err != nil {
+				return nil, fmt.Errorf("tree %d: %v", i, err)
+			}
+		}
+		m.trees = f.Trees
+		m.intercept = f.BaseScore
+	default:
+		return nil, fmt.Errorf("unsupported model type %q", f.Type)
+	}
+	return m, nil
+}
+
+// checkMlTree validates the nodes, the children must follow their parent
+// to ensure that the evaluation terminates
+func checkMlTree(tree []mlTreeNode, features int) error {
+	if len(tree) == 0 {
+		return fmt.Errorf("no nodes defined")
+	}
+	for i, node := range tree {
+		if node.Leaf != nil {
+			continue
+		}
+		if node.Feature < 0 || node.Feature >= features {
+			return fmt.Errorf("node %d: invalid feature index %d", i, node.Feature)
+		}
+		if node.Left <= i || node.Left >= len(tree) || node.Right <= i || node.Right >= len(tree) {
+			return fmt.Errorf("node %d: invalid children %d and %d", i, node.Left, node.Right)
+		}
+	}
+	return nil
+}
+
+// Score returns the probability of the positive class
+func (m *mlModel) Score(ml *dnsutils.TransformML) float64 {
+	raw := m.intercept
+	switch m.kind {
+	case ML_MODEL_LOGISTIC_REGRESSION:
+		for i, feature := range m.features {
+			raw += m.weights[i] * feature(ml)
+		}
+	case ML_MODEL_GRADIENT_BOOSTED_TREES:
+		for _, tree := range m.trees {
+			i := 0
+			for tree[i].Leaf == nil {
+				if m.features[tree[i].Feature](ml) < tree[i].Threshold {
+					i = tree[i].Left
+				} else {
+					i = tree[i].Right
+				}
+			}
+			raw += *tree[i].Leaf
+		}
+	}
+	return 1 / (1 + math.Exp(-raw))
+}
+
+// Class returns the label of the class according to the probability
+func (m *mlModel) Class(probability float64) string {
+	if probability >= m.threshold {
+		return m.positive
+	}
+	return m.negative
+}
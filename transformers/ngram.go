@@ -0,0 +1,105 @@
+package transformers
+
+import (
+	"math"
+	"strings"
+)
+
+// words used to train the bigram model, common english words and tokens of domain names
+var bigramCorpus = `
+about account ad admin ads agency air alert all amazon analytics and android api app apple apps archive
+art asset assets auth auto back backup bank base beta bet big bing blog blue board book books box brand
+build business buy cache calendar call camera car card care cart cast cdn center central chat check
+china city class clean click client cloud club code com common community company config connect
+contact content control cookie core corp count country courses data date deal delivery design dev
+device digital direct directory discover doc docs domain download drive east edge edu email energy
+engine events exchange express facebook family fast feed file files film finance find first fit
+flash food forum free friend front fun game games gateway global go gold good google gov graph green
+group guide health help home host hosting hot house hub image images info insight install internet
+investor item jobs join journal just key kids lab land language learn legal library life light line
+link linux list live local location login logs love mail main manager map maps market marketing
+master media member message metrics micro microsoft mobile money monitor movie music my name national
+net network new news next node note now office online open order outlook page pay people phone photo
+pictures play plus point policy portal post power press price print pro product profile project
+proxy public push quick radio read real record register relay remote report research resolver
+resource rest review room router safe sale sales school search secure security server service
+services session share shop shopping sign site smart social soft software solution sound source
+space sport sports staff stage start static station status storage store stream student studio
+style support sync system tag team tech telemetry test text time today tool tools top track trade
+traffic travel trust tube update upload user video view vpn watch weather web website welcome west
+wiki windows wireless work world www yahoo your youtube zone
+the and that have for not with you this but his from they say her she will one all would there their
+what out about who get which when make can like time just him know take people into year good some
+could them see other than then now look only come its over think also back after use two how our
+work first well way even new want because any these give day most us information through
+`
+
+const (
+	bigramBoundary = 0
+	bigramSymbols  = 39
+	// add-k smoothing of the counts
+	bigramSmoothing = 0.1
+)
+
+// bigramModel is a character bigram model of domain labels, used to estimate the likelihood
+// of a label to be made of words. Labels generated by DGA have a low likelihood.
+type bigramModel struct {
+	logProbs [bigramSymbols][bigramSymbols]float64
+}
+
+func bigramSymbol(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 1
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 1
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 27
+	case c == '-':
+		return 37
+	}
+	return 38
+}
+
+func newBigramModel(corpus string) *bigramModel {
+	var counts [bigramSymbols][bigramSymbols]float64
+	for _, word := range strings.Fields(corpus) {
+		prev := bigramBoundary
+		for i := 0; i < len(word); i++ {
+			cur := bigramSymbol(word[i])
+			counts[prev][cur]++
+			prev = cur
+		}
+		counts[prev][bigramBoundary]++
+	}
+
+	m := &bigramModel{}
+	for i := range counts {
+		total := 0.0
+		for j := range counts[i] {
+			total += counts[i][j]
+		}
+		for j := range counts[i] {
+			m.logProbs[i][j] = math.Log10((counts[i][j] + bigramSmoothing) / (total + bigramSmoothing*bigramSymbols))
+		}
+	}
+	return m
+}
+
+// Likelihood returns the average log10 probability of the transitions of the label,
+// around -1.0 to -1.6 for words and below -1.8 for random strings
+func (m *bigramModel) Likelihood(label string) float64 {
+	if len(label) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	prev := bigramBoundary
+	for i := 0; i < len(label); i++ {
+		cur := bigramSymbol(label[i])
+		sum += m.logProbs[prev][cur]
+		prev = cur
+	}
+	sum += m.logProbs[prev][bigramBoundary]
+	return sum / float64(len(label)+1)
+}
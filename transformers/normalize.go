@@ -120,6 +120,10 @@ func (p *NormalizeProcessor) LoadActiveProcessors() {
 		p.activeProcessors = append(p.activeProcessors, p.GetEffectiveTldPlusOne)
 		p.LogInfo("add tld+1 subprocessor enabled")
 	}
+	if p.config.Normalize.AddUnicode {
+		p.activeProcessors = append(p.activeProcessors, p.AddUnicode)
+		p.LogInfo("add unicode subprocessor enabled")
+	}
 }
 
 func (s *NormalizeProcessor) IsEnabled() bool {
@@ -135,6 +139,15 @@ func (p *NormalizeProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 	}
 }
 
+func (p *NormalizeProcessor) InitIdnMessage(dm *dnsutils.DnsMessage) {
+	if dm.Idn == nil {
+		dm.Idn = &dnsutils.TransformIdn{
+			QnameUnicode:   "-",
+			AnswersUnicode: []string{},
+		}
+	}
+}
+
 func (p *NormalizeProcessor) LowercaseQname(dm *dnsutils.DnsMessage) int {
 	dm.DNS.Qname = strings.ToLower(dm.DNS.Qname)
 
@@ -185,6 +198,18 @@ func (p *NormalizeProcessor) GetEffectiveTldPlusOne(dm *dnsutils.DnsMessage) int
 	return RETURN_SUCCESS
 }
 
+func (p *NormalizeProcessor) AddUnicode(dm *dnsutils.DnsMessage) int {
+	// convert punycode labels (xn--) to unicode, the ascii form is kept in the dns part
+	dm.Idn.QnameUnicode = dnsutils.IdnToUnicode(dm.DNS.Qname)
+
+	dm.DecodeSections()
+	dm.Idn.AnswersUnicode = make([]string, 0, len(dm.DNS.DnsRRs.Answers))
+	for _, rr := range dm.DNS.DnsRRs.Answers {
+		dm.Idn.AnswersUnicode = append(dm.Idn.AnswersUnicode, dnsutils.IdnToUnicode(rr.Name))
+	}
+	return RETURN_SUCCESS
+}
+
 func (p *NormalizeProcessor) ProcessDnsMessage(dm *dnsutils.DnsMessage) int {
 	if len(p.activeProcessors) == 0 {
 		return RETURN_SUCCESS
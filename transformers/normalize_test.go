@@ -198,3 +198,37 @@ func TestNormalize_AddTldPlusOne(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalize_AddUnicode(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Normalize.Enable = true
+	config.Normalize.AddUnicode = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	norm := NewNormalizeSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "www.xn--bcher-kva.de"
+	dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{
+		{Name: "www.xn--bcher-kva.de", Rdatatype: "CNAME", Rdata: "cdn.dnscollector.dev"},
+		{Name: "cdn.dnscollector.dev", Rdatatype: "A", Rdata: "192.0.2.1"},
+	}
+
+	norm.InitIdnMessage(&dm)
+	norm.ProcessDnsMessage(&dm)
+
+	if dm.DNS.Qname != "www.xn--bcher-kva.de" {
+		t.Errorf("ascii qname should be kept, got %s", dm.DNS.Qname)
+	}
+	if dm.Idn.QnameUnicode != "www.bücher.de" {
+		t.Errorf("invalid unicode qname, got %s", dm.Idn.QnameUnicode)
+	}
+	expected := []string{"www.bücher.de", "cdn.dnscollector.dev"}
+	if !reflect.DeepEqual(dm.Idn.AnswersUnicode, expected) {
+		t.Errorf("invalid unicode answers, want %v got %v", expected, dm.Idn.AnswersUnicode)
+	}
+}
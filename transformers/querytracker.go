@@ -0,0 +1,387 @@
+package transformers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+var (
+	// number of shards of the tracker, limit the lock contention
+	trackerShards = 16
+	// resolution of the timing wheel
+	trackerTick = 100 * time.Millisecond
+	// interval to log the counters when queries are evicted or events dropped
+	trackerReportInterval = time.Minute
+)
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+type trackerEntry struct {
+	key        uint64
+	dm         dnsutils.DnsMessage
+	expire     uint64
+	level      int
+	slot       int
+	prev, next *trackerEntry
+}
+
+// timingWheel is a hierarchical timing wheel: the level 0 contains the entries expiring
+// in the next 64 ticks, the level 1 the next 64*64 ticks and so on. The entries of an upper
+// level are cascaded to the lower levels when the level 0 has done a full turn.
+type timingWheel struct {
+	now   uint64
+	slots [wheelLevels][wheelSlots]*trackerEntry
+}
+
+func (w *timingWheel) add(e *trackerEntry) {
+	delta := e.expire - w.now
+	level := 0
+	for level < wheelLevels-1 && delta >= uint64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+	if max := uint64(1) << (wheelBits * wheelLevels); delta >= max {
+		// out of the wheel, stored at the farthest position
+		e.expire = w.now + max - 1
+	}
+
+	e.level = level
+	e.slot = int(e.expire>>(wheelBits*level)) & wheelMask
+	e.prev = nil
+	e.next = w.slots[level][e.slot]
+	if e.next != nil {
+		e.next.prev = e
+	}
+	w.slots[level][e.slot] = e
+}
+
+func (w *timingWheel) remove(e *trackerEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		w.slots[e.level][e.slot] = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.prev, e.next = nil, nil
+}
+
+// take detaches and returns the list of a slot
+func (w *timingWheel) take(level int, slot int) *trackerEntry {
+	head := w.slots[level][slot]
+	w.slots[level][slot] = nil
+	return head
+}
+
+// advance moves the wheel to the tick and calls expired for each entry expiring
+func (w *timingWheel) advance(to uint64, expired func(e *trackerEntry)) {
+	for w.now < to {
+		w.now++
+
+		// cascade the upper levels when the lower one has done a full turn
+		for level := 1; level < wheelLevels; level++ {
+			if (w.now>>(wheelBits*(level-1)))&wheelMask != 0 {
+				break
+			}
+			for e := w.take(level, int(w.now>>(wheelBits*level))&wheelMask); e != nil; {
+				next := e.next
+				w.add(e)
+				e = next
+			}
+		}
+
+		for e := w.take(0, int(w.now)&wheelMask); e != nil; {
+			next := e.next
+			e.prev, e.next = nil, nil
+			expired(e)
+			e = next
+		}
+	}
+}
+
+// soonest returns the entry expiring first, used to evict an entry when the shard is full
+func (w *timingWheel) soonest() *trackerEntry {
+	for level := 0; level < wheelLevels; level++ {
+		current := int(w.now>>(wheelBits*level)) & wheelMask
+		for i := 0; i < wheelSlots; i++ {
+			if e := w.slots[level][(current+i)&wheelMask]; e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+type trackerShard struct {
+	sync.Mutex
+	kv    map[uint64]*trackerEntry
+	wheel timingWheel
+}
+
+type QueriesTrackerStats struct {
+	Collector string
+	Kind      string
+	Tracked   int
+	Evicted   uint64
+	Expired   uint64
+	Dropped   uint64
+}
+
+type trackerId struct {
+	collector string
+	kind      string
+}
+
+// trackersRegistry keeps the trackers to export their counters, aggregated per collector and
+// kind of tracker. The counters of the stopped trackers are kept, a counter never decreases
+// when the tracker of a connection is stopped.
+type trackersRegistry struct {
+	sync.Mutex
+	running map[*QueriesTracker]struct{}
+	stopped map[trackerId]QueriesTrackerStats
+}
+
+var trackers = trackersRegistry{
+	running: make(map[*QueriesTracker]struct{}),
+	stopped: make(map[trackerId]QueriesTrackerStats),
+}
+
+func (r *trackersRegistry) add(t *QueriesTracker) {
+	r.Lock()
+	defer r.Unlock()
+	r.running[t] = struct{}{}
+}
+
+func (r *trackersRegistry) remove(t *QueriesTracker) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.running, t)
+
+	stats := t.Stats()
+	id := trackerId{collector: stats.Collector, kind: stats.Kind}
+	total := r.stopped[id]
+	total.Collector, total.Kind = stats.Collector, stats.Kind
+	total.Evicted += stats.Evicted
+	total.Expired += stats.Expired
+	total.Dropped += stats.Dropped
+	r.stopped[id] = total
+}
+
+// QueriesTrackersStats returns the counters of all the trackers, aggregated per collector and kind
+func QueriesTrackersStats() []QueriesTrackerStats {
+	trackers.Lock()
+	defer trackers.Unlock()
+
+	totals := make(map[trackerId]QueriesTrackerStats, len(trackers.stopped))
+	for id, stats := range trackers.stopped {
+		totals[id] = stats
+	}
+	for t := range trackers.running {
+		stats := t.Stats()
+		id := trackerId{collector: stats.Collector, kind: stats.Kind}
+		total := totals[id]
+		total.Collector, total.Kind = stats.Collector, stats.Kind
+		total.Tracked += stats.Tracked
+		total.Evicted += stats.Evicted
+		total.Expired += stats.Expired
+		total.Dropped += stats.Dropped
+		totals[id] = total
+	}
+
+	all := make([]QueriesTrackerStats, 0, len(totals))
+	for _, stats := range totals {
+		all = append(all, stats)
+	}
+	return all
+}
+
+// QueriesTracker keeps the queries waiting for their reply. The queries are sharded and
+// the timeouts are handled by a timing wheel per shard, advanced by one goroutine, instead
+// of one timer per query. The number of queries is limited, the oldest ones are evicted
+// when the limit is reached. Expired queries are sent to the channels without blocking.
+// The counters are exported with QueriesTrackersStats, by the prometheus logger.
+type QueriesTracker struct {
+	collector string
+	kind      string
+	shards    []*trackerShard
+	ttl       uint64
+	maxShard  int
+	start     time.Time
+	channels  []chan dnsutils.DnsMessage
+	onExpire  func(dm *dnsutils.DnsMessage)
+	logInfo   func(msg string, v ...interface{})
+	evicted   uint64
+	expired   uint64
+	dropped   uint64
+	stop      chan bool
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func NewQueriesTracker(collector string, kind string, ttl time.Duration, maxQueries int, channels []chan dnsutils.DnsMessage,
+	onExpire func(dm *dnsutils.DnsMessage), logInfo func(msg string, v ...interface{})) *QueriesTracker {
+	t := &QueriesTracker{
+		collector: collector,
+		kind:      kind,
+		ttl:       uint64((ttl + trackerTick - 1) / trackerTick),
+		maxShard:  (maxQueries + trackerShards - 1) / trackerShards,
+		start:     time.Now(),
+		channels:  channels,
+		onExpire:  onExpire,
+		logInfo:   logInfo,
+		stop:      make(chan bool),
+		done:      make(chan bool),
+	}
+	if t.ttl == 0 {
+		t.ttl = 1
+	}
+	if t.maxShard < 1 {
+		t.maxShard = 1
+	}
+	for i := 0; i < trackerShards; i++ {
+		t.shards = append(t.shards, &trackerShard{kv: make(map[uint64]*trackerEntry)})
+	}
+
+	trackers.add(t)
+	go t.Run()
+	return t
+}
+
+func (t *QueriesTracker) shard(key uint64) *trackerShard {
+	return t.shards[key%uint64(len(t.shards))]
+}
+
+// Set stores the query, a query already stored with the same key is replaced
+func (t *QueriesTracker) Set(key uint64, dm dnsutils.DnsMessage) {
+	s := t.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if e, ok := s.kv[key]; ok {
+		s.wheel.remove(e)
+		delete(s.kv, key)
+	} else if len(s.kv) >= t.maxShard {
+		if e := s.wheel.soonest(); e != nil {
+			s.wheel.remove(e)
+			delete(s.kv, e.key)
+			atomic.AddUint64(&t.evicted, 1)
+		}
+	}
+
+	e := &trackerEntry{key: key, dm: dm, expire: s.wheel.now + t.ttl}
+	s.wheel.add(e)
+	s.kv[key] = e
+}
+
+// Pop returns the query and removes it from the tracker
+func (t *QueriesTracker) Pop(key uint64) (dnsutils.DnsMessage, bool) {
+	s := t.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	e, ok := s.kv[key]
+	if !ok {
+		return dnsutils.DnsMessage{}, false
+	}
+	s.wheel.remove(e)
+	delete(s.kv, key)
+	return e.dm, true
+}
+
+// Delete removes the query, returns false if the query is unknown
+func (t *QueriesTracker) Delete(key uint64) bool {
+	_, ok := t.Pop(key)
+	return ok
+}
+
+func (t *QueriesTracker) Len() int {
+	n := 0
+	for _, s := range t.shards {
+		s.Lock()
+		n += len(s.kv)
+		s.Unlock()
+	}
+	return n
+}
+
+func (t *QueriesTracker) Stats() QueriesTrackerStats {
+	return QueriesTrackerStats{
+		Collector: t.collector,
+		Kind:      t.kind,
+		Tracked:   t.Len(),
+		Evicted:   atomic.LoadUint64(&t.evicted),
+		Expired:   atomic.LoadUint64(&t.expired),
+		Dropped:   atomic.LoadUint64(&t.dropped),
+	}
+}
+
+// advance moves the wheels to the current tick and sends the expired queries
+func (t *QueriesTracker) advance(now time.Time) {
+	to := uint64(now.Sub(t.start) / trackerTick)
+
+	var expired []dnsutils.DnsMessage
+	for _, s := range t.shards {
+		s.Lock()
+		s.wheel.advance(to, func(e *trackerEntry) {
+			delete(s.kv, e.key)
+			expired = append(expired, e.dm)
+		})
+		s.Unlock()
+	}
+
+	for i := range expired {
+		atomic.AddUint64(&t.expired, 1)
+		if t.onExpire != nil {
+			t.onExpire(&expired[i])
+		}
+		for j := range t.channels {
+			select {
+			case t.channels[j] <- expired[i]:
+			default:
+				atomic.AddUint64(&t.dropped, 1)
+			}
+		}
+	}
+}
+
+func (t *QueriesTracker) Run() {
+	ticker := time.NewTicker(trackerTick)
+	defer ticker.Stop()
+	report := time.NewTicker(trackerReportInterval)
+	defer report.Stop()
+
+	var lastEvicted, lastDropped uint64
+	for {
+		select {
+		case <-t.stop:
+			close(t.done)
+			return
+		case now := <-ticker.C:
+			t.advance(now)
+		case <-report.C:
+			stats := t.Stats()
+			if stats.Evicted != lastEvicted || stats.Dropped != lastDropped {
+				t.logInfo("%d queries tracked, %d evicted (limit reached), %d expired, %d timeout events dropped (channels full)",
+					stats.Tracked, stats.Evicted, stats.Expired, stats.Dropped)
+				lastEvicted, lastDropped = stats.Evicted, stats.Dropped
+			}
+		}
+	}
+}
+
+// Stop terminates the goroutine of the timing wheels
+func (t *QueriesTracker) Stop() {
+	t.closeOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+		trackers.remove(t)
+	})
+}
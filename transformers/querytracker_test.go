@@ -0,0 +1,170 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+)
+
+func TestTimingWheel_Expire(t *testing.T) {
+	w := timingWheel{}
+
+	// entries expiring on the 3 first levels and out of the wheel
+	delays := []uint64{1, 5, 63, 64, 65, 100, 4095, 4096, 5000, 300000, 1 << 30}
+	for i, d := range delays {
+		w.add(&trackerEntry{key: uint64(i), expire: w.now + d})
+	}
+
+	expired := make(map[uint64]uint64)
+	w.advance(1<<(wheelBits*wheelLevels), func(e *trackerEntry) {
+		expired[e.key] = w.now
+	})
+
+	for i, d := range delays {
+		at, ok := expired[uint64(i)]
+		if !ok {
+			t.Errorf("entry %d not expired", i)
+			continue
+		}
+		if max := uint64(1)<<(wheelBits*wheelLevels) - 1; d > max {
+			d = max
+		}
+		if at != d {
+			t.Errorf("entry %d expired at tick %d, expected %d", i, at, d)
+		}
+	}
+}
+
+func TestTimingWheel_Remove(t *testing.T) {
+	w := timingWheel{}
+	e1 := &trackerEntry{key: 1, expire: 10}
+	e2 := &trackerEntry{key: 2, expire: 10}
+	w.add(e1)
+	w.add(e2)
+	w.remove(e2)
+
+	if e := w.soonest(); e != e1 {
+		t.Errorf("soonest entry should be the first one")
+	}
+
+	n := 0
+	w.advance(20, func(e *trackerEntry) { n++ })
+	if n != 1 {
+		t.Errorf("one entry expected, got %d", n)
+	}
+}
+
+func TestQueriesTracker_Pop(t *testing.T) {
+	tracker := NewQueriesTracker("test", "test", time.Second, 100, nil, nil, func(msg string, v ...interface{}) {})
+	defer tracker.Stop()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	tracker.Set(1, dm)
+	if tracker.Len() != 1 {
+		t.Errorf("query not tracked")
+	}
+
+	query, ok := tracker.Pop(1)
+	if !ok || query.DNS.Qname != dm.DNS.Qname {
+		t.Errorf("query not found")
+	}
+	if tracker.Delete(1) || tracker.Len() != 0 {
+		t.Errorf("query should be removed")
+	}
+}
+
+func TestQueriesTracker_Evict(t *testing.T) {
+	tracker := NewQueriesTracker("test", "test", time.Minute, trackerShards, nil, nil, func(msg string, v ...interface{}) {})
+	defer tracker.Stop()
+
+	// one query per shard max, the keys 0 and trackerShards use the same shard
+	tracker.Set(0, dnsutils.GetFakeDnsMessage())
+	tracker.Set(uint64(trackerShards), dnsutils.GetFakeDnsMessage())
+
+	if _, ok := tracker.Pop(0); ok {
+		t.Errorf("the oldest query should be evicted")
+	}
+	if _, ok := tracker.Pop(uint64(trackerShards)); !ok {
+		t.Errorf("the newest query should be kept")
+	}
+	if stats := tracker.Stats(); stats.Evicted != 1 {
+		t.Errorf("one eviction expected, got %d", stats.Evicted)
+	}
+}
+
+func TestQueriesTracker_Timeout(t *testing.T) {
+	// the output channel is full, the event is dropped without blocking
+	full := make(chan dnsutils.DnsMessage)
+	out := make(chan dnsutils.DnsMessage, 1)
+	onExpire := func(dm *dnsutils.DnsMessage) { dm.DNS.Rcode = "TIMEOUT" }
+
+	tracker := NewQueriesTracker("test", "test", 200*time.Millisecond, 100, []chan dnsutils.DnsMessage{full, out}, onExpire,
+		func(msg string, v ...interface{}) {})
+	defer tracker.Stop()
+
+	tracker.Set(1, dnsutils.GetFakeDnsMessage())
+
+	select {
+	case dm := <-out:
+		if dm.DNS.Rcode != "TIMEOUT" {
+			t.Errorf("invalid rcode: %s", dm.DNS.Rcode)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout event not sent")
+	}
+
+	stats := tracker.Stats()
+	if stats.Tracked != 0 || stats.Expired != 1 || stats.Dropped != 1 {
+		t.Errorf("invalid stats: %+v", stats)
+	}
+}
+
+func BenchmarkQueriesTracker_Set(b *testing.B) {
+	tracker := NewQueriesTracker("test", "test", 2*time.Second, 100000, nil, nil, func(msg string, v ...interface{}) {})
+	defer tracker.Stop()
+	dm := dnsutils.GetFakeDnsMessage()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tracker.Set(uint64(i), dm)
+	}
+}
+
+func TestQueriesTracker_Registry(t *testing.T) {
+	out := make(chan dnsutils.DnsMessage, 1)
+	statsOf := func() (QueriesTrackerStats, bool) {
+		for _, stats := range QueriesTrackersStats() {
+			if stats.Collector == "registry" && stats.Kind == "transactions" {
+				return stats, true
+			}
+		}
+		return QueriesTrackerStats{}, false
+	}
+
+	// two trackers of the same collector, for two connections
+	tracker1 := NewQueriesTracker("registry", "transactions", time.Minute, trackerShards, []chan dnsutils.DnsMessage{out}, nil,
+		func(msg string, v ...interface{}) {})
+	tracker2 := NewQueriesTracker("registry", "transactions", time.Minute, trackerShards, []chan dnsutils.DnsMessage{out}, nil,
+		func(msg string, v ...interface{}) {})
+	defer tracker2.Stop()
+
+	// one query per shard, then one eviction for each tracker
+	for _, tracker := range []*QueriesTracker{tracker1, tracker2} {
+		for i := 0; i <= trackerShards; i++ {
+			tracker.Set(uint64(i), dnsutils.GetFakeDnsMessage())
+		}
+	}
+
+	stats, ok := statsOf()
+	if !ok || stats.Tracked != 2*trackerShards || stats.Evicted != 2 {
+		t.Errorf("invalid aggregated stats: %+v", stats)
+	}
+
+	// the counters of a stopped tracker are kept
+	tracker1.Stop()
+	stats, ok = statsOf()
+	if !ok || stats.Tracked != trackerShards || stats.Evicted != 2 {
+		t.Errorf("invalid stats after stop: %+v", stats)
+	}
+}
@@ -2,6 +2,7 @@ package transformers
 
 import (
 	"container/list"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -11,15 +12,114 @@ import (
 	publicsuffixlist "golang.org/x/net/publicsuffix"
 )
 
-type expiredKey struct {
+var (
+	REDUCER_WINDOW_TUMBLING = "tumbling"
+	REDUCER_WINDOW_SLIDING  = "sliding"
+	REDUCER_WINDOW_SESSION  = "session"
+)
+
+// reducerStats aggregates the messages of a key during a window, or during a step of a sliding window
+type reducerStats struct {
+	occurences   int
+	length       int
+	firstSeen    string
+	lastSeen     string
+	clients      map[string]struct{}
+	latencyMin   float64
+	latencyMax   float64
+	latencySum   float64
+	latencyCount int
+}
+
+func (s *reducerStats) add(dm *dnsutils.DnsMessage) {
+	if s.occurences == 0 {
+		s.firstSeen = dm.DnsTap.TimestampRFC3339
+		s.clients = make(map[string]struct{})
+	}
+	s.occurences++
+	s.length += dm.DNS.Length
+	s.lastSeen = dm.DnsTap.TimestampRFC3339
+	s.clients[dm.NetworkInfo.QueryIp] = struct{}{}
+
+	// queries have no latency
+	if latency := dm.DnsTap.Latency; latency > 0 {
+		if s.latencyCount == 0 || latency < s.latencyMin {
+			s.latencyMin = latency
+		}
+		if latency > s.latencyMax {
+			s.latencyMax = latency
+		}
+		s.latencySum += latency
+		s.latencyCount++
+	}
+}
+
+// merge adds the aggregates of the next step
+func (s *reducerStats) merge(o *reducerStats) {
+	if o.occurences == 0 {
+		return
+	}
+	if s.occurences == 0 {
+		s.firstSeen = o.firstSeen
+		s.clients = make(map[string]struct{})
+	}
+	s.occurences += o.occurences
+	s.length += o.length
+	s.lastSeen = o.lastSeen
+	for client := range o.clients {
+		s.clients[client] = struct{}{}
+	}
+	if o.latencyCount > 0 {
+		if s.latencyCount == 0 || o.latencyMin < s.latencyMin {
+			s.latencyMin = o.latencyMin
+		}
+		if o.latencyMax > s.latencyMax {
+			s.latencyMax = o.latencyMax
+		}
+		s.latencySum += o.latencySum
+		s.latencyCount += o.latencyCount
+	}
+}
+
+// message returns a copy of the first message of the key with the aggregates
+func (s *reducerStats) message(dm *dnsutils.DnsMessage) dnsutils.DnsMessage {
+	out := *dm
+	out.Reducer = &dnsutils.TransformReducer{
+		Occurences:       s.occurences,
+		CumulativeLength: s.length,
+		FirstSeen:        s.firstSeen,
+		LastSeen:         s.lastSeen,
+		DistinctClients:  len(s.clients),
+		LatencyMin:       s.latencyMin,
+		LatencyMax:       s.latencyMax,
+	}
+	if s.latencyCount > 0 {
+		out.Reducer.LatencyAvg = s.latencySum / float64(s.latencyCount)
+	}
+	return out
+}
+
+// reducerEntry aggregates the messages of one key
+type reducerEntry struct {
 	key     string
+	dm      *dnsutils.DnsMessage
 	expTime time.Time
+	maxTime time.Time
+	elem    *list.Element
+	stats   reducerStats
+	// sliding window, one aggregate per step and end of the current step
+	steps   []reducerStats
+	stepEnd time.Time
 }
 
 type MapTraffic struct {
-	sync.RWMutex
+	sync.Mutex
 	ttl          time.Duration
-	kv           *sync.Map
+	session      bool
+	maxLength    time.Duration
+	sliding      bool
+	step         time.Duration
+	kv           map[string]*reducerEntry
 	channels     []chan dnsutils.DnsMessage
 	expiredKeys  *list.List
 	droppedCount int
@@ -31,7 +131,7 @@ func NewMapTraffic(ttl time.Duration, channels []chan dnsutils.DnsMessage,
 	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{})) MapTraffic {
 	return MapTraffic{
 		ttl:         ttl,
-		kv:          &sync.Map{},
+		kv:          make(map[string]*reducerEntry),
 		channels:    channels,
 		expiredKeys: list.New(),
 		logInfo:     logInfo,
@@ -39,34 +139,85 @@ func NewMapTraffic(ttl time.Duration, channels []chan dnsutils.DnsMessage,
 	}
 }
 
+// Set aggregates the message with the previous ones of the same key. With a tumbling window,
+// the key is flushed after the watch interval; with a session window, the expiration is
+// postponed on each new message and the key is flushed when no message is seen during the interval,
+// or when the session reaches its maximum length. With a sliding window, the key is flushed
+// at each step with the messages of the last watch interval.
 func (mp *MapTraffic) Set(key string, dm *dnsutils.DnsMessage) {
 	mp.Lock()
 	defer mp.Unlock()
 
-	if v, ok := mp.kv.Load(key); ok {
-		v.(*dnsutils.DnsMessage).Reducer.Occurences++
-		v.(*dnsutils.DnsMessage).Reducer.CumulativeLength += dm.DNS.Length
+	now := time.Now()
+	if e, ok := mp.kv[key]; ok && (!mp.sliding || mp.slide(e, now)) {
+		if mp.sliding {
+			e.steps[len(e.steps)-1].add(dm)
+			return
+		}
+		e.stats.add(dm)
+		if mp.session {
+			e.expTime = now.Add(mp.ttl)
+			if e.expTime.After(e.maxTime) {
+				e.expTime = e.maxTime
+			}
+			mp.expiredKeys.MoveToBack(e.elem)
+		}
 		return
 	}
 
-	dm.Reducer.Occurences = 1
-	dm.Reducer.CumulativeLength = dm.DNS.Length
-	mp.kv.Store(key, dm)
+	e := &reducerEntry{key: key, dm: dm, expTime: now.Add(mp.ttl), maxTime: now.Add(mp.maxLength)}
+	if mp.sliding {
+		e.steps = make([]reducerStats, mp.ttl/mp.step)
+		e.stepEnd = now.Add(mp.step)
+		e.steps[len(e.steps)-1].add(dm)
+	} else {
+		e.stats.add(dm)
+		e.elem = mp.expiredKeys.PushBack(e)
+	}
+	mp.kv[key] = e
+}
 
-	expTime := time.Now().Add(mp.ttl)
-	mp.expiredKeys.PushBack(expiredKey{key, expTime})
+// slide flushes the windows ended before now, each window is made of the last steps.
+// False is returned when the window is empty and the key removed.
+func (mp *MapTraffic) slide(e *reducerEntry, now time.Time) bool {
+	for !now.Before(e.stepEnd) {
+		window := reducerStats{}
+		for i := range e.steps {
+			window.merge(&e.steps[i])
+		}
+		if window.occurences > 0 {
+			for i := range mp.channels {
+				mp.channels[i] <- window.message(e.dm)
+			}
+		}
 
+		// the oldest step leaves the window, the key is removed with its last message
+		window.occurences -= e.steps[0].occurences
+		copy(e.steps, e.steps[1:])
+		e.steps[len(e.steps)-1] = reducerStats{}
+		e.stepEnd = e.stepEnd.Add(mp.step)
+
+		if window.occurences == 0 {
+			delete(mp.kv, e.key)
+			return false
+		}
+	}
+	return true
 }
 
 func (mp *MapTraffic) Run() {
-	flushTimer := time.NewTimer(mp.ttl)
+	interval := mp.ttl
+	if mp.sliding {
+		interval = mp.step
+	}
+	flushTimer := time.NewTimer(interval)
 	for range flushTimer.C {
 		if mp.droppedCount > 0 {
 			mp.logError("reducer: event(s) %d dropped, output channel full", mp.droppedCount)
 			mp.droppedCount = 0
 		}
 		mp.ProcessExpiredKeys()
-		flushTimer.Reset(mp.ttl)
+		flushTimer.Reset(interval)
 	}
 }
 
@@ -76,18 +227,28 @@ func (mp *MapTraffic) ProcessExpiredKeys() {
 
 	now := time.Now()
 
+	if mp.sliding {
+		for _, e := range mp.kv {
+			mp.slide(e, now)
+		}
+		return
+	}
+
+	// the keys are sorted by expiration, except the sessions capped by the maximum length
+	// which can expire before the keys in front of them, then the whole list is checked
 	for e := mp.expiredKeys.Front(); e != nil; {
-		expired := e.Value.(expiredKey)
+		expired := e.Value.(*reducerEntry)
 		if now.Before(expired.expTime) {
-			break
-		}
-		key := expired.key
-		if v, ok := mp.kv.Load(key); ok {
-			for i := range mp.channels {
-				mp.channels[i] <- *v.(*dnsutils.DnsMessage)
+			if !mp.session {
+				break
 			}
-			mp.kv.Delete(key)
+			e = e.Next()
+			continue
+		}
+		for i := range mp.channels {
+			mp.channels[i] <- expired.stats.message(expired.dm)
 		}
+		delete(mp.kv, expired.key)
 
 		next := e.Next()
 		mp.expiredKeys.Remove(e)
@@ -103,6 +264,7 @@ type ReducerProcessor struct {
 	outChannels      []chan dnsutils.DnsMessage
 	activeProcessors []func(dm *dnsutils.DnsMessage) int
 	mapTraffic       MapTraffic
+	keys             []*dnsutils.Field
 	logInfo          func(msg string, v ...interface{})
 	logError         func(msg string, v ...interface{})
 	strBuilder       strings.Builder
@@ -124,11 +286,63 @@ func NewReducerSubprocessor(
 	}
 
 	s.mapTraffic = NewMapTraffic(time.Duration(config.Reducer.WatchInterval)*time.Second, outChannels, logInfo, logError)
+	if err := s.LoadKeys(); err != nil {
+		s.LogError("reducer disabled: %v", err)
+		return &s
+	}
 	s.LoadActiveReducers()
 
 	return &s
 }
 
+func (p *ReducerProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=reducer#%d - ", p.instance)
+	p.logInfo(log+msg, v...)
+}
+
+func (p *ReducerProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=reducer#%d - ", p.instance)
+	p.logError(log+msg, v...)
+}
+
+// LoadKeys compiles the fields used to build the aggregation key and selects the window.
+// An invalid key is an error, the messages would be aggregated under a partial key
+func (p *ReducerProcessor) LoadKeys() error {
+	p.keys = p.keys[:0]
+	for _, name := range p.config.Reducer.Keys {
+		field, err := dnsutils.CompileField(name)
+		if err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+		p.keys = append(p.keys, field)
+	}
+	if len(p.keys) == 0 {
+		return fmt.Errorf("no aggregation key")
+	}
+
+	p.mapTraffic.session, p.mapTraffic.sliding = false, false
+	switch p.config.Reducer.Window {
+	case REDUCER_WINDOW_SESSION:
+		if p.config.Reducer.MaxSessionLength < p.config.Reducer.WatchInterval {
+			return fmt.Errorf("max session length %ds lower than the watch interval %ds",
+				p.config.Reducer.MaxSessionLength, p.config.Reducer.WatchInterval)
+		}
+		p.mapTraffic.session = true
+		p.mapTraffic.maxLength = time.Duration(p.config.Reducer.MaxSessionLength) * time.Second
+	case REDUCER_WINDOW_SLIDING:
+		step, interval := p.config.Reducer.WindowStep, p.config.Reducer.WatchInterval
+		if step <= 0 || step > interval || interval%step != 0 {
+			return fmt.Errorf("window step %ds must divide the watch interval %ds", step, interval)
+		}
+		p.mapTraffic.sliding = true
+		p.mapTraffic.step = time.Duration(step) * time.Second
+	case REDUCER_WINDOW_TUMBLING:
+	default:
+		return fmt.Errorf("invalid window %s", p.config.Reducer.Window)
+	}
+	return nil
+}
+
 func (p *ReducerProcessor) LoadActiveReducers() {
 	if p.config.Reducer.RepetitiveTrafficDetector {
 		p.activeProcessors = append(p.activeProcessors, p.RepetitiveTrafficDetector)
@@ -141,15 +355,13 @@ func (p *ReducerProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
 		dm.Reducer = &dnsutils.TransformReducer{
 			Occurences:       0,
 			CumulativeLength: 0,
+			FirstSeen:        "-",
+			LastSeen:         "-",
 		}
 	}
 }
 
 func (p *ReducerProcessor) RepetitiveTrafficDetector(dm *dnsutils.DnsMessage) int {
-	p.strBuilder.Reset()
-	p.strBuilder.WriteString(dm.DnsTap.Identity)
-	p.strBuilder.WriteString(dm.DnsTap.Operation)
-	p.strBuilder.WriteString(dm.NetworkInfo.QueryIp)
 	if p.config.Reducer.QnamePlusOne {
 		qname := strings.ToLower(dm.DNS.Qname)
 		qname = strings.TrimSuffix(qname, ".")
@@ -157,8 +369,12 @@ func (p *ReducerProcessor) RepetitiveTrafficDetector(dm *dnsutils.DnsMessage) in
 			dm.DNS.Qname = etld
 		}
 	}
-	p.strBuilder.WriteString(dm.DNS.Qname)
-	p.strBuilder.WriteString(dm.DNS.Qtype)
+
+	p.strBuilder.Reset()
+	for _, field := range p.keys {
+		p.strBuilder.WriteString(field.Value(dm))
+		p.strBuilder.WriteByte(0)
+	}
 	dmTag := p.strBuilder.String()
 
 	p.mapTraffic.Set(dmTag, dm)
@@ -167,12 +383,12 @@ func (p *ReducerProcessor) RepetitiveTrafficDetector(dm *dnsutils.DnsMessage) in
 }
 
 func (s *ReducerProcessor) ProcessDnsMessage(dm *dnsutils.DnsMessage) int {
-	dmCopy := *dm
-
 	if len(s.activeProcessors) == 0 {
 		return RETURN_SUCCESS
 	}
 
+	dmCopy := *dm
+
 	var r_code int
 	for _, fn := range s.activeProcessors {
 		r_code = fn(&dmCopy)
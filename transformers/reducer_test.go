@@ -31,7 +31,13 @@ func TestReducer_Json(t *testing.T) {
 			{
 				"reducer": {
 				  "occurences": 0,
-				  "cumulative-length": 0
+				  "cumulative-length": 0,
+				  "first-seen": "-",
+				  "last-seen": "-",
+				  "distinct-clients": 0,
+				  "latency-min": 0,
+				  "latency-max": 0,
+				  "latency-avg": 0
 				}
 			}
 			`
@@ -236,3 +242,234 @@ func TestReducer_QnamePlusOne(t *testing.T) {
 		})
 	}
 }
+
+func TestReducer_Keys(t *testing.T) {
+	// enable feature, aggregate by qname and rcode
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.WatchInterval = 1
+	config.Reducer.Keys = []string{"dns.qname", "dns.rcode"}
+
+	log := logger.New(false)
+	outChan := make(chan dnsutils.DnsMessage, 2)
+	outChans := []chan dnsutils.DnsMessage{outChan}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dnsMessagesIn := []dnsutils.DnsMessage{
+		{
+			DnsTap:      dnsutils.DnsTap{Operation: "CLIENT_RESPONSE", TimestampRFC3339: "t1", Latency: 0.01},
+			DNS:         dnsutils.Dns{Qname: "hello.world", Qtype: "A", Rcode: "NOERROR", Length: 50},
+			NetworkInfo: dnsutils.DnsNetInfo{QueryIp: "127.0.0.1"},
+		},
+		{
+			DnsTap:      dnsutils.DnsTap{Operation: "CLIENT_RESPONSE", TimestampRFC3339: "t2", Latency: 0.03},
+			DNS:         dnsutils.Dns{Qname: "hello.world", Qtype: "AAAA", Rcode: "NOERROR", Length: 60},
+			NetworkInfo: dnsutils.DnsNetInfo{QueryIp: "127.0.0.2"},
+		},
+		{
+			DnsTap:      dnsutils.DnsTap{Operation: "CLIENT_QUERY", TimestampRFC3339: "t3"},
+			DNS:         dnsutils.Dns{Qname: "hello.world", Qtype: "A", Rcode: "NOERROR", Length: 40},
+			NetworkInfo: dnsutils.DnsNetInfo{QueryIp: "127.0.0.1"},
+		},
+		{
+			DnsTap:      dnsutils.DnsTap{Operation: "CLIENT_RESPONSE", TimestampRFC3339: "t4"},
+			DNS:         dnsutils.Dns{Qname: "hello.world", Qtype: "A", Rcode: "NXDOMAIN", Length: 40},
+			NetworkInfo: dnsutils.DnsNetInfo{QueryIp: "127.0.0.1"},
+		},
+	}
+	for _, dmIn := range dnsMessagesIn {
+		reducer.InitDnsMessage(&dmIn)
+		if ret := reducer.ProcessDnsMessage(&dmIn); ret != RETURN_DROP {
+			t.Errorf("DNS message should be dropped")
+		}
+	}
+
+	time.Sleep(1 * time.Second)
+
+	summaries := make(map[string]*dnsutils.TransformReducer)
+	for i := 0; i < 2; i++ {
+		dm := <-outChan
+		summaries[dm.DNS.Rcode] = dm.Reducer
+	}
+
+	want := dnsutils.TransformReducer{Occurences: 3, CumulativeLength: 150, FirstSeen: "t1", LastSeen: "t3",
+		DistinctClients: 2, LatencyMin: 0.01, LatencyMax: 0.03, LatencyAvg: 0.02}
+	if r := summaries["NOERROR"]; r == nil || *r != want {
+		t.Errorf("invalid summary, want %+v, got %+v", want, r)
+	}
+	if r := summaries["NXDOMAIN"]; r == nil || r.Occurences != 1 || r.FirstSeen != "t4" {
+		t.Errorf("invalid summary for nxdomain: %+v", r)
+	}
+}
+
+func TestReducer_SessionWindow(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.WatchInterval = 1
+	config.Reducer.Window = REDUCER_WINDOW_SESSION
+
+	log := logger.New(false)
+	outChan := make(chan dnsutils.DnsMessage, 1)
+	outChans := []chan dnsutils.DnsMessage{outChan}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	reducer.InitDnsMessage(&dm)
+	reducer.mapTraffic.Set("key", &dm)
+
+	// the window is extended on each message
+	for i := 0; i < 3; i++ {
+		time.Sleep(600 * time.Millisecond)
+		dmCopy := dnsutils.GetFakeDnsMessage()
+		reducer.InitDnsMessage(&dmCopy)
+		reducer.mapTraffic.Set("key", &dmCopy)
+		reducer.mapTraffic.ProcessExpiredKeys()
+		if len(outChan) != 0 {
+			t.Fatalf("the key should not be flushed while messages are received")
+		}
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	reducer.mapTraffic.ProcessExpiredKeys()
+	newDm := <-outChan
+	if newDm.Reducer.Occurences != 4 {
+		t.Errorf("4 occurences expected, got %d", newDm.Reducer.Occurences)
+	}
+}
+
+func TestReducer_SessionWindow_MaxLength(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.WatchInterval = 1
+	config.Reducer.Window = REDUCER_WINDOW_SESSION
+	config.Reducer.MaxSessionLength = 2
+
+	log := logger.New(false)
+	outChan := make(chan dnsutils.DnsMessage, 2)
+	outChans := []chan dnsutils.DnsMessage{outChan}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	// a first session, then a second one behind it in the expiration list
+	dm := dnsutils.GetFakeDnsMessage()
+	reducer.InitDnsMessage(&dm)
+	reducer.mapTraffic.Set("key", &dm)
+
+	time.Sleep(500 * time.Millisecond)
+	other := dnsutils.GetFakeDnsMessage()
+	reducer.InitDnsMessage(&other)
+	reducer.mapTraffic.Set("other", &other)
+
+	// the first session never stops to receive messages, it is flushed after the max length
+	flushed := false
+	for i := 0; i < 10 && !flushed; i++ {
+		time.Sleep(300 * time.Millisecond)
+		// the capped session is moved behind a session expiring later
+		for _, key := range []string{"other", "key"} {
+			dmCopy := dnsutils.GetFakeDnsMessage()
+			reducer.InitDnsMessage(&dmCopy)
+			reducer.mapTraffic.Set(key, &dmCopy)
+		}
+		reducer.mapTraffic.ProcessExpiredKeys()
+		flushed = len(outChan) > 0
+	}
+	if !flushed {
+		t.Fatalf("the session should be flushed after the max length")
+	}
+	newDm := <-outChan
+	if newDm.Reducer.Occurences < 5 {
+		t.Errorf("at least 5 occurences expected, got %d", newDm.Reducer.Occurences)
+	}
+}
+
+func TestReducer_InvalidKey(t *testing.T) {
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.Keys = []string{"dns.qname", "dns.unknown"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{make(chan dnsutils.DnsMessage, 1)}
+
+	// the reducer is disabled, messages are not aggregated under a partial key
+	reducer := NewReducerSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if err := reducer.LoadKeys(); err == nil {
+		t.Errorf("error expected with an invalid key")
+	}
+
+	dm := dnsutils.GetFakeDnsMessage()
+	if reducer.ProcessDnsMessage(&dm) != RETURN_SUCCESS {
+		t.Errorf("message should not be aggregated by a disabled reducer")
+	}
+
+	config.Reducer.Keys = []string{"dns.qname"}
+	config.Reducer.Window = "hopping"
+	if err := reducer.LoadKeys(); err == nil {
+		t.Errorf("error expected with an invalid window")
+	}
+
+	config.Reducer.Window = REDUCER_WINDOW_SLIDING
+	config.Reducer.WatchInterval = 5
+	config.Reducer.WindowStep = 2
+	if err := reducer.LoadKeys(); err == nil {
+		t.Errorf("error expected with a step not dividing the watch interval")
+	}
+}
+
+func TestReducer_SlidingWindow(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.WatchInterval = 2
+	config.Reducer.Window = REDUCER_WINDOW_SLIDING
+	config.Reducer.WindowStep = 1
+
+	log := logger.New(false)
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	outChans := []chan dnsutils.DnsMessage{outChan}
+
+	// init subproccesor
+	reducer := NewReducerSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	reducer.InitDnsMessage(&dm)
+	reducer.mapTraffic.Set("key", &dm)
+
+	// a second message during the second step
+	time.Sleep(1200 * time.Millisecond)
+	dmCopy := dnsutils.GetFakeDnsMessage()
+	reducer.InitDnsMessage(&dmCopy)
+	reducer.mapTraffic.Set("key", &dmCopy)
+
+	// the window is flushed at each step with the messages of the last 2 seconds
+	for i, expected := range []int{1, 2, 1} {
+		select {
+		case newDm := <-outChan:
+			if newDm.Reducer.Occurences != expected {
+				t.Errorf("window %d: %d occurences expected, got %d", i, expected, newDm.Reducer.Occurences)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("window %d not flushed", i)
+		}
+	}
+
+	// the key is removed with its last message
+	time.Sleep(1200 * time.Millisecond)
+	reducer.mapTraffic.ProcessExpiredKeys()
+	reducer.mapTraffic.Lock()
+	defer reducer.mapTraffic.Unlock()
+	if len(outChan) != 0 || len(reducer.mapTraffic.kv) != 0 {
+		t.Errorf("the key should be removed, %d message(s) flushed", len(outChan))
+	}
+}
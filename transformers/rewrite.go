@@ -0,0 +1,183 @@
+package transformers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+var (
+	REWRITE_SET       = "set"
+	REWRITE_COPY      = "copy"
+	REWRITE_RENAME    = "rename"
+	REWRITE_DELETE    = "delete"
+	REWRITE_REPLACE   = "replace"
+	REWRITE_LOWERCASE = "lowercase"
+	REWRITE_TRUNCATE  = "truncate"
+)
+
+type rewriteOperation struct {
+	action      string
+	field       *dnsutils.Field
+	target      *dnsutils.Field
+	value       string
+	regex       *regexp.Regexp
+	replacement string
+	length      int
+}
+
+type RewriteProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	operations  []rewriteOperation
+	name        string
+	instance    int
+	outChannels []chan dnsutils.DnsMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewRewriteSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DnsMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *RewriteProcessor {
+	s := RewriteProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	if config.Rewrite.Enable {
+		if err := s.LoadOperations(); err != nil {
+			// a skipped operation could export a field which must be removed
+			logger.Fatal(fmt.Sprintf("[%s] transformer=rewrite#%d - ", name, instance), err)
+		}
+	}
+
+	return &s
+}
+
+func (p *RewriteProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=rewrite#%d - ", p.instance)
+	p.logInfo(log+msg, v...)
+}
+
+func (p *RewriteProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=rewrite#%d - ", p.instance)
+	p.logError(log+msg, v...)
+}
+
+// LoadOperations compiles the operations in the configured order, an error is
+// returned if one of them is invalid
+func (p *RewriteProcessor) LoadOperations() error {
+	operations := []rewriteOperation{}
+	for i, config := range p.config.Rewrite.Operations {
+		op, err := compileRewriteOperation(config)
+		if err != nil {
+			return fmt.Errorf("invalid operation #%d: %v", i+1, err)
+		}
+		operations = append(operations, op)
+	}
+	p.operations = operations
+	p.LogInfo("%d operations loaded", len(p.operations))
+	return nil
+}
+
+func compileRewriteOperation(config dnsutils.ConfigRewriteOperation) (rewriteOperation, error) {
+	op := rewriteOperation{
+		action:      config.Action,
+		value:       config.Value,
+		replacement: config.Replacement,
+		length:      config.Length,
+	}
+
+	var err error
+	if op.action == REWRITE_DELETE {
+		// the sections can be deleted (geoip, edns, ...)
+		op.field, err = dnsutils.CompileSection(config.Field)
+	} else {
+		op.field, err = dnsutils.CompileField(config.Field)
+	}
+	if err != nil {
+		return op, err
+	}
+
+	switch op.action {
+	case REWRITE_DELETE, REWRITE_LOWERCASE:
+	case REWRITE_SET:
+		// the value is converted once to the type of the field
+		if err := op.field.CheckValue(op.value); err != nil {
+			return op, err
+		}
+	case REWRITE_COPY, REWRITE_RENAME:
+		if op.target, err = dnsutils.CompileField(config.Target); err != nil {
+			return op, fmt.Errorf("invalid target: %v", err)
+		}
+		if !op.target.Accepts(op.field) {
+			return op, fmt.Errorf("target %s can not hold the values of %s", op.target.Name(), op.field.Name())
+		}
+	case REWRITE_REPLACE:
+		if op.regex, err = regexp.Compile(config.Regex); err != nil {
+			return op, fmt.Errorf("invalid regex: %v", err)
+		}
+	case REWRITE_TRUNCATE:
+		if op.length <= 0 {
+			return op, fmt.Errorf("invalid length %d", op.length)
+		}
+	default:
+		return op, fmt.Errorf("unsupported action %q", op.action)
+	}
+	return op, nil
+}
+
+// Rewrite applies the operations in order on the message
+func (p *RewriteProcessor) Rewrite(dm *dnsutils.DnsMessage) {
+	for i := range p.operations {
+		if err := p.operations[i].apply(dm); err != nil {
+			p.LogError("%s %s: %v", p.operations[i].action, p.operations[i].field.Name(), err)
+		}
+	}
+}
+
+func (op *rewriteOperation) apply(dm *dnsutils.DnsMessage) error {
+	if op.action == REWRITE_SET {
+		return op.field.Set(dm, op.value)
+	}
+	if op.action == REWRITE_DELETE {
+		op.field.Delete(dm)
+		return nil
+	}
+
+	// the others operations are ignored when the field is not set
+	value, ok := op.field.Lookup(dm)
+	if !ok {
+		return nil
+	}
+
+	switch op.action {
+	case REWRITE_COPY:
+		return op.target.Set(dm, value)
+	case REWRITE_RENAME:
+		if err := op.target.Set(dm, value); err != nil {
+			return err
+		}
+		op.field.Delete(dm)
+		return nil
+	case REWRITE_REPLACE:
+		return op.field.Set(dm, op.regex.ReplaceAllString(value, op.replacement))
+	case REWRITE_LOWERCASE:
+		return op.field.Set(dm, strings.ToLower(value))
+	case REWRITE_TRUNCATE:
+		if runes := []rune(value); len(runes) > op.length {
+			return op.field.Set(dm, string(runes[:op.length]))
+		}
+	}
+	return nil
+}
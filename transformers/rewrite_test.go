@@ -0,0 +1,119 @@
+package transformers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestRewrite_Operations(t *testing.T) {
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Rewrite.Enable = true
+	config.Rewrite.Operations = []dnsutils.ConfigRewriteOperation{
+		{Action: REWRITE_SET, Field: "labels.fleet", Value: "eu-west"},
+		{Action: REWRITE_REPLACE, Field: "dnstap.identity", Regex: `^resolver-(\d+)\.PAR$`, Replacement: "par-$1"},
+		{Action: REWRITE_LOWERCASE, Field: "dns.qname"},
+		{Action: REWRITE_TRUNCATE, Field: "dns.qname", Length: 7},
+		{Action: REWRITE_COPY, Field: "dnstap.identity", Target: "labels.identity"},
+		{Action: REWRITE_RENAME, Field: "labels.fleet", Target: "labels.region"},
+		{Action: REWRITE_DELETE, Field: "network.query-ip"},
+		{Action: REWRITE_DELETE, Field: "geoip"},
+		// ignored when the field is not set
+		{Action: REWRITE_COPY, Field: "rpz.zone", Target: "labels.zone"},
+	}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	rewrite := NewRewriteSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	if len(rewrite.operations) != len(config.Rewrite.Operations) {
+		t.Fatalf("operations not loaded: %d", len(rewrite.operations))
+	}
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.Identity = "resolver-01.PAR"
+	dm.DNS.Qname = "WWW.DNS.COLLECTOR"
+	dm.Geo = &dnsutils.TransformDnsGeo{City: "Paris"}
+	dm.Labels = map[string]string{"site": "paris"}
+
+	// shared with the others loggers
+	shared := dm
+
+	rewrite.Rewrite(&dm)
+
+	if dm.DnsTap.Identity != "par-01" {
+		t.Errorf("invalid identity: %s", dm.DnsTap.Identity)
+	}
+	if dm.DNS.Qname != "www.dns" {
+		t.Errorf("invalid qname: %s", dm.DNS.Qname)
+	}
+	if dm.NetworkInfo.QueryIp != "" || dm.Geo != nil {
+		t.Errorf("fields not deleted: %s %v", dm.NetworkInfo.QueryIp, dm.Geo)
+	}
+	expected := map[string]string{"site": "paris", "region": "eu-west", "identity": "par-01"}
+	if !reflect.DeepEqual(dm.Labels, expected) {
+		t.Errorf("want labels %v, got %v", expected, dm.Labels)
+	}
+
+	if len(shared.Labels) != 1 || shared.Geo == nil {
+		t.Errorf("shared message updated: %v %v", shared.Labels, shared.Geo)
+	}
+}
+
+func TestRewrite_InvalidOperations(t *testing.T) {
+	log := logger.New(false)
+
+	for _, op := range []dnsutils.ConfigRewriteOperation{
+		{Action: "upper", Field: "dns.qname"},
+		{Action: REWRITE_SET, Field: "dns.unknown", Value: "-"},
+		{Action: REWRITE_SET, Field: "geoip", Value: "-"},
+		{Action: REWRITE_SET, Field: "dns.length", Value: "large"},
+		{Action: REWRITE_COPY, Field: "dns.qname"},
+		{Action: REWRITE_REPLACE, Field: "dns.qname", Regex: "("},
+		{Action: REWRITE_TRUNCATE, Field: "dns.qname"},
+		{Action: REWRITE_DELETE, Field: "dns.unknown"},
+	} {
+		// enable the transformer, the constructor refuses to start with this error
+		config := dnsutils.GetFakeConfigTransformers()
+		config.Rewrite.Enable = true
+		config.Rewrite.Operations = []dnsutils.ConfigRewriteOperation{{Action: REWRITE_LOWERCASE, Field: "dns.qname"}, op}
+
+		rewrite := &RewriteProcessor{config: config, logInfo: log.Info, logError: log.Error}
+		if err := rewrite.LoadOperations(); err == nil {
+			t.Errorf("invalid operation not detected: %+v", op)
+		}
+	}
+}
+
+func TestRewrite_TargetType(t *testing.T) {
+	log := logger.New(false)
+
+	testcases := []struct {
+		field, target string
+		valid         bool
+	}{
+		{field: "dns.qname", target: "dns.length", valid: false},
+		{field: "dns.flags.qr", target: "dns.length", valid: false},
+		{field: "dns.length", target: "dns.qname", valid: true},
+		{field: "dns.length", target: "labels.length", valid: true},
+		{field: "dns.length", target: "transaction.latency", valid: true},
+		{field: "transaction.latency", target: "dns.length", valid: false},
+		{field: "transaction.latency", target: "dnstap.latency", valid: true},
+	}
+
+	for _, tc := range testcases {
+		for _, action := range []string{REWRITE_COPY, REWRITE_RENAME} {
+			config := dnsutils.GetFakeConfigTransformers()
+			config.Rewrite.Enable = true
+			config.Rewrite.Operations = []dnsutils.ConfigRewriteOperation{{Action: action, Field: tc.field, Target: tc.target}}
+
+			rewrite := &RewriteProcessor{config: config, logInfo: log.Info, logError: log.Error}
+			if err := rewrite.LoadOperations(); (err == nil) != tc.valid {
+				t.Errorf("%s %s to %s: want valid=%v, got %v", action, tc.field, tc.target, tc.valid, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package transformers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+	"inet.af/netaddr"
+)
+
+var (
+	RPZ_TRIGGER_QNAME   = "QNAME"
+	RPZ_TRIGGER_IP      = "IP"
+	RPZ_TRIGGER_NSDNAME = "NSDNAME"
+
+	RPZ_ACTION_NXDOMAIN  = "NXDOMAIN"
+	RPZ_ACTION_NODATA    = "NODATA"
+	RPZ_ACTION_PASSTHRU  = "PASSTHRU"
+	RPZ_ACTION_DROP      = "DROP"
+	RPZ_ACTION_TCPONLY   = "TCP-ONLY"
+	RPZ_ACTION_LOCALDATA = "LOCAL-DATA"
+)
+
+type rpzRule struct {
+	rule   string
+	action string
+}
+
+// rpzZone contains the triggers of one policy zone
+type rpzZone struct {
+	name             string
+	qnames           map[string]rpzRule
+	qnameWildcards   map[string]rpzRule
+	nsdnames         map[string]rpzRule
+	nsdnameWildcards map[string]rpzRule
+	ips              map[netaddr.IPPrefix]rpzRule
+	ipBits           []uint8
+	unsupported      int
+}
+
+type RpzProcessor struct {
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	zones       []*rpzZone
+	name        string
+	instance    int
+	outChannels []chan dnsutils.DnsMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewRpzSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DnsMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *RpzProcessor {
+	d := RpzProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	if config.Rpz.Enable {
+		d.LoadZones()
+	}
+
+	return &d
+}
+
+func (p *RpzProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=rpz#%d - ", p.instance)
+	p.logInfo(log+msg, v...)
+}
+
+func (p *RpzProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=rpz#%d - ", p.instance)
+	p.logError(log+msg, v...)
+}
+
+func (p *RpzProcessor) InitDnsMessage(dm *dnsutils.DnsMessage) {
+	if dm.Rpz == nil {
+		dm.Rpz = &dnsutils.TransformRpz{
+			Zone:    "-",
+			Rule:    "-",
+			Trigger: "-",
+			Action:  "-",
+		}
+	}
+}
+
+// LoadZones loads the policy zones in the configured order, the first zone matching wins
+func (p *RpzProcessor) LoadZones() {
+	for _, cfg := range p.config.Rpz.Zones {
+		zone, err := loadRpzZone(cfg.Name, cfg.File)
+		if err != nil {
+			p.LogError("unable to load zone %s: %v", cfg.Name, err)
+			continue
+		}
+		p.zones = append(p.zones, zone)
+		p.LogInfo("zone %s loaded with %d qname, %d ip and %d nsdname triggers (%d unsupported)", zone.name,
+			len(zone.qnames)+len(zone.qnameWildcards), len(zone.ips),
+			len(zone.nsdnames)+len(zone.nsdnameWildcards), zone.unsupported)
+	}
+}
+
+func loadRpzZone(name string, fname string) (*rpzZone, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	origin := dns.CanonicalName(name)
+	zone := &rpzZone{
+		name:             strings.TrimSuffix(origin, "."),
+		qnames:           make(map[string]rpzRule),
+		qnameWildcards:   make(map[string]rpzRule),
+		nsdnames:         make(map[string]rpzRule),
+		nsdnameWildcards: make(map[string]rpzRule),
+		ips:              make(map[netaddr.IPPrefix]rpzRule),
+	}
+	bits := make(map[uint8]bool)
+
+	zp := dns.NewZoneParser(file, origin, fname)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		owner := strings.ToLower(rr.Header().Name)
+		if !strings.HasSuffix(owner, "."+origin) {
+			// SOA and NS records of the zone
+			continue
+		}
+		trigger := strings.TrimSuffix(owner, "."+origin)
+		rule := rpzRule{rule: trigger, action: rpzAction(rr)}
+
+		switch {
+		case strings.HasSuffix(trigger, ".rpz-ip"):
+			prefix, err := parseRpzIp(strings.TrimSuffix(trigger, ".rpz-ip"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid trigger %s: %v", trigger, err)
+			}
+			if _, exists := zone.ips[prefix]; !exists {
+				zone.ips[prefix] = rule
+			}
+			bits[prefix.Bits()] = true
+
+		case strings.HasSuffix(trigger, ".rpz-nsdname"):
+			addRpzName(zone.nsdnames, zone.nsdnameWildcards, strings.TrimSuffix(trigger, ".rpz-nsdname"), rule)
+
+		case strings.HasSuffix(trigger, ".rpz-client-ip"), strings.HasSuffix(trigger, ".rpz-nsip"):
+			zone.unsupported++
+
+		default:
+			addRpzName(zone.qnames, zone.qnameWildcards, trigger, rule)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	// longest prefixes first
+	for b := range bits {
+		zone.ipBits = append(zone.ipBits, b)
+	}
+	sort.Slice(zone.ipBits, func(i, j int) bool { return zone.ipBits[i] > zone.ipBits[j] })
+
+	return zone, nil
+}
+
+// addRpzName adds a name trigger, *.example.com matches only the subdomains of example.com,
+// the first record of a trigger is used when several records are defined (local data)
+func addRpzName(names map[string]rpzRule, wildcards map[string]rpzRule, name string, rule rpzRule) {
+	list := names
+	if strings.HasPrefix(name, "*.") {
+		list = wildcards
+		name = name[2:]
+	}
+	if _, exists := list[name]; !exists {
+		list[name] = rule
+	}
+}
+
+// rpzAction returns the policy action of the record, special CNAME targets are
+// used for actions, all other records are local data
+func rpzAction(rr dns.RR) string {
+	if cname, ok := rr.(*dns.CNAME); ok {
+		switch strings.ToLower(cname.Target) {
+		case ".":
+			return RPZ_ACTION_NXDOMAIN
+		case "*.":
+			return RPZ_ACTION_NODATA
+		case "rpz-passthru.":
+			return RPZ_ACTION_PASSTHRU
+		case "rpz-drop.":
+			return RPZ_ACTION_DROP
+		case "rpz-tcp-only.":
+			return RPZ_ACTION_TCPONLY
+		}
+	}
+	return RPZ_ACTION_LOCALDATA
+}
+
+// parseRpzIp decodes the prefix of a rpz-ip trigger: the prefix length
+// followed by the address in reverse order, zz is used for :: in ipv6
+// 24.0.2.0.192 => 192.0.2.0/24, 48.zz.db8.2001 => 2001:db8::/48
+func parseRpzIp(trigger string) (netaddr.IPPrefix, error) {
+	labels := strings.Split(trigger, ".")
+	if len(labels) < 2 {
+		return netaddr.IPPrefix{}, fmt.Errorf("invalid prefix")
+	}
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netaddr.IPPrefix{}, err
+	}
+
+	addr := labels[1:]
+	for i, j := 0, len(addr)-1; i < j; i, j = i+1, j-1 {
+		addr[i], addr[j] = addr[j], addr[i]
+	}
+
+	var ip string
+	if len(addr) == 4 && !strings.Contains(trigger, "zz") && bits <= 32 {
+		ip = strings.Join(addr, ".")
+	} else {
+		for i := range addr {
+			if addr[i] == "zz" {
+				addr[i] = ""
+			}
+		}
+		if addr[0] == "" {
+			addr = append([]string{""}, addr...)
+		}
+		if addr[len(addr)-1] == "" {
+			addr = append(addr, "")
+		}
+		ip = strings.Join(addr, ":")
+	}
+
+	prefix, err := netaddr.ParseIPPrefix(ip + "/" + strconv.Itoa(bits))
+	if err != nil {
+		return netaddr.IPPrefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+func (z *rpzZone) matchName(names map[string]rpzRule, wildcards map[string]rpzRule, name string) (rpzRule, bool) {
+	if rule, ok := names[name]; ok {
+		return rule, true
+	}
+	// closest wildcard first
+	for i := strings.IndexByte(name, '.'); i >= 0; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if rule, ok := wildcards[name]; ok {
+			return rule, true
+		}
+	}
+	return rpzRule{}, false
+}
+
+func (z *rpzZone) matchIp(addr string) (rpzRule, bool) {
+	ip, err := netaddr.ParseIP(addr)
+	if err != nil {
+		return rpzRule{}, false
+	}
+	for _, bits := range z.ipBits {
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if rule, ok := z.ips[prefix]; ok {
+			return rule, true
+		}
+	}
+	return rpzRule{}, false
+}
+
+// match evaluates the triggers by order of precedence: qname, response ip and nameserver name
+func (z *rpzZone) match(dm *dnsutils.DnsMessage) (string, rpzRule, bool) {
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+	if rule, ok := z.matchName(z.qnames, z.qnameWildcards, qname); ok {
+		return RPZ_TRIGGER_QNAME, rule, true
+	}
+
+	if len(z.ips) > 0 || len(z.nsdnames) > 0 || len(z.nsdnameWildcards) > 0 {
+		dm.DecodeSections()
+	}
+
+	if len(z.ips) > 0 {
+		for _, rr := range dm.DNS.DnsRRs.Answers {
+			if rr.Rdatatype != "A" && rr.Rdatatype != "AAAA" {
+				continue
+			}
+			if rule, ok := z.matchIp(rr.Rdata); ok {
+				return RPZ_TRIGGER_IP, rule, true
+			}
+		}
+	}
+
+	if len(z.nsdnames) > 0 || len(z.nsdnameWildcards) > 0 {
+		for _, records := range [][]dnsutils.DnsAnswer{dm.DNS.DnsRRs.Answers, dm.DNS.DnsRRs.Nameservers} {
+			for _, rr := range records {
+				if rr.Rdatatype != "NS" {
+					continue
+				}
+				nsdname := strings.TrimSuffix(strings.ToLower(rr.Rdata), ".")
+				if rule, ok := z.matchName(z.nsdnames, z.nsdnameWildcards, nsdname); ok {
+					return RPZ_TRIGGER_NSDNAME, rule, true
+				}
+			}
+		}
+	}
+
+	return "", rpzRule{}, false
+}
+
+// CheckRpz tags the dns message with the policy of the first zone matching,
+// the traffic is not modified
+func (p *RpzProcessor) CheckRpz(dm *dnsutils.DnsMessage) {
+	if dm.Rpz == nil {
+		p.LogError("transformer is not properly initialized")
+		return
+	}
+
+	for _, zone := range p.zones {
+		if trigger, rule, ok := zone.match(dm); ok {
+			dm.Rpz.Zone = zone.name
+			dm.Rpz.Rule = rule.rule
+			dm.Rpz.Trigger = trigger
+			dm.Rpz.Action = rule.action
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestRpz_Triggers(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Rpz.Enable = true
+	config.Rpz.Zones = []dnsutils.ConfigRpzZone{{Name: "rpz.local", File: "../testsdata/rpz.local.zone"}}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	rpz := NewRpzSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	testcases := []struct {
+		name    string
+		qname   string
+		answers []dnsutils.DnsAnswer
+		ns      []dnsutils.DnsAnswer
+		rule    string
+		trigger string
+		action  string
+	}{
+		{name: "no match", qname: "www.google.com", rule: "-", trigger: "-", action: "-"},
+		{name: "qname exact", qname: "malware.com", rule: "malware.com", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_NXDOMAIN},
+		{name: "qname wildcard", qname: "a.b.malware.com", rule: "*.malware.com", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_NXDOMAIN},
+		{name: "wildcard without apex", qname: "tracker.net", rule: "-", trigger: "-", action: "-"},
+		{name: "nodata", qname: "www.tracker.net", rule: "*.tracker.net", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_NODATA},
+		{name: "exact before wildcard", qname: "good.example.com", rule: "good.example.com", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_PASSTHRU},
+		{name: "drop", qname: "ADS.example.com.", rule: "ads.example.com", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_DROP},
+		{name: "tcp only", qname: "www.example.com", rule: "*.example.com", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_TCPONLY},
+		{name: "local data", qname: "walled.org", rule: "walled.org", trigger: RPZ_TRIGGER_QNAME, action: RPZ_ACTION_LOCALDATA},
+		{
+			name:    "ip exact",
+			qname:   "www.google.fr",
+			answers: []dnsutils.DnsAnswer{{Rdatatype: "A", Rdata: "192.0.2.1"}},
+			rule:    "32.1.2.0.192.rpz-ip", trigger: RPZ_TRIGGER_IP, action: RPZ_ACTION_NXDOMAIN,
+		},
+		{
+			name:    "ip prefix",
+			qname:   "www.google.fr",
+			answers: []dnsutils.DnsAnswer{{Rdatatype: "CNAME", Rdata: "cdn.google.fr"}, {Rdatatype: "A", Rdata: "198.51.100.200"}},
+			rule:    "24.0.100.51.198.rpz-ip", trigger: RPZ_TRIGGER_IP, action: RPZ_ACTION_NODATA,
+		},
+		{
+			name:    "ipv6 prefix",
+			qname:   "www.google.fr",
+			answers: []dnsutils.DnsAnswer{{Rdatatype: "AAAA", Rdata: "2001:db8::1"}},
+			rule:    "48.zz.db8.2001.rpz-ip", trigger: RPZ_TRIGGER_IP, action: RPZ_ACTION_DROP,
+		},
+		{
+			name:    "ip not matching",
+			qname:   "www.google.fr",
+			answers: []dnsutils.DnsAnswer{{Rdatatype: "A", Rdata: "192.0.2.2"}},
+			rule:    "-", trigger: "-", action: "-",
+		},
+		{
+			name:  "nsdname",
+			qname: "www.google.fr",
+			ns:    []dnsutils.DnsAnswer{{Rdatatype: "NS", Rdata: "ns1.evil.net"}},
+			rule:  "ns1.evil.net.rpz-nsdname", trigger: RPZ_TRIGGER_NSDNAME, action: RPZ_ACTION_NXDOMAIN,
+		},
+		{
+			name:  "nsdname wildcard",
+			qname: "www.google.fr",
+			ns:    []dnsutils.DnsAnswer{{Rdatatype: "NS", Rdata: "a.ns.bad-ns.org."}},
+			rule:  "*.bad-ns.org.rpz-nsdname", trigger: RPZ_TRIGGER_NSDNAME, action: RPZ_ACTION_DROP,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = tc.qname
+			dm.DNS.DnsRRs.Answers = tc.answers
+			dm.DNS.DnsRRs.Nameservers = tc.ns
+
+			rpz.InitDnsMessage(&dm)
+			rpz.CheckRpz(&dm)
+
+			if dm.Rpz.Rule != tc.rule || dm.Rpz.Trigger != tc.trigger || dm.Rpz.Action != tc.action {
+				t.Errorf("invalid rpz policy: %+v", dm.Rpz)
+			}
+			if tc.rule != "-" && dm.Rpz.Zone != "rpz.local" {
+				t.Errorf("invalid rpz zone: %s", dm.Rpz.Zone)
+			}
+			if dm.DNS.Qname != tc.qname {
+				t.Errorf("qname should not be modified")
+			}
+		})
+	}
+}
+
+func TestRpz_ZonesOrder(t *testing.T) {
+	// the same zone loaded twice, the first one wins
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Rpz.Enable = true
+	config.Rpz.Zones = []dnsutils.ConfigRpzZone{
+		{Name: "first.rpz", File: "../testsdata/rpz.local.zone"},
+		{Name: "second.rpz", File: "../testsdata/rpz.local.zone"},
+	}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	rpz := NewRpzSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "malware.com"
+	rpz.InitDnsMessage(&dm)
+	rpz.CheckRpz(&dm)
+
+	if dm.Rpz.Zone != "first.rpz" {
+		t.Errorf("invalid rpz zone: %s", dm.Rpz.Zone)
+	}
+}
+
+func TestRpz_ParseIp(t *testing.T) {
+	for trigger, expected := range map[string]string{
+		"32.1.2.0.192":            "192.0.2.1/32",
+		"24.0.2.0.192":            "192.0.2.0/24",
+		"128.1.zz.db8.2001":       "2001:db8::1/128",
+		"48.zz.db8.2001":          "2001:db8::/48",
+		"128.1.zz":                "::1/128",
+		"64.0.0.0.0.0.0.db8.2001": "2001:db8::/64",
+	} {
+		prefix, err := parseRpzIp(trigger)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", trigger, err)
+			continue
+		}
+		if prefix.String() != expected {
+			t.Errorf("%s: want %s got %s", trigger, expected, prefix.String())
+		}
+	}
+
+	for _, trigger := range []string{"32", "a.1.2.0.192", "33.1.2.0.192"} {
+		if _, err := parseRpzIp(trigger); err == nil {
+			t.Errorf("%s: error expected", trigger)
+		}
+	}
+}
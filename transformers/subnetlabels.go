@@ -0,0 +1,266 @@
+package transformers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+	"inet.af/netaddr"
+)
+
+// subnetLabelsTable contains the attributes of the subnets, looked up with the longest prefix first
+type subnetLabelsTable struct {
+	prefixes map[netaddr.IPPrefix]map[string]string
+	bits     []uint8
+}
+
+func (t *subnetLabelsTable) Lookup(addr string) (map[string]string, bool) {
+	ip, err := netaddr.ParseIP(addr)
+	if err != nil {
+		return nil, false
+	}
+	for _, bits := range t.bits {
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if attributes, ok := t.prefixes[prefix]; ok {
+			return attributes, true
+		}
+	}
+	return nil, false
+}
+
+type SubnetLabelsProcessor struct {
+	sync.RWMutex
+	config      *dnsutils.ConfigTransformers
+	logger      *logger.Logger
+	files       map[string]map[netaddr.IPPrefix]map[string]string
+	table       *subnetLabelsTable
+	fileWatcher *FileWatcher
+	name        string
+	instance    int
+	outChannels []chan dnsutils.DnsMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewSubnetLabelsSubprocessor(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DnsMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *SubnetLabelsProcessor {
+	d := SubnetLabelsProcessor{
+		config:      config,
+		logger:      logger,
+		files:       make(map[string]map[netaddr.IPPrefix]map[string]string),
+		table:       &subnetLabelsTable{},
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	if config.SubnetLabels.Enable {
+		d.LoadFiles()
+		d.WatchFiles()
+	}
+
+	return &d
+}
+
+func (p *SubnetLabelsProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=subnet-labels#%d - ", p.instance)
+	p.logInfo(log+msg, v...)
+}
+
+func (p *SubnetLabelsProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=subnet-labels#%d - ", p.instance)
+	p.logError(log+msg, v...)
+}
+
+func (p *SubnetLabelsProcessor) LoadFiles() {
+	for _, fname := range p.config.SubnetLabels.Files {
+		if err := p.LoadFile(fname); err != nil {
+			p.LogError("unable to load %s: %v", fname, err)
+		}
+	}
+}
+
+// LoadFile loads the mapping file and rebuilds the table, the previous
+// mapping of the file is kept if the file is invalid
+func (p *SubnetLabelsProcessor) LoadFile(fname string) error {
+	prefixes, renamed, err := loadSubnetLabelsFile(fname)
+	if err != nil {
+		return err
+	}
+	for name, label := range renamed {
+		p.LogInfo("%s: label %s renamed to %s", fname, name, label)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.files[filepath.Clean(fname)] = prefixes
+
+	// the files are merged in the configured order, the last file wins for a same subnet
+	table := &subnetLabelsTable{prefixes: make(map[netaddr.IPPrefix]map[string]string)}
+	bits := make(map[uint8]bool)
+	for _, f := range p.config.SubnetLabels.Files {
+		for prefix, attributes := range p.files[filepath.Clean(f)] {
+			table.prefixes[prefix] = attributes
+			bits[prefix.Bits()] = true
+		}
+	}
+
+	// longest prefixes first
+	for b := range bits {
+		table.bits = append(table.bits, b)
+	}
+	sort.Slice(table.bits, func(i, j int) bool { return table.bits[i] > table.bits[j] })
+	p.table = table
+
+	p.LogInfo("%s loaded with %d subnets", fname, len(prefixes))
+	return nil
+}
+
+// loadSubnetLabelsFile reads a json file (subnet to attributes object) or a csv file
+// with a header, the first column contains the subnets and the others the attributes.
+// The names of the attributes are sanitized to be valid prometheus and loki label names,
+// the renamed attributes are returned.
+func loadSubnetLabelsFile(fname string) (map[netaddr.IPPrefix]map[string]string, map[string]string, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	subnets := make(map[string]map[string]string)
+	if strings.ToLower(filepath.Ext(fname)) == ".json" {
+		if err := json.NewDecoder(file).Decode(&subnets); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		reader := csv.NewReader(file)
+		reader.Comment = '#'
+		reader.TrimLeadingSpace = true
+
+		header, err := reader.Read()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid header: %v", err)
+		}
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			attributes := make(map[string]string)
+			for i := 1; i < len(record); i++ {
+				if len(record[i]) > 0 {
+					attributes[header[i]] = record[i]
+				}
+			}
+			subnets[record[0]] = attributes
+		}
+	}
+
+	prefixes := make(map[netaddr.IPPrefix]map[string]string)
+	renamed := make(map[string]string)
+	for subnet, attributes := range subnets {
+		prefix, err := parseSubnet(subnet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid subnet %s: %v", subnet, err)
+		}
+		labels := make(map[string]string, len(attributes))
+		for name, value := range attributes {
+			if len(name) == 0 {
+				return nil, nil, fmt.Errorf("empty label name for subnet %s", subnet)
+			}
+			label := dnsutils.SanitizeLabelName(name)
+			if label != name {
+				renamed[name] = label
+			}
+			labels[label] = value
+		}
+		prefixes[prefix] = labels
+	}
+	return prefixes, renamed, nil
+}
+
+// parseSubnet parses a prefix or an address, considered as a full length prefix
+func parseSubnet(subnet string) (netaddr.IPPrefix, error) {
+	subnet = strings.TrimSpace(subnet)
+	if !strings.Contains(subnet, "/") {
+		ip, err := netaddr.ParseIP(subnet)
+		if err != nil {
+			return netaddr.IPPrefix{}, err
+		}
+		return ip.Prefix(uint8(ip.BitLen()))
+	}
+	prefix, err := netaddr.ParseIPPrefix(subnet)
+	if err != nil {
+		return netaddr.IPPrefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// Lookup returns the attributes of the longest subnet containing the address
+func (p *SubnetLabelsProcessor) Lookup(addr string) (map[string]string, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	return p.table.Lookup(addr)
+}
+
+// AddLabels adds the attributes of the subnet of the query ip to the labels of the message,
+// the labels are copied before the update, they are shared with the others loggers
+func (p *SubnetLabelsProcessor) AddLabels(dm *dnsutils.DnsMessage) {
+	attributes, ok := p.Lookup(dm.NetworkInfo.QueryIp)
+	if !ok {
+		return
+	}
+
+	labels := make(map[string]string, len(dm.Labels)+len(attributes))
+	for k, v := range dm.Labels {
+		labels[k] = v
+	}
+	for k, v := range attributes {
+		labels[k] = v
+	}
+	dm.Labels = labels
+}
+
+// WatchFiles reloads the mapping files when they are modified or replaced
+func (p *SubnetLabelsProcessor) WatchFiles() {
+	if len(p.config.SubnetLabels.Files) == 0 {
+		return
+	}
+
+	watcher, err := NewFileWatcher(p.config.SubnetLabels.Files, p.reloadFile, p.LogError)
+	if err != nil {
+		p.LogError("unable to watch files: %v", err)
+		return
+	}
+	p.fileWatcher = watcher
+}
+
+func (p *SubnetLabelsProcessor) reloadFile(fname string) {
+	if err := p.LoadFile(fname); err != nil {
+		p.LogError("unable to reload %s, previous mapping is kept: %v", fname, err)
+	}
+}
+
+func (p *SubnetLabelsProcessor) Close() {
+	if p.fileWatcher != nil {
+		p.fileWatcher.Close()
+	}
+}
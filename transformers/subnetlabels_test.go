@@ -0,0 +1,197 @@
+package transformers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func writeTestFile(t *testing.T, fname string, content string) {
+	tmp := fname + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, fname); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubnetLabels_LongestPrefix(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "# inventory\nsubnet,site,vlan\n10.0.0.0/8,paris,\n10.1.0.0/16,lyon,20\n2001:db8::/32,nantes,30\n192.168.1.1,home,1\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	testcases := []struct {
+		ip     string
+		labels map[string]string
+	}{
+		{ip: "10.2.3.4", labels: map[string]string{"site": "paris"}},
+		{ip: "10.1.3.4", labels: map[string]string{"site": "lyon", "vlan": "20"}},
+		{ip: "2001:db8::1", labels: map[string]string{"site": "nantes", "vlan": "30"}},
+		{ip: "192.168.1.1", labels: map[string]string{"site": "home", "vlan": "1"}},
+		{ip: "192.168.1.2", labels: nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.ip, func(t *testing.T) {
+			labels, _ := subnets.Lookup(tc.ip)
+			if !reflect.DeepEqual(labels, tc.labels) {
+				t.Errorf("want %v, got %v", tc.labels, labels)
+			}
+		})
+	}
+}
+
+func TestSubnetLabels_MergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "subnets.csv")
+	jsonFile := filepath.Join(dir, "subnets.json")
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/8,paris\n10.1.0.0/16,lyon\n")
+	writeTestFile(t, jsonFile, `{"10.1.0.0/16": {"site": "marseille", "owner": "netops"}}`)
+
+	// enable the transformer, the last file wins
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile, jsonFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	if labels, _ := subnets.Lookup("10.1.0.1"); !reflect.DeepEqual(labels, map[string]string{"site": "marseille", "owner": "netops"}) {
+		t.Errorf("invalid labels for 10.1.0.1: %v", labels)
+	}
+	if labels, _ := subnets.Lookup("10.2.0.1"); !reflect.DeepEqual(labels, map[string]string{"site": "paris"}) {
+		t.Errorf("invalid labels for 10.2.0.1: %v", labels)
+	}
+}
+
+func TestSubnetLabels_SanitizeNames(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,device-owner,site.name,1vlan\n10.0.0.0/8,netops,paris,20\n")
+	jsonFile := filepath.Join(t.TempDir(), "subnets.json")
+	writeTestFile(t, jsonFile, `{"10.1.0.0/16": {"device-owner": "sysops"}}`)
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile, jsonFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	// the names are valid prometheus and loki label names
+	want := map[string]string{"device_owner": "netops", "site_name": "paris", "_vlan": "20"}
+	if labels, _ := subnets.Lookup("10.2.0.1"); !reflect.DeepEqual(labels, want) {
+		t.Errorf("want %v, got %v", want, labels)
+	}
+	want = map[string]string{"device_owner": "sysops"}
+	if labels, _ := subnets.Lookup("10.1.0.1"); !reflect.DeepEqual(labels, want) {
+		t.Errorf("want %v, got %v", want, labels)
+	}
+}
+
+func TestSubnetLabels_InvalidFile(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/8,paris\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	// the previous mapping is kept
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/33,lyon\n")
+	if err := subnets.LoadFile(csvFile); err == nil {
+		t.Errorf("invalid subnet not detected")
+	}
+	if labels, _ := subnets.Lookup("10.2.0.1"); !reflect.DeepEqual(labels, map[string]string{"site": "paris"}) {
+		t.Errorf("previous mapping not kept: %v", labels)
+	}
+}
+
+func TestSubnetLabels_AddLabels(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/8,paris\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	// the labels are shared with the others loggers and must not be updated
+	shared := map[string]string{"env": "prod"}
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "10.0.0.1"
+	dm.Labels = shared
+
+	subnets.AddLabels(&dm)
+	if !reflect.DeepEqual(dm.Labels, map[string]string{"env": "prod", "site": "paris"}) {
+		t.Errorf("invalid labels: %v", dm.Labels)
+	}
+	if len(shared) != 1 {
+		t.Errorf("shared labels updated: %v", shared)
+	}
+}
+
+func TestSubnetLabels_WatchFiles(t *testing.T) {
+	defer func(delay time.Duration) { fileWatcherReloadDelay = delay }(fileWatcherReloadDelay)
+	fileWatcherReloadDelay = 10 * time.Millisecond
+
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/8,paris\n")
+
+	// enable the transformer
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	subnets := NewSubnetLabelsSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	defer subnets.Close()
+
+	// update the file
+	writeTestFile(t, csvFile, "subnet,site\n10.0.0.0/8,lyon\n")
+
+	for i := 0; i < 100; i++ {
+		if labels, _ := subnets.Lookup("10.0.0.1"); labels["site"] == "lyon" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("mapping not reloaded")
+}
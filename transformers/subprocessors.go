@@ -20,16 +20,23 @@ type Transforms struct {
 	instance int
 
 	SuspiciousTransform      SuspiciousTransform
-	GeoipTransform           GeoIpProcessor
-	FilteringTransform       FilteringProcessor
+	GeoipTransform           *GeoIpProcessor
+	FilteringTransform       *FilteringProcessor
 	UserPrivacyTransform     UserPrivacyProcessor
 	NormalizeTransform       NormalizeProcessor
 	LatencyTransform         *LatencyProcessor
 	ReducerTransform         *ReducerProcessor
 	ExtractProcessor         ExtractProcessor
 	MachineLearningTransform MlProcessor
+	RpzTransform             *RpzProcessor
+	SubnetLabelsTransform    *SubnetLabelsProcessor
+	ClientNamesTransform     *ClientNamesProcessor
+	RewriteTransform         *RewriteProcessor
 
 	activeTransforms []func(dm *dnsutils.DnsMessage) int
+
+	// transformers defined as an ordered list, applied one after the other
+	pipeline []Transforms
 }
 
 func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DnsMessage, instance int) Transforms {
@@ -41,6 +48,14 @@ func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, n
 		instance: instance,
 	}
 
+	// ordered list, each item enables one transformer applied with the default order
+	if len(config.Pipeline) > 0 {
+		for i := range config.Pipeline {
+			d.pipeline = append(d.pipeline, NewTransforms(&config.Pipeline[i], logger, name, outChannels, instance))
+		}
+		return d
+	}
+
 	d.SuspiciousTransform = NewSuspiciousSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.NormalizeTransform = NewNormalizeSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.ExtractProcessor = NewExtractSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
@@ -50,6 +65,10 @@ func NewTransforms(config *dnsutils.ConfigTransformers, logger *logger.Logger, n
 	d.FilteringTransform = NewFilteringProcessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.GeoipTransform = NewDnsGeoIpProcessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.MachineLearningTransform = NewMachineLearningSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.RpzTransform = NewRpzSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.SubnetLabelsTransform = NewSubnetLabelsSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.ClientNamesTransform = NewClientNamesSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.RewriteTransform = NewRewriteSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 
 	d.Prepare()
 	return d
@@ -64,9 +83,32 @@ func (p *Transforms) Prepare() error {
 
 		if err := p.GeoipTransform.Open(); err != nil {
 			p.LogError(prefixlog+"open error %v", err)
+		} else {
+			p.GeoipTransform.WatchFiles()
 		}
 	}
 
+	if p.config.SubnetLabels.Enable {
+		// before user privacy, to match the original query ip
+		p.activeTransforms = append(p.activeTransforms, p.subnetLabelsTransform)
+		prefixlog := fmt.Sprintf("transformer=subnet-labels#%d - ", p.instance)
+		p.LogInfo(prefixlog + "is enabled")
+	}
+
+	if p.config.ClientNames.Enable {
+		// before user privacy, the leases and hosts tables are keyed by the real client address
+		p.activeTransforms = append(p.activeTransforms, p.clientNamesTransform)
+		prefixlog := fmt.Sprintf("transformer=client-names#%d - ", p.instance)
+		p.LogInfo(prefixlog + "is enabled")
+	}
+
+	if p.config.Rpz.Enable {
+		// before user privacy, to evaluate the policies on the original qname
+		p.activeTransforms = append(p.activeTransforms, p.rpzTransform)
+		prefixlog := fmt.Sprintf("transformer=rpz#%d - ", p.instance)
+		p.LogInfo(prefixlog + "is enabled")
+	}
+
 	if p.config.UserPrivacy.Enable {
 		// Apply user privacy on qname and query ip
 		if p.config.UserPrivacy.AnonymizeIP {
@@ -94,15 +136,22 @@ func (p *Transforms) Prepare() error {
 	}
 
 	if p.config.Latency.Enable {
-		if p.config.Latency.MeasureLatency {
-			p.activeTransforms = append(p.activeTransforms, p.measureLatency)
+		// the transaction mode measures the latency and detects the unanswered queries too
+		if p.config.Latency.Transaction {
+			p.activeTransforms = append(p.activeTransforms, p.pairTransaction)
 			prefixlog := fmt.Sprintf("transformer=latency#%d - ", p.instance)
-			p.LogInfo(prefixlog + "subprocessor measure latency is enabled")
-		}
-		if p.config.Latency.UnansweredQueries {
-			p.activeTransforms = append(p.activeTransforms, p.detectEvictedTimeout)
-			prefixlog := fmt.Sprintf("transformer=latency#%d - ", p.instance)
-			p.LogInfo(prefixlog + "subprocessor unanswered queries is enabled")
+			p.LogInfo(prefixlog + "subprocessor transaction is enabled")
+		} else {
+			if p.config.Latency.MeasureLatency {
+				p.activeTransforms = append(p.activeTransforms, p.measureLatency)
+				prefixlog := fmt.Sprintf("transformer=latency#%d - ", p.instance)
+				p.LogInfo(prefixlog + "subprocessor measure latency is enabled")
+			}
+			if p.config.Latency.UnansweredQueries {
+				p.activeTransforms = append(p.activeTransforms, p.detectEvictedTimeout)
+				prefixlog := fmt.Sprintf("transformer=latency#%d - ", p.instance)
+				p.LogInfo(prefixlog + "subprocessor unanswered queries is enabled")
+			}
 		}
 	}
 
@@ -132,10 +181,21 @@ func (p *Transforms) Prepare() error {
 		p.LogInfo(prefixlog + "is enabled")
 	}
 
+	if p.config.Rewrite.Enable {
+		// at the end, to rewrite the fields added by the others transformers before the export
+		p.activeTransforms = append(p.activeTransforms, p.rewriteTransform)
+		prefixlog := fmt.Sprintf("transformer=rewrite#%d - ", p.instance)
+		p.LogInfo(prefixlog + "is enabled")
+	}
+
 	return nil
 }
 
 func (p *Transforms) InitDnsMessageFormat(dm *dnsutils.DnsMessage) {
+	for i := range p.pipeline {
+		p.pipeline[i].InitDnsMessageFormat(dm)
+	}
+
 	if p.config.GeoIP.Enable {
 		p.GeoipTransform.InitDnsMessage(dm)
 	}
@@ -146,6 +206,9 @@ func (p *Transforms) InitDnsMessageFormat(dm *dnsutils.DnsMessage) {
 		if p.config.Normalize.AddTld || p.config.Normalize.AddTldPlusOne {
 			p.NormalizeTransform.InitDnsMessage(dm)
 		}
+		if p.config.Normalize.AddUnicode {
+			p.NormalizeTransform.InitIdnMessage(dm)
+		}
 	}
 	if p.config.Extract.Enable {
 		if p.config.Extract.AddPayload {
@@ -158,12 +221,37 @@ func (p *Transforms) InitDnsMessageFormat(dm *dnsutils.DnsMessage) {
 	if p.config.MachineLearning.Enable {
 		p.MachineLearningTransform.InitDnsMessage(dm)
 	}
+	if p.config.Rpz.Enable {
+		p.RpzTransform.InitDnsMessage(dm)
+	}
+	if p.config.ClientNames.Enable {
+		p.ClientNamesTransform.InitDnsMessage(dm)
+	}
+	if p.config.Latency.Enable && p.config.Latency.Transaction {
+		p.LatencyTransform.InitDnsMessage(dm)
+	}
 }
 
 func (p *Transforms) Reset() {
+	for i := range p.pipeline {
+		p.pipeline[i].Reset()
+	}
+
 	if p.config.GeoIP.Enable {
 		p.GeoipTransform.Close()
 	}
+	if p.config.Filtering.Enable {
+		p.FilteringTransform.Close()
+	}
+	if p.config.SubnetLabels.Enable {
+		p.SubnetLabelsTransform.Close()
+	}
+	if p.config.ClientNames.Enable {
+		p.ClientNamesTransform.Close()
+	}
+	if p.config.Latency.Enable {
+		p.LatencyTransform.Close()
+	}
 }
 
 func (p *Transforms) LogInfo(msg string, v ...interface{}) {
@@ -177,6 +265,27 @@ func (p *Transforms) LogError(msg string, v ...interface{}) {
 // transform functions: return code
 func (p *Transforms) machineLearningTransform(dm *dnsutils.DnsMessage) int {
 	p.MachineLearningTransform.AddFeatures(dm)
+	p.MachineLearningTransform.Score(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) subnetLabelsTransform(dm *dnsutils.DnsMessage) int {
+	p.SubnetLabelsTransform.AddLabels(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) rewriteTransform(dm *dnsutils.DnsMessage) int {
+	p.RewriteTransform.Rewrite(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) clientNamesTransform(dm *dnsutils.DnsMessage) int {
+	p.ClientNamesTransform.AddClient(dm)
+	return RETURN_SUCCESS
+}
+
+func (p *Transforms) rpzTransform(dm *dnsutils.DnsMessage) int {
+	p.RpzTransform.CheckRpz(dm)
 	return RETURN_SUCCESS
 }
 
@@ -197,6 +306,25 @@ func (p *Transforms) geoipTransform(dm *dnsutils.DnsMessage) int {
 	dm.Geo.City = geoInfo.City
 	dm.Geo.AutonomousSystemNumber = geoInfo.ASN
 	dm.Geo.AutonomousSystemOrg = geoInfo.ASO
+	dm.Geo.SubdivisionIsoCode = geoInfo.Subdivision
+	dm.Geo.Latitude = geoInfo.Latitude
+	dm.Geo.Longitude = geoInfo.Longitude
+
+	if p.config.GeoIP.LookupResponseIp {
+		dm.Geo.ResponseIp, err = p.GeoipTransform.LookupResponseIp(dm)
+		if err != nil {
+			p.LogError("geoip lookup error %v", err)
+			return RETURN_ERROR
+		}
+	}
+
+	if p.config.GeoIP.LookupAnswers {
+		dm.Geo.Answers, err = p.GeoipTransform.LookupAnswers(dm)
+		if err != nil {
+			p.LogError("geoip lookup error %v", err)
+			return RETURN_ERROR
+		}
+	}
 
 	return RETURN_SUCCESS
 }
@@ -204,6 +332,46 @@ func (p *Transforms) geoipTransform(dm *dnsutils.DnsMessage) int {
 func (p *Transforms) anonymizeIP(dm *dnsutils.DnsMessage) int {
 	dm.NetworkInfo.QueryIp = p.UserPrivacyTransform.AnonymizeIP(dm.NetworkInfo.QueryIp)
 
+	if p.config.UserPrivacy.AnonymizeResponseIP {
+		dm.NetworkInfo.ResponseIp = p.UserPrivacyTransform.AnonymizeIP(dm.NetworkInfo.ResponseIp)
+	}
+
+	if p.config.UserPrivacy.AnonymizeRdata || p.config.UserPrivacy.AnonymizeClientSubnet {
+		dm.DecodeSections()
+	}
+
+	// slices are copied before the update, they are shared with the others loggers
+	updated := false
+	if p.config.UserPrivacy.AnonymizeRdata && len(dm.DNS.DnsRRs.Answers) > 0 {
+		answers := make([]dnsutils.DnsAnswer, len(dm.DNS.DnsRRs.Answers))
+		copy(answers, dm.DNS.DnsRRs.Answers)
+		for i := range answers {
+			if answers[i].Rdatatype == "A" || answers[i].Rdatatype == "AAAA" {
+				answers[i].Rdata = p.UserPrivacyTransform.AnonymizeIP(answers[i].Rdata)
+				updated = true
+			}
+		}
+		dm.DNS.DnsRRs.Answers = answers
+	}
+
+	if p.config.UserPrivacy.AnonymizeClientSubnet && len(dm.EDNS.Options) > 0 {
+		options := make([]dnsutils.DnsOption, len(dm.EDNS.Options))
+		copy(options, dm.EDNS.Options)
+		for i := range options {
+			if options[i].Name == "CSUBNET" {
+				options[i].Data = p.UserPrivacyTransform.AnonymizeSubnet(options[i].Data)
+				updated = true
+			}
+		}
+		dm.EDNS.Options = options
+	}
+
+	// the original payload still contains the addresses, it is encoded
+	// again from the anonymized fields when needed (dnstap, pcap, extract)
+	if updated {
+		dm.DNS.Payload = nil
+	}
+
 	return RETURN_SUCCESS
 }
 
@@ -213,6 +381,10 @@ func (p *Transforms) hashIP(dm *dnsutils.DnsMessage) int {
 	return RETURN_SUCCESS
 }
 
+func (p *Transforms) pairTransaction(dm *dnsutils.DnsMessage) int {
+	return p.LatencyTransform.PairTransaction(dm)
+}
+
 func (p *Transforms) measureLatency(dm *dnsutils.DnsMessage) int {
 	p.LatencyTransform.MeasureLatency(dm)
 	return RETURN_SUCCESS
@@ -230,6 +402,16 @@ func (p *Transforms) minimazeQname(dm *dnsutils.DnsMessage) int {
 }
 
 func (p *Transforms) ProcessMessage(dm *dnsutils.DnsMessage) int {
+	// transformers defined in order
+	if len(p.pipeline) > 0 {
+		for i := range p.pipeline {
+			if r_code := p.pipeline[i].ProcessMessage(dm); r_code != RETURN_SUCCESS {
+				return r_code
+			}
+		}
+		return RETURN_SUCCESS
+	}
+
 	// Begin to normalize
 	p.NormalizeTransform.ProcessDnsMessage(dm)
 
@@ -255,6 +437,18 @@ func (p *Transforms) ProcessMessage(dm *dnsutils.DnsMessage) int {
 	return RETURN_SUCCESS
 }
 
+// ApplyTransforms inits and transforms the message like InitDnsMessageFormat and ProcessMessage,
+// on a copy taken from the pool: the message of the caller is updated but not moved to the heap.
+func (p *Transforms) ApplyTransforms(dm *dnsutils.DnsMessage) int {
+	pdm := dnsutils.AcquireDnsMessage()
+	*pdm = *dm
+	p.InitDnsMessageFormat(pdm)
+	r_code := p.ProcessMessage(pdm)
+	*dm = *pdm
+	dnsutils.ReleaseDnsMessage(pdm)
+	return r_code
+}
+
 func (p *Transforms) addBase64Payload(dm *dnsutils.DnsMessage) int {
 	dm.Extracted.Base64Payload = p.ExtractProcessor.AddBase64Payload(dm)
 	return RETURN_SUCCESS
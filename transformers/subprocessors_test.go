@@ -1,10 +1,14 @@
 package transformers
 
 import (
+	"net"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
 )
 
 const (
@@ -104,6 +108,168 @@ func TestTransformsGeoIPLookupAsn(t *testing.T) {
 	}
 }
 
+func TestTransformsGeoIPLookupResponseAndAnswers(t *testing.T) {
+	// enable geoip
+	config := dnsutils.GetFakeConfigTransformers()
+	config.GeoIP.Enable = true
+	config.GeoIP.LookupResponseIp = true
+	config.GeoIP.LookupAnswers = true
+	config.GeoIP.DbCityFile = filepath.Join(t.TempDir(), "city.mmdb")
+	config.GeoIP.DbAsnFile = filepath.Join(t.TempDir(), "asn.mmdb")
+	writeTestMmdb(t, config.GeoIP.DbCityFile, testMmdbCity("FR"))
+	writeTestMmdb(t, config.GeoIP.DbAsnFile, map[string]interface{}{
+		"autonomous_system_number":       uint32(3215),
+		"autonomous_system_organization": "Orange",
+	})
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+	defer subprocessors.Reset()
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "83.112.146.176"
+	dm.NetworkInfo.ResponseIp = "83.112.146.1"
+	dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{
+		{Name: "dns.collector", Rdatatype: "CNAME", Rdata: "www.dns.collector"},
+		{Name: "www.dns.collector", Rdatatype: "A", Rdata: "192.0.2.1"},
+	}
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	// apply subprocessors
+	return_code := subprocessors.ProcessMessage(&dm)
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+
+	if dm.Geo.SubdivisionIsoCode != "IDF" || dm.Geo.Latitude != 48.8582 || dm.Geo.Longitude != 2.3387 {
+		t.Errorf("location invalid got: %s %v %v", dm.Geo.SubdivisionIsoCode, dm.Geo.Latitude, dm.Geo.Longitude)
+	}
+	if dm.Geo.ResponseIp == nil || dm.Geo.ResponseIp.CountryIsoCode != "FR" || dm.Geo.ResponseIp.AutonomousSystemNumber != "3215" {
+		t.Errorf("response ip geo invalid got: %+v", dm.Geo.ResponseIp)
+	}
+	if len(dm.Geo.Answers) != 1 {
+		t.Fatalf("one answer expected, got: %d", len(dm.Geo.Answers))
+	}
+	if dm.Geo.Answers[0].Rdata != "192.0.2.1" || dm.Geo.Answers[0].AutonomousSystemOrg != "Orange" {
+		t.Errorf("answer geo invalid got: %+v", dm.Geo.Answers[0])
+	}
+}
+
+func TestTransformsSubnetLabelsBeforeAnonymize(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,site\n192.168.1.0/24,paris\n")
+
+	// enable subnet labels and ip anonymization
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+	defer subprocessors.Reset()
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "192.168.1.2"
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	return_code := subprocessors.ProcessMessage(&dm)
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+	if dm.NetworkInfo.QueryIp != "192.168.0.0" {
+		t.Errorf("Ipv4 anonymization failed, got %v", dm.NetworkInfo.QueryIp)
+	}
+	if dm.Labels["site"] != "paris" {
+		t.Errorf("subnet labels invalid, got %v", dm.Labels)
+	}
+}
+
+func TestTransformsClientNames(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	writeTestFile(t, hostsFile, "192.168.1.2 laptop.home.lab\n")
+
+	// enable client names and ip anonymization
+	config := dnsutils.GetFakeConfigTransformers()
+	config.ClientNames.Enable = true
+	config.ClientNames.HostsFiles = []string{hostsFile}
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+	defer subprocessors.Reset()
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "192.168.1.2"
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	return_code := subprocessors.ProcessMessage(&dm)
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+	if dm.Client.Hostname != "laptop.home.lab" || dm.Client.Mac != "-" || dm.Client.Source != CLIENT_SOURCE_HOSTS {
+		t.Errorf("client name invalid, got %v", dm.Client)
+	}
+
+	// unknown client
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "192.168.1.3"
+	subprocessors.InitDnsMessageFormat(&dm)
+	subprocessors.ProcessMessage(&dm)
+	if dm.Client.Hostname != "-" || dm.Client.Source != "-" {
+		t.Errorf("client name invalid, got %v", dm.Client)
+	}
+}
+
+func TestTransformsRewriteAfterSubnetLabels(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "subnets.csv")
+	writeTestFile(t, csvFile, "subnet,site,vlan\n192.168.1.0/24,paris,10\n")
+
+	// enable subnet labels and rewrite
+	config := dnsutils.GetFakeConfigTransformers()
+	config.SubnetLabels.Enable = true
+	config.SubnetLabels.Files = []string{csvFile}
+	config.Rewrite.Enable = true
+	config.Rewrite.Operations = []dnsutils.ConfigRewriteOperation{
+		{Action: REWRITE_RENAME, Field: "labels.site", Target: "labels.location"},
+		{Action: REWRITE_DELETE, Field: "labels.vlan"},
+	}
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+	defer subprocessors.Reset()
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.NetworkInfo.QueryIp = "192.168.1.2"
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	return_code := subprocessors.ProcessMessage(&dm)
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+	if len(dm.Labels) != 1 || dm.Labels["location"] != "paris" {
+		t.Errorf("labels not rewritten, got %v", dm.Labels)
+	}
+}
+
 func TestTransformsReduceQname(t *testing.T) {
 	// enable feature
 	config := dnsutils.GetFakeConfigTransformers()
@@ -208,6 +374,116 @@ func TestTransformsAnonymizeIPv6(t *testing.T) {
 	}
 }
 
+func TestTransformsAnonymizeResponse(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.AnonymizeResponseIP = true
+	config.UserPrivacy.AnonymizeRdata = true
+	config.UserPrivacy.AnonymizeClientSubnet = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+
+	// create test message
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = []dnsutils.DnsAnswer{
+		{Name: "dns.collector", Rdatatype: "A", Rdata: "192.168.1.2"},
+		{Name: "dns.collector", Rdatatype: "TXT", Rdata: "192.168.1.2"},
+	}
+	dm.EDNS.Options = []dnsutils.DnsOption{{Code: 8, Name: "CSUBNET", Data: "192.168.1.0/24"}}
+	answers := dm.DNS.DnsRRs.Answers
+
+	// init dns message with additional part
+	subprocessors.InitDnsMessageFormat(&dm)
+
+	return_code := subprocessors.ProcessMessage(&dm)
+	if return_code != RETURN_SUCCESS {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", return_code, RETURN_SUCCESS)
+	}
+	if dm.NetworkInfo.ResponseIp != "4.3.0.0" {
+		t.Errorf("response ip anonymization failed, got %s", dm.NetworkInfo.ResponseIp)
+	}
+	if dm.DNS.DnsRRs.Answers[0].Rdata != "192.168.0.0" {
+		t.Errorf("rdata anonymization failed, got %s", dm.DNS.DnsRRs.Answers[0].Rdata)
+	}
+	if dm.DNS.DnsRRs.Answers[1].Rdata != "192.168.1.2" {
+		t.Errorf("TXT rdata should be unchanged, got %s", dm.DNS.DnsRRs.Answers[1].Rdata)
+	}
+	if dm.EDNS.Options[0].Data != "192.168.0.0/24" {
+		t.Errorf("client subnet anonymization failed, got %s", dm.EDNS.Options[0].Data)
+	}
+
+	// the original answers are shared with the others loggers
+	if answers[0].Rdata != "192.168.1.2" {
+		t.Errorf("original answers must not be updated, got %s", answers[0].Rdata)
+	}
+}
+
+func TestTransformsAnonymizeResponsePayload(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.AnonymizeRdata = true
+	config.UserPrivacy.AnonymizeClientSubnet = true
+	config.Extract.Enable = true
+	config.Extract.AddPayload = true
+
+	// init the processor
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+
+	// reply with an address and a client subnet
+	q := new(dns.Msg)
+	q.SetQuestion("dns.collector.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rr, _ := dns.NewRR("dns.collector. 300 IN A 192.168.1.2")
+	m.Answer = append(m.Answer, rr)
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(1232)
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.168.1.0")})
+	m.Extra = append(m.Extra, opt)
+	payload, _ := m.Pack()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Type = dnsutils.DnsReply
+	dm.DNS.Payload = payload
+	header, _ := dnsutils.DecodeDns(payload)
+	if err := dnsutils.DecodePayloadLazy(&dm, &header, dnsutils.GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subprocessors.InitDnsMessageFormat(&dm)
+	if ret := subprocessors.ProcessMessage(&dm); ret != RETURN_SUCCESS {
+		t.Fatalf("Return code is %v and not RETURN_SUCCESS (%v)", ret, RETURN_SUCCESS)
+	}
+
+	// the payload is encoded again from the anonymized fields
+	for name, data := range map[string][]byte{"payload": nil, "extracted payload": dm.Extracted.Base64Payload} {
+		if data == nil {
+			var err error
+			if data, err = dm.GetPayload(); err != nil {
+				t.Fatalf("unable to encode the payload: %v", err)
+			}
+		}
+		encoded := new(dns.Msg)
+		if err := encoded.Unpack(data); err != nil {
+			t.Fatalf("%s: invalid payload: %v", name, err)
+		}
+		if len(encoded.Answer) != 1 || encoded.Answer[0].(*dns.A).A.String() != "192.168.0.0" {
+			t.Errorf("%s: rdata not anonymized: %v", name, encoded.Answer)
+		}
+		ecs := encoded.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+		if ecs.Address.String() != "192.168.0.0" || ecs.SourceNetmask != 24 {
+			t.Errorf("%s: client subnet not anonymized: %v", name, ecs)
+		}
+	}
+}
+
 func TestTransformsNormalizeLowercaseQname(t *testing.T) {
 	// enable feature
 	config := dnsutils.GetFakeConfigTransformers()
@@ -309,3 +585,107 @@ func TestTransformAndFilter(t *testing.T) {
 		t.Errorf("Ipv6 anonymization failed, got %s", dm.NetworkInfo.QueryIp)
 	}
 }
+
+func TestTransformsPipeline_Order(t *testing.T) {
+	// filtering then user privacy, the fqdn is dropped
+	filtering := dnsutils.GetFakeConfigTransformers()
+	filtering.Filtering.Enable = true
+	filtering.Filtering.DropFqdnFile = "../testsdata/filtering_fqdn.txt"
+
+	privacy := dnsutils.GetFakeConfigTransformers()
+	privacy.UserPrivacy.Enable = true
+	privacy.UserPrivacy.MinimazeQname = true
+
+	testcases := []struct {
+		name     string
+		pipeline []dnsutils.ConfigTransformers
+		expected int
+	}{
+		{name: "filtering-first", pipeline: []dnsutils.ConfigTransformers{*filtering, *privacy}, expected: RETURN_DROP},
+		{name: "privacy-first", pipeline: []dnsutils.ConfigTransformers{*privacy, *filtering}, expected: RETURN_SUCCESS},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := dnsutils.GetFakeConfigTransformers()
+			config.Pipeline = tc.pipeline
+
+			channels := []chan dnsutils.DnsMessage{}
+			subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = "mail.google.com"
+			subprocessors.InitDnsMessageFormat(&dm)
+
+			if return_code := subprocessors.ProcessMessage(&dm); return_code != tc.expected {
+				t.Errorf("Return code is %v and not %v", return_code, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTransformsPipeline_SameTransformer(t *testing.T) {
+	// the suspicious transformer is used twice with different settings
+	first := dnsutils.GetFakeConfigTransformers()
+	first.Suspicious.Enable = true
+
+	second := dnsutils.GetFakeConfigTransformers()
+	second.Suspicious.Enable = true
+	second.Suspicious.ThresholdQnameLen = 4
+
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Pipeline = []dnsutils.ConfigTransformers{*first, *second}
+
+	channels := []chan dnsutils.DnsMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "longdomain.com"
+	subprocessors.InitDnsMessageFormat(&dm)
+	subprocessors.ProcessMessage(&dm)
+
+	if dm.Suspicious.Score != 1.0 || !dm.Suspicious.LongDomain {
+		t.Errorf("only the second suspicious transformer should detect a long domain, score: %v", dm.Suspicious.Score)
+	}
+}
+
+func TestTransformsReducerBeforeLatency(t *testing.T) {
+	// enable the latency measure and the reducer in the same stanza
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Latency.Enable = true
+	config.Latency.MeasureLatency = true
+	config.Reducer.Enable = true
+	config.Reducer.RepetitiveTrafficDetector = true
+	config.Reducer.WatchInterval = 1
+
+	// init the processor
+	outChan := make(chan dnsutils.DnsMessage, 10)
+	subprocessors := NewTransforms(config, logger.New(false), "test", []chan dnsutils.DnsMessage{outChan}, 0)
+	defer subprocessors.Reset()
+
+	query := dnsutils.GetFakeDnsMessage()
+	query.DnsTap.Timestamp = 1000000000
+	reply := dnsutils.GetFakeDnsMessage()
+	reply.DNS.Type = dnsutils.DnsReply
+	reply.DnsTap.Timestamp = 1500000000
+
+	// the reducer drops the messages before the latency is measured
+	for _, dm := range []*dnsutils.DnsMessage{&query, &reply} {
+		subprocessors.InitDnsMessageFormat(dm)
+		if ret := subprocessors.ProcessMessage(dm); ret != RETURN_DROP {
+			t.Errorf("message should be reduced, got %d", ret)
+		}
+	}
+
+	select {
+	case dm := <-outChan:
+		if dm.Reducer.Occurences != 2 {
+			t.Errorf("2 occurences expected, got %d", dm.Reducer.Occurences)
+		}
+		if dm.Reducer.LatencyMin != 0 || dm.Reducer.LatencyMax != 0 || dm.Reducer.LatencyAvg != 0 {
+			t.Errorf("no latency expected, got %+v", dm.Reducer)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("reduced message not flushed")
+	}
+}
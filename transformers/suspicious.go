@@ -4,17 +4,57 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-logger"
+	"golang.org/x/net/publicsuffix"
 )
 
+var (
+	// labels shorter than this length are ignored by the entropy and n-gram detectors
+	suspiciousMinLabelLen = 8
+	// maximum number of registered domains tracked per window
+	suspiciousMaxDomains = 100000
+	// names of the checks, used as keys of the weights
+	suspiciousChecks = map[string]bool{
+		"malformed-pkt": true, "large-pkt": true, "long-domain": true, "slow-domain": true,
+		"unallowed-chars": true, "uncommon-qtypes": true, "excessive-number-labels": true,
+		"homograph-domain": true, "unique-subdomains": true, "txt-null-volume": true,
+		"random-subdomains": true, "high-entropy": true, "unlikely-ngrams": true,
+	}
+)
+
+// suspiciousDomain contains the counters of a registered domain for the current window,
+// the sets of subdomains are bounded by the thresholds
+type suspiciousDomain struct {
+	subdomains     map[string]struct{}
+	nxSubdomains   map[string]struct{}
+	txtNullQueries int
+}
+
+type suspiciousState struct {
+	sync.Mutex
+	windowStart time.Time
+	domains     map[string]*suspiciousDomain
+}
+
+func addBounded(set map[string]struct{}, key string, threshold int) int {
+	if len(set) <= threshold {
+		set[key] = struct{}{}
+	}
+	return len(set)
+}
+
 type SuspiciousTransform struct {
 	config                *dnsutils.ConfigTransformers
 	logger                *logger.Logger
 	name                  string
 	CommonQtypes          map[string]bool
 	whitelistDomainsRegex map[string]*regexp.Regexp
+	bigrams               *bigramModel
+	state                 *suspiciousState
 	instance              int
 	outChannels           []chan dnsutils.DnsMessage
 	logInfo               func(msg string, v ...interface{})
@@ -31,24 +71,42 @@ func NewSuspiciousSubprocessor(config *dnsutils.ConfigTransformers, logger *logg
 		name:                  name,
 		CommonQtypes:          make(map[string]bool),
 		whitelistDomainsRegex: make(map[string]*regexp.Regexp),
+		state:                 &suspiciousState{domains: make(map[string]*suspiciousDomain)},
 		instance:              instance,
 		outChannels:           outChannels,
 		logInfo:               logInfo,
 		logError:              logError,
 	}
 
-	d.ReadConfig()
+	if err := d.ReadConfig(); err != nil && config.Suspicious.Enable {
+		// a misconfigured check would be silently disabled or counted with the default weight
+		logger.Fatal(fmt.Sprintf("[%s] transformer=suspicious#%d - ", name, instance), err)
+	}
 
 	return d
 }
 
-func (p *SuspiciousTransform) ReadConfig() {
+func (p *SuspiciousTransform) ReadConfig() error {
 	for _, v := range p.config.Suspicious.CommonQtypes {
 		p.CommonQtypes[v] = true
 	}
 	for _, v := range p.config.Suspicious.WhitelistDomains {
 		p.whitelistDomainsRegex[v] = regexp.MustCompile(v)
 	}
+	if p.config.Suspicious.ThresholdNgramLikelihood < 0 {
+		p.bigrams = newBigramModel(bigramCorpus)
+	}
+
+	// the traffic is counted per window, a null window resets the counters on each message
+	if p.config.Suspicious.Window <= 0 {
+		return fmt.Errorf("invalid window %d, must be greater than 0", p.config.Suspicious.Window)
+	}
+	for k := range p.config.Suspicious.Weights {
+		if !suspiciousChecks[k] {
+			return fmt.Errorf("invalid weight %q, unknown check", k)
+		}
+	}
+	return nil
 }
 
 func (p *SuspiciousTransform) IsEnabled() bool {
@@ -76,10 +134,24 @@ func (p *SuspiciousTransform) InitDnsMessage(dm *dnsutils.DnsMessage) {
 			UnallowedChars:        false,
 			UncommonQtypes:        false,
 			ExcessiveNumberLabels: false,
+			HomographDomain:       false,
+			UniqueSubdomains:      false,
+			TxtNullVolume:         false,
+			RandomSubdomains:      false,
+			HighEntropy:           false,
+			UnlikelyNgrams:        false,
 		}
 	}
 }
 
+// weight returns the weight of the flag in the score, 1.0 by default
+func (p *SuspiciousTransform) weight(flag string) float64 {
+	if w, ok := p.config.Suspicious.Weights[flag]; ok {
+		return w
+	}
+	return 1.0
+}
+
 func (p *SuspiciousTransform) CheckIfSuspicious(dm *dnsutils.DnsMessage) {
 
 	if dm.Suspicious == nil {
@@ -94,48 +166,156 @@ func (p *SuspiciousTransform) CheckIfSuspicious(dm *dnsutils.DnsMessage) {
 		}
 	}
 
-	// dns decoding error?
+	// dns decoding error? the errors in the records are detected when they are decoded
+	dm.DecodeSections()
 	if dm.DNS.MalformedPacket {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("malformed-pkt")
 		dm.Suspicious.MalformedPacket = true
 	}
 
 	// long domain name ?
 	if len(dm.DNS.Qname) > p.config.Suspicious.ThresholdQnameLen {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("long-domain")
 		dm.Suspicious.LongDomain = true
 	}
 
 	// large packet size ?
 	if dm.DNS.Length > p.config.Suspicious.ThresholdPacketLen {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("large-pkt")
 		dm.Suspicious.LargePacket = true
 	}
 
 	// slow domain name resolution ?
 	if dm.DnsTap.Latency > p.config.Suspicious.ThresholdSlow {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("slow-domain")
 		dm.Suspicious.SlowDomain = true
 	}
 
 	// uncommon qtype?
 	if _, found := p.CommonQtypes[dm.DNS.Qtype]; !found {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("uncommon-qtypes")
 		dm.Suspicious.UncommonQtypes = true
 	}
 
 	// count the number of labels in qname
 	if strings.Count(dm.DNS.Qname, ".") > p.config.Suspicious.ThresholdMaxLabels {
-		dm.Suspicious.Score += 1.0
+		dm.Suspicious.Score += p.weight("excessive-number-labels")
 		dm.Suspicious.ExcessiveNumberLabels = true
 	}
 
 	// search for unallowed characters
 	for _, v := range p.config.Suspicious.UnallowedChars {
 		if strings.Contains(dm.DNS.Qname, v) {
-			dm.Suspicious.Score += 1.0
+			dm.Suspicious.Score += p.weight("unallowed-chars")
 			dm.Suspicious.UnallowedChars = true
 			break
 		}
 	}
+
+	// internationalized domain name with mixed scripts or confusable characters ?
+	if dnsutils.IsHomograph(dm.DNS.Qname) {
+		dm.Suspicious.Score += p.weight("homograph-domain")
+		dm.Suspicious.HomographDomain = true
+	}
+
+	p.CheckDomain(dm)
+}
+
+// CheckDomain runs the detectors of DGA and DNS tunnelling, based on the labels
+// and on the traffic of the registered domain during the time window
+func (p *SuspiciousTransform) CheckDomain(dm *dnsutils.DnsMessage) {
+	cfg := &p.config.Suspicious
+	stateful := cfg.ThresholdUniqueSubdomains > 0 || cfg.ThresholdTxtNullQueries > 0 || cfg.ThresholdRandomSubdomains > 0
+	if !stateful && cfg.ThresholdEntropy <= 0 && p.bigrams == nil {
+		return
+	}
+
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(qname)
+	if err != nil {
+		return
+	}
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(qname, domain), ".")
+	label := domain[:strings.IndexByte(domain, '.')]
+
+	// random labels used to encode data ?
+	if cfg.ThresholdEntropy > 0 {
+		longest := label
+		for _, l := range strings.Split(subdomain, ".") {
+			if len(l) > len(longest) {
+				longest = l
+			}
+		}
+		if len(longest) >= suspiciousMinLabelLen && shannonEntropy(longest) > cfg.ThresholdEntropy {
+			dm.Suspicious.Score += p.weight("high-entropy")
+			dm.Suspicious.HighEntropy = true
+		}
+	}
+
+	// registered domain generated by an algorithm ?
+	if p.bigrams != nil && len(label) >= suspiciousMinLabelLen {
+		if p.bigrams.Likelihood(label) < cfg.ThresholdNgramLikelihood {
+			dm.Suspicious.Score += p.weight("unlikely-ngrams")
+			dm.Suspicious.UnlikelyNgrams = true
+		}
+	}
+
+	if stateful {
+		p.checkDomainTraffic(dm, domain, subdomain)
+	}
+}
+
+func (p *SuspiciousTransform) checkDomainTraffic(dm *dnsutils.DnsMessage, domain string, subdomain string) {
+	cfg := &p.config.Suspicious
+	st := p.state
+	st.Lock()
+	defer st.Unlock()
+
+	// new window ?
+	if now := time.Now(); now.Sub(st.windowStart) >= time.Duration(cfg.Window)*time.Second {
+		st.windowStart = now
+		st.domains = make(map[string]*suspiciousDomain)
+	}
+
+	d, ok := st.domains[domain]
+	if !ok {
+		if len(st.domains) >= suspiciousMaxDomains {
+			return
+		}
+		d = &suspiciousDomain{subdomains: make(map[string]struct{}), nxSubdomains: make(map[string]struct{})}
+		st.domains[domain] = d
+	}
+
+	if len(subdomain) > 0 {
+		// tunnelling, a lot of unique subdomains
+		if cfg.ThresholdUniqueSubdomains > 0 {
+			if addBounded(d.subdomains, subdomain, cfg.ThresholdUniqueSubdomains) > cfg.ThresholdUniqueSubdomains {
+				dm.Suspicious.Score += p.weight("unique-subdomains")
+				dm.Suspicious.UniqueSubdomains = true
+			}
+		}
+
+		// water torture, a lot of random subdomains which do not exist
+		if cfg.ThresholdRandomSubdomains > 0 {
+			count := len(d.nxSubdomains)
+			if dm.DNS.Type == dnsutils.DnsReply && dm.DNS.Rcode == "NXDOMAIN" {
+				count = addBounded(d.nxSubdomains, subdomain, cfg.ThresholdRandomSubdomains)
+			}
+			if count > cfg.ThresholdRandomSubdomains {
+				dm.Suspicious.Score += p.weight("random-subdomains")
+				dm.Suspicious.RandomSubdomains = true
+			}
+		}
+	}
+
+	// tunnelling, volume of TXT and NULL queries
+	if cfg.ThresholdTxtNullQueries > 0 && (dm.DNS.Qtype == "TXT" || dm.DNS.Qtype == "NULL") {
+		if dm.DNS.Type == dnsutils.DnsQuery {
+			d.txtNullQueries++
+		}
+		if d.txtNullQueries > cfg.ThresholdTxtNullQueries {
+			dm.Suspicious.Score += p.weight("txt-null-volume")
+			dm.Suspicious.TxtNullVolume = true
+		}
+	}
 }
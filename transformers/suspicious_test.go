@@ -3,6 +3,7 @@ package transformers
 import (
 	"encoding/json"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -35,7 +36,13 @@ func TestSuspicious_Json(t *testing.T) {
 					"slow-domain":false,
 					"unallowed-chars":false,
 					"uncommon-qtypes":false,
-					"excessive-number-labels":false
+					"excessive-number-labels":false,
+					"homograph-domain":false,
+					"unique-subdomains":false,
+					"txt-null-volume":false,
+					"random-subdomains":false,
+					"high-entropy":false,
+					"unlikely-ngrams":false
 				}
 			}
 			`
@@ -292,3 +299,210 @@ func TestSuspicious_WhitelistDomains(t *testing.T) {
 		t.Errorf("suspicious score should be equal to 0.0, got: %d", int(dm.Suspicious.Score))
 	}
 }
+
+func TestSuspicious_HomographDomain(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	// cyrillic "а" mixed with latin letters: аpple.com
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "xn--pple-43d.com"
+
+	// init dns message with additional part
+	suspicious.InitDnsMessage(&dm)
+
+	suspicious.CheckIfSuspicious(&dm)
+
+	if dm.Suspicious.Score != 1.0 {
+		t.Errorf("suspicious score should be equal to 1.0, got: %d", int(dm.Suspicious.Score))
+	}
+
+	if dm.Suspicious.HomographDomain != true {
+		t.Errorf("suspicious homograph domain flag should be equal to true")
+	}
+}
+
+func TestSuspicious_UniqueSubdomains(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdUniqueSubdomains = 2
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	for i, qname := range []string{"a1.example.com", "a2.example.com", "a2.example.com", "a3.example.com", "www.other.com"} {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+
+		// only the third unique subdomain exceeds the threshold
+		expected := i == 3
+		if dm.Suspicious.UniqueSubdomains != expected {
+			t.Errorf("%s: unique subdomains flag should be %v", qname, expected)
+		}
+	}
+}
+
+func TestSuspicious_TxtNullVolume(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdTxtNullQueries = 2
+	config.Suspicious.Weights = map[string]float64{"txt-null-volume": 3.0}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	var dm dnsutils.DnsMessage
+	for i := 0; i < 3; i++ {
+		dm = dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = "t.tunnel.com"
+		dm.DNS.Qtype = "NULL"
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+	}
+
+	if !dm.Suspicious.TxtNullVolume {
+		t.Errorf("txt/null volume flag should be equal to true")
+	}
+	// NULL is an uncommon qtype too
+	if dm.Suspicious.Score != 4.0 {
+		t.Errorf("suspicious score should be equal to 4.0, got: %v", dm.Suspicious.Score)
+	}
+}
+
+func TestSuspicious_RandomSubdomains(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdRandomSubdomains = 1
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	var dm dnsutils.DnsMessage
+	for _, qname := range []string{"x7f2.victim.com", "k9q1.victim.com"} {
+		dm = dnsutils.GetFakeDnsMessage()
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DNS.Rcode = "NXDOMAIN"
+		dm.DNS.Qname = qname
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+	}
+	if !dm.Suspicious.RandomSubdomains {
+		t.Errorf("random subdomains flag should be equal to true")
+	}
+
+	// queries of the domain are flagged too
+	dm = dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "p3z8.victim.com"
+	suspicious.InitDnsMessage(&dm)
+	suspicious.CheckIfSuspicious(&dm)
+	if !dm.Suspicious.RandomSubdomains {
+		t.Errorf("random subdomains flag should be equal to true for queries")
+	}
+}
+
+func TestSuspicious_HighEntropy(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdEntropy = 4.0
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	testcases := map[string]bool{
+		"mail.example.com": false,
+		"onvhm6dboqztcmjrgu3dcnrxgq2tmmjy.example.com": true,
+	}
+	for qname, expected := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+		if dm.Suspicious.HighEntropy != expected {
+			t.Errorf("%s: high entropy flag should be %v", qname, expected)
+		}
+	}
+}
+
+func TestSuspicious_UnlikelyNgrams(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Suspicious.Enable = true
+	config.Suspicious.ThresholdNgramLikelihood = -1.8
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	suspicious := NewSuspiciousSubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	testcases := map[string]bool{
+		"www.facebook.com":      false,
+		"www.stackoverflow.com": false,
+		"bbc.co.uk":             false,
+		"mxlhsnbpqyfh.com":      true,
+		"a3f9c2e1b7d4.net":      true,
+	}
+	for qname, expected := range testcases {
+		dm := dnsutils.GetFakeDnsMessage()
+		dm.DNS.Qname = qname
+		suspicious.InitDnsMessage(&dm)
+		suspicious.CheckIfSuspicious(&dm)
+		if dm.Suspicious.UnlikelyNgrams != expected {
+			t.Errorf("%s: unlikely n-grams flag should be %v", qname, expected)
+		}
+	}
+}
+
+func TestSuspicious_InvalidConfig(t *testing.T) {
+	log := logger.New(false)
+
+	testcases := []struct {
+		window  int
+		weights map[string]float64
+		valid   bool
+	}{
+		{window: 60, weights: map[string]float64{"unlikely-ngrams": 2.0}, valid: true},
+		{window: 0, weights: map[string]float64{}},
+		{window: -1, weights: map[string]float64{}},
+		{window: 60, weights: map[string]float64{"unlikely-ngram": 2.0}},
+	}
+
+	for _, tc := range testcases {
+		// enable the transformer, the constructor refuses to start with this error
+		config := dnsutils.GetFakeConfigTransformers()
+		config.Suspicious.Enable = true
+		config.Suspicious.Window = tc.window
+		config.Suspicious.Weights = tc.weights
+
+		suspicious := &SuspiciousTransform{config: config, CommonQtypes: make(map[string]bool),
+			whitelistDomainsRegex: make(map[string]*regexp.Regexp), logInfo: log.Info, logError: log.Error}
+		if err := suspicious.ReadConfig(); (err == nil) != tc.valid {
+			t.Errorf("window %d, weights %v: want valid=%v, got %v", tc.window, tc.weights, tc.valid, err)
+		}
+	}
+}
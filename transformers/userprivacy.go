@@ -1,9 +1,15 @@
 package transformers
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -12,14 +18,19 @@ import (
 )
 
 var (
-	defaultIPv4Mask = net.IPv4Mask(255, 255, 0, 0)                                                       // /24
+	defaultIPv4Mask = net.IPv4Mask(255, 255, 0, 0)                                                       // /16
 	defaultIPv6Mask = net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0} // /64
+
+	// minimum size of the secret read from the key file
+	userPrivacyMinKeySize = 16
 )
 
 type UserPrivacyProcessor struct {
 	config      *dnsutils.ConfigTransformers
 	v4Mask      net.IPMask
 	v6Mask      net.IPMask
+	cryptoPan   *cryptoPan
+	hashKey     []byte
 	instance    int
 	outChannels []chan dnsutils.DnsMessage
 	logInfo     func(msg string, v ...interface{})
@@ -40,9 +51,68 @@ func NewUserPrivacySubprocessor(config *dnsutils.ConfigTransformers, logger *log
 		logError:    logError,
 	}
 
+	if config.UserPrivacy.Enable {
+		s.LoadMasks()
+		s.LoadKey()
+	}
+
 	return s
 }
 
+func (s *UserPrivacyProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=userprivacy#%d - ", s.instance)
+	s.logInfo(log+msg, v...)
+}
+
+func (s *UserPrivacyProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("transformer=userprivacy#%d - ", s.instance)
+	s.logError(log+msg, v...)
+}
+
+func (s *UserPrivacyProcessor) LoadMasks() {
+	if mask := net.CIDRMask(s.config.UserPrivacy.AnonymizeV4Bits, 32); mask != nil {
+		s.v4Mask = mask
+	} else {
+		s.LogError("invalid ipv4 prefix length %d, /16 is used", s.config.UserPrivacy.AnonymizeV4Bits)
+	}
+
+	if mask := net.CIDRMask(s.config.UserPrivacy.AnonymizeV6Bits, 128); mask != nil {
+		s.v6Mask = mask
+	} else {
+		s.LogError("invalid ipv6 prefix length %d, /64 is used", s.config.UserPrivacy.AnonymizeV6Bits)
+	}
+}
+
+// LoadKey reads the secret used for the prefix-preserving anonymization and the hmac hashing,
+// without a valid key the ip addresses are masked and hashed with sha1
+func (s *UserPrivacyProcessor) LoadKey() {
+	if len(s.config.UserPrivacy.KeyFile) == 0 {
+		if s.config.UserPrivacy.PrefixPreserving {
+			s.LogError("prefix-preserving mode requires a key file, ip addresses are masked")
+		}
+		return
+	}
+
+	secret, err := os.ReadFile(s.config.UserPrivacy.KeyFile)
+	if err != nil {
+		s.LogError("unable to read key file: %v", err)
+		return
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < userPrivacyMinKeySize {
+		s.LogError("key is too short, %d bytes minimum", userPrivacyMinKeySize)
+		return
+	}
+
+	key := sha256.Sum256(secret)
+	if s.cryptoPan, err = newCryptoPan(key[:]); err != nil {
+		s.LogError("unable to init prefix-preserving anonymization: %v", err)
+		return
+	}
+	s.hashKey = secret
+	s.LogInfo("key loaded from %s", s.config.UserPrivacy.KeyFile)
+}
+
 func (s *UserPrivacyProcessor) MinimazeQname(qname string) string {
 	if etpo, err := publicsuffix.EffectiveTLDPlusOne(qname); err == nil {
 		return etpo
@@ -51,20 +121,66 @@ func (s *UserPrivacyProcessor) MinimazeQname(qname string) string {
 	return qname
 }
 
+// AnonymizeIP masks the host part of the address or, in prefix-preserving mode,
+// replaces the address with the Crypto-PAn anonymized one. Invalid addresses are returned unchanged
 func (s *UserPrivacyProcessor) AnonymizeIP(ip string) string {
 	ipaddr := net.ParseIP(ip)
-	isipv4 := strings.LastIndex(ip, ".")
+	if ipaddr == nil {
+		return ip
+	}
+	prefixPreserving := s.config.UserPrivacy.PrefixPreserving && s.cryptoPan != nil
 
-	// ipv4, /16 mask
-	if isipv4 != -1 {
-		return ipaddr.Mask(s.v4Mask).String()
+	// ipv4, /16 mask by default
+	if ipv4 := ipaddr.To4(); ipv4 != nil {
+		if prefixPreserving {
+			return net.IP(s.cryptoPan.Anonymize(ipv4)).String()
+		}
+		return ipv4.Mask(s.v4Mask).String()
 	}
 
-	// ipv6, /64 mask
+	// ipv6, /64 mask by default
+	if prefixPreserving {
+		return net.IP(s.cryptoPan.Anonymize(ipaddr)).String()
+	}
 	return ipaddr.Mask(s.v6Mask).String()
 }
 
+// AnonymizeSubnet anonymizes the address of a subnet formatted as the EDNS client subnet
+// option (192.0.2.0/24 or [2001:db8::]/56), the source prefix length is kept
+func (s *UserPrivacyProcessor) AnonymizeSubnet(subnet string) string {
+	addr, bits, found := strings.Cut(subnet, "/")
+	if !found {
+		return subnet
+	}
+	prefixLen, err := strconv.Atoi(bits)
+	if err != nil {
+		return subnet
+	}
+
+	ipaddr := net.ParseIP(s.AnonymizeIP(strings.Trim(addr, "[]")))
+	if ipaddr == nil {
+		return subnet
+	}
+	if ipv4 := ipaddr.To4(); ipv4 != nil {
+		if mask := net.CIDRMask(prefixLen, 32); mask != nil {
+			return fmt.Sprintf("%s/%d", ipv4.Mask(mask), prefixLen)
+		}
+		return subnet
+	}
+	if mask := net.CIDRMask(prefixLen, 128); mask != nil {
+		return fmt.Sprintf("[%s]/%d", ipaddr.Mask(mask), prefixLen)
+	}
+	return subnet
+}
+
+// HashIP returns the hmac-sha256 of the address when a key is loaded, sha1 otherwise
 func (s *UserPrivacyProcessor) HashIP(ip string) string {
+	if s.hashKey != nil {
+		mac := hmac.New(sha256.New, s.hashKey)
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
 	hash := sha1.New()
 	hash.Write([]byte(ip))
 	return fmt.Sprintf("%x", hash.Sum(nil))
@@ -1,6 +1,9 @@
 package transformers
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -77,3 +80,177 @@ func TestAnonymizeIPv6(t *testing.T) {
 		t.Errorf("Ipv6 anonymization failed, got %s", ret)
 	}
 }
+
+func TestAnonymizeIPv4Bits(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.AnonymizeV4Bits = 24
+	config.UserPrivacy.AnonymizeV6Bits = 32
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	ret := userPrivacy.AnonymizeIP("192.168.1.2")
+	if ret != "192.168.1.0" {
+		t.Errorf("Ipv4 anonymization failed, got %s", ret)
+	}
+
+	ret = userPrivacy.AnonymizeIP("2001:db8:85a3::8a2e:370:7334")
+	if ret != "2001:db8::" {
+		t.Errorf("Ipv6 anonymization failed, got %s", ret)
+	}
+}
+
+func TestCryptoPanVectors(t *testing.T) {
+	key := []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+		216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+	cp, err := newCryptoPan(key)
+	if err != nil {
+		t.Fatalf("unable to init crypto-pan: %v", err)
+	}
+
+	// reference vectors of the original implementation
+	vectors := map[string]string{
+		"128.11.68.132":   "135.242.180.132",
+		"129.118.74.4":    "134.136.186.123",
+		"130.132.252.244": "133.68.164.234",
+		"141.223.7.43":    "141.167.8.160",
+		"192.102.249.13":  "252.138.62.131",
+	}
+	for ip, expected := range vectors {
+		ret := net.IP(cp.Anonymize(net.ParseIP(ip).To4())).String()
+		if ret != expected {
+			t.Errorf("crypto-pan failed for %s, expected %s, got %s", ip, expected, ret)
+		}
+	}
+
+	if _, err := newCryptoPan(key[:16]); err != ErrCryptoPanKeySize {
+		t.Errorf("invalid key size not detected")
+	}
+}
+
+func writeUserPrivacyKey(t *testing.T) string {
+	fname := filepath.Join(t.TempDir(), "userprivacy.key")
+	if err := os.WriteFile(fname, []byte("c2VjcmV0LWtleS1mb3ItdGVzdHM=\n"), 0600); err != nil {
+		t.Fatalf("unable to write key file: %v", err)
+	}
+	return fname
+}
+
+func TestAnonymizeIPPrefixPreserving(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.PrefixPreserving = true
+	config.UserPrivacy.KeyFile = writeUserPrivacyKey(t)
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	ip1 := userPrivacy.AnonymizeIP("192.168.1.2")
+	ip2 := userPrivacy.AnonymizeIP("192.168.1.200")
+	if ip1 == "192.168.1.2" || ip1 == "192.168.0.0" {
+		t.Errorf("Ipv4 prefix-preserving anonymization failed, got %s", ip1)
+	}
+	if ip1 != userPrivacy.AnonymizeIP("192.168.1.2") {
+		t.Errorf("Ipv4 prefix-preserving anonymization is not deterministic")
+	}
+
+	// 192.168.1.2 and 192.168.1.200 share the first 24 bits
+	a1, a2 := net.ParseIP(ip1).To4(), net.ParseIP(ip2).To4()
+	if !a1.Mask(net.CIDRMask(24, 32)).Equal(a2.Mask(net.CIDRMask(24, 32))) || a1.Equal(a2) {
+		t.Errorf("Ipv4 prefix not preserved, got %s and %s", ip1, ip2)
+	}
+
+	ip6 := userPrivacy.AnonymizeIP("fe80::6111:626:c1b2:2353")
+	if net.ParseIP(ip6) == nil || net.ParseIP(ip6).To4() != nil || ip6 == "fe80::6111:626:c1b2:2353" {
+		t.Errorf("Ipv6 prefix-preserving anonymization failed, got %s", ip6)
+	}
+
+	// invalid addresses are kept
+	if ret := userPrivacy.AnonymizeIP("-"); ret != "-" {
+		t.Errorf("invalid ip should be unchanged, got %s", ret)
+	}
+}
+
+func TestAnonymizeIPPrefixPreservingWithoutKey(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+	config.UserPrivacy.PrefixPreserving = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	// fallback to the mask
+	ret := userPrivacy.AnonymizeIP("192.168.1.2")
+	if ret != "192.168.0.0" {
+		t.Errorf("Ipv4 anonymization failed, got %s", ret)
+	}
+}
+
+func TestHashIPWithKey(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.HashIP = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// sha1 without key
+	userPrivacy := NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	sha1 := userPrivacy.HashIP("192.168.1.2")
+	if len(sha1) != 40 {
+		t.Errorf("sha1 hash expected, got %s", sha1)
+	}
+
+	// hmac-sha256 with key
+	config.UserPrivacy.KeyFile = writeUserPrivacyKey(t)
+	userPrivacy = NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	hmac := userPrivacy.HashIP("192.168.1.2")
+	if len(hmac) != 64 {
+		t.Errorf("hmac-sha256 hash expected, got %s", hmac)
+	}
+	if hmac != userPrivacy.HashIP("192.168.1.2") {
+		t.Errorf("hmac hash is not deterministic")
+	}
+}
+
+func TestAnonymizeSubnet(t *testing.T) {
+	// enable feature
+	config := dnsutils.GetFakeConfigTransformers()
+	config.UserPrivacy.Enable = true
+	config.UserPrivacy.AnonymizeIP = true
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init the processor
+	userPrivacy := NewUserPrivacySubprocessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+
+	subnets := map[string]string{
+		"192.168.1.0/24":      "192.168.0.0/24",
+		"10.1.0.0/8":          "10.0.0.0/8",
+		"[2001:db8:1:2::]/56": "[2001:db8:1::]/56",
+		"invalid":             "invalid",
+	}
+	for subnet, expected := range subnets {
+		if ret := userPrivacy.AnonymizeSubnet(subnet); ret != expected {
+			t.Errorf("subnet anonymization failed for %s, expected %s, got %s", subnet, expected, ret)
+		}
+	}
+}